@@ -13,8 +13,13 @@ import (
 
 	"github.com/gruntwork-io/kubergrunt/eks"
 	"github.com/gruntwork-io/kubergrunt/eksawshelper"
+	"github.com/gruntwork-io/kubergrunt/kubectl"
 )
 
+// coreComponentsDriftExitCode is the exit code of sync-core-components --dry-run when the core components have drifted,
+// which distinguishes drift from other errors.
+const coreComponentsDriftExitCode = 2
+
 var (
 	eksClusterArnFlag = cli.StringFlag{
 		Name:  "eks-cluster-arn",
@@ -39,7 +44,31 @@ var (
 	}
 	clusterAsgNameFlag = cli.StringSliceFlag{
 		Name:  "asg-name",
-		Usage: "(Required) The name of the autoscaling group that is a part of the EKS cluster.",
+		Usage: "The name of the autoscaling group that is a part of the EKS cluster. Can be passed in multiple times. Either --asg-name or --nodegroup-name is required.",
+	}
+	nodegroupNameFlag = cli.StringSliceFlag{
+		Name:  "nodegroup-name",
+		Usage: "The name of an EKS managed node group that is a part of the EKS cluster. Can be passed in multiple times. Requires --eks-cluster-name or --eks-cluster-arn.",
+	}
+	eksClusterNameFlag = cli.StringFlag{
+		Name:  "eks-cluster-name",
+		Usage: "The name of the EKS cluster of the managed node groups given with --nodegroup-name. Defaults to the name in --eks-cluster-arn.",
+	}
+	nodegroupKubernetesVersionFlag = cli.StringFlag{
+		Name:  "nodegroup-kubernetes-version",
+		Usage: "The Kubernetes version to update the managed node groups to. Only used with --nodegroup-name. Defaults to the version of the cluster.",
+	}
+	nodegroupReleaseVersionFlag = cli.StringFlag{
+		Name:  "nodegroup-release-version",
+		Usage: "The EKS optimized AMI release version to update the managed node groups to. Only used with --nodegroup-name. Defaults to the latest release for the Kubernetes version.",
+	}
+	nodegroupLaunchTemplateVersionFlag = cli.StringFlag{
+		Name:  "nodegroup-launch-template-version",
+		Usage: "The launch template version to bump the managed node groups to, or $Latest for the latest version of the launch template. Only used with --nodegroup-name, for node groups that use a launch template.",
+	}
+	nodegroupForceFlag = cli.BoolFlag{
+		Name:  "nodegroup-force",
+		Usage: "Update the managed node groups even if Pods can not be evicted due to PodDisruptionBudgets. Only used with --nodegroup-name.",
 	}
 	drainTimeoutFlag = cli.DurationFlag{
 		Name:  "drain-timeout",
@@ -50,6 +79,59 @@ var (
 		Name:  "delete-emptydir-data",
 		Usage: "Continue even if there are pods using emptyDir (local data that will be deleted when the node is drained).",
 	}
+	maxConcurrentDrainsFlag = cli.IntFlag{
+		Name:  "max-concurrent-drains",
+		Usage: "The maximum number of nodes to drain at the same time. Defaults to zero, which drains all the nodes at once.",
+	}
+	drainOrderFlag = cli.StringFlag{
+		Name:  "drain-order",
+		Value: string(kubectl.GivenDrainOrder),
+		Usage: "The order in which to drain the nodes. Must be one of given (the order of the instances in the ASGs), az (one availability zone at a time), or fewest-pods (the nodes with the fewest Pods to evict first). Defaults to given.",
+	}
+	failFastFlag = cli.BoolFlag{
+		Name:  "fail-fast",
+		Usage: "Stop draining new nodes after the first node fails to drain. Drains that are already in progress are allowed to finish.",
+	}
+	jobGracePeriodFlag = cli.DurationFlag{
+		Name:  "job-grace-period",
+		Usage: "The length of time as duration (e.g 10m = 10 minutes) to wait for Pods owned by Jobs, or with the annotation set with --job-wait-annotation, to complete before evicting them. These Pods are evicted after all the other Pods on the node. Not included in --drain-timeout. Defaults to zero, which evicts them right away.",
+	}
+	forceDeleteStuckPodsFlag = cli.BoolFlag{
+		Name:  "force-delete-stuck-pods",
+		Usage: "When the eviction of a Pod times out (e.g the Pod is stuck on a finalizer or an unresponsive kubelet), delete it with --stuck-pod-grace-period instead of failing the drain, ignoring PodDisruptionBudgets. If the Pod is still not gone after twice the grace period, force delete it with a grace period of zero.",
+	}
+	stuckPodGracePeriodFlag = cli.DurationFlag{
+		Name:  "stuck-pod-grace-period",
+		Value: kubectl.DefaultStuckPodGracePeriod,
+		Usage: "The grace period to use when deleting Pods whose eviction timed out. Only used with --force-delete-stuck-pods. Zero means the Pods are force deleted right away. Defaults to 30 seconds.",
+	}
+	jobWaitAnnotationFlag = cli.StringFlag{
+		Name:  "job-wait-annotation",
+		Value: kubectl.DefaultWaitAnnotation,
+		Usage: "Pods with this annotation set to \"true\" are given the --job-grace-period to complete before being evicted, like Pods owned by Jobs.",
+	}
+	nodeSelectorFlag = cli.StringFlag{
+		Name:  "node-selector",
+		Usage: "A label selector (e.g eks.amazonaws.com/nodegroup=my-nodegroup) for the nodes to uncordon, in addition to the nodes of the instances in the ASGs passed in with --asg-name.",
+	}
+	drainReportFlag = cli.StringFlag{
+		Name:  "drain-report",
+		Usage: "Path of a file to write a report to, listing each Pod evicted from the drained nodes, the node it was rescheduled on, how long it took to become Ready again, and the Pods that are still Pending.",
+	}
+	drainReportFormatFlag = cli.StringFlag{
+		Name:  "drain-report-format",
+		Value: string(eks.JSONDrainReportFormat),
+		Usage: "The format of the report written with --drain-report. Must be one of json or markdown. Defaults to json.",
+	}
+	drainReportWaitFlag = cli.DurationFlag{
+		Name:  "drain-report-wait",
+		Value: 5 * time.Minute,
+		Usage: "The length of time as duration (e.g 5m = 5 minutes) to wait for the evicted Pods to be Ready again before writing the report with --drain-report. Each drain (each wave, with deploy) waits for this long at most before moving on, so set this to 0 to write the report right away. Defaults to 5 minutes.",
+	}
+	podEvictionTimeoutFlag = cli.DurationFlag{
+		Name:  "pod-eviction-timeout",
+		Usage: "The length of time as duration (e.g 5m = 5 minutes) to wait for each Pod to be evicted when draining nodes, including retries on PodDisruptionBudget violations, before giving up. Defaults to zero, which means each Pod is only bounded by --drain-timeout.",
+	}
 	waitMaxRetriesFlag = cli.IntFlag{
 		Name:  "max-retries",
 		Value: 0,
@@ -60,6 +142,124 @@ var (
 		Value: 15 * time.Second,
 		Usage: "The amount of time to sleep between retries as duration (e.g 10m = 10 minutes) for retry loops during the command. The total amount of time this command will try is based on max-retries and sleep-between-retries. Defaults to 15 seconds.",
 	}
+	deployMaxSurgeFlag = cli.StringFlag{
+		Name:  "max-surge",
+		Value: "100%",
+		Usage: "The maximum number of instances that can be launched above the original capacity of the ASG during the roll out, as an absolute number (e.g 5) or a percentage of the original capacity (e.g 25%). Defaults to 100%.",
+	}
+	deployBatchSizeFlag = cli.StringFlag{
+		Name:  "batch-size",
+		Value: "100%",
+		Usage: "The maximum number of original instances to replace in each wave of the roll out, as an absolute number (e.g 5) or a percentage of the original capacity (e.g 25%). Each wave is further limited by --max-surge. Defaults to 100%.",
+	}
+	deployASGRolloutModeFlag = cli.StringFlag{
+		Name:  "asg-rollout-mode",
+		Value: string(eks.ParallelASGRollout),
+		Usage: "How to roll out the changes when multiple ASGs are provided with --asg-name. Must be one of parallel (each wave covers all the ASGs) or sequential (each ASG is fully rolled out before moving on to the next). Defaults to parallel.",
+	}
+	elbRegistrationPolicyFlag = cli.StringFlag{
+		Name:  "elb-registration-policy",
+		Value: string(eks.AnyELBRegistrationPolicy),
+		Usage: "How many of the new instances of each wave must be healthy in each external load balancer before the old nodes are drained. Must be one of any, all, or a percentage (e.g 50%). For load balancers using the IP target type, this applies to the Pods rescheduled by the drain instead, which are waited on before the old nodes are deregistered. For the load balancers of Services with externalTrafficPolicy set to Local, the new instances only need to be registered. Defaults to any.",
+	}
+	deployStateBackendFlag = cli.StringFlag{
+		Name:  "state-backend",
+		Value: string(eks.LocalDeployStateBackend),
+		Usage: "Where to store the recovery state of the deploy. Must be one of local (a file on the local disk), configmap (a ConfigMap in the EKS cluster), secret (a Secret in the EKS cluster), or s3 (an object in an S3 bucket). Defaults to local.",
+	}
+	deployStateFileFlag = cli.StringFlag{
+		Name:  "state-file",
+		Value: eks.DefaultStateFile,
+		Usage: fmt.Sprintf("The path to the recovery file when using the local state backend. Defaults to %s.", eks.DefaultStateFile),
+	}
+	deployStateNamespaceFlag = cli.StringFlag{
+		Name:  "state-namespace",
+		Value: eks.DefaultDeployStateNamespace,
+		Usage: "The Kubernetes Namespace of the ConfigMap or Secret when using the configmap or secret state backends. Defaults to kube-system.",
+	}
+	deployStateNameFlag = cli.StringFlag{
+		Name:  "state-name",
+		Value: eks.DefaultDeployStateName,
+		Usage: "The name of the ConfigMap or Secret when using the configmap or secret state backends. Defaults to kubergrunt-deploy-state.",
+	}
+	deployStateS3BucketFlag = cli.StringFlag{
+		Name:  "state-s3-bucket",
+		Usage: "The name of the S3 bucket to store the recovery state in. Required when using the s3 state backend.",
+	}
+	deployStateS3KeyFlag = cli.StringFlag{
+		Name:  "state-s3-key",
+		Value: eks.DefaultDeployStateS3Key,
+		Usage: "The S3 object key to store the recovery state at when using the s3 state backend. Defaults to kubergrunt/deploy.state.",
+	}
+	deployStateS3RegionFlag = cli.StringFlag{
+		Name:  "state-s3-region",
+		Usage: "The AWS region code of the S3 bucket when using the s3 state backend. Defaults to the value of --region.",
+	}
+	forceUnlockFlag = cli.BoolFlag{
+		Name:  "force-unlock",
+		Usage: "Take over the cluster lock even if it is held by another deploy or drain operation. Only use this if you are sure the holder of the lock is no longer running.",
+	}
+	lockTTLFlag = cli.DurationFlag{
+		Name:  "lock-ttl",
+		Value: eks.DefaultClusterLockTTL,
+		Usage: "The amount of time as duration (e.g 5m = 5 minutes) the cluster lock is held without renewal before it is considered abandoned. The lock is renewed every third of the TTL while the command runs. Defaults to 5 minutes.",
+	}
+	lockHolderFlag = cli.StringFlag{
+		Name:  "lock-holder",
+		Usage: "The identity to record as the holder of the cluster lock (e.g the name of the CI job), for use in error messages. Each run is told apart with a unique nonce, so runs with the same identity still exclude each other. Defaults to the hostname and process ID.",
+	}
+	pdbPreflightFlag = cli.StringFlag{
+		Name:  "pdb-preflight",
+		Value: string(eks.WarnPDBPreflight),
+		Usage: "What to do when the Pods on the nodes to be drained can never be evicted due to a PodDisruptionBudget (e.g maxUnavailable of 0, or minAvailable covering all replicas). Must be one of fail (exit before making any changes), warn (log the blocked Pods and continue), or off (skip the check). Defaults to warn.",
+	}
+	healthGateCommandFlag = cli.StringSliceFlag{
+		Name:  "health-gate-command",
+		Usage: "A shell command that must exit with 0 after the new nodes are ready and again after the old nodes are drained, before the roll out continues. Can be passed in multiple times.",
+	}
+	healthGateWorkloadFlag = cli.StringSliceFlag{
+		Name:  "health-gate-workload",
+		Usage: "A Deployment or StatefulSet, in the format KIND/NAMESPACE/NAME (e.g deployment/default/web), that must be fully available after the new nodes are ready and again after the old nodes are drained, before the roll out continues. Can be passed in multiple times.",
+	}
+	healthGateURLFlag = cli.StringSliceFlag{
+		Name:  "health-gate-url",
+		Usage: "An HTTP endpoint that must return a 2xx status code after the new nodes are ready and again after the old nodes are drained, before the roll out continues. Can be passed in multiple times.",
+	}
+	deployStrategyFlag = cli.StringFlag{
+		Name:  "strategy",
+		Value: string(eks.SurgeDeployStrategy),
+		Usage: "How to replace the instances of the ASGs. Must be one of surge (scale up the ASGs and replace the instances in waves) or instance-refresh (drive the native Instance Refresh of the ASGs). Defaults to surge.",
+	}
+	instanceRefreshMinHealthyPercentageFlag = cli.Int64Flag{
+		Name:  "instance-refresh-min-healthy-percentage",
+		Value: 90,
+		Usage: "The percentage of the desired capacity of the ASG that must remain healthy during the Instance Refresh. Only used with --strategy=instance-refresh. Defaults to 90.",
+	}
+	instanceRefreshMaxHealthyPercentageFlag = cli.Int64Flag{
+		Name:  "instance-refresh-max-healthy-percentage",
+		Usage: "The percentage of the desired capacity the ASG can grow to during the Instance Refresh. Values above 100 launch the replacement instances before terminating the original ones. Only used with --strategy=instance-refresh. Defaults to the ASG default.",
+	}
+	instanceRefreshWarmupFlag = cli.DurationFlag{
+		Name:  "instance-refresh-warmup",
+		Usage: "How long as duration (e.g 5m = 5 minutes) to wait after a new instance is healthy before moving on to the next instance. Only used with --strategy=instance-refresh. Defaults to the ASG default.",
+	}
+	instanceRefreshCheckpointPercentagesFlag = cli.Int64SliceFlag{
+		Name:  "instance-refresh-checkpoint-percentage",
+		Usage: "A percentage of the Instance Refresh at which to pause for --instance-refresh-checkpoint-delay. Can be passed in multiple times. Only used with --strategy=instance-refresh.",
+	}
+	instanceRefreshCheckpointDelayFlag = cli.DurationFlag{
+		Name:  "instance-refresh-checkpoint-delay",
+		Usage: "How long as duration (e.g 10m = 10 minutes) to pause at each checkpoint of the Instance Refresh. Only used with --strategy=instance-refresh.",
+	}
+	dryRunFlag = cli.BoolFlag{
+		Name:  "dry-run",
+		Usage: "Print the plan of what the command would do, without making any changes.",
+	}
+	planFormatFlag = cli.StringFlag{
+		Name:  "plan-format",
+		Value: string(eks.TextPlanFormat),
+		Usage: "The format of the plan printed with --dry-run. Must be one of text or json. Defaults to text.",
+	}
 	waitTimeoutFlag = cli.StringFlag{
 		Name:  "wait-timeout",
 		Value: "10m",
@@ -95,6 +295,34 @@ var (
 		Name:  "skip-aws-vpc-cni",
 		Usage: "Whether or not to skip syncing aws-vpc-cni service to EKS control plane version.",
 	}
+	syncKubeProxyVersionFlag = cli.StringFlag{
+		Name:  "kube-proxy-version",
+		Usage: "Pin the version of kube-proxy to sync to (e.g 1.29.15-minimal-eksbuild.2), instead of looking it up in the version catalog. With --use-addons and with migrate-to-addons, this is the add-on version.",
+	}
+	syncCoreDNSVersionFlag = cli.StringFlag{
+		Name:  "coredns-version",
+		Usage: "Pin the version of coredns to sync to (e.g 1.11.4-eksbuild.2), instead of looking it up in the version catalog. With --use-addons and with migrate-to-addons, this is the add-on version.",
+	}
+	syncVPCCNIVersionFlag = cli.StringFlag{
+		Name:  "aws-vpc-cni-version",
+		Usage: "Pin the version of aws-vpc-cni to sync to (e.g 1.19.6), instead of looking it up in the version catalog. With --use-addons and with migrate-to-addons, this is the add-on version.",
+	}
+	versionCatalogFlag = cli.StringFlag{
+		Name:  "version-catalog",
+		Usage: "Path to a YAML or JSON file with a catalog of core component versions, which extends the catalog built into kubergrunt. Entries in the file take precedence over the built-in ones. Run kubergrunt eks versions to see the resulting catalog.",
+	}
+	coreComponentsSnapshotDirFlag = cli.StringFlag{
+		Name:  "snapshot-dir",
+		Usage: "Path to a local directory where the snapshots of the core components are stored, in a subdirectory for each cluster. When omitted, the snapshots are stored as ConfigMaps in the kube-system namespace of the cluster.",
+	}
+	coreComponentsSnapshotIDFlag = cli.StringFlag{
+		Name:  "snapshot-id",
+		Usage: "The ID of the snapshot of the core components to restore, as logged by sync-core-components. When omitted, the latest snapshot is restored.",
+	}
+	syncUseAddonsFlag = cli.BoolFlag{
+		Name:  "use-addons",
+		Usage: "Whether or not to sync the core components as EKS add-ons. Use this for clusters that have been migrated with migrate-to-addons.",
+	}
 
 	// Flags for cleaning up security group
 	securityGroupIDFlag = cli.StringFlag{
@@ -206,7 +434,13 @@ There are three core applications on an EKS cluster:
 
 Each of these are managed in Kubernetes as DaemonSet, Deployment, and DaemonSet respectively. This command will use kubectl under the hood to patch the manifests to deploy the expected version based on what the current Kubernetes version is of the cluster. As such, this command should be run every time the Kubernetes version is updated on the EKS cluster.
 
-The versions deployed are based on what is listed in the official guide provided by AWS: https://docs.aws.amazon.com/eks/latest/userguide/update-cluster.html`,
+The versions deployed are based on what is listed in the official guide provided by AWS: https://docs.aws.amazon.com/eks/latest/userguide/update-cluster.html
+
+Pass in --dry-run to print the current and target image of each component, the coredns Corefile and ClusterRole compatibility patches that would be applied, and the diff of the VPC CNI manifest against the cluster (using kubectl diff, which does a server side dry run), without making any changes. The command exits with code 2 when any of the components have drifted from the expected configuration, so that it can be used as a CI check.
+
+For clusters where the core components are managed as EKS add-ons (see migrate-to-addons), pass in --use-addons. In this mode, each add-on is updated with the EKS add-ons API to the version that EKS recommends for the Kubernetes version of the cluster, preserving any customizations to the add-on resources. --dry-run is not supported with --use-addons.
+
+Before changing the components, this command saves a snapshot of the kube-proxy DaemonSet, the coredns Deployment, ConfigMap and ClusterRole, and the aws-node DaemonSet and ClusterRole, so that a failed upgrade can be rolled back with rollback-core-components. The snapshots are stored as ConfigMaps in the kube-system namespace, or in a subdirectory for the cluster of the directory passed in with --snapshot-dir. The 10 most recent snapshots of the cluster are kept. No snapshots are taken with --dry-run or --use-addons.`,
 				Action: syncClusterComponents,
 				Flags: []cli.Flag{
 					eksClusterArnFlag,
@@ -215,32 +449,150 @@ The versions deployed are based on what is listed in the official guide provided
 					syncSkipKubeProxyFlag,
 					syncSkipCoreDNSFlag,
 					syncSkipVPCCNIFlag,
+					syncKubeProxyVersionFlag,
+					syncCoreDNSVersionFlag,
+					syncVPCCNIVersionFlag,
+					versionCatalogFlag,
+					syncUseAddonsFlag,
+					dryRunFlag,
+					coreComponentsSnapshotDirFlag,
+				},
+			},
+			cli.Command{
+				Name:  "rollback-core-components",
+				Usage: "Restore the core Kubernetes applications deployed on to the EKS cluster to a snapshot taken by sync-core-components.",
+				Description: `Restore the core Kubernetes applications deployed on to an EKS cluster (kube-proxy, coredns, and the VPC CNI Plugin) to a snapshot that sync-core-components saved before changing them. Use this when an upgrade of the components breaks DNS resolution or Pod networking.
+
+The resources recorded in the snapshot replace the resources in the cluster, and this command waits until the restored DaemonSets and Deployments have rolled out. Pass in --snapshot-id to choose the snapshot to restore, otherwise the latest snapshot is restored. If the snapshots were stored in a local directory, pass in the same --snapshot-dir that was used with sync-core-components.`,
+				Action: rollbackCoreComponents,
+				Flags: []cli.Flag{
+					eksClusterArnFlag,
+					coreComponentsSnapshotIDFlag,
+					coreComponentsSnapshotDirFlag,
+					waitTimeoutFlag,
+				},
+			},
+			cli.Command{
+				Name:  "versions",
+				Usage: "Print the matrix of core component versions that sync-core-components deploys for each Kubernetes version.",
+				Description: `Print the matrix of core component versions (kube-proxy, coredns, and the VPC CNI Plugin) that sync-core-components deploys for each supported Kubernetes version, along with the AWS accounts hosting the container images in each region.
+
+Pass in --version-catalog to print the catalog that results from extending the built-in catalog with the given catalog file. The resulting catalog is validated, and the command fails if any problems are found (e.g a supported Kubernetes version that is missing a component version), so this can be used to check a catalog file before using it with sync-core-components.`,
+				Action: printVersionCatalog,
+				Flags: []cli.Flag{
+					versionCatalogFlag,
+				},
+			},
+			cli.Command{
+				Name:  "migrate-to-addons",
+				Usage: "Adopt the self managed core components of the EKS cluster as EKS managed add-ons.",
+				Description: `Migrate the core Kubernetes applications deployed on to an EKS cluster (kube-proxy, coredns, and the VPC CNI Plugin) from self managed resources to EKS managed add-ons. For each component, this command will:
+
+  1. Look up the add-on version that EKS recommends for the Kubernetes version of the cluster.
+  2. Create the add-on with resolveConflicts=PRESERVE, so that EKS adopts the existing resources while keeping any customizations to them.
+  3. Wait for the add-on to be ACTIVE.
+
+The add-on version is never older than the version of the running component (the tag of its image), as that would downgrade the component: when the recommended version is older, the latest compatible version is used instead, and the command fails if that is older as well. Use --kube-proxy-version, --coredns-version, and --aws-vpc-cni-version to pin the add-on versions instead.
+
+Components that are already managed as add-ons are left as is, so an interrupted migration can be resumed by running the command again. Once migrated, use "kubergrunt eks sync-core-components --use-addons" to keep the add-ons up to date with the Kubernetes version.`,
+				Action: migrateToAddons,
+				Flags: []cli.Flag{
+					eksClusterArnFlag,
+					syncSkipKubeProxyFlag,
+					syncSkipCoreDNSFlag,
+					syncSkipVPCCNIFlag,
+					syncKubeProxyVersionFlag,
+					syncCoreDNSVersionFlag,
+					syncVPCCNIVersionFlag,
+					waitMaxRetriesFlag,
+					waitSleepBetweenRetriesFlag,
 				},
 			},
 			cli.Command{
 				Name:  "deploy",
 				Usage: "Zero downtime roll out of cluster updates to worker nodes.",
-				Description: `Performs a zero downtime rolling deployment of changes to the underlying EC2 instances in an EKS cluster. This subcommand will:
+				Description: `Performs a zero downtime rolling deployment of changes to the underlying EC2 instances in an EKS cluster. This subcommand will roll out the change in waves, where each wave will:
 
-  1. Double the desired capacity of the Auto Scaling Group that powers the EKS Cluster. This will launch new EKS workers with the new launch configuration.
-  2. Wait for the new nodes to be ready for Pod scheduling in Kubernetes.
+  1. Increase the desired capacity of the Auto Scaling Groups that power the EKS Cluster. This will launch new EKS workers with the new launch configuration.
+  2. Wait for the new nodes to be ready for Pod scheduling in Kubernetes, and to be healthy in the external load balancers.
   3. Cordon the old nodes in the cluster so that they won't be able to schedule new Pods.
   4. Drain the pods scheduled on the old EKS workers (using the equivalent of "kubectl drain"), so that they will be rescheduled on the new EKS workers.
-  5. Wait for all the pods to migrate off of the old EKS workers.
-  6. Set the desired capacity down to the original value and remove the old EKS workers from the ASG.
+  5. Wait for all the pods to migrate off of the old EKS workers, and for the rescheduled Pods to be healthy in the load balancers that use the IP target type.
+  6. Deregister the old EKS workers from the external load balancers, and wait for connection draining to finish.
+  7. Set the desired capacity down to the original value and remove the old EKS workers from the Auto Scaling Groups.
+
+By default, this doubles the desired capacity and replaces all the old EKS workers in a single wave. For large Auto Scaling Groups, use --max-surge to limit how many instances are launched above the original capacity, and --batch-size to limit how many old EKS workers are replaced in each wave. Both can be expressed as an absolute number of instances (e.g 5) or as a percentage of the original capacity (e.g 25%), and are applied to each Auto Scaling Group individually. You can roll out multiple Auto Scaling Groups by passing in --asg-name multiple times: by default each wave replaces old EKS workers in all the Auto Scaling Groups together, while --asg-rollout-mode=sequential fully rolls out each Auto Scaling Group before moving on to the next.
+
+The external load balancers are the ones of the LoadBalancer Services, and of the Ingress resources managed by the AWS Load Balancer Controller. Load balancers provisioned for Gateway API resources are not detected, and are not waited on. Use --elb-registration-policy to choose how many of the new instances must be healthy in each load balancer (any, all, or a percentage). The new instances only need to be registered to the load balancers of Services with externalTrafficPolicy set to Local, as their health checks only pass on the nodes running the Pods of the Service, which the new nodes don't until the old nodes are drained. Before they are deregistered, the old nodes are labeled with node.kubernetes.io/exclude-from-external-load-balancers so that the load balancer controllers don't register them again.
+
+Before making any changes, this command checks the Pods on the nodes to be drained against the PodDisruptionBudgets in the cluster, and reports evictions that can never succeed (e.g a PodDisruptionBudget with maxUnavailable of 0, or a single replica Deployment covered by a PodDisruptionBudget with minAvailable of 1), since these would cause the drain to hang until it times out. Use --pdb-preflight to choose whether to fail, warn (the default), or skip the check. To also gate the roll out on application health, declare health gates with --health-gate-command (a shell command that must exit with 0), --health-gate-workload (a Deployment or StatefulSet that must be fully available), and --health-gate-url (an HTTP endpoint that must return 2xx). The gates are checked after the new nodes of each wave are ready, and again after the old nodes are drained, retrying with --max-retries and --sleep-between-retries. If a gate does not pass, the deploy stops with an error naming the gate, and keeps the recovery state so that it can be resumed or rolled back.
 
 Note that to minimize service disruption from this command, your services should setup a PodDisruptionBudget, a readiness probe that fails on container shutdown events, and implement graceful handling of SIGTERM in the container.
 
-This command includes retry loops for certain stages (e.g waiting for the ASG to scale up). This retry loop is configurable with the options --max-retries and --sleep-between-retries. The command will try up to --max-retries times, sleeping for the duration specified by --sleep-between-retries inbetween each failed attempt.
+This command includes retry loops for certain stages (e.g waiting for the ASG to scale up). This retry loop is configurable with the options --max-retries and --sleep-between-retries. The command will try up to --max-retries times, sleeping for the duration specified by --sleep-between-retries inbetween each failed attempt. If max-retries is unspecified, this command will use a value that translates to a total wait time of 5 minutes per wave of ASG, where each wave is 10 instances. For example, if the number of instances in the ASG is 15 instances, this translates to 2 waves, which leads to a total wait time of 10 minutes. To achieve a 10 minute wait time with the default sleep between retries (15 seconds), the max retries needs to be set to 40.
 
-If max-retries is unspecified, this command will use a value that translates to a total wait time of 5 minutes per wave of ASG, where each wave is 10 instances. For example, if the number of instances in the ASG is 15 instances, this translates to 2 waves, which leads to a total wait time of 10 minutes. To achieve a 10 minute wait time with the default sleep between retries (15 seconds), the max retries needs to be set to 40.
+As the deploy command contains multiple stages, this command also generates a recovery file (.kubergrunt.state) containing the current deploy state in the working directory. The state file is used to resume the deploy operation from the point of failure, and is automatically deleted upon completion of the command. You can optionally ignore the state file with --ignore-recovery-file flag, which will generate a new recovery file. Use --state-backend to store the recovery state in a ConfigMap or Secret in the EKS cluster (configmap or secret), or in an S3 bucket (s3) instead, so that the deploy can be resumed from a different machine. If the deploy fails partway, you can also undo the wave that was in progress with "kubergrunt eks deploy rollback". Refer to the help text of the rollback subcommand for more details.
 
-As the deploy command contains multiple stages, this command also generates a recovery file (.kubergrunt.state) containing the current deploy state in the working directory. The state file is used to resume the deploy operation from the point of failure, and is automatically deleted upon completion of the command. You can optionally ignore the state file with --ignore-recovery-file flag, which will generate a new recovery file.
+To prevent multiple deploy or drain operations from fighting over the same cluster, this command holds a lock on the cluster (a Lease named kubergrunt-lock in the kube-system Namespace) for its entire duration. If the lock is held by someone else, the command fails with an error naming the holder. The lock expires if it is not renewed within --lock-ttl, and can be forcefully taken over with --force-unlock.
+
+To see what the deploy would do before running it, pass in --dry-run. This runs all the read only lookups (the Auto Scaling Groups with their current and target capacities, the instances and Kubernetes nodes that will be cordoned and drained in each wave along with the Pods that will be evicted, and the load balancers the new nodes must register to), makes no changes, and prints the plan in the format given by --plan-format (text or json).
+
+By default, this command uses the surge strategy described above. Alternatively, pass in --strategy=instance-refresh to replace the instances with the native Instance Refresh of the Auto Scaling Groups. In this mode, kubergrunt adds a lifecycle hook (kubergrunt-drain) to the Auto Scaling Groups, starts an Instance Refresh on each of them, and cordons and drains each old EKS worker while the lifecycle hook holds it in the Terminating:Wait state, before releasing it to be terminated. The pace of the refresh is controlled with the --instance-refresh-* options. Running "kubergrunt eks deploy rollback" on an interrupted instance refresh cancels the refreshes with CancelInstanceRefresh. Note that the old EKS workers that were already replaced are not restored: to revert them, restore the previous launch configuration and deploy again. --dry-run, --max-surge, and --batch-size only apply to the surge strategy.
+
+To roll out EKS managed node groups, pass in --nodegroup-name (along with --eks-cluster-name or --eks-cluster-arn) instead of --asg-name. In this mode, the command looks up the Auto Scaling Groups and nodes of each node group through the EKS API, runs the PodDisruptionBudget preflight check, and triggers UpdateNodegroupVersion: use --nodegroup-kubernetes-version and --nodegroup-release-version to update the AMI, or --nodegroup-launch-template-version to bump the launch template version. EKS replaces and drains the nodes itself, while the command tracks the update and reports the Pods that were evicted from the original nodes, along with any PodDisruptionBudget eviction failures. If the command is interrupted, running it again resumes tracking the update in progress.
 `,
 				Action: rollOutDeployment,
+				Subcommands: cli.Commands{
+					cli.Command{
+						Name:  "rollback",
+						Usage: "Roll back an interrupted deploy using the recovery state.",
+						Description: `Rolls back a deploy that was interrupted partway (e.g because the new nodes never became ready, or a drain timed out), using the recovery state recorded by the deploy command. This subcommand will undo the wave that was in progress:
+
+  1. Uncordon the old nodes of the wave so that Pods can be scheduled on them again.
+  2. If the old nodes were being deregistered from the external load balancers, remove the node.kubernetes.io/exclude-from-external-load-balancers label, register them again, and wait until they are healthy.
+  3. Cordon the new nodes launched in the wave so that they won't be able to schedule new Pods.
+  4. Drain the pods scheduled on the new nodes (using the equivalent of "kubectl drain"), so that they will be rescheduled on the old nodes.
+  5. Remove the new nodes from the Auto Scaling Groups and terminate them.
+  6. Restore the original desired capacity and max size of the Auto Scaling Groups.
+
+Waves that were fully completed before the interruption are not undone. A roll back is not possible once the old nodes of the interrupted wave have been detached from the Auto Scaling Group; resume the deploy instead.
+
+If the deploy was using --strategy=instance-refresh, this subcommand instead cancels the Instance Refresh of each Auto Scaling Group with CancelInstanceRefresh, drains any old EKS workers that the refresh was already terminating, and removes the lifecycle hooks. The old EKS workers that were already replaced are not restored.
+
+Like the deploy command, each step is checkpointed in the recovery state so that the roll back can be resumed from the point of failure. The recovery state is deleted upon completion of the roll back. Use the same --state-backend options that were passed to the deploy command.
+`,
+						Action: rollbackDeployment,
+						Flags: []cli.Flag{
+							clusterRegionFlag,
+							eksKubectlContextNameFlag,
+							genericKubeconfigFlag,
+							genericKubectlServerFlag,
+							genericKubectlCAFlag,
+							genericKubectlTokenFlag,
+							genericKubectlEKSClusterArnFlag,
+							drainTimeoutFlag,
+							deleteEmptyDirDataFlag,
+							podEvictionTimeoutFlag,
+							waitMaxRetriesFlag,
+							waitSleepBetweenRetriesFlag,
+							deployStateBackendFlag,
+							deployStateFileFlag,
+							deployStateNamespaceFlag,
+							deployStateNameFlag,
+							deployStateS3BucketFlag,
+							deployStateS3KeyFlag,
+							deployStateS3RegionFlag,
+							forceUnlockFlag,
+							lockTTLFlag,
+							lockHolderFlag,
+						},
+					},
+				},
 				Flags: []cli.Flag{
 					clusterRegionFlag,
 					clusterAsgNameFlag,
+					nodegroupNameFlag,
+					eksClusterNameFlag,
 					eksKubectlContextNameFlag,
 					genericKubeconfigFlag,
 					genericKubectlServerFlag,
@@ -249,9 +601,50 @@ As the deploy command contains multiple stages, this command also generates a re
 					genericKubectlEKSClusterArnFlag,
 					drainTimeoutFlag,
 					deleteEmptyDirDataFlag,
+					podEvictionTimeoutFlag,
+					maxConcurrentDrainsFlag,
+					drainOrderFlag,
+					failFastFlag,
+					jobGracePeriodFlag,
+					jobWaitAnnotationFlag,
+					forceDeleteStuckPodsFlag,
+					stuckPodGracePeriodFlag,
+					drainReportFlag,
+					drainReportFormatFlag,
+					drainReportWaitFlag,
 					waitMaxRetriesFlag,
 					waitSleepBetweenRetriesFlag,
 					ignoreRecoveryFileFlag,
+					deployMaxSurgeFlag,
+					deployBatchSizeFlag,
+					deployASGRolloutModeFlag,
+					elbRegistrationPolicyFlag,
+					deployStateBackendFlag,
+					deployStateFileFlag,
+					deployStateNamespaceFlag,
+					deployStateNameFlag,
+					deployStateS3BucketFlag,
+					deployStateS3KeyFlag,
+					deployStateS3RegionFlag,
+					forceUnlockFlag,
+					lockTTLFlag,
+					lockHolderFlag,
+					pdbPreflightFlag,
+					healthGateCommandFlag,
+					healthGateWorkloadFlag,
+					healthGateURLFlag,
+					deployStrategyFlag,
+					instanceRefreshMinHealthyPercentageFlag,
+					instanceRefreshMaxHealthyPercentageFlag,
+					instanceRefreshWarmupFlag,
+					instanceRefreshCheckpointPercentagesFlag,
+					instanceRefreshCheckpointDelayFlag,
+					nodegroupKubernetesVersionFlag,
+					nodegroupReleaseVersionFlag,
+					nodegroupLaunchTemplateVersionFlag,
+					nodegroupForceFlag,
+					dryRunFlag,
+					planFormatFlag,
 				},
 			},
 			cli.Command{
@@ -268,11 +661,21 @@ To drain the Auto Scaling Group "my-asg" in the region "us-east-2":
 You can also drain multiple ASGs by providing the "--asg-name" option multiple times:
 
   kubergrunt eks drain --asg-name my-asg-a --asg-name my-asg-b --asg-name my-asg-c --region us-east-2
+
+To drain EKS managed node groups, pass in --nodegroup-name (along with --eks-cluster-name or --eks-cluster-arn). The Auto Scaling Groups backing the node groups are looked up through the EKS API, and drained along with any Auto Scaling Groups given with --asg-name.
+
+Like the deploy command, this command checks the Pods against the PodDisruptionBudgets in the cluster before cordoning the nodes, and reports evictions that can never succeed. Use --pdb-preflight to choose whether to fail, warn (the default), or skip the check.
+
+Pass in --dry-run to print the instances and Kubernetes nodes that will be cordoned and drained, along with the Pods that will be evicted, without making any changes.
+
+This command holds the same cluster lock as the deploy command while draining, so that only one deploy or drain operation runs against the cluster at a time. Use --force-unlock to take over a lock that was left behind by an operation that is no longer running.
 `,
 				Action: drainASG,
 				Flags: []cli.Flag{
 					clusterRegionFlag,
 					clusterAsgNameFlag,
+					nodegroupNameFlag,
+					eksClusterNameFlag,
 					eksKubectlContextNameFlag,
 					genericKubeconfigFlag,
 					genericKubectlServerFlag,
@@ -281,6 +684,58 @@ You can also drain multiple ASGs by providing the "--asg-name" option multiple t
 					genericKubectlEKSClusterArnFlag,
 					drainTimeoutFlag,
 					deleteEmptyDirDataFlag,
+					podEvictionTimeoutFlag,
+					maxConcurrentDrainsFlag,
+					drainOrderFlag,
+					failFastFlag,
+					jobGracePeriodFlag,
+					jobWaitAnnotationFlag,
+					forceDeleteStuckPodsFlag,
+					stuckPodGracePeriodFlag,
+					drainReportFlag,
+					drainReportFormatFlag,
+					drainReportWaitFlag,
+					forceUnlockFlag,
+					lockTTLFlag,
+					lockHolderFlag,
+					pdbPreflightFlag,
+					dryRunFlag,
+					planFormatFlag,
+				},
+			},
+			cli.Command{
+				Name:  "uncordon",
+				Usage: "Uncordon all the nodes of the instances in the provided Auto Scaling Groups.",
+				Description: `Uncordon the nodes of the instances in the provided Auto Scaling Groups, making them schedulable again. This can be used to recover from a drain that failed or was interrupted, which leaves the nodes cordoned. Nodes that were labeled with node.kubernetes.io/exclude-from-external-load-balancers by an interrupted deploy are also added back to the external load balancers, by removing the label.
+
+To uncordon the nodes of the Auto Scaling Group "my-asg" in the region "us-east-2":
+
+  kubergrunt eks uncordon --asg-name my-asg --region us-east-2
+
+Like the drain command, you can pass in --asg-name multiple times, or pass in EKS managed node groups with --nodegroup-name (along with --eks-cluster-name or --eks-cluster-arn).
+
+To uncordon nodes that are not in an Auto Scaling Group, or without looking up the instances through AWS, select the nodes by label with --node-selector:
+
+  kubergrunt eks uncordon --node-selector eks.amazonaws.com/nodegroup=my-nodegroup
+
+This command holds the same cluster lock as the deploy and drain commands, so that it does not uncordon the nodes of an operation that is still running. Use --force-unlock to take over a lock that was left behind by an operation that is no longer running.
+`,
+				Action: uncordonNodes,
+				Flags: []cli.Flag{
+					clusterRegionFlag,
+					clusterAsgNameFlag,
+					nodegroupNameFlag,
+					eksClusterNameFlag,
+					nodeSelectorFlag,
+					eksKubectlContextNameFlag,
+					genericKubeconfigFlag,
+					genericKubectlServerFlag,
+					genericKubectlCAFlag,
+					genericKubectlTokenFlag,
+					genericKubectlEKSClusterArnFlag,
+					forceUnlockFlag,
+					lockTTLFlag,
+					lockHolderFlag,
 				},
 			},
 			cli.Command{
@@ -393,6 +848,12 @@ func getOIDCThumbprint(cliContext *cli.Context) error {
 
 // Command action for `kubergrunt eks deploy`
 func rollOutDeployment(cliContext *cli.Context) error {
+	// Validate the plan format up front, before looking anything up.
+	planFormat := eks.PlanFormat(cliContext.String(planFormatFlag.Name))
+	if err := eks.ValidatePlanFormat(planFormat); err != nil {
+		return err
+	}
+
 	kubectlOptions, err := parseKubectlOptions(cliContext)
 	if err != nil {
 		return err
@@ -403,33 +864,164 @@ func rollOutDeployment(cliContext *cli.Context) error {
 		return errors.WithStackTrace(err)
 	}
 
-	// TODO: handle multiple ASGs correctly
 	asgNames := cliContext.StringSlice(clusterAsgNameFlag.Name)
-	if len(asgNames) != 1 {
-		return ExactlyOneASGErr{flagName: clusterAsgNameFlag.Name}
+	nodegroupNames := cliContext.StringSlice(nodegroupNameFlag.Name)
+	if len(nodegroupNames) > 0 {
+		return rollOutNodegroups(cliContext, region, asgNames, nodegroupNames)
+	}
+	if len(asgNames) == 0 {
+		return entrypoint.NewRequiredArgsError("You must provide at least one ASG Name with --asg-name, or node group name with --nodegroup-name.")
 	}
-	asgName := asgNames[0]
 
-	drainTimeout := cliContext.Duration(drainTimeoutFlag.Name)
-	deleteEmptyDirData := cliContext.Bool(deleteEmptyDirDataFlag.Name)
 	ignoreRecoveryFile := cliContext.Bool(ignoreRecoveryFileFlag.Name)
 	waitMaxRetries := cliContext.Int(waitMaxRetriesFlag.Name)
 	waitSleepBetweenRetries := cliContext.Duration(waitSleepBetweenRetriesFlag.Name)
+	maxSurge := cliContext.String(deployMaxSurgeFlag.Name)
+	batchSize := cliContext.String(deployBatchSizeFlag.Name)
+	rolloutMode := eks.ASGRolloutMode(cliContext.String(deployASGRolloutModeFlag.Name))
+	strategy := eks.DeployStrategy(cliContext.String(deployStrategyFlag.Name))
+
+	if cliContext.Bool(dryRunFlag.Name) {
+		if strategy != eks.SurgeDeployStrategy {
+			return errors.WithStackTrace(UnsupportedDryRunStrategyErr{strategy: strategy})
+		}
+		plan, err := eks.PlanDeployment(region, asgNames, kubectlOptions, maxSurge, batchSize, rolloutMode)
+		if err != nil {
+			return err
+		}
+		return plan.Write(os.Stdout, planFormat)
+	}
 
 	return eks.RollOutDeployment(
 		region,
-		asgName,
+		asgNames,
 		kubectlOptions,
-		drainTimeout,
-		deleteEmptyDirData,
+		parseDrainOptions(cliContext),
 		waitMaxRetries,
 		waitSleepBetweenRetries,
 		ignoreRecoveryFile,
+		maxSurge,
+		batchSize,
+		rolloutMode,
+		parseDeployStateBackendConfig(cliContext),
+		parseClusterLockOptions(cliContext),
+		eks.PDBPreflightMode(cliContext.String(pdbPreflightFlag.Name)),
+		eks.HealthGatesConfig{
+			Commands:  cliContext.StringSlice(healthGateCommandFlag.Name),
+			Workloads: cliContext.StringSlice(healthGateWorkloadFlag.Name),
+			URLs:      cliContext.StringSlice(healthGateURLFlag.Name),
+		},
+		strategy,
+		eks.InstanceRefreshOptions{
+			MinHealthyPercentage:  cliContext.Int64(instanceRefreshMinHealthyPercentageFlag.Name),
+			MaxHealthyPercentage:  cliContext.Int64(instanceRefreshMaxHealthyPercentageFlag.Name),
+			InstanceWarmup:        cliContext.Duration(instanceRefreshWarmupFlag.Name),
+			CheckpointPercentages: cliContext.Int64Slice(instanceRefreshCheckpointPercentagesFlag.Name),
+			CheckpointDelay:       cliContext.Duration(instanceRefreshCheckpointDelayFlag.Name),
+		},
+		parseDrainReportOptions(cliContext),
+		eks.ELBRegistrationPolicy(cliContext.String(elbRegistrationPolicyFlag.Name)),
+	)
+}
+
+// rollOutNodegroups is the command action for `kubergrunt eks deploy` when rolling out EKS managed node groups.
+func rollOutNodegroups(cliContext *cli.Context, region string, asgNames []string, nodegroupNames []string) error {
+	if len(asgNames) > 0 {
+		return errors.WithStackTrace(MutuallyExclusiveFlagError{Message: "--asg-name and --nodegroup-name can not be used together with deploy."})
+	}
+	if cliContext.Bool(dryRunFlag.Name) {
+		return errors.WithStackTrace(MutuallyExclusiveFlagError{Message: "--dry-run is not supported with --nodegroup-name."})
+	}
+
+	kubectlOptions, err := parseKubectlOptions(cliContext)
+	if err != nil {
+		return err
+	}
+	clusterName, err := getEKSClusterName(cliContext)
+	if err != nil {
+		return err
+	}
+
+	return eks.DeployNodegroups(
+		region,
+		clusterName,
+		nodegroupNames,
+		kubectlOptions,
+		eks.NodegroupUpdateOptions{
+			KubernetesVersion:     cliContext.String(nodegroupKubernetesVersionFlag.Name),
+			ReleaseVersion:        cliContext.String(nodegroupReleaseVersionFlag.Name),
+			LaunchTemplateVersion: cliContext.String(nodegroupLaunchTemplateVersionFlag.Name),
+			Force:                 cliContext.Bool(nodegroupForceFlag.Name),
+		},
+		cliContext.Int(waitMaxRetriesFlag.Name),
+		cliContext.Duration(waitSleepBetweenRetriesFlag.Name),
+		parseClusterLockOptions(cliContext),
+		eks.PDBPreflightMode(cliContext.String(pdbPreflightFlag.Name)),
 	)
 }
 
+// getEKSClusterName returns the name of the EKS cluster of the managed node groups, either from --eks-cluster-name or
+// from the ARN in --eks-cluster-arn.
+func getEKSClusterName(cliContext *cli.Context) (string, error) {
+	clusterName := cliContext.String(eksClusterNameFlag.Name)
+	if clusterName != "" {
+		return clusterName, nil
+	}
+	eksClusterArn := cliContext.String(KubectlEKSClusterArnFlagName)
+	if eksClusterArn == "" {
+		return "", entrypoint.NewRequiredArgsError("You must provide the EKS cluster with --eks-cluster-name or --eks-cluster-arn when using --nodegroup-name.")
+	}
+	clusterName, err := eksawshelper.GetClusterNameFromArn(eksClusterArn)
+	return clusterName, errors.WithStackTrace(err)
+}
+
+// Command action for `kubergrunt eks deploy rollback`
+func rollbackDeployment(cliContext *cli.Context) error {
+	kubectlOptions, err := parseKubectlOptions(cliContext)
+	if err != nil {
+		return err
+	}
+
+	region, err := entrypoint.StringFlagRequiredE(cliContext, clusterRegionFlag.Name)
+	if err != nil {
+		return errors.WithStackTrace(err)
+	}
+
+	waitMaxRetries := cliContext.Int(waitMaxRetriesFlag.Name)
+	waitSleepBetweenRetries := cliContext.Duration(waitSleepBetweenRetriesFlag.Name)
+
+	return eks.RollbackDeployment(
+		region,
+		kubectlOptions,
+		parseDrainOptions(cliContext),
+		waitMaxRetries,
+		waitSleepBetweenRetries,
+		parseDeployStateBackendConfig(cliContext),
+		parseClusterLockOptions(cliContext),
+	)
+}
+
+// parseDeployStateBackendConfig extracts the deploy state backend configuration from the CLI flags.
+func parseDeployStateBackendConfig(cliContext *cli.Context) eks.DeployStateBackendConfig {
+	return eks.DeployStateBackendConfig{
+		Type:      eks.DeployStateBackendType(cliContext.String(deployStateBackendFlag.Name)),
+		Path:      cliContext.String(deployStateFileFlag.Name),
+		Namespace: cliContext.String(deployStateNamespaceFlag.Name),
+		Name:      cliContext.String(deployStateNameFlag.Name),
+		S3Bucket:  cliContext.String(deployStateS3BucketFlag.Name),
+		S3Key:     cliContext.String(deployStateS3KeyFlag.Name),
+		S3Region:  cliContext.String(deployStateS3RegionFlag.Name),
+	}
+}
+
 // Command action for `kubergrunt eks drain`
 func drainASG(cliContext *cli.Context) error {
+	// Validate the plan format up front, before looking anything up.
+	planFormat := eks.PlanFormat(cliContext.String(planFormatFlag.Name))
+	if err := eks.ValidatePlanFormat(planFormat); err != nil {
+		return err
+	}
+
 	kubectlOptions, err := parseKubectlOptions(cliContext)
 	if err != nil {
 		return err
@@ -440,22 +1032,121 @@ func drainASG(cliContext *cli.Context) error {
 		return errors.WithStackTrace(err)
 	}
 
-	asgNames := cliContext.StringSlice(clusterAsgNameFlag.Name)
+	asgNames, err := getASGNamesWithNodegroups(cliContext, region)
+	if err != nil {
+		return err
+	}
 	if len(asgNames) == 0 {
-		return entrypoint.NewRequiredArgsError("You must provide at least one ASG Name with --asg-name.")
+		return entrypoint.NewRequiredArgsError("You must provide at least one ASG Name with --asg-name, or node group name with --nodegroup-name.")
+	}
+
+	if cliContext.Bool(dryRunFlag.Name) {
+		plan, err := eks.PlanDrain(region, asgNames, kubectlOptions)
+		if err != nil {
+			return err
+		}
+		return plan.Write(os.Stdout, planFormat)
 	}
 
-	drainTimeout := cliContext.Duration(drainTimeoutFlag.Name)
-	deleteEmptyDirData := cliContext.Bool(deleteEmptyDirDataFlag.Name)
 	return eks.DrainASG(
 		region,
 		asgNames,
 		kubectlOptions,
-		drainTimeout,
-		deleteEmptyDirData,
+		parseDrainOptions(cliContext),
+		parseClusterLockOptions(cliContext),
+		eks.PDBPreflightMode(cliContext.String(pdbPreflightFlag.Name)),
+		parseDrainReportOptions(cliContext),
 	)
 }
 
+// getASGNamesWithNodegroups returns the ASGs passed in with --asg-name, along with the ASGs backing the managed node
+// groups passed in with --nodegroup-name.
+func getASGNamesWithNodegroups(cliContext *cli.Context, region string) ([]string, error) {
+	asgNames := cliContext.StringSlice(clusterAsgNameFlag.Name)
+	nodegroupNames := cliContext.StringSlice(nodegroupNameFlag.Name)
+	if len(nodegroupNames) == 0 {
+		return asgNames, nil
+	}
+	clusterName, err := getEKSClusterName(cliContext)
+	if err != nil {
+		return nil, err
+	}
+	nodegroupASGNames, err := eks.ResolveNodegroupASGs(region, clusterName, nodegroupNames)
+	if err != nil {
+		return nil, err
+	}
+	return append(asgNames, nodegroupASGNames...), nil
+}
+
+// Command action for `kubergrunt eks uncordon`
+func uncordonNodes(cliContext *cli.Context) error {
+	kubectlOptions, err := parseKubectlOptions(cliContext)
+	if err != nil {
+		return err
+	}
+
+	nodeSelector := cliContext.String(nodeSelectorFlag.Name)
+	hasASGs := len(cliContext.StringSlice(clusterAsgNameFlag.Name)) > 0 || len(cliContext.StringSlice(nodegroupNameFlag.Name)) > 0
+	if !hasASGs && nodeSelector == "" {
+		return entrypoint.NewRequiredArgsError("You must provide at least one ASG Name with --asg-name, node group name with --nodegroup-name, or a node selector with --node-selector.")
+	}
+
+	region := ""
+	asgNames := []string{}
+	if hasASGs {
+		region, err = entrypoint.StringFlagRequiredE(cliContext, clusterRegionFlag.Name)
+		if err != nil {
+			return errors.WithStackTrace(err)
+		}
+		asgNames, err = getASGNamesWithNodegroups(cliContext, region)
+		if err != nil {
+			return err
+		}
+	}
+
+	return eks.Uncordon(
+		region,
+		asgNames,
+		nodeSelector,
+		kubectlOptions,
+		parseClusterLockOptions(cliContext),
+	)
+}
+
+// parseDrainOptions extracts the options for draining nodes from the CLI flags.
+func parseDrainOptions(cliContext *cli.Context) kubectl.DrainOptions {
+	return kubectl.DrainOptions{
+		Timeout:              cliContext.Duration(drainTimeoutFlag.Name),
+		PodTimeout:           cliContext.Duration(podEvictionTimeoutFlag.Name),
+		DeleteEmptyDirData:   cliContext.Bool(deleteEmptyDirDataFlag.Name),
+		MaxConcurrentDrains:  cliContext.Int(maxConcurrentDrainsFlag.Name),
+		Order:                kubectl.DrainOrder(cliContext.String(drainOrderFlag.Name)),
+		FailFast:             cliContext.Bool(failFastFlag.Name),
+		JobGracePeriod:       cliContext.Duration(jobGracePeriodFlag.Name),
+		WaitAnnotation:       cliContext.String(jobWaitAnnotationFlag.Name),
+		ForceDeleteStuckPods: cliContext.Bool(forceDeleteStuckPodsFlag.Name),
+		StuckPodGracePeriod:  cliContext.Duration(stuckPodGracePeriodFlag.Name),
+	}
+}
+
+// parseDrainReportOptions extracts the drain report configuration from the CLI flags.
+func parseDrainReportOptions(cliContext *cli.Context) eks.DrainReportOptions {
+	return eks.DrainReportOptions{
+		Path:         cliContext.String(drainReportFlag.Name),
+		Format:       eks.DrainReportFormat(cliContext.String(drainReportFormatFlag.Name)),
+		WaitForReady: cliContext.Duration(drainReportWaitFlag.Name),
+	}
+}
+
+// parseClusterLockOptions extracts the cluster lock configuration from the CLI flags.
+func parseClusterLockOptions(cliContext *cli.Context) eks.ClusterLockOptions {
+	return eks.ClusterLockOptions{
+		HolderIdentity: cliContext.String(lockHolderFlag.Name),
+		TTL:            cliContext.Duration(lockTTLFlag.Name),
+		ForceUnlock:    cliContext.Bool(forceUnlockFlag.Name),
+	}
+}
+
 // Command action for `kubergrunt eks sync-core-components`
 func syncClusterComponents(cliContext *cli.Context) error {
 	eksClusterArn, err := entrypoint.StringFlagRequiredE(cliContext, eksClusterArnFlag.Name)
@@ -467,7 +1158,86 @@ func syncClusterComponents(cliContext *cli.Context) error {
 	skipKubeProxy := cliContext.Bool(syncSkipKubeProxyFlag.Name)
 	skipCoreDNS := cliContext.Bool(syncSkipCoreDNSFlag.Name)
 	skipVPCCNI := cliContext.Bool(syncSkipVPCCNIFlag.Name)
-	return eks.SyncClusterComponents(eksClusterArn, shouldWait, waitTimeout, eks.SkipComponentsConfig{KubeProxy: skipKubeProxy, CoreDNS: skipCoreDNS, VPCCNI: skipVPCCNI})
+	skipConfig := eks.SkipComponentsConfig{KubeProxy: skipKubeProxy, CoreDNS: skipCoreDNS, VPCCNI: skipVPCCNI}
+	pins := eks.ComponentVersionPins{
+		KubeProxy: cliContext.String(syncKubeProxyVersionFlag.Name),
+		CoreDNS:   cliContext.String(syncCoreDNSVersionFlag.Name),
+		VPCCNI:    cliContext.String(syncVPCCNIVersionFlag.Name),
+	}
+
+	if cliContext.Bool(syncUseAddonsFlag.Name) {
+		if cliContext.Bool(dryRunFlag.Name) {
+			return errors.WithStackTrace(MutuallyExclusiveFlagError{Message: "--dry-run is not supported with --use-addons."})
+		}
+		if cliContext.String(versionCatalogFlag.Name) != "" {
+			return errors.WithStackTrace(MutuallyExclusiveFlagError{Message: "--version-catalog is not supported with --use-addons: EKS looks up the add-on versions."})
+		}
+		return eks.SyncClusterAddons(eksClusterArn, shouldWait, waitTimeout, skipConfig, pins)
+	}
+
+	catalog, err := eks.LoadVersionCatalog(cliContext.String(versionCatalogFlag.Name))
+	if err != nil {
+		return err
+	}
+
+	if cliContext.Bool(dryRunFlag.Name) {
+		plan, err := eks.PlanSyncClusterComponents(eksClusterArn, skipConfig, catalog, pins)
+		if err != nil {
+			return err
+		}
+		if err := plan.Write(os.Stdout); err != nil {
+			return err
+		}
+		if drifted := plan.DriftedComponents(); len(drifted) > 0 {
+			err := errors.ErrorWithExitCode{Err: CoreComponentsDriftErr{components: drifted}, ExitCode: coreComponentsDriftExitCode}
+			return errors.WithStackTrace(err)
+		}
+		return nil
+	}
+	snapshotDir := cliContext.String(coreComponentsSnapshotDirFlag.Name)
+	return eks.SyncClusterComponents(eksClusterArn, shouldWait, waitTimeout, skipConfig, catalog, pins, snapshotDir)
+}
+
+// Command action for `kubergrunt eks rollback-core-components`
+func rollbackCoreComponents(cliContext *cli.Context) error {
+	eksClusterArn, err := entrypoint.StringFlagRequiredE(cliContext, eksClusterArnFlag.Name)
+	if err != nil {
+		return err
+	}
+	snapshotDir := cliContext.String(coreComponentsSnapshotDirFlag.Name)
+	snapshotID := cliContext.String(coreComponentsSnapshotIDFlag.Name)
+	waitTimeout := cliContext.String(waitTimeoutFlag.Name)
+	return eks.RollbackCoreComponents(eksClusterArn, snapshotDir, snapshotID, waitTimeout)
+}
+
+// Command action for `kubergrunt eks versions`
+func printVersionCatalog(cliContext *cli.Context) error {
+	catalog, err := eks.LoadVersionCatalog(cliContext.String(versionCatalogFlag.Name))
+	if err != nil {
+		return err
+	}
+	return catalog.Write(os.Stdout)
+}
+
+// Command action for `kubergrunt eks migrate-to-addons`
+func migrateToAddons(cliContext *cli.Context) error {
+	eksClusterArn, err := entrypoint.StringFlagRequiredE(cliContext, eksClusterArnFlag.Name)
+	if err != nil {
+		return err
+	}
+	skipConfig := eks.SkipComponentsConfig{
+		KubeProxy: cliContext.Bool(syncSkipKubeProxyFlag.Name),
+		CoreDNS:   cliContext.Bool(syncSkipCoreDNSFlag.Name),
+		VPCCNI:    cliContext.Bool(syncSkipVPCCNIFlag.Name),
+	}
+	pins := eks.ComponentVersionPins{
+		KubeProxy: cliContext.String(syncKubeProxyVersionFlag.Name),
+		CoreDNS:   cliContext.String(syncCoreDNSVersionFlag.Name),
+		VPCCNI:    cliContext.String(syncVPCCNIVersionFlag.Name),
+	}
+	waitMaxRetries := cliContext.Int(waitMaxRetriesFlag.Name)
+	waitSleepBetweenRetries := cliContext.Duration(waitSleepBetweenRetriesFlag.Name)
+	return eks.MigrateToAddons(eksClusterArn, skipConfig, pins, waitMaxRetries, waitSleepBetweenRetries)
 }
 
 // Command action for `kubergrunt eks cleanup-security-group`
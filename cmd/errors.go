@@ -1,6 +1,11 @@
 package main
 
-import "fmt"
+import (
+	"fmt"
+	"strings"
+
+	"github.com/gruntwork-io/kubergrunt/eks"
+)
 
 // MutualExclusiveFlagError is returned when there is a violation of a mutually exclusive flag set.
 type MutuallyExclusiveFlagError struct {
@@ -11,11 +16,30 @@ func (err MutuallyExclusiveFlagError) Error() string {
 	return err.Message
 }
 
-// ExactlyOneASGErr is returned if a user does not provide exactly one ASG.
-type ExactlyOneASGErr struct {
-	flagName string
+// NoNodesMatchSelectorErr is returned when no nodes match the label selector of the nodes to drain.
+type NoNodesMatchSelectorErr struct {
+	selector string
+}
+
+func (err NoNodesMatchSelectorErr) Error() string {
+	return fmt.Sprintf("No nodes match the selector %s.", err.selector)
+}
+
+// UnsupportedDryRunStrategyErr is returned when --dry-run is requested for a deploy strategy that does not support it.
+type UnsupportedDryRunStrategyErr struct {
+	strategy eks.DeployStrategy
+}
+
+func (err UnsupportedDryRunStrategyErr) Error() string {
+	return fmt.Sprintf("--dry-run is not supported with the %s strategy.", err.strategy)
+}
+
+// CoreComponentsDriftErr is returned by sync-core-components --dry-run when the core components do not match the
+// expected configuration.
+type CoreComponentsDriftErr struct {
+	components []string
 }
 
-func (err ExactlyOneASGErr) Error() string {
-	return fmt.Sprintf("You must provide exactly one ASG using %s to this command.", err.flagName)
+func (err CoreComponentsDriftErr) Error() string {
+	return fmt.Sprintf("Core components have drifted from the expected configuration: %s", strings.Join(err.components, ", "))
 }
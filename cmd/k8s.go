@@ -3,9 +3,12 @@ package main
 import (
 	"time"
 
+	"github.com/gruntwork-io/go-commons/collections"
 	"github.com/gruntwork-io/go-commons/entrypoint"
+	"github.com/gruntwork-io/go-commons/errors"
 	"github.com/urfave/cli"
 
+	"github.com/gruntwork-io/kubergrunt/eks"
 	"github.com/gruntwork-io/kubergrunt/kubectl"
 )
 
@@ -29,6 +32,15 @@ var (
 		Value: 5 * time.Second,
 		Usage: "The amount of time to sleep inbetween each check attempt. Accepted as a duration (5s, 10m, 1h).",
 	}
+
+	drainSelectorFlag = cli.StringFlag{
+		Name:  "selector",
+		Usage: "A label selector (e.g karpenter.sh/nodepool=default) for the nodes to drain.",
+	}
+	drainNodeFlag = cli.StringSliceFlag{
+		Name:  "node",
+		Usage: "The name of a node to drain. Pass in multiple times to drain multiple nodes.",
+	}
 )
 
 func SetupK8SCommand() cli.Command {
@@ -61,6 +73,49 @@ You can configure the timeout settings using the --max-retries and --sleep-betwe
 					genericKubectlEKSClusterArnFlag,
 				},
 			},
+			cli.Command{
+				Name:  "drain",
+				Usage: "Drain all Pods from the provided Kubernetes nodes.",
+				Description: `Cordon and drain the nodes matching the label selector passed in with --selector, along with the nodes passed in with --node. Unlike "kubergrunt eks drain", this works directly on the Kubernetes nodes, so it can be used for nodes that are not in an Auto Scaling Group (e.g nodes launched by Karpenter, or by hand).
+
+All the nodes are cordoned before any of them is drained, so that the evicted Pods are not rescheduled on nodes that are about to be drained. The Pods are evicted with the same logic as "kubergrunt eks drain", respecting PodDisruptionBudgets.
+
+For example, to drain all the nodes of the Karpenter node pool "default":
+
+  kubergrunt k8s drain --selector karpenter.sh/nodepool=default
+
+To drain specific nodes:
+
+  kubergrunt k8s drain --node ip-10-0-0-1.ec2.internal --node ip-10-0-0-2.ec2.internal
+
+This command holds the same cluster lock as "kubergrunt eks deploy" and "kubergrunt eks drain" while draining, so that only one deploy or drain operation runs against the cluster at a time. Use --force-unlock to take over a lock that was left behind by an operation that is no longer running.`,
+				Action: drainNodes,
+				Flags: []cli.Flag{
+					drainSelectorFlag,
+					drainNodeFlag,
+					drainTimeoutFlag,
+					deleteEmptyDirDataFlag,
+					podEvictionTimeoutFlag,
+					maxConcurrentDrainsFlag,
+					drainOrderFlag,
+					failFastFlag,
+					jobGracePeriodFlag,
+					jobWaitAnnotationFlag,
+					forceDeleteStuckPodsFlag,
+					stuckPodGracePeriodFlag,
+					forceUnlockFlag,
+					lockTTLFlag,
+					lockHolderFlag,
+
+					// Kubernetes auth flags
+					genericKubectlContextNameFlag,
+					genericKubeconfigFlag,
+					genericKubectlServerFlag,
+					genericKubectlCAFlag,
+					genericKubectlTokenFlag,
+					genericKubectlEKSClusterArnFlag,
+				},
+			},
 			cli.Command{
 				Name:  "kubectl",
 				Usage: "Thin wrapper around kubectl to rely on kubergrunt for temporarily authenticating to the cluster.",
@@ -114,6 +169,58 @@ func waitForIngressEndpoint(cliContext *cli.Context) error {
 	return kubectl.WaitUntilIngressEndpointProvisioned(kubectlOptions, namespace, ingressName, maxRetries, sleepBetweenRetries)
 }
 
+// drainNodes is the action function for k8s drain command.
+func drainNodes(cliContext *cli.Context) error {
+	// Extract Kubernetes auth information
+	kubectlOptions, err := parseKubectlOptions(cliContext)
+	if err != nil {
+		return err
+	}
+
+	selector := cliContext.String(drainSelectorFlag.Name)
+	nodeNames := cliContext.StringSlice(drainNodeFlag.Name)
+	if selector == "" && len(nodeNames) == 0 {
+		return entrypoint.NewRequiredArgsError("You must provide a label selector with --selector, or at least one node with --node.")
+	}
+	drainOptions := parseDrainOptions(cliContext)
+	if err := kubectl.ValidateDrainOrder(drainOptions.Order); err != nil {
+		return err
+	}
+
+	if selector != "" {
+		selectedNodeNames, err := kubectl.ListNodeNames(kubectlOptions, selector)
+		if err != nil {
+			return err
+		}
+		if len(selectedNodeNames) == 0 {
+			return errors.WithStackTrace(NoNodesMatchSelectorErr{selector: selector})
+		}
+		nodeNames = mergeNodeNames(nodeNames, selectedNodeNames)
+	}
+
+	lock, err := eks.AcquireClusterLock(kubectlOptions, parseClusterLockOptions(cliContext))
+	if err != nil {
+		return err
+	}
+	defer eks.ReleaseClusterLock(lock)
+
+	if err := lock.CheckHeld(); err != nil {
+		return err
+	}
+	_, err = kubectl.CordonAndDrainNodes(kubectlOptions, nodeNames, drainOptions)
+	return err
+}
+
+// mergeNodeNames appends the selected node names that are not in the list of node names yet, preserving the order.
+func mergeNodeNames(nodeNames []string, selectedNodeNames []string) []string {
+	for _, nodeName := range selectedNodeNames {
+		if !collections.ListContainsElement(nodeNames, nodeName) {
+			nodeNames = append(nodeNames, nodeName)
+		}
+	}
+	return nodeNames
+}
+
 // kubectlWrapper is the action function for k8s kubectl command.
 func kubectlWrapper(cliContext *cli.Context) error {
 	// Extract Kubernetes auth information
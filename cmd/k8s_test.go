@@ -0,0 +1,32 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMergeNodeNames(t *testing.T) {
+	t.Parallel()
+
+	testCases := []struct {
+		name              string
+		nodeNames         []string
+		selectedNodeNames []string
+		expected          []string
+	}{
+		{"onlyNodes", []string{"node-a", "node-b"}, []string{}, []string{"node-a", "node-b"}},
+		{"onlySelector", []string{}, []string{"node-a", "node-b"}, []string{"node-a", "node-b"}},
+		{"overlap", []string{"node-b", "node-c"}, []string{"node-a", "node-b"}, []string{"node-b", "node-c", "node-a"}},
+	}
+
+	for _, tc := range testCases {
+		// Capture range variable to bring it in scope for the for loop.
+		tc := tc
+
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			assert.Equal(t, tc.expected, mergeNodeNames(tc.nodeNames, tc.selectedNodeNames))
+		})
+	}
+}
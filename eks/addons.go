@@ -0,0 +1,482 @@
+package eks
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/eks"
+	"github.com/aws/aws-sdk-go/service/eks/eksiface"
+	"github.com/blang/semver/v4"
+	"github.com/gruntwork-io/go-commons/errors"
+	"github.com/gruntwork-io/go-commons/retry"
+	"k8s.io/client-go/kubernetes"
+
+	"github.com/gruntwork-io/kubergrunt/eksawshelper"
+	"github.com/gruntwork-io/kubergrunt/kubectl"
+	"github.com/gruntwork-io/kubergrunt/logging"
+)
+
+const (
+	kubeProxyAddonName = "kube-proxy"
+	coreDNSAddonName   = "coredns"
+	vpcCNIAddonName    = "vpc-cni"
+
+	// How long to wait for an add-on to become ACTIVE when the max retries are not configured.
+	defaultAddonWaitTimeout = 10 * time.Minute
+	// How often to poll the status of an add-on when waiting on sync-core-components --use-addons.
+	addonPollInterval = 10 * time.Second
+)
+
+// MigrateToAddons adopts the self managed core components (kube-proxy, coredns, and the VPC CNI plugin) of the EKS
+// cluster as EKS managed add-ons. For each component, this will:
+//  1. Look up the add-on version that EKS recommends for the Kubernetes version of the cluster, unless it is pinned.
+//  2. Create the add-on with resolveConflicts=PRESERVE, so that EKS takes over the existing resources while keeping any
+//     customizations to them.
+//  3. Wait for the add-on to be ACTIVE.
+//
+// Components that are already managed as add-ons are not recreated, so that an interrupted migration can be resumed by
+// running it again. Once migrated, use SyncClusterAddons instead of SyncClusterComponents to keep them up to date.
+// The add-on version is never older than the version of the running component, as adopting the component would
+// downgrade it: when the recommended version is older, the latest compatible version is used instead, and the
+// migration fails if that is older as well.
+func MigrateToAddons(
+	eksClusterArn string,
+	skipConfig SkipComponentsConfig,
+	pins ComponentVersionPins,
+	maxRetries int,
+	sleepBetweenRetries time.Duration,
+) error {
+	logger := logging.GetProjectLogger()
+
+	eksSvc, clusterName, err := newAddonsClient(eksClusterArn)
+	if err != nil {
+		return err
+	}
+	k8sVersion, err := getClusterKubernetesVersion(eksSvc, clusterName)
+	if err != nil {
+		return err
+	}
+	if sleepBetweenRetries <= 0 {
+		sleepBetweenRetries = addonPollInterval
+	}
+	if maxRetries == 0 {
+		maxRetries = int(defaultAddonWaitTimeout / sleepBetweenRetries)
+	}
+	clientset, err := kubectl.GetKubernetesClientFromOptions(&kubectl.KubectlOptions{EKSClusterArn: eksClusterArn})
+	if err != nil {
+		return err
+	}
+
+	for _, addonName := range getAddonNames(skipConfig) {
+		if err := adoptAddon(eksSvc, clientset, clusterName, addonName, k8sVersion, pins.getAddonVersion(addonName)); err != nil {
+			return err
+		}
+		if err := waitForAddonActive(eksSvc, clusterName, addonName, maxRetries, sleepBetweenRetries); err != nil {
+			return err
+		}
+	}
+
+	logger.Info("Successfully migrated core components to EKS add-ons.")
+	return nil
+}
+
+// SyncClusterAddons is the equivalent of SyncClusterComponents for clusters where the core components are managed as EKS
+// add-ons (see MigrateToAddons). This updates each add-on to the version that EKS recommends for the Kubernetes version
+// of the cluster, or to the pinned add-on version, preserving any customizations to the add-on resources. Like
+// MigrateToAddons, add-ons are never downgraded unless the version is pinned. If shouldWait is set to true, this will
+// wait up to waitTimeout for each updated add-on to be ACTIVE again.
+func SyncClusterAddons(
+	eksClusterArn string,
+	shouldWait bool,
+	waitTimeout string,
+	skipConfig SkipComponentsConfig,
+	pins ComponentVersionPins,
+) error {
+	logger := logging.GetProjectLogger()
+
+	timeout, err := time.ParseDuration(waitTimeout)
+	if err != nil {
+		return errors.WithStackTrace(err)
+	}
+	maxRetries := int(timeout/addonPollInterval) + 1
+
+	eksSvc, clusterName, err := newAddonsClient(eksClusterArn)
+	if err != nil {
+		return err
+	}
+	k8sVersion, err := getClusterKubernetesVersion(eksSvc, clusterName)
+	if err != nil {
+		return err
+	}
+
+	for _, addonName := range getAddonNames(skipConfig) {
+		addon, err := getAddon(eksSvc, clusterName, addonName)
+		if err != nil {
+			return err
+		}
+		if addon == nil {
+			return errors.WithStackTrace(AddonNotInstalledErr{addonName: addonName})
+		}
+
+		currentVersion := aws.StringValue(addon.AddonVersion)
+		targetVersion := pins.getAddonVersion(addonName)
+		if targetVersion == "" {
+			targetVersion, err = findCompatibleAddonVersion(eksSvc, addonName, k8sVersion, currentVersion)
+			if err != nil {
+				return err
+			}
+		}
+		if currentVersion == targetVersion {
+			logger.Infof("Current %s add-on version matches expected version (%s). Skipping update.", addonName, targetVersion)
+			continue
+		}
+
+		logger.Infof("Updating %s add-on from %s to %s.", addonName, currentVersion, targetVersion)
+		_, err = eksSvc.UpdateAddon(&eks.UpdateAddonInput{
+			ClusterName:      aws.String(clusterName),
+			AddonName:        aws.String(addonName),
+			AddonVersion:     aws.String(targetVersion),
+			ResolveConflicts: aws.String(eks.ResolveConflictsPreserve),
+		})
+		if err != nil {
+			return errors.WithStackTrace(err)
+		}
+		if shouldWait {
+			if err := waitForAddonActive(eksSvc, clusterName, addonName, maxRetries, addonPollInterval); err != nil {
+				return err
+			}
+		}
+	}
+
+	logger.Info("Successfully updated core component add-ons.")
+	return nil
+}
+
+// adoptAddon creates the add-on for the self managed core component, using the pinned add-on version, or else the
+// add-on version that is compatible with the given Kubernetes version and not older than the running component. This is
+// a no-op if the add-on is already installed.
+func adoptAddon(
+	eksSvc eksiface.EKSAPI,
+	clientset kubernetes.Interface,
+	clusterName string,
+	addonName string,
+	k8sVersion string,
+	pinnedVersion string,
+) error {
+	logger := logging.GetProjectLogger()
+
+	addon, err := getAddon(eksSvc, clusterName, addonName)
+	if err != nil {
+		return err
+	}
+	if addon != nil {
+		logger.Infof("%s is already managed as an EKS add-on (version %s). Skipping creation.", addonName, aws.StringValue(addon.AddonVersion))
+		return nil
+	}
+
+	addonVersion := pinnedVersion
+	if addonVersion == "" {
+		runningVersion, err := getRunningComponentVersion(clientset, addonName)
+		if err != nil {
+			return err
+		}
+		addonVersion, err = findCompatibleAddonVersion(eksSvc, addonName, k8sVersion, runningVersion)
+		if err != nil {
+			return err
+		}
+	}
+	logger.Infof("Adopting self managed %s as EKS add-on version %s", addonName, addonVersion)
+	_, err = eksSvc.CreateAddon(&eks.CreateAddonInput{
+		ClusterName:      aws.String(clusterName),
+		AddonName:        aws.String(addonName),
+		AddonVersion:     aws.String(addonVersion),
+		ResolveConflicts: aws.String(eks.ResolveConflictsPreserve),
+	})
+	return errors.WithStackTrace(err)
+}
+
+// newAddonsClient returns an EKS client for the region of the given cluster, along with the name of the cluster.
+func newAddonsClient(eksClusterArn string) (eksiface.EKSAPI, string, error) {
+	region, err := eksawshelper.GetRegionFromArn(eksClusterArn)
+	if err != nil {
+		return nil, "", err
+	}
+	clusterName, err := eksawshelper.GetClusterNameFromArn(eksClusterArn)
+	if err != nil {
+		return nil, "", err
+	}
+	eksSvc, err := eksawshelper.NewEksClient(region)
+	if err != nil {
+		return nil, "", err
+	}
+	return eksSvc, clusterName, nil
+}
+
+// getAddonNames returns the names of the EKS add-ons for the core components that are not skipped.
+func getAddonNames(skipConfig SkipComponentsConfig) []string {
+	addonNames := []string{}
+	if !skipConfig.KubeProxy {
+		addonNames = append(addonNames, kubeProxyAddonName)
+	}
+	if !skipConfig.CoreDNS {
+		addonNames = append(addonNames, coreDNSAddonName)
+	}
+	if !skipConfig.VPCCNI {
+		addonNames = append(addonNames, vpcCNIAddonName)
+	}
+	return addonNames
+}
+
+// getAddonVersion returns the pinned version of the add-on, or an empty string if it is not pinned.
+func (pins ComponentVersionPins) getAddonVersion(addonName string) string {
+	version := ""
+	switch addonName {
+	case kubeProxyAddonName:
+		version = pins.KubeProxy
+	case coreDNSAddonName:
+		version = pins.CoreDNS
+	case vpcCNIAddonName:
+		version = pins.VPCCNI
+	}
+	// Add-on versions are always prefixed with v, but the image versions used by sync-core-components are not.
+	if version != "" && !strings.HasPrefix(version, "v") {
+		version = "v" + version
+	}
+	return version
+}
+
+// getAddonVersionOption returns the name of the command line option that pins the version of the add-on.
+func getAddonVersionOption(addonName string) string {
+	if addonName == vpcCNIAddonName {
+		return "aws-vpc-cni-version"
+	}
+	return addonName + "-version"
+}
+
+// getRunningComponentVersion returns the version of the self managed core component, which is the tag of its image
+// (e.g v1.11.1-eksbuild.4 for 602401143452.dkr.ecr.us-west-2.amazonaws.com/eks/coredns:v1.11.1-eksbuild.4).
+func getRunningComponentVersion(clientset kubernetes.Interface, addonName string) (string, error) {
+	var image string
+	var err error
+	switch addonName {
+	case kubeProxyAddonName:
+		image, err = getCurrentDeployedKubeProxyImage(clientset)
+	case coreDNSAddonName:
+		image, err = getCurrentDeployedCoreDNSImage(clientset)
+	case vpcCNIAddonName:
+		image, err = getCurrentDeployedVPCCNIImage(clientset)
+	}
+	if err != nil {
+		return "", err
+	}
+
+	unknownVersionErr := UnknownComponentVersionErr{addonName: addonName, image: image, option: getAddonVersionOption(addonName)}
+	tagIndex := strings.LastIndex(image, ":")
+	if tagIndex == -1 || strings.Contains(image[tagIndex:], "/") {
+		return "", errors.WithStackTrace(unknownVersionErr)
+	}
+	version := image[tagIndex+1:]
+	coreVersion, _ := splitAddonVersion(version)
+	if _, err := semver.Make(coreVersion); err != nil {
+		return "", errors.WithStackTrace(unknownVersionErr)
+	}
+	return version, nil
+}
+
+// getClusterKubernetesVersion looks up the Kubernetes version of the EKS cluster.
+func getClusterKubernetesVersion(eksSvc eksiface.EKSAPI, clusterName string) (string, error) {
+	output, err := eksSvc.DescribeCluster(&eks.DescribeClusterInput{Name: aws.String(clusterName)})
+	if err != nil {
+		return "", errors.WithStackTrace(err)
+	}
+	return aws.StringValue(output.Cluster.Version), nil
+}
+
+// getAddon looks up the EKS add-on with the given name, returning nil if the add-on is not installed on the cluster.
+func getAddon(eksSvc eksiface.EKSAPI, clusterName string, addonName string) (*eks.Addon, error) {
+	output, err := eksSvc.DescribeAddon(&eks.DescribeAddonInput{
+		ClusterName: aws.String(clusterName),
+		AddonName:   aws.String(addonName),
+	})
+	if awsErr, isAwsErr := err.(awserr.Error); isAwsErr && awsErr.Code() == eks.ErrCodeResourceNotFoundException {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, errors.WithStackTrace(err)
+	}
+	return output.Addon, nil
+}
+
+// findCompatibleAddonVersion returns the version of the add-on that EKS marks as the default for the given Kubernetes
+// version. If none of the versions are marked as the default, or if the default is older than minVersion (the running
+// version of the component, which may be empty), this falls back to the latest version that is compatible with the
+// Kubernetes version. This fails with an AddonDowngradeErr if that is older than minVersion as well.
+func findCompatibleAddonVersion(eksSvc eksiface.EKSAPI, addonName string, k8sVersion string, minVersion string) (string, error) {
+	input := &eks.DescribeAddonVersionsInput{
+		AddonName:         aws.String(addonName),
+		KubernetesVersion: aws.String(k8sVersion),
+	}
+	defaultVersion := ""
+	latestVersion := ""
+	// Handle pagination by repeatedly making the API call while there is a next token set.
+	for {
+		output, err := eksSvc.DescribeAddonVersions(input)
+		if err != nil {
+			return "", errors.WithStackTrace(err)
+		}
+		for _, addonInfo := range output.Addons {
+			for _, versionInfo := range addonInfo.AddonVersions {
+				version := aws.StringValue(versionInfo.AddonVersion)
+				for _, compatibility := range versionInfo.Compatibilities {
+					if aws.StringValue(compatibility.ClusterVersion) != k8sVersion {
+						continue
+					}
+					if aws.BoolValue(compatibility.DefaultVersion) && defaultVersion == "" {
+						defaultVersion = version
+					}
+					isLater, err := isLaterAddonVersion(version, latestVersion)
+					if err != nil {
+						return "", err
+					}
+					if isLater {
+						latestVersion = version
+					}
+				}
+			}
+		}
+		if output.NextToken == nil {
+			break
+		}
+		input.NextToken = output.NextToken
+	}
+
+	if latestVersion == "" {
+		return "", errors.WithStackTrace(NoCompatibleAddonVersionErr{addonName: addonName, k8sVersion: k8sVersion})
+	}
+	for _, version := range []string{defaultVersion, latestVersion} {
+		if version == "" {
+			continue
+		}
+		isOlder, err := isOlderAddonVersion(version, minVersion)
+		if err != nil {
+			return "", err
+		}
+		if !isOlder {
+			return version, nil
+		}
+	}
+	err := AddonDowngradeErr{
+		addonName:      addonName,
+		k8sVersion:     k8sVersion,
+		currentVersion: minVersion,
+		latestVersion:  latestVersion,
+		option:         getAddonVersionOption(addonName),
+	}
+	return "", errors.WithStackTrace(err)
+}
+
+// isOlderAddonVersion returns whether the add-on version is older than the other version, which is either an add-on
+// version or the image tag of a running component, and may be empty. The EKS builds (e.g eksbuild.2) are only compared
+// when both versions have one, as the image tags of some components have other suffixes (e.g
+// v1.29.0-minimal-eksbuild.1).
+func isOlderAddonVersion(version string, other string) (bool, error) {
+	if other == "" {
+		return false, nil
+	}
+	coreVersion, build := splitAddonVersion(version)
+	otherCoreVersion, otherBuild := splitAddonVersion(other)
+	compareVal, err := semverStringCompare(coreVersion, otherCoreVersion)
+	if err != nil {
+		return false, err
+	}
+	if compareVal == 0 && strings.HasPrefix(build, "eksbuild.") && strings.HasPrefix(otherBuild, "eksbuild.") {
+		compareVal, err = semverStringCompare(coreVersion+"-"+build, otherCoreVersion+"-"+otherBuild)
+		if err != nil {
+			return false, err
+		}
+	}
+	return compareVal < 0, nil
+}
+
+// splitAddonVersion splits the add-on version (e.g v1.19.6-eksbuild.2) into the version without the v prefix (1.19.6)
+// and the suffix (eksbuild.2), which is empty when there is none.
+func splitAddonVersion(version string) (string, string) {
+	parts := strings.SplitN(strings.TrimPrefix(version, "v"), "-", 2)
+	if len(parts) == 1 {
+		return parts[0], ""
+	}
+	return parts[0], parts[1]
+}
+
+// isLaterAddonVersion returns whether the add-on version (e.g v1.19.6-eksbuild.2) is later than the other version,
+// which may be empty.
+func isLaterAddonVersion(version string, other string) (bool, error) {
+	if other == "" {
+		return true, nil
+	}
+	compareVal, err := semverStringCompare(strings.TrimPrefix(version, "v"), strings.TrimPrefix(other, "v"))
+	if err != nil {
+		return false, err
+	}
+	return compareVal > 0, nil
+}
+
+// waitForAddonActive polls the add-on until it is ACTIVE. This fails immediately if the creation or update of the
+// add-on failed.
+func waitForAddonActive(
+	eksSvc eksiface.EKSAPI,
+	clusterName string,
+	addonName string,
+	maxRetries int,
+	sleepBetweenRetries time.Duration,
+) error {
+	logger := logging.GetProjectLogger()
+	logger.Infof("Waiting for %s add-on to be %s.", addonName, eks.AddonStatusActive)
+	err := retry.DoWithRetry(
+		logger.Logger,
+		fmt.Sprintf("wait for %s add-on to be %s", addonName, eks.AddonStatusActive),
+		maxRetries,
+		sleepBetweenRetries,
+		func() error {
+			addon, err := getAddon(eksSvc, clusterName, addonName)
+			if err != nil {
+				return retry.FatalError{Underlying: err}
+			}
+			if addon == nil {
+				return retry.FatalError{Underlying: errors.WithStackTrace(AddonNotInstalledErr{addonName: addonName})}
+			}
+			status := aws.StringValue(addon.Status)
+			switch status {
+			case eks.AddonStatusActive:
+				return nil
+			case eks.AddonStatusCreateFailed, eks.AddonStatusUpdateFailed:
+				err := AddonFailedErr{addonName: addonName, status: status, issues: formatAddonIssues(addon)}
+				return retry.FatalError{Underlying: errors.WithStackTrace(err)}
+			}
+			return fmt.Errorf("%s add-on is %s", addonName, status)
+		},
+	)
+	if fatalErr, isFatalErr := err.(retry.FatalError); isFatalErr {
+		return fatalErr.Underlying
+	}
+	if err != nil {
+		return errors.WithStackTrace(err)
+	}
+	logger.Infof("%s add-on is %s.", addonName, eks.AddonStatusActive)
+	return nil
+}
+
+// formatAddonIssues returns the health issues reported on the add-on, in a human readable format.
+func formatAddonIssues(addon *eks.Addon) []string {
+	issues := []string{}
+	if addon.Health == nil {
+		return issues
+	}
+	for _, issue := range addon.Health.Issues {
+		issues = append(issues, fmt.Sprintf("%s: %s", aws.StringValue(issue.Code), aws.StringValue(issue.Message)))
+	}
+	return issues
+}
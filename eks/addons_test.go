@@ -0,0 +1,250 @@
+package eks
+
+import (
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/eks"
+	"github.com/aws/aws-sdk-go/service/eks/eksiface"
+	"github.com/gruntwork-io/go-commons/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+// fakeAddonsEKS fakes the EKS add-ons API. Each call to DescribeAddon pops the next status of the add-on, and add-ons
+// without any statuses are not installed. Each call to DescribeAddonVersions returns the next page of addonVersions.
+type fakeAddonsEKS struct {
+	eksiface.EKSAPI
+
+	addonStatuses map[string][]string
+	addonVersions [][]*eks.AddonVersionInfo
+	createInputs  []*eks.CreateAddonInput
+}
+
+func (fake *fakeAddonsEKS) DescribeAddon(input *eks.DescribeAddonInput) (*eks.DescribeAddonOutput, error) {
+	addonName := aws.StringValue(input.AddonName)
+	statuses := fake.addonStatuses[addonName]
+	if len(statuses) == 0 {
+		return nil, awserr.New(eks.ErrCodeResourceNotFoundException, "No addon: "+addonName, nil)
+	}
+	status := statuses[0]
+	if len(statuses) > 1 {
+		fake.addonStatuses[addonName] = statuses[1:]
+	}
+	addon := &eks.Addon{AddonName: input.AddonName, AddonVersion: aws.String("v1.0.0-eksbuild.1"), Status: aws.String(status)}
+	if status == eks.AddonStatusCreateFailed {
+		addon.Health = &eks.AddonHealth{
+			Issues: []*eks.AddonIssue{{Code: aws.String(eks.AddonIssueCodeConfigurationConflict), Message: aws.String("Conflicts found")}},
+		}
+	}
+	return &eks.DescribeAddonOutput{Addon: addon}, nil
+}
+
+func (fake *fakeAddonsEKS) DescribeAddonVersions(input *eks.DescribeAddonVersionsInput) (*eks.DescribeAddonVersionsOutput, error) {
+	page := 0
+	if input.NextToken != nil {
+		page = 1
+	}
+	output := &eks.DescribeAddonVersionsOutput{
+		Addons: []*eks.AddonInfo{{AddonName: input.AddonName, AddonVersions: fake.addonVersions[page]}},
+	}
+	if page+1 < len(fake.addonVersions) {
+		output.NextToken = aws.String("next")
+	}
+	return output, nil
+}
+
+func (fake *fakeAddonsEKS) CreateAddon(input *eks.CreateAddonInput) (*eks.CreateAddonOutput, error) {
+	fake.createInputs = append(fake.createInputs, input)
+	fake.addonStatuses[aws.StringValue(input.AddonName)] = []string{eks.AddonStatusCreating, eks.AddonStatusActive}
+	return &eks.CreateAddonOutput{}, nil
+}
+
+func TestFindCompatibleAddonVersion(t *testing.T) {
+	t.Parallel()
+
+	testCases := []struct {
+		name            string
+		addonVersions   [][]*eks.AddonVersionInfo
+		minVersion      string
+		expectedVersion string
+	}{
+		{
+			"DefaultVersionOnLaterPage",
+			[][]*eks.AddonVersionInfo{
+				{newAddonVersionInfo("v1.19.6-eksbuild.7", "1.30", false)},
+				{newAddonVersionInfo("v1.19.6-eksbuild.1", "1.30", true), newAddonVersionInfo("v1.20.0-eksbuild.1", "1.31", true)},
+			},
+			"",
+			"v1.19.6-eksbuild.1",
+		},
+		{
+			"LatestWithoutDefault",
+			[][]*eks.AddonVersionInfo{
+				{newAddonVersionInfo("v1.18.3-eksbuild.3", "1.30", false), newAddonVersionInfo("v1.19.6-eksbuild.2", "1.30", false)},
+				{newAddonVersionInfo("v1.19.6-eksbuild.10", "1.30", false), newAddonVersionInfo("v1.20.0-eksbuild.1", "1.31", false)},
+			},
+			"",
+			"v1.19.6-eksbuild.10",
+		},
+		{
+			"DefaultOlderThanRunningImage",
+			[][]*eks.AddonVersionInfo{
+				{newAddonVersionInfo("v1.18.3-eksbuild.3", "1.30", true), newAddonVersionInfo("v1.19.6-eksbuild.2", "1.30", false)},
+			},
+			"v1.19.6",
+			"v1.19.6-eksbuild.2",
+		},
+		{
+			"DefaultMatchesRunningImageWithOtherSuffix",
+			[][]*eks.AddonVersionInfo{
+				{newAddonVersionInfo("v1.30.0-eksbuild.1", "1.30", true), newAddonVersionInfo("v1.30.6-eksbuild.2", "1.30", false)},
+			},
+			"v1.30.0-minimal-eksbuild.3",
+			"v1.30.0-eksbuild.1",
+		},
+		{
+			"DefaultOlderBuildThanCurrentAddon",
+			[][]*eks.AddonVersionInfo{
+				{newAddonVersionInfo("v1.19.6-eksbuild.1", "1.30", true), newAddonVersionInfo("v1.19.6-eksbuild.3", "1.30", false)},
+			},
+			"v1.19.6-eksbuild.2",
+			"v1.19.6-eksbuild.3",
+		},
+	}
+
+	for _, tc := range testCases {
+		// Capture range variable to bring it in scope for the for loop.
+		tc := tc
+
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			version, err := findCompatibleAddonVersion(&fakeAddonsEKS{addonVersions: tc.addonVersions}, vpcCNIAddonName, "1.30", tc.minVersion)
+			require.NoError(t, err)
+			assert.Equal(t, tc.expectedVersion, version)
+		})
+	}
+}
+
+func TestFindCompatibleAddonVersionNoneCompatible(t *testing.T) {
+	t.Parallel()
+
+	fakeEKS := &fakeAddonsEKS{
+		addonVersions: [][]*eks.AddonVersionInfo{{newAddonVersionInfo("v1.20.0-eksbuild.1", "1.31", true)}},
+	}
+	_, err := findCompatibleAddonVersion(fakeEKS, vpcCNIAddonName, "1.30", "")
+	require.Error(t, err)
+	_, isNoCompatibleVersionErr := errors.Unwrap(err).(NoCompatibleAddonVersionErr)
+	assert.True(t, isNoCompatibleVersionErr)
+}
+
+func TestFindCompatibleAddonVersionDowngrade(t *testing.T) {
+	t.Parallel()
+
+	fakeEKS := &fakeAddonsEKS{
+		addonVersions: [][]*eks.AddonVersionInfo{{
+			newAddonVersionInfo("v1.18.3-eksbuild.3", "1.30", true),
+			newAddonVersionInfo("v1.19.5-eksbuild.1", "1.30", false),
+		}},
+	}
+	_, err := findCompatibleAddonVersion(fakeEKS, vpcCNIAddonName, "1.30", "v1.19.6")
+	require.Error(t, err)
+	downgradeErr, isDowngradeErr := errors.Unwrap(err).(AddonDowngradeErr)
+	require.True(t, isDowngradeErr)
+	assert.Equal(t, "v1.19.5-eksbuild.1", downgradeErr.latestVersion)
+	assert.Contains(t, downgradeErr.Error(), "--aws-vpc-cni-version")
+}
+
+func TestGetRunningComponentVersion(t *testing.T) {
+	t.Parallel()
+
+	clientset := fake.NewSimpleClientset(
+		newSyncPlanTestDaemonSet(
+			kubeProxyDaemonSetName,
+			corev1.Container{Name: kubeProxyDaemonSetName, Image: "602401143452.dkr.ecr.us-west-2.amazonaws.com/eks/kube-proxy:v1.30.0-minimal-eksbuild.3"},
+		),
+		newSyncPlanTestDaemonSet(
+			vpcCNIDaemonSetName,
+			corev1.Container{Name: vpcCNIContainerName, Image: "localhost:5000/amazon-k8s-cni@sha256:0123456789abcdef"},
+		),
+	)
+
+	version, err := getRunningComponentVersion(clientset, kubeProxyAddonName)
+	require.NoError(t, err)
+	assert.Equal(t, "v1.30.0-minimal-eksbuild.3", version)
+
+	// Images referenced by digest have no version.
+	_, err = getRunningComponentVersion(clientset, vpcCNIAddonName)
+	require.Error(t, err)
+	_, isUnknownVersionErr := errors.Unwrap(err).(UnknownComponentVersionErr)
+	assert.True(t, isUnknownVersionErr)
+}
+
+func TestAdoptAddon(t *testing.T) {
+	t.Parallel()
+
+	fakeEKS := &fakeAddonsEKS{
+		addonStatuses: map[string][]string{kubeProxyAddonName: {eks.AddonStatusActive}},
+		addonVersions: [][]*eks.AddonVersionInfo{{newAddonVersionInfo("v1.30.0-eksbuild.3", "1.30", true)}},
+	}
+
+	clientset := newTestCoreComponentsClientset()
+
+	// Add-ons that are already installed are left as is.
+	require.NoError(t, adoptAddon(fakeEKS, clientset, "cluster", kubeProxyAddonName, "1.30", ""))
+	assert.Empty(t, fakeEKS.createInputs)
+
+	require.NoError(t, adoptAddon(fakeEKS, clientset, "cluster", coreDNSAddonName, "1.30", ""))
+	require.Equal(t, 1, len(fakeEKS.createInputs))
+	input := fakeEKS.createInputs[0]
+	assert.Equal(t, coreDNSAddonName, aws.StringValue(input.AddonName))
+	assert.Equal(t, "v1.30.0-eksbuild.3", aws.StringValue(input.AddonVersion))
+	assert.Equal(t, eks.ResolveConflictsPreserve, aws.StringValue(input.ResolveConflicts))
+
+	require.NoError(t, waitForAddonActive(fakeEKS, "cluster", coreDNSAddonName, 3, 0))
+
+	// The running version of the component is not looked up when the add-on version is pinned.
+	require.NoError(t, adoptAddon(fakeEKS, fake.NewSimpleClientset(), "cluster", vpcCNIAddonName, "1.30", "v1.19.6-eksbuild.1"))
+	require.Equal(t, 2, len(fakeEKS.createInputs))
+	assert.Equal(t, "v1.19.6-eksbuild.1", aws.StringValue(fakeEKS.createInputs[1].AddonVersion))
+}
+
+func TestWaitForAddonActiveFailed(t *testing.T) {
+	t.Parallel()
+
+	fakeEKS := &fakeAddonsEKS{
+		addonStatuses: map[string][]string{coreDNSAddonName: {eks.AddonStatusCreating, eks.AddonStatusCreateFailed}},
+	}
+	err := waitForAddonActive(fakeEKS, "cluster", coreDNSAddonName, 5, 0)
+	require.Error(t, err)
+	failedErr, isFailedErr := errors.Unwrap(err).(AddonFailedErr)
+	require.True(t, isFailedErr)
+	assert.Equal(t, []string{"ConfigurationConflict: Conflicts found"}, failedErr.issues)
+
+	// The add-on is never installed.
+	err = waitForAddonActive(fakeEKS, "cluster", vpcCNIAddonName, 5, 0)
+	require.Error(t, err)
+	_, isNotInstalledErr := errors.Unwrap(err).(AddonNotInstalledErr)
+	assert.True(t, isNotInstalledErr)
+}
+
+func TestGetAddonNames(t *testing.T) {
+	t.Parallel()
+
+	assert.Equal(t, []string{kubeProxyAddonName, coreDNSAddonName, vpcCNIAddonName}, getAddonNames(SkipComponentsConfig{}))
+	assert.Equal(t, []string{coreDNSAddonName}, getAddonNames(SkipComponentsConfig{KubeProxy: true, VPCCNI: true}))
+}
+
+// newAddonVersionInfo returns an add-on version that is compatible with the given Kubernetes version.
+func newAddonVersionInfo(version string, k8sVersion string, isDefault bool) *eks.AddonVersionInfo {
+	return &eks.AddonVersionInfo{
+		AddonVersion: aws.String(version),
+		Compatibilities: []*eks.Compatibility{
+			{ClusterVersion: aws.String(k8sVersion), DefaultVersion: aws.Bool(isDefault)},
+		},
+	}
+}
@@ -7,7 +7,9 @@ import (
 	"github.com/aws/aws-sdk-go/service/autoscaling"
 	"github.com/aws/aws-sdk-go/service/ec2"
 	"github.com/aws/aws-sdk-go/service/elb"
+	"github.com/aws/aws-sdk-go/service/elb/elbiface"
 	"github.com/aws/aws-sdk-go/service/elbv2"
+	"github.com/aws/aws-sdk-go/service/elbv2/elbv2iface"
 	"github.com/gruntwork-io/go-commons/collections"
 	"github.com/gruntwork-io/go-commons/errors"
 	"github.com/hashicorp/go-multierror"
@@ -32,27 +34,34 @@ func GetAsgByName(svc *autoscaling.AutoScaling, asgName string) (*autoscaling.Gr
 	return groups[0], nil
 }
 
-// scaleUp will scale the ASG up, wait until all the nodes are available and return new instance IDs.
-func scaleUp(
-	asgSvc *autoscaling.AutoScaling,
-	asgName string,
-	originalInstanceIds []string,
-	desiredCapacity int64,
-	maxRetries int,
-	sleepBetweenRetries time.Duration,
-) ([]string, error) {
+// scaleUp will scale the ASG up by setting the desired capacity. This will not wait for the new instances to launch.
+// See waitForLaunchedInstances to wait for the nodes to be available.
+func scaleUp(asgSvc *autoscaling.AutoScaling, asgName string, desiredCapacity int64) error {
 	logger := logging.GetProjectLogger()
 
 	err := setAsgCapacity(asgSvc, asgName, desiredCapacity)
 	if err != nil {
 		logger.Errorf("Failed to set ASG capacity to %d", desiredCapacity)
 		logger.Errorf("If the capacity is set in AWS, undo by lowering back to the original capacity. If the capacity is not yet set, triage the error message below and try again.")
-		return nil, err
+		return err
 	}
+	return nil
+}
+
+// waitForLaunchedInstances will wait until all the nodes in the scaled up ASG are available and return new instance
+// IDs.
+func waitForLaunchedInstances(
+	asgSvc *autoscaling.AutoScaling,
+	asgName string,
+	originalInstanceIds []string,
+	maxRetries int,
+	sleepBetweenRetries time.Duration,
+) ([]string, error) {
+	logger := logging.GetProjectLogger()
 
 	// All of the following are read operations and do not affect the state, so it's safe to run these
 	// each time we execute
-	err = waitForCapacity(asgSvc, asgName, maxRetries, sleepBetweenRetries)
+	err := waitForCapacity(asgSvc, asgName, maxRetries, sleepBetweenRetries)
 	if err != nil {
 		logger.Errorf("Timed out waiting for ASG to reach steady state.")
 		logger.Errorf("Undo by terminating all the new instances and trying again")
@@ -80,11 +89,18 @@ func waitAndVerifyNewInstances(
 	elbv2Svc *elbv2.ELBV2,
 	instanceIds []string,
 	kubectlOptions *kubectl.KubectlOptions,
+	elbRegistrationPolicy ELBRegistrationPolicy,
 	maxRetries int,
 	sleepBetweenRetries time.Duration,
 ) error {
 	logger := logging.GetProjectLogger()
 
+	// Looking up the details of an empty list of instances returns all the instances in the region, so bail out early.
+	if len(instanceIds) == 0 {
+		logger.Warn("No new instances to wait for")
+		return nil
+	}
+
 	instances, err := instanceDetailsFromIds(ec2Svc, instanceIds)
 	if err != nil {
 		logger.Errorf("Error retrieving detailed about the instances")
@@ -111,7 +127,15 @@ func waitAndVerifyNewInstances(
 		logger.Errorf("Undo by terminating all the new instances and trying again")
 		return err
 	}
-	err = waitForAnyInstancesRegisteredToELB(elbSvc, elbv2Svc, elbs, instanceIds)
+	err = waitForInstancesRegisteredToELB(
+		elbSvc,
+		elbv2Svc,
+		elbs,
+		instanceIds,
+		elbRegistrationPolicy,
+		maxRetries,
+		sleepBetweenRetries,
+	)
 	if err != nil {
 		logger.Errorf("Timed out waiting for the instances to register to the Service ELBs.")
 		logger.Errorf("Undo by terminating all the new instances and trying again")
@@ -224,20 +248,29 @@ func idsFromAsgInstances(instances []*autoscaling.Instance) []string {
 // Make the call to drain all the provided nodes in Kubernetes. This is different from terminating the instances:
 // - Taint the nodes so that new pods are not scheduled
 // - Evict all the pods gracefully
+// The results of the drain are recorded in the drain report, if one was requested, even when the drain fails. Note that
+// writing the report waits up to the configured WaitForReady for the evicted Pods to be Ready again, which delays the
+// next stage.
 func drainNodesInAsg(
 	ec2Svc *ec2.EC2,
 	kubectlOptions *kubectl.KubectlOptions,
 	asgInstanceIds []string,
-	drainTimeout time.Duration,
-	deleteEmptyDirData bool,
+	drainOptions kubectl.DrainOptions,
+	reporter *drainReporter,
 ) error {
+	logger := logging.GetProjectLogger()
 	instances, err := instanceDetailsFromIds(ec2Svc, asgInstanceIds)
 	if err != nil {
 		return err
 	}
 	eksKubeNodeNames := kubeNodeNamesFromInstances(instances)
 
-	return kubectl.DrainNodes(kubectlOptions, eksKubeNodeNames, drainTimeout, deleteEmptyDirData)
+	results, drainErr := kubectl.DrainNodes(kubectlOptions, eksKubeNodeNames, drainOptions)
+	// The report is informational, so failing to write it should not fail the drain.
+	if err := reporter.record(instances, results); err != nil {
+		logger.Errorf("Error writing drain report: %s", err)
+	}
+	return drainErr
 }
 
 // Make the call to cordon all the provided nodes in Kubernetes so that they won't be used to schedule new Pods.
@@ -255,6 +288,21 @@ func cordonNodesInAsg(
 	return kubectl.CordonNodes(kubectlOptions, eksKubeNodeNames)
 }
 
+// Make the call to uncordon all the provided nodes in Kubernetes so that they can be used to schedule new Pods again.
+func uncordonNodesInAsg(
+	ec2Svc *ec2.EC2,
+	kubectlOptions *kubectl.KubectlOptions,
+	asgInstanceIds []string,
+) error {
+	instances, err := instanceDetailsFromIds(ec2Svc, asgInstanceIds)
+	if err != nil {
+		return err
+	}
+	eksKubeNodeNames := kubeNodeNamesFromInstances(instances)
+
+	return kubectl.UncordonNodes(kubectlOptions, eksKubeNodeNames)
+}
+
 // detachInstances will request AWS to detach the instances, removing them from the ASG. In the process, it will also
 // request to auto decrement the desired capacity.
 func detachInstances(asgSvc *autoscaling.AutoScaling, asgName string, idList []string) error {
@@ -278,9 +326,10 @@ func detachInstances(asgSvc *autoscaling.AutoScaling, asgName string, idList []s
 	return nil
 }
 
-// waitForAnyInstancesRegisteredToELB waits until any of the instances provided are registered to all the ELBs
-// provided. Here we wait for any instance to be registered, because we only need one instance to be registered to
-// preserve service uptime, due to the way Kubernetes works.
+// waitForInstancesRegisteredToELB waits until enough of the instances provided are healthy in all the ELBs provided,
+// as determined by the registration policy. By default (AnyELBRegistrationPolicy), we wait for any instance to be
+// healthy, because we only need one instance to be registered to preserve service uptime, due to the way Kubernetes
+// works.
 // Pros:
 // - Shorter wait time.
 // - Can continue on to drain nodes succinctly, which is also time consuming.
@@ -290,14 +339,30 @@ func detachInstances(asgSvc *autoscaling.AutoScaling, asgName string, idList []s
 // - Not all instances are registered, so there is no "load balancing" initially. This may bring down the new server
 //   that is launched.
 // Ultimately, it was decided that the cons are not worth the extended wait time it will introduce to the command.
-func waitForAnyInstancesRegisteredToELB(elbSvc *elb.ELB, elbv2Svc *elbv2.ELBV2, elbs []kubectl.AWSLoadBalancer, instanceIds []string) error {
+// However, with a large surge the old nodes end up drained while most of the new nodes are not serving yet, so the
+// policy can require all (AllELBRegistrationPolicy) or a percentage (e.g 50%) of the instances to be healthy instead.
+// ELBs with the IP target type route directly to Pods instead of instances, so those are skipped here, and waited on
+// once the old nodes are drained instead (see waitForPodTargetsHealthyInELBs). The instances are only required to be
+// registered (whatever their health) for the ELBs of Services with externalTrafficPolicy set to Local, as the health
+// checks of those only pass on the nodes running the Pods of the Service, which the new nodes don't until the old nodes
+// are drained.
+func waitForInstancesRegisteredToELB(
+	elbSvc elbiface.ELBAPI,
+	elbv2Svc elbv2iface.ELBV2API,
+	elbs []kubectl.AWSLoadBalancer,
+	instanceIds []string,
+	policy ELBRegistrationPolicy,
+	maxRetries int,
+	sleepBetweenRetries time.Duration,
+) error {
 	logger := logging.GetProjectLogger()
 	logger.Infof("Verifying new nodes are registered to external load balancers.")
 
 	var multipleErrs *multierror.Error
 	for _, elb := range elbs {
-		if elb.TargetType == kubectl.IPTarget {
-			// We ignore ELBs of the IP type as those directly link to Pods and not instances.
+		if elb.TargetType == kubectl.IPTarget && elb.Type == kubectl.CLB {
+			// Classic Load Balancers can only route to instances, so this should never happen.
+			multipleErrs = multierror.Append(multipleErrs, commonerrors.ImpossibleErr("IP_TARGET_TYPE_FOR_CLB_IN_WAIT"))
 			continue
 		} else if elb.TargetType == kubectl.UnknownELBTarget {
 			// This should never happen, so we return a generic error that indicates this is an impossible condition and
@@ -306,12 +371,29 @@ func waitForAnyInstancesRegisteredToELB(elbSvc *elb.ELB, elbv2Svc *elbv2.ELBV2,
 			continue
 		}
 
+		registrationOnly := elb.LocalTrafficPolicy && elb.TargetType == kubectl.InstanceTarget
+		if registrationOnly {
+			logger.Warnf(
+				"Load balancer %s routes to a Service with externalTrafficPolicy Local, whose health checks only pass on the nodes running its Pods. Only waiting for the new instances to be registered.",
+				elb.Name,
+			)
+		}
+
 		var err error
 		switch elb.Type {
 		case kubectl.CLB:
-			err = waitForAnyInstancesRegisteredToCLB(logger, elbSvc, elb.Name, instanceIds)
+			err = waitForInstancesRegisteredToCLB(logger, elbSvc, elb.Name, instanceIds, registrationOnly, policy, maxRetries, sleepBetweenRetries)
 		case kubectl.NLB, kubectl.ALB:
-			err = waitForAnyInstancesRegisteredToALBOrNLB(logger, elbv2Svc, elb.Name, instanceIds)
+			err = waitForInstancesRegisteredToALBOrNLB(
+				logger,
+				elbv2Svc,
+				elb,
+				instanceIds,
+				registrationOnly,
+				policy,
+				maxRetries,
+				sleepBetweenRetries,
+			)
 		default:
 			// This should never happen, so we return a generic error that indicates this is an impossible condition and
 			// almost 100% a bug with kubergrunt.
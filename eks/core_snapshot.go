@@ -0,0 +1,533 @@
+package eks
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gruntwork-io/go-commons/collections"
+	"github.com/gruntwork-io/go-commons/errors"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/rand"
+	"k8s.io/client-go/kubernetes"
+
+	"github.com/gruntwork-io/kubergrunt/kubectl"
+	"github.com/gruntwork-io/kubergrunt/logging"
+)
+
+const (
+	// The snapshots in the cluster are stored as ConfigMaps in the kube-system namespace, named with this prefix followed
+	// by the snapshot ID, and labeled with the snapshot label so that they can be listed.
+	coreComponentsSnapshotPrefix = "kubergrunt-core-components-"
+	coreComponentsSnapshotLabel  = "kubergrunt.gruntwork.io/core-components-snapshot"
+	// The key in the ConfigMap data where the snapshot is stored.
+	coreComponentsSnapshotDataKey = "snapshot"
+	// The format of the snapshot IDs, which is the time the snapshot was taken followed by a random suffix of this length,
+	// so that snapshots taken within the same second don't collide. This sorts in chronological order (up to the second)
+	// and is a valid ConfigMap name suffix.
+	coreComponentsSnapshotIDFormat       = "20060102-150405"
+	coreComponentsSnapshotIDSuffixLength = 5
+
+	// The number of snapshots that are kept in the snapshot store. Older snapshots are deleted when a new one is saved.
+	maxCoreComponentsSnapshots = 10
+)
+
+// CoreComponentsSnapshot records the Kubernetes resources of the core components, as they were before
+// sync-core-components changed them, so that a failed upgrade can be rolled back. Components that were skipped in the
+// sync are not recorded.
+type CoreComponentsSnapshot struct {
+	ID            string    `json:"id"`
+	EKSClusterArn string    `json:"eksClusterArn"`
+	CreatedAt     time.Time `json:"createdAt"`
+
+	KubeProxyDaemonSet *appsv1.DaemonSet   `json:"kubeProxyDaemonSet,omitempty"`
+	CoreDNSDeployment  *appsv1.Deployment  `json:"corednsDeployment,omitempty"`
+	CoreDNSConfigMap   *corev1.ConfigMap   `json:"corednsConfigMap,omitempty"`
+	CoreDNSClusterRole *rbacv1.ClusterRole `json:"corednsClusterRole,omitempty"`
+	VPCCNIDaemonSet    *appsv1.DaemonSet   `json:"vpcCNIDaemonSet,omitempty"`
+	VPCCNIClusterRole  *rbacv1.ClusterRole `json:"vpcCNIClusterRole,omitempty"`
+}
+
+// CoreComponentsSnapshotStore is the interface for storing the snapshots of the core components.
+type CoreComponentsSnapshotStore interface {
+	// Save stores the snapshot under its ID.
+	Save(snapshot *CoreComponentsSnapshot) error
+	// Load returns the snapshot with the given ID, or nil if there is no such snapshot.
+	Load(id string) (*CoreComponentsSnapshot, error)
+	// List returns the IDs of the stored snapshots, from the oldest to the latest.
+	List() ([]string, error)
+	// Delete removes the snapshot with the given ID.
+	Delete(id string) error
+	// Location returns a human readable description of where the snapshots are stored, for use in log messages.
+	Location() string
+}
+
+// NewCoreComponentsSnapshotStore returns the store for the snapshots of the core components of the given cluster. The
+// snapshots are stored as files in a subdirectory of the given directory for the cluster, so that the directory can be
+// shared by multiple clusters, or as ConfigMaps in the kube-system namespace of the cluster when the directory is empty.
+func NewCoreComponentsSnapshotStore(directory string, eksClusterArn string, clientset kubernetes.Interface) CoreComponentsSnapshotStore {
+	if directory != "" {
+		clusterDirectory := strings.NewReplacer(":", "_", "/", "_").Replace(eksClusterArn)
+		return &LocalCoreComponentsSnapshotStore{Directory: filepath.Join(directory, clusterDirectory)}
+	}
+	return &ConfigMapCoreComponentsSnapshotStore{Clientset: clientset, Namespace: componentNamespace}
+}
+
+// newCoreComponentsSnapshotID returns a new snapshot ID for a snapshot taken at the given time.
+func newCoreComponentsSnapshotID(now time.Time) string {
+	return fmt.Sprintf("%s-%s", now.Format(coreComponentsSnapshotIDFormat), rand.String(coreComponentsSnapshotIDSuffixLength))
+}
+
+// isCoreComponentsSnapshotID returns whether the given name is a snapshot ID, which is the time the snapshot was taken,
+// optionally followed by a suffix of lowercase letters and digits.
+func isCoreComponentsSnapshotID(name string) bool {
+	if len(name) < len(coreComponentsSnapshotIDFormat) {
+		return false
+	}
+	if _, err := time.Parse(coreComponentsSnapshotIDFormat, name[:len(coreComponentsSnapshotIDFormat)]); err != nil {
+		return false
+	}
+	suffix := name[len(coreComponentsSnapshotIDFormat):]
+	if suffix == "" {
+		return true
+	}
+	if !strings.HasPrefix(suffix, "-") || len(suffix) == 1 {
+		return false
+	}
+	for _, char := range suffix[1:] {
+		if (char < 'a' || char > 'z') && (char < '0' || char > '9') {
+			return false
+		}
+	}
+	return true
+}
+
+// LocalCoreComponentsSnapshotStore stores each core component snapshot as a JSON file in a directory on the local disk.
+// Files that are not named after a snapshot ID are ignored.
+type LocalCoreComponentsSnapshotStore struct {
+	Directory string
+}
+
+func (store *LocalCoreComponentsSnapshotStore) Save(snapshot *CoreComponentsSnapshot) error {
+	data, err := json.MarshalIndent(snapshot, "", "  ")
+	if err != nil {
+		return errors.WithStackTrace(err)
+	}
+	if err := os.MkdirAll(store.Directory, 0755); err != nil {
+		return errors.WithStackTrace(err)
+	}
+	return errors.WithStackTrace(ioutil.WriteFile(store.path(snapshot.ID), data, 0644))
+}
+
+func (store *LocalCoreComponentsSnapshotStore) Load(id string) (*CoreComponentsSnapshot, error) {
+	data, err := ioutil.ReadFile(store.path(id))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, errors.WithStackTrace(err)
+	}
+	return parseCoreComponentsSnapshot(data)
+}
+
+func (store *LocalCoreComponentsSnapshotStore) List() ([]string, error) {
+	files, err := ioutil.ReadDir(store.Directory)
+	if os.IsNotExist(err) {
+		return []string{}, nil
+	}
+	if err != nil {
+		return nil, errors.WithStackTrace(err)
+	}
+	ids := []string{}
+	for _, file := range files {
+		id := strings.TrimSuffix(file.Name(), ".json")
+		if !file.IsDir() && strings.HasSuffix(file.Name(), ".json") && isCoreComponentsSnapshotID(id) {
+			ids = append(ids, id)
+		}
+	}
+	sort.Strings(ids)
+	return ids, nil
+}
+
+func (store *LocalCoreComponentsSnapshotStore) Delete(id string) error {
+	return errors.WithStackTrace(os.Remove(store.path(id)))
+}
+
+func (store *LocalCoreComponentsSnapshotStore) Location() string {
+	return store.Directory
+}
+
+func (store *LocalCoreComponentsSnapshotStore) path(id string) string {
+	return filepath.Join(store.Directory, id+".json")
+}
+
+// ConfigMapCoreComponentsSnapshotStore stores each core component snapshot in a ConfigMap in the target Kubernetes
+// cluster.
+type ConfigMapCoreComponentsSnapshotStore struct {
+	Clientset kubernetes.Interface
+	Namespace string
+}
+
+func (store *ConfigMapCoreComponentsSnapshotStore) Save(snapshot *CoreComponentsSnapshot) error {
+	data, err := json.Marshal(snapshot)
+	if err != nil {
+		return errors.WithStackTrace(err)
+	}
+	configMap := &corev1.ConfigMap{
+		ObjectMeta: kubergruntObjectMeta(store.Namespace, coreComponentsSnapshotPrefix+snapshot.ID),
+		Data:       map[string]string{coreComponentsSnapshotDataKey: string(data)},
+	}
+	configMap.Labels[coreComponentsSnapshotLabel] = "true"
+	_, err = store.Clientset.CoreV1().ConfigMaps(store.Namespace).Create(context.Background(), configMap, metav1.CreateOptions{})
+	return errors.WithStackTrace(err)
+}
+
+func (store *ConfigMapCoreComponentsSnapshotStore) Load(id string) (*CoreComponentsSnapshot, error) {
+	configMap, err := store.Clientset.CoreV1().ConfigMaps(store.Namespace).Get(context.Background(), coreComponentsSnapshotPrefix+id, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, errors.WithStackTrace(err)
+	}
+	data, hasData := configMap.Data[coreComponentsSnapshotDataKey]
+	if !hasData {
+		return nil, nil
+	}
+	return parseCoreComponentsSnapshot([]byte(data))
+}
+
+func (store *ConfigMapCoreComponentsSnapshotStore) List() ([]string, error) {
+	configMaps, err := store.Clientset.CoreV1().ConfigMaps(store.Namespace).List(
+		context.Background(),
+		metav1.ListOptions{LabelSelector: coreComponentsSnapshotLabel + "=true"},
+	)
+	if err != nil {
+		return nil, errors.WithStackTrace(err)
+	}
+	ids := []string{}
+	for _, configMap := range configMaps.Items {
+		id := strings.TrimPrefix(configMap.Name, coreComponentsSnapshotPrefix)
+		if strings.HasPrefix(configMap.Name, coreComponentsSnapshotPrefix) && isCoreComponentsSnapshotID(id) {
+			ids = append(ids, id)
+		}
+	}
+	sort.Strings(ids)
+	return ids, nil
+}
+
+func (store *ConfigMapCoreComponentsSnapshotStore) Delete(id string) error {
+	err := store.Clientset.CoreV1().ConfigMaps(store.Namespace).Delete(context.Background(), coreComponentsSnapshotPrefix+id, metav1.DeleteOptions{})
+	return errors.WithStackTrace(err)
+}
+
+func (store *ConfigMapCoreComponentsSnapshotStore) Location() string {
+	return fmt.Sprintf("ConfigMaps %s/%s*", store.Namespace, coreComponentsSnapshotPrefix)
+}
+
+// parseCoreComponentsSnapshot parses the snapshot from the raw data in the store.
+func parseCoreComponentsSnapshot(data []byte) (*CoreComponentsSnapshot, error) {
+	var snapshot CoreComponentsSnapshot
+	if err := json.Unmarshal(data, &snapshot); err != nil {
+		return nil, errors.WithStackTrace(err)
+	}
+	return &snapshot, nil
+}
+
+// saveCoreComponentsSnapshot takes a snapshot of the core components that are not skipped and saves it to the store,
+// deleting the oldest snapshots beyond the maximum number that is kept.
+func saveCoreComponentsSnapshot(
+	store CoreComponentsSnapshotStore,
+	clientset kubernetes.Interface,
+	eksClusterArn string,
+	skipConfig SkipComponentsConfig,
+) (*CoreComponentsSnapshot, error) {
+	snapshot, err := snapshotCoreComponents(clientset, eksClusterArn, skipConfig, time.Now().UTC())
+	if err != nil {
+		return nil, err
+	}
+	if err := store.Save(snapshot); err != nil {
+		return nil, err
+	}
+
+	ids, err := store.List()
+	if err != nil {
+		return nil, err
+	}
+	for len(ids) > maxCoreComponentsSnapshots {
+		if err := store.Delete(ids[0]); err != nil {
+			return nil, err
+		}
+		ids = ids[1:]
+	}
+	return snapshot, nil
+}
+
+// snapshotCoreComponents records the current Kubernetes resources of the core components that are not skipped. The
+// fields that are managed by the Kubernetes API server (e.g the resource version and status) are stripped from the
+// resources, so that they can be reapplied to the cluster.
+func snapshotCoreComponents(
+	clientset kubernetes.Interface,
+	eksClusterArn string,
+	skipConfig SkipComponentsConfig,
+	now time.Time,
+) (*CoreComponentsSnapshot, error) {
+	snapshot := &CoreComponentsSnapshot{
+		ID:            newCoreComponentsSnapshotID(now),
+		EKSClusterArn: eksClusterArn,
+		CreatedAt:     now,
+	}
+	daemonsets := clientset.AppsV1().DaemonSets(componentNamespace)
+
+	if !skipConfig.KubeProxy {
+		daemonset, err := daemonsets.Get(context.Background(), kubeProxyDaemonSetName, metav1.GetOptions{})
+		if err != nil {
+			return nil, errors.WithStackTrace(err)
+		}
+		stripServerManagedFields(&daemonset.ObjectMeta)
+		daemonset.Status = appsv1.DaemonSetStatus{}
+		snapshot.KubeProxyDaemonSet = daemonset
+	}
+
+	if !skipConfig.CoreDNS {
+		deployment, err := clientset.AppsV1().Deployments(componentNamespace).Get(context.Background(), corednsDeploymentName, metav1.GetOptions{})
+		if err != nil {
+			return nil, errors.WithStackTrace(err)
+		}
+		stripServerManagedFields(&deployment.ObjectMeta)
+		delete(deployment.Annotations, "deployment.kubernetes.io/revision")
+		deployment.Status = appsv1.DeploymentStatus{}
+		snapshot.CoreDNSDeployment = deployment
+
+		configMap, err := getCorednsConfigMap(clientset)
+		if err != nil {
+			return nil, err
+		}
+		stripServerManagedFields(&configMap.ObjectMeta)
+		snapshot.CoreDNSConfigMap = configMap
+
+		clusterRole, err := getCorednsClusterRole(clientset)
+		if err != nil {
+			return nil, err
+		}
+		stripServerManagedFields(&clusterRole.ObjectMeta)
+		snapshot.CoreDNSClusterRole = clusterRole
+	}
+
+	if !skipConfig.VPCCNI {
+		daemonset, err := daemonsets.Get(context.Background(), vpcCNIDaemonSetName, metav1.GetOptions{})
+		if err != nil {
+			return nil, errors.WithStackTrace(err)
+		}
+		stripServerManagedFields(&daemonset.ObjectMeta)
+		daemonset.Status = appsv1.DaemonSetStatus{}
+		snapshot.VPCCNIDaemonSet = daemonset
+
+		// The ClusterRole is part of the VPC CNI manifest, and its rules change between versions.
+		clusterRole, err := clientset.RbacV1().ClusterRoles().Get(context.Background(), vpcCNIClusterRoleName, metav1.GetOptions{})
+		if err != nil && !apierrors.IsNotFound(err) {
+			return nil, errors.WithStackTrace(err)
+		}
+		if err == nil {
+			stripServerManagedFields(&clusterRole.ObjectMeta)
+			snapshot.VPCCNIClusterRole = clusterRole
+		}
+	}
+	return snapshot, nil
+}
+
+// stripServerManagedFields removes the metadata fields that are set by the Kubernetes API server.
+func stripServerManagedFields(meta *metav1.ObjectMeta) {
+	meta.UID = ""
+	meta.ResourceVersion = ""
+	meta.Generation = 0
+	meta.SelfLink = ""
+	meta.CreationTimestamp = metav1.Time{}
+	meta.ManagedFields = nil
+}
+
+// RollbackCoreComponents restores the core components of the EKS cluster to the given snapshot, which is taken by
+// sync-core-components before it changes the components. When the snapshot ID is empty, the latest snapshot is restored.
+// The snapshots are looked up in the given directory, or in the cluster when the directory is empty. Once the resources
+// are restored, this will wait until the restored DaemonSets and Deployments have rolled out.
+func RollbackCoreComponents(eksClusterArn string, snapshotDir string, snapshotID string, waitTimeout string) error {
+	logger := logging.GetProjectLogger()
+
+	kubectlOptions := &kubectl.KubectlOptions{EKSClusterArn: eksClusterArn}
+	clientset, err := kubectl.GetKubernetesClientFromOptions(kubectlOptions)
+	if err != nil {
+		return err
+	}
+	store := NewCoreComponentsSnapshotStore(snapshotDir, eksClusterArn, clientset)
+
+	snapshot, err := loadCoreComponentsSnapshot(store, eksClusterArn, snapshotID)
+	if err != nil {
+		return err
+	}
+	logger.Infof("Restoring snapshot %s of the core components, taken at %s.", snapshot.ID, snapshot.CreatedAt.Format(time.RFC3339))
+	if err := restoreCoreComponents(clientset, snapshot); err != nil {
+		return err
+	}
+
+	logger.Info("Waiting until the restored core components are rolled out.")
+	for _, resource := range snapshot.rolloutResources() {
+		// Like with sync-core-components, we rely on the built in mechanism in kubectl to wait for the rollout.
+		args := []string{"rollout", "status", resource, "-n", componentNamespace, "--timeout", waitTimeout}
+		if err := kubectl.RunKubectl(kubectlOptions, args...); err != nil {
+			return err
+		}
+	}
+
+	logger.Infof("Successfully restored snapshot %s of the core components.", snapshot.ID)
+	return nil
+}
+
+// loadCoreComponentsSnapshot loads the snapshot with the given ID from the store, or the latest snapshot when the ID is
+// empty, and checks that it was taken from the given cluster.
+func loadCoreComponentsSnapshot(store CoreComponentsSnapshotStore, eksClusterArn string, snapshotID string) (*CoreComponentsSnapshot, error) {
+	ids, err := store.List()
+	if err != nil {
+		return nil, err
+	}
+	if snapshotID == "" {
+		if len(ids) == 0 {
+			return nil, errors.WithStackTrace(NoCoreComponentsSnapshotsErr{location: store.Location()})
+		}
+		snapshotID = ids[len(ids)-1]
+	}
+	if !collections.ListContainsElement(ids, snapshotID) {
+		return nil, errors.WithStackTrace(CoreComponentsSnapshotNotFoundErr{id: snapshotID, location: store.Location(), available: ids})
+	}
+
+	snapshot, err := store.Load(snapshotID)
+	if err != nil {
+		return nil, err
+	}
+	if snapshot == nil {
+		return nil, errors.WithStackTrace(CoreComponentsSnapshotNotFoundErr{id: snapshotID, location: store.Location(), available: ids})
+	}
+	if snapshot.EKSClusterArn != eksClusterArn {
+		err := CoreComponentsSnapshotClusterMismatchErr{id: snapshotID, snapshotClusterArn: snapshot.EKSClusterArn, eksClusterArn: eksClusterArn}
+		return nil, errors.WithStackTrace(err)
+	}
+	return snapshot, nil
+}
+
+// restoreCoreComponents replaces the resources of the core components in the cluster with the resources recorded in
+// the snapshot. The configuration resources are restored before the workloads, so that the rolled back Pods start with
+// the rolled back configuration.
+func restoreCoreComponents(clientset kubernetes.Interface, snapshot *CoreComponentsSnapshot) error {
+	for _, clusterRole := range []*rbacv1.ClusterRole{snapshot.CoreDNSClusterRole, snapshot.VPCCNIClusterRole} {
+		if clusterRole != nil {
+			if err := restoreClusterRole(clientset, clusterRole); err != nil {
+				return err
+			}
+		}
+	}
+	if snapshot.CoreDNSConfigMap != nil {
+		if err := restoreConfigMap(clientset, snapshot.CoreDNSConfigMap); err != nil {
+			return err
+		}
+	}
+	for _, daemonset := range []*appsv1.DaemonSet{snapshot.KubeProxyDaemonSet, snapshot.VPCCNIDaemonSet} {
+		if daemonset != nil {
+			if err := restoreDaemonSet(clientset, daemonset); err != nil {
+				return err
+			}
+		}
+	}
+	if snapshot.CoreDNSDeployment != nil {
+		if err := restoreDeployment(clientset, snapshot.CoreDNSDeployment); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// rolloutResources returns the kubectl resource names of the workloads in the snapshot, for waiting on the rollout.
+func (snapshot *CoreComponentsSnapshot) rolloutResources() []string {
+	resources := []string{}
+	if snapshot.KubeProxyDaemonSet != nil {
+		resources = append(resources, "daemonset/"+snapshot.KubeProxyDaemonSet.Name)
+	}
+	if snapshot.VPCCNIDaemonSet != nil {
+		resources = append(resources, "daemonset/"+snapshot.VPCCNIDaemonSet.Name)
+	}
+	if snapshot.CoreDNSDeployment != nil {
+		resources = append(resources, "deployment/"+snapshot.CoreDNSDeployment.Name)
+	}
+	return resources
+}
+
+// The following functions create the resource when it no longer exists, or otherwise replace the existing resource
+// with the snapshot.
+
+func restoreDaemonSet(clientset kubernetes.Interface, daemonset *appsv1.DaemonSet) error {
+	daemonsets := clientset.AppsV1().DaemonSets(daemonset.Namespace)
+	existing, err := daemonsets.Get(context.Background(), daemonset.Name, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		_, err = daemonsets.Create(context.Background(), daemonset, metav1.CreateOptions{})
+		return errors.WithStackTrace(err)
+	}
+	if err != nil {
+		return errors.WithStackTrace(err)
+	}
+	daemonset.ResourceVersion = existing.ResourceVersion
+	_, err = daemonsets.Update(context.Background(), daemonset, metav1.UpdateOptions{})
+	return errors.WithStackTrace(err)
+}
+
+func restoreDeployment(clientset kubernetes.Interface, deployment *appsv1.Deployment) error {
+	deployments := clientset.AppsV1().Deployments(deployment.Namespace)
+	existing, err := deployments.Get(context.Background(), deployment.Name, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		_, err = deployments.Create(context.Background(), deployment, metav1.CreateOptions{})
+		return errors.WithStackTrace(err)
+	}
+	if err != nil {
+		return errors.WithStackTrace(err)
+	}
+	deployment.ResourceVersion = existing.ResourceVersion
+	_, err = deployments.Update(context.Background(), deployment, metav1.UpdateOptions{})
+	return errors.WithStackTrace(err)
+}
+
+func restoreConfigMap(clientset kubernetes.Interface, configMap *corev1.ConfigMap) error {
+	configMaps := clientset.CoreV1().ConfigMaps(configMap.Namespace)
+	existing, err := configMaps.Get(context.Background(), configMap.Name, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		_, err = configMaps.Create(context.Background(), configMap, metav1.CreateOptions{})
+		return errors.WithStackTrace(err)
+	}
+	if err != nil {
+		return errors.WithStackTrace(err)
+	}
+	configMap.ResourceVersion = existing.ResourceVersion
+	_, err = configMaps.Update(context.Background(), configMap, metav1.UpdateOptions{})
+	return errors.WithStackTrace(err)
+}
+
+func restoreClusterRole(clientset kubernetes.Interface, clusterRole *rbacv1.ClusterRole) error {
+	clusterRoles := clientset.RbacV1().ClusterRoles()
+	existing, err := clusterRoles.Get(context.Background(), clusterRole.Name, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		_, err = clusterRoles.Create(context.Background(), clusterRole, metav1.CreateOptions{})
+		return errors.WithStackTrace(err)
+	}
+	if err != nil {
+		return errors.WithStackTrace(err)
+	}
+	clusterRole.ResourceVersion = existing.ResourceVersion
+	_, err = clusterRoles.Update(context.Background(), clusterRole, metav1.UpdateOptions{})
+	return errors.WithStackTrace(err)
+}
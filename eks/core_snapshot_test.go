@@ -0,0 +1,291 @@
+package eks
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/gruntwork-io/go-commons/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+const testSnapshotClusterArn = "arn:aws:eks:us-east-1:123456789012:cluster/test"
+
+func TestCoreComponentsSnapshotStores(t *testing.T) {
+	t.Parallel()
+
+	testCases := []struct {
+		name  string
+		store func(t *testing.T) CoreComponentsSnapshotStore
+	}{
+		{
+			"local",
+			func(t *testing.T) CoreComponentsSnapshotStore {
+				return NewCoreComponentsSnapshotStore(t.TempDir(), testSnapshotClusterArn, nil)
+			},
+		},
+		{
+			"configmap",
+			func(t *testing.T) CoreComponentsSnapshotStore {
+				return NewCoreComponentsSnapshotStore("", testSnapshotClusterArn, fake.NewSimpleClientset())
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		// Capture range variable to bring it in scope for the for loop.
+		tc := tc
+
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			store := tc.store(t)
+			ids, err := store.List()
+			require.NoError(t, err)
+			assert.Empty(t, ids)
+
+			for _, id := range []string{"20260102-000000", "20260101-000000"} {
+				snapshot := &CoreComponentsSnapshot{
+					ID:                 id,
+					EKSClusterArn:      testSnapshotClusterArn,
+					KubeProxyDaemonSet: newTestKubeProxyDaemonSet("kube-proxy:v1.30.0"),
+				}
+				require.NoError(t, store.Save(snapshot))
+			}
+			ids, err = store.List()
+			require.NoError(t, err)
+			assert.Equal(t, []string{"20260101-000000", "20260102-000000"}, ids)
+
+			snapshot, err := store.Load("20260102-000000")
+			require.NoError(t, err)
+			require.NotNil(t, snapshot)
+			assert.Equal(t, testSnapshotClusterArn, snapshot.EKSClusterArn)
+			assert.Equal(t, "kube-proxy:v1.30.0", snapshot.KubeProxyDaemonSet.Spec.Template.Spec.Containers[0].Image)
+			assert.Nil(t, snapshot.CoreDNSDeployment)
+
+			missing, err := store.Load("20250101-000000")
+			require.NoError(t, err)
+			assert.Nil(t, missing)
+
+			require.NoError(t, store.Delete("20260101-000000"))
+			ids, err = store.List()
+			require.NoError(t, err)
+			assert.Equal(t, []string{"20260102-000000"}, ids)
+		})
+	}
+}
+
+func TestSnapshotAndRestoreCoreComponents(t *testing.T) {
+	t.Parallel()
+
+	clientset := newTestCoreComponentsClientset()
+	snapshot, err := snapshotCoreComponents(clientset, testSnapshotClusterArn, SkipComponentsConfig{}, time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC))
+	require.NoError(t, err)
+	assert.Regexp(t, "^20260102-030405-[a-z0-9]{5}$", snapshot.ID)
+	assert.True(t, isCoreComponentsSnapshotID(snapshot.ID))
+	assert.Equal(t, "", snapshot.KubeProxyDaemonSet.ResourceVersion)
+	assert.Equal(t, appsv1.DaemonSetStatus{}, snapshot.KubeProxyDaemonSet.Status)
+	assert.NotContains(t, snapshot.CoreDNSDeployment.Annotations, "deployment.kubernetes.io/revision")
+	assert.NotNil(t, snapshot.CoreDNSConfigMap)
+	assert.NotNil(t, snapshot.CoreDNSClusterRole)
+	assert.NotNil(t, snapshot.VPCCNIDaemonSet)
+	// The aws-node ClusterRole is optional.
+	assert.Nil(t, snapshot.VPCCNIClusterRole)
+	assert.Equal(
+		t,
+		[]string{"daemonset/kube-proxy", "daemonset/aws-node", "deployment/coredns"},
+		snapshot.rolloutResources(),
+	)
+
+	// Simulate a broken upgrade.
+	ctx := context.Background()
+	brokenDaemonSet := newTestKubeProxyDaemonSet("kube-proxy:v1.31.0")
+	_, err = clientset.AppsV1().DaemonSets(componentNamespace).Update(ctx, brokenDaemonSet, metav1.UpdateOptions{})
+	require.NoError(t, err)
+	require.NoError(t, clientset.CoreV1().ConfigMaps(componentNamespace).Delete(ctx, corednsConfigMapName, metav1.DeleteOptions{}))
+
+	require.NoError(t, restoreCoreComponents(clientset, snapshot))
+	kubeProxyImage, err := getCurrentDeployedKubeProxyImage(clientset)
+	require.NoError(t, err)
+	assert.Equal(t, "kube-proxy:v1.30.0", kubeProxyImage)
+	configMap, err := getCorednsConfigMap(clientset)
+	require.NoError(t, err)
+	assert.Equal(t, ".:53 {}", configMap.Data[corednsConfigMapConfigKey])
+}
+
+func TestSnapshotCoreComponentsIDsAreUnique(t *testing.T) {
+	t.Parallel()
+
+	now := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	first, err := snapshotCoreComponents(newTestCoreComponentsClientset(), testSnapshotClusterArn, SkipComponentsConfig{}, now)
+	require.NoError(t, err)
+	second, err := snapshotCoreComponents(newTestCoreComponentsClientset(), testSnapshotClusterArn, SkipComponentsConfig{}, now)
+	require.NoError(t, err)
+	assert.NotEqual(t, first.ID, second.ID)
+}
+
+func TestIsCoreComponentsSnapshotID(t *testing.T) {
+	t.Parallel()
+
+	testCases := []struct {
+		name     string
+		expected bool
+	}{
+		{"20260102-030405", true},
+		{"20260102-030405-x7k2p", true},
+		{"20260102-030405-", false},
+		{"20260102-030405-X7K2P", false},
+		{"20260102-030405x7k2p", false},
+		{"20261302-030405", false},
+		{"notes", false},
+		{"", false},
+	}
+
+	for _, tc := range testCases {
+		// Capture range variable to bring it in scope for the for loop.
+		tc := tc
+
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			assert.Equal(t, tc.expected, isCoreComponentsSnapshotID(tc.name))
+		})
+	}
+}
+
+func TestLocalCoreComponentsSnapshotStoreIsPerCluster(t *testing.T) {
+	t.Parallel()
+
+	directory := t.TempDir()
+	otherClusterArn := "arn:aws:eks:us-east-1:123456789012:cluster/other"
+	store := NewCoreComponentsSnapshotStore(directory, testSnapshotClusterArn, nil)
+	otherStore := NewCoreComponentsSnapshotStore(directory, otherClusterArn, nil)
+	for i := 0; i < maxCoreComponentsSnapshots; i++ {
+		require.NoError(t, otherStore.Save(&CoreComponentsSnapshot{ID: fmt.Sprintf("20000101-0000%02d", i), EKSClusterArn: otherClusterArn}))
+	}
+	// Files that are not snapshots are not listed, and never pruned.
+	require.NoError(t, ioutil.WriteFile(filepath.Join(store.Location(), "..", "notes.json"), []byte("{}"), 0644))
+	require.NoError(t, ioutil.WriteFile(filepath.Join(otherStore.Location(), "notes.json"), []byte("{}"), 0644))
+
+	snapshot, err := saveCoreComponentsSnapshot(store, newTestCoreComponentsClientset(), testSnapshotClusterArn, SkipComponentsConfig{})
+	require.NoError(t, err)
+	ids, err := store.List()
+	require.NoError(t, err)
+	assert.Equal(t, []string{snapshot.ID}, ids)
+
+	otherIds, err := otherStore.List()
+	require.NoError(t, err)
+	assert.Equal(t, maxCoreComponentsSnapshots, len(otherIds))
+	assert.Equal(t, "20000101-000000", otherIds[0])
+	assert.FileExists(t, filepath.Join(otherStore.Location(), "notes.json"))
+	assert.FileExists(t, filepath.Join(directory, "notes.json"))
+}
+
+func TestSnapshotCoreComponentsSkipped(t *testing.T) {
+	t.Parallel()
+
+	skipConfig := SkipComponentsConfig{KubeProxy: true, VPCCNI: true}
+	snapshot, err := snapshotCoreComponents(newTestCoreComponentsClientset(), testSnapshotClusterArn, skipConfig, time.Now())
+	require.NoError(t, err)
+	assert.Nil(t, snapshot.KubeProxyDaemonSet)
+	assert.Nil(t, snapshot.VPCCNIDaemonSet)
+	assert.NotNil(t, snapshot.CoreDNSDeployment)
+	assert.Equal(t, []string{"deployment/coredns"}, snapshot.rolloutResources())
+}
+
+func TestSaveCoreComponentsSnapshotPrunesOldSnapshots(t *testing.T) {
+	t.Parallel()
+
+	store := NewCoreComponentsSnapshotStore(t.TempDir(), testSnapshotClusterArn, nil)
+	for i := 0; i < maxCoreComponentsSnapshots; i++ {
+		require.NoError(t, store.Save(&CoreComponentsSnapshot{ID: fmt.Sprintf("20000101-0000%02d", i)}))
+	}
+
+	snapshot, err := saveCoreComponentsSnapshot(store, newTestCoreComponentsClientset(), testSnapshotClusterArn, SkipComponentsConfig{})
+	require.NoError(t, err)
+	ids, err := store.List()
+	require.NoError(t, err)
+	assert.Equal(t, maxCoreComponentsSnapshots, len(ids))
+	assert.Equal(t, "20000101-000001", ids[0])
+	assert.Equal(t, snapshot.ID, ids[len(ids)-1])
+}
+
+func TestLoadCoreComponentsSnapshot(t *testing.T) {
+	t.Parallel()
+
+	store := NewCoreComponentsSnapshotStore(t.TempDir(), testSnapshotClusterArn, nil)
+	_, err := loadCoreComponentsSnapshot(store, testSnapshotClusterArn, "")
+	require.Error(t, err)
+	_, isNoSnapshotsErr := errors.Unwrap(err).(NoCoreComponentsSnapshotsErr)
+	assert.True(t, isNoSnapshotsErr)
+
+	require.NoError(t, store.Save(&CoreComponentsSnapshot{ID: "20260101-000000", EKSClusterArn: testSnapshotClusterArn}))
+	require.NoError(t, store.Save(&CoreComponentsSnapshot{ID: "20260102-000000", EKSClusterArn: testSnapshotClusterArn}))
+	require.NoError(t, store.Save(&CoreComponentsSnapshot{ID: "20260103-000000", EKSClusterArn: "arn:aws:eks:us-east-1:123456789012:cluster/other"}))
+
+	snapshot, err := loadCoreComponentsSnapshot(store, testSnapshotClusterArn, "20260101-000000")
+	require.NoError(t, err)
+	assert.Equal(t, "20260101-000000", snapshot.ID)
+
+	_, err = loadCoreComponentsSnapshot(store, testSnapshotClusterArn, "20250101-000000")
+	require.Error(t, err)
+	notFoundErr, isNotFoundErr := errors.Unwrap(err).(CoreComponentsSnapshotNotFoundErr)
+	require.True(t, isNotFoundErr)
+	assert.Equal(t, []string{"20260101-000000", "20260102-000000", "20260103-000000"}, notFoundErr.available)
+
+	// The latest snapshot is from a different cluster.
+	_, err = loadCoreComponentsSnapshot(store, testSnapshotClusterArn, "")
+	require.Error(t, err)
+	_, isMismatchErr := errors.Unwrap(err).(CoreComponentsSnapshotClusterMismatchErr)
+	assert.True(t, isMismatchErr)
+}
+
+// newTestCoreComponentsClientset returns a fake clientset with the resources of the core components, except for the
+// optional aws-node ClusterRole.
+func newTestCoreComponentsClientset() kubernetes.Interface {
+	corednsDeployment := &appsv1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:            corednsDeploymentName,
+			Namespace:       componentNamespace,
+			ResourceVersion: "42",
+			Annotations:     map[string]string{"deployment.kubernetes.io/revision": "3"},
+		},
+		Spec: appsv1.DeploymentSpec{
+			Template: corev1.PodTemplateSpec{
+				Spec: corev1.PodSpec{Containers: []corev1.Container{{Name: "coredns", Image: "coredns:v1.11.1"}}},
+			},
+		},
+	}
+	corednsConfigMap := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Name: corednsConfigMapName, Namespace: componentNamespace},
+		Data:       map[string]string{corednsConfigMapConfigKey: ".:53 {}"},
+	}
+	corednsClusterRole := &rbacv1.ClusterRole{ObjectMeta: metav1.ObjectMeta{Name: corednsClusterRoleName}}
+	vpcCNIDaemonSet := newSyncPlanTestDaemonSet(vpcCNIDaemonSetName, corev1.Container{Name: vpcCNIContainerName, Image: "amazon-k8s-cni:v1.19.6"})
+	vpcCNIDaemonSet.ResourceVersion = "7"
+	return fake.NewSimpleClientset(
+		newTestKubeProxyDaemonSet("kube-proxy:v1.30.0"),
+		vpcCNIDaemonSet,
+		corednsDeployment,
+		corednsConfigMap,
+		corednsClusterRole,
+	)
+}
+
+// newTestKubeProxyDaemonSet returns the kube-proxy DaemonSet running the given image, with the fields that are managed
+// by the Kubernetes API server set.
+func newTestKubeProxyDaemonSet(image string) *appsv1.DaemonSet {
+	daemonset := newSyncPlanTestDaemonSet(kubeProxyDaemonSetName, corev1.Container{Name: kubeProxyDaemonSetName, Image: image})
+	daemonset.ResourceVersion = "7"
+	daemonset.Status = appsv1.DaemonSetStatus{NumberReady: 3}
+	return daemonset
+}
@@ -2,12 +2,15 @@ package eks
 
 import (
 	"math"
+	"strings"
 	"time"
 
 	"github.com/aws/aws-sdk-go/service/autoscaling"
+	"github.com/aws/aws-sdk-go/service/autoscaling/autoscalingiface"
 	"github.com/aws/aws-sdk-go/service/ec2"
 	"github.com/aws/aws-sdk-go/service/elb"
 	"github.com/aws/aws-sdk-go/service/elbv2"
+	"github.com/gruntwork-io/go-commons/collections"
 	"github.com/gruntwork-io/go-commons/errors"
 
 	"github.com/gruntwork-io/kubergrunt/eksawshelper"
@@ -16,29 +19,77 @@ import (
 )
 
 // RollOutDeployment will perform a zero downtime roll out of the current launch configuration associated with the
-// provided ASG in the provided EKS cluster. This is accomplished by:
-// 1. Double the desired capacity of the Auto Scaling Group that powers the EKS Cluster. This will launch new EKS
-//    workers with the new launch configuration.
-// 2. Wait for the new nodes to be ready for Pod scheduling in Kubernetes.
-// 3. Cordon the old nodes so that no new Pods will be scheduled there.
-// 4. Drain the pods scheduled on the old EKS workers (using the equivalent of "kubectl drain"), so that they will be
-//    rescheduled on the new EKS workers.
-// 5. Wait for all the pods to migrate off of the old EKS workers.
-// 6. Set the desired capacity down to the original value and remove the old EKS workers from the ASG.
-// The process is broken up into stages/checkpoints, state is stored along the way so that command can pick up
-// from a stage if something bad happens.
+// provided ASGs in the provided EKS cluster. This is accomplished by repeating the following in waves:
+// 1. Increase the desired capacity of the ASGs. This will launch new EKS workers with the new launch configuration.
+// 2. Wait for the new nodes to be ready for Pod scheduling in Kubernetes, and to be healthy in the external load
+//    balancers.
+// 3. Cordon the old nodes of the wave so that no new Pods will be scheduled there.
+// 4. Drain the pods scheduled on the old EKS workers of the wave (using the equivalent of "kubectl drain"), so that
+//    they will be rescheduled on the new EKS workers.
+// 5. Wait for all the pods to migrate off of the old EKS workers, and for the rescheduled Pods to be healthy in the
+//    external load balancers that use the IP target type.
+// 6. Deregister the old EKS workers from the external load balancers, and wait for connection draining to finish.
+// 7. Set the desired capacity down to the original value and remove the old EKS workers from the ASGs.
+// Each wave replaces up to batchSize of the original instances while launching at most maxSurge extra instances, both
+// expressed as an absolute number of instances or as a percentage of the original capacity. With the defaults (100%),
+// this doubles the capacity and replaces all the instances in a single wave. rolloutMode determines whether multiple
+// ASGs are rolled out together (each wave covers every ASG) or in sequence.
+// elbRegistrationPolicy determines how many of the new instances must be healthy in each load balancer. For the load
+// balancers of Services with externalTrafficPolicy set to Local, the new instances only need to be registered, as the
+// health checks only pass on the nodes running the Pods of the Service. The old nodes are labeled to be excluded from
+// the load balancers before they are deregistered, so that the load balancer controllers don't register them again.
+// Before making any changes, the Pods to be evicted are checked against the PodDisruptionBudgets in the cluster (see
+// pdbPreflight), and the health gates of healthGatesConfig are checked after the new nodes of each wave are ready and
+// after the old nodes are drained. When drainReportOptions has a path, a report of where the evicted Pods were
+// rescheduled is written to it after each drain.
+// The process is broken up into stages/checkpoints, and the state is stored along the way (in the backend selected by
+// stateBackendConfig) so that the roll out can pick up from a stage and wave if something bad happens, or be undone
+// with RollbackDeployment. The cluster lock is held for the entire duration, so that only one deploy or drain runs at
+// a time.
+// This is the surge strategy. Alternatively, the instance-refresh strategy drives the native Instance Refresh of each
+// ASG (configured with instanceRefreshOptions), and uses a lifecycle hook to cordon and drain each original instance
+// before the refresh terminates it.
 func RollOutDeployment(
 	region string,
-	eksAsgName string,
+	eksAsgNames []string,
 	kubectlOptions *kubectl.KubectlOptions,
-	drainTimeout time.Duration,
-	deleteEmptyDirData bool,
+	drainOptions kubectl.DrainOptions,
 	maxRetries int,
 	sleepBetweenRetries time.Duration,
 	ignoreRecoveryFile bool,
+	maxSurge string,
+	batchSize string,
+	rolloutMode ASGRolloutMode,
+	stateBackendConfig DeployStateBackendConfig,
+	lockOptions ClusterLockOptions,
+	pdbPreflight PDBPreflightMode,
+	healthGatesConfig HealthGatesConfig,
+	strategy DeployStrategy,
+	instanceRefreshOptions InstanceRefreshOptions,
+	drainReportOptions DrainReportOptions,
+	elbRegistrationPolicy ELBRegistrationPolicy,
 ) (returnErr error) {
 	logger := logging.GetProjectLogger()
-	logger.Infof("Beginning roll out for EKS cluster worker group %s in %s", eksAsgName, region)
+	if !collections.ListContainsElement(ASGRolloutModes, string(rolloutMode)) {
+		return errors.WithStackTrace(InvalidASGRolloutModeErr{mode: string(rolloutMode)})
+	}
+	if err := validatePDBPreflightMode(pdbPreflight); err != nil {
+		return err
+	}
+	if err := validateDeployStrategy(strategy); err != nil {
+		return err
+	}
+	if err := kubectl.ValidateDrainOrder(drainOptions.Order); err != nil {
+		return err
+	}
+	if err := validateDrainReportOptions(drainReportOptions); err != nil {
+		return err
+	}
+	if err := ValidateELBRegistrationPolicy(elbRegistrationPolicy); err != nil {
+		return err
+	}
+	asgNamesStr := strings.Join(eksAsgNames, ",")
+	logger.Infof("Beginning roll out for EKS cluster worker groups %s in %s", asgNamesStr, region)
 
 	// Construct clients for AWS
 	sess, err := eksawshelper.NewAuthenticatedSession(region)
@@ -51,65 +102,77 @@ func RollOutDeployment(
 	elbv2Svc := elbv2.New(sess)
 	logger.Infof("Successfully authenticated with AWS")
 
-	stateFile := defaultStateFile
-
-	// Retrieve state if one exists or construct a new one
-	state, err := initDeployState(stateFile, ignoreRecoveryFile, maxRetries, sleepBetweenRetries)
+	healthGates, err := NewHealthGates(healthGatesConfig, kubectlOptions)
 	if err != nil {
 		return err
 	}
 
-	err = state.gatherASGInfo(asgSvc, []string{eksAsgName})
+	// Take the cluster lock before changing anything, so that concurrent deploys and drains don't fight over the ASGs.
+	lock, err := AcquireClusterLock(kubectlOptions, lockOptions)
 	if err != nil {
 		return err
 	}
+	defer ReleaseClusterLock(lock)
 
-	err = state.setMaxCapacity(asgSvc)
+	stateBackend, err := NewDeployStateBackend(stateBackendConfig, region, kubectlOptions)
 	if err != nil {
 		return err
 	}
 
-	err = state.scaleUp(asgSvc)
+	// Retrieve state if one exists or construct a new one
+	state, err := initDeployState(stateBackend, ignoreRecoveryFile, maxRetries, sleepBetweenRetries, maxSurge, batchSize, rolloutMode, strategy)
 	if err != nil {
 		return err
 	}
-
-	err = state.waitForNodes(ec2Svc, elbSvc, elbv2Svc, kubectlOptions)
-	if err != nil {
-		return err
+	state.lock = lock
+	if state.Strategy != strategy {
+		return errors.WithStackTrace(DeployStrategyMismatchErr{requested: strategy, recorded: state.Strategy})
 	}
-
-	err = state.cordonNodes(ec2Svc, kubectlOptions)
-	if err != nil {
-		return err
+	if state.RollbackPlanDone {
+		return errors.WithStackTrace(RollbackInProgressErr{location: stateBackend.Location()})
 	}
 
-	err = state.drainNodes(ec2Svc, kubectlOptions, drainTimeout, deleteEmptyDirData)
+	err = state.gatherASGInfo(asgSvc, eksAsgNames)
 	if err != nil {
 		return err
 	}
 
-	err = state.detachInstances(asgSvc)
+	// Make sure the Pods on the nodes that are yet to be replaced can be evicted before making any changes. This is
+	// read only, so it is safe to run each time the roll out is resumed.
+	err = runPDBPreflight(ec2Svc, kubectlOptions, state.remainingOriginalInstances(), pdbPreflight)
 	if err != nil {
 		return err
 	}
 
-	err = state.terminateInstances(ec2Svc)
-	if err != nil {
-		return err
+	reporter := newDrainReporter(drainReportOptions, kubectlOptions)
+	switch strategy {
+	case InstanceRefreshDeployStrategy:
+		drainInstances := newInstanceDrainer(ec2Svc, kubectlOptions, drainOptions, reporter)
+		err = rollOutWithInstanceRefresh(state, asgSvc, drainOptions.MaxNodeDrainDuration(), instanceRefreshOptions, drainInstances, healthGates)
+	default:
+		err = rollOutWithSurge(
+			state,
+			asgSvc,
+			ec2Svc,
+			elbSvc,
+			elbv2Svc,
+			kubectlOptions,
+			drainOptions,
+			reporter,
+			healthGates,
+			elbRegistrationPolicy,
+		)
 	}
-
-	err = state.restoreCapacity(asgSvc)
 	if err != nil {
 		return err
 	}
 
 	err = state.delete()
 	if err != nil {
-		logger.Warnf("Error deleting state file %s: %s", stateFile, err.Error())
-		logger.Warn("Remove the file manually")
+		logger.Warnf("Error deleting state in %s: %s", stateBackend.Location(), err.Error())
+		logger.Warn("Remove the state manually")
 	}
-	logger.Infof("Successfully finished roll out for EKS cluster worker group %s in %s", eksAsgName, region)
+	logger.Infof("Successfully finished roll out for EKS cluster worker groups %s in %s", asgNamesStr, region)
 	return nil
 }
 
@@ -134,3 +197,131 @@ func getDefaultMaxRetries(scaleUpCount int64, sleepBetweenRetries time.Duration)
 
 	return defaultMaxRetries
 }
+
+// rollOutWithSurge replaces the instances of the ASGs in waves, by scaling up the ASGs to launch the replacement
+// instances, and then draining, deregistering from the load balancers, detaching, and terminating the original
+// instances. See RollOutDeployment for details.
+func rollOutWithSurge(
+	state *DeployState,
+	asgSvc *autoscaling.AutoScaling,
+	ec2Svc *ec2.EC2,
+	elbSvc *elb.ELB,
+	elbv2Svc *elbv2.ELBV2,
+	kubectlOptions *kubectl.KubectlOptions,
+	drainOptions kubectl.DrainOptions,
+	reporter *drainReporter,
+	healthGates []HealthGate,
+	elbRegistrationPolicy ELBRegistrationPolicy,
+) error {
+	err := state.setMaxCapacity(asgSvc)
+	if err != nil {
+		return err
+	}
+
+	for {
+		hasWave, err := state.startWave()
+		if err != nil {
+			return err
+		}
+		if !hasWave {
+			// All the waves of the active ASGs are done, so move on to the next ASGs, if any.
+			hasMoreASGs, err := state.finishActiveASGs()
+			if err != nil {
+				return err
+			}
+			if !hasMoreASGs {
+				break
+			}
+			continue
+		}
+
+		err = state.scaleUp(asgSvc)
+		if err != nil {
+			return err
+		}
+
+		err = state.waitForNodes(ec2Svc, elbSvc, elbv2Svc, kubectlOptions, elbRegistrationPolicy)
+		if err != nil {
+			return err
+		}
+
+		err = state.checkNodesHealthGates(healthGates)
+		if err != nil {
+			return err
+		}
+
+		err = state.cordonNodes(ec2Svc, kubectlOptions)
+		if err != nil {
+			return err
+		}
+
+		err = state.drainNodes(ec2Svc, kubectlOptions, drainOptions, reporter)
+		if err != nil {
+			return err
+		}
+
+		err = state.checkDrainHealthGates(healthGates)
+		if err != nil {
+			return err
+		}
+
+		err = state.waitForPodTargets(ec2Svc, elbv2Svc, kubectlOptions, elbRegistrationPolicy)
+		if err != nil {
+			return err
+		}
+
+		err = state.deregisterInstances(ec2Svc, elbSvc, elbv2Svc, kubectlOptions)
+		if err != nil {
+			return err
+		}
+
+		err = state.detachInstances(asgSvc)
+		if err != nil {
+			return err
+		}
+
+		err = state.terminateInstances(ec2Svc)
+		if err != nil {
+			return err
+		}
+
+		err = state.finishWave()
+		if err != nil {
+			return err
+		}
+	}
+
+	return state.restoreCapacity(asgSvc)
+}
+
+// rollOutWithInstanceRefresh replaces the instances of the ASGs using the native Instance Refresh of each ASG. A
+// lifecycle hook holds each original instance in the Terminating:Wait state until its node is drained with
+// drainInstances, and the health gates pass. When multiple ASGs are rolled out sequentially, the refresh of each ASG is
+// only started once the previous one succeeds.
+func rollOutWithInstanceRefresh(
+	state *DeployState,
+	asgSvc autoscalingiface.AutoScalingAPI,
+	drainTimeout time.Duration,
+	options InstanceRefreshOptions,
+	drainInstances func(instanceIds []string) error,
+	healthGates []HealthGate,
+) error {
+	err := state.putDrainLifecycleHooks(asgSvc, drainTimeout)
+	if err != nil {
+		return err
+	}
+
+	for len(state.activeASGs()) > 0 {
+		err = state.startInstanceRefreshes(asgSvc, options)
+		if err != nil {
+			return err
+		}
+
+		err = state.waitForInstanceRefreshes(asgSvc, drainInstances, healthGates)
+		if err != nil {
+			return err
+		}
+	}
+
+	return state.deleteDrainLifecycleHooks(asgSvc)
+}
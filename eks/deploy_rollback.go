@@ -0,0 +1,420 @@
+package eks
+
+import (
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/service/autoscaling"
+	"github.com/aws/aws-sdk-go/service/autoscaling/autoscalingiface"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/aws/aws-sdk-go/service/elb"
+	"github.com/aws/aws-sdk-go/service/elb/elbiface"
+	"github.com/aws/aws-sdk-go/service/elbv2"
+	"github.com/aws/aws-sdk-go/service/elbv2/elbv2iface"
+	"github.com/gruntwork-io/go-commons/errors"
+
+	"github.com/gruntwork-io/kubergrunt/eksawshelper"
+	"github.com/gruntwork-io/kubergrunt/kubectl"
+	"github.com/gruntwork-io/kubergrunt/logging"
+)
+
+// RollbackDeployment will roll back an interrupted roll out using the recovery state recorded by RollOutDeployment.
+// This will undo the wave that was in progress when the roll out was interrupted:
+// 1. Uncordon the original nodes of the wave so that Pods can be scheduled on them again.
+// 2. Register the original nodes again to the external load balancers they were being deregistered from.
+// 3. Cordon the new nodes launched in the wave so that no new Pods will be scheduled there.
+// 4. Drain the pods scheduled on the new nodes, so that they will be rescheduled on the original nodes.
+// 5. Remove the new nodes from the ASGs, decrementing the desired capacity back to the original value.
+// 6. Terminate the new nodes.
+// 7. Restore the original desired capacity and max size of the ASGs.
+// Step 2 only applies once the deregistration has started: the label excluding the original nodes from the external
+// load balancers is removed, and the roll back waits until the re-registered instances are healthy.
+// Waves that were fully completed before the interruption are not undone, as the original instances of those waves are
+// already terminated. Similarly, the roll back is not possible once the original instances of the interrupted wave have
+// been detached from the ASGs; resume the roll out instead.
+// For a roll out with the instance-refresh strategy, the Instance Refresh of each ASG is cancelled instead, with
+// CancelInstanceRefresh. Instances that were already replaced by the refresh are kept.
+// Like the roll out, the process is broken up into stages/checkpoints that are recorded in the recovery state, so that
+// the roll back can pick up from a stage if something bad happens.
+func RollbackDeployment(
+	region string,
+	kubectlOptions *kubectl.KubectlOptions,
+	drainOptions kubectl.DrainOptions,
+	maxRetries int,
+	sleepBetweenRetries time.Duration,
+	stateBackendConfig DeployStateBackendConfig,
+	lockOptions ClusterLockOptions,
+) error {
+	logger := logging.GetProjectLogger()
+
+	// Construct clients for AWS
+	sess, err := eksawshelper.NewAuthenticatedSession(region)
+	if err != nil {
+		return errors.WithStackTrace(err)
+	}
+	asgSvc := autoscaling.New(sess)
+	ec2Svc := ec2.New(sess)
+	elbSvc := elb.New(sess)
+	elbv2Svc := elbv2.New(sess)
+	logger.Infof("Successfully authenticated with AWS")
+
+	lock, err := AcquireClusterLock(kubectlOptions, lockOptions)
+	if err != nil {
+		return err
+	}
+	defer ReleaseClusterLock(lock)
+
+	stateBackend, err := NewDeployStateBackend(stateBackendConfig, region, kubectlOptions)
+	if err != nil {
+		return err
+	}
+	state, err := initDeployState(stateBackend, false, maxRetries, sleepBetweenRetries, "", "", "", "")
+	if err != nil {
+		return err
+	}
+	state.lock = lock
+	if !state.GatherASGInfoDone {
+		return errors.WithStackTrace(NoDeployStateToRollbackErr{location: stateBackend.Location()})
+	}
+	state.maxRetries = ensureMaxRetries(state.maxRetries, state.sleepBetweenRetries, state.largestOriginalCapacity())
+	asgNamesStr := strings.Join(state.asgNames(), ",")
+	logger.Infof("Beginning roll back for EKS cluster worker groups %s in %s", asgNamesStr, region)
+
+	switch state.Strategy {
+	case InstanceRefreshDeployStrategy:
+		drainInstances := newInstanceDrainer(ec2Svc, kubectlOptions, drainOptions, nil)
+		err = rollbackInstanceRefresh(state, asgSvc, drainInstances)
+	default:
+		err = rollbackSurge(state, asgSvc, ec2Svc, elbSvc, elbv2Svc, kubectlOptions, drainOptions)
+	}
+	if err != nil {
+		return err
+	}
+
+	err = state.delete()
+	if err != nil {
+		logger.Warnf("Error deleting state in %s: %s", stateBackend.Location(), err.Error())
+		logger.Warn("Remove the state manually")
+	}
+	logger.Infof("Successfully finished roll back for EKS cluster worker groups %s in %s", asgNamesStr, region)
+	return nil
+}
+
+// rollbackSurge undoes the interrupted wave of a roll out with the surge strategy. See RollbackDeployment for details.
+func rollbackSurge(
+	state *DeployState,
+	asgSvc *autoscaling.AutoScaling,
+	ec2Svc *ec2.EC2,
+	elbSvc elbiface.ELBAPI,
+	elbv2Svc elbv2iface.ELBV2API,
+	kubectlOptions *kubectl.KubectlOptions,
+	drainOptions kubectl.DrainOptions,
+) error {
+	err := state.planRollback(asgSvc)
+	if err != nil {
+		return err
+	}
+
+	err = state.rollbackUncordonNodes(ec2Svc, kubectlOptions)
+	if err != nil {
+		return err
+	}
+
+	includeNodes := func(nodeNames []string) error {
+		return kubectl.SetNodesExcludedFromLoadBalancers(kubectlOptions, nodeNames, false)
+	}
+	err = state.rollbackReregisterInstances(elbSvc, elbv2Svc, includeNodes)
+	if err != nil {
+		return err
+	}
+
+	err = state.rollbackCordonNodes(ec2Svc, kubectlOptions)
+	if err != nil {
+		return err
+	}
+
+	err = state.rollbackDrainNodes(ec2Svc, kubectlOptions, drainOptions)
+	if err != nil {
+		return err
+	}
+
+	err = state.rollbackDetachInstances(asgSvc)
+	if err != nil {
+		return err
+	}
+
+	err = state.rollbackTerminateInstances(ec2Svc)
+	if err != nil {
+		return err
+	}
+
+	return state.rollbackRestoreCapacity(asgSvc)
+}
+
+// rollbackInstanceRefresh stops a roll out with the instance-refresh strategy by cancelling the Instance Refresh of
+// each ASG and removing the lifecycle hooks. Instances that were already replaced keep running with the new launch
+// configuration: to revert them, restore the previous launch configuration and deploy again.
+func rollbackInstanceRefresh(
+	state *DeployState,
+	asgSvc autoscalingiface.AutoScalingAPI,
+	drainInstances func(instanceIds []string) error,
+) error {
+	// Mark the roll back as started, so that the roll out can not be resumed from the partially cancelled state.
+	if !state.RollbackPlanDone {
+		state.RollbackPlanDone = true
+		if err := state.persist(); err != nil {
+			return err
+		}
+	}
+
+	err := state.cancelInstanceRefreshes(asgSvc, drainInstances)
+	if err != nil {
+		return err
+	}
+	return state.deleteDrainLifecycleHooks(asgSvc)
+}
+
+// planRollback determines the instances launched by the interrupted wave that need to be removed to roll back.
+func (state *DeployState) planRollback(asgSvc *autoscaling.AutoScaling) error {
+	if state.RollbackPlanDone {
+		state.logger.Debug("Roll back already planned - skipping")
+		return nil
+	}
+	if state.DetachInstancesDone {
+		return errors.WithStackTrace(RollbackNotPossibleErr{wave: state.CurrentWave + 1})
+	}
+
+	// Recovery files from older versions do not record the waves, so plan the wave that the interrupted roll out was
+	// working on to find the instances it launched.
+	if len(state.waveASGs()) == 0 {
+		if _, err := state.startWave(); err != nil {
+			return err
+		}
+	}
+
+	for _, asg := range state.waveASGs() {
+		wave := &asg.Waves[state.CurrentWave]
+		if state.ScaleUpDone {
+			asg.RollbackInstances = wave.NewInstances
+			continue
+		}
+
+		// The scale up was interrupted, so the new instances were not recorded. Wait for the ASG to finish launching
+		// instances so that we can find all of them.
+		state.logger.Infof("Ensuring ASG %s is in steady state to find the instances launched in the interrupted wave", asg.Name)
+		err := waitForCapacity(asgSvc, asg.Name, state.maxRetries, state.sleepBetweenRetries)
+		if err != nil {
+			return err
+		}
+		knownInstanceIds := append(append([]string{}, asg.OriginalInstances...), asg.NewInstances...)
+		launchedInstanceIds, err := getLaunchedInstanceIds(asgSvc, asg.Name, knownInstanceIds)
+		if err != nil {
+			return err
+		}
+		asg.RollbackInstances = launchedInstanceIds
+	}
+	for _, asg := range state.ASGs {
+		if len(asg.RollbackInstances) > 0 {
+			state.logger.Infof("Rolling back the following instances in ASG %s: %s", asg.Name, strings.Join(asg.RollbackInstances, ","))
+		}
+	}
+	state.RollbackPlanDone = true
+	return state.persist()
+}
+
+// rollbackUncordonNodes uncordons the original nodes of the interrupted wave so that Pods can be scheduled on them
+// again.
+func (state *DeployState) rollbackUncordonNodes(ec2Svc *ec2.EC2, kubectlOptions *kubectl.KubectlOptions) error {
+	if state.RollbackUncordonDone {
+		state.logger.Debug("Original nodes already uncordoned - skipping")
+		return nil
+	}
+	originalInstances := state.waveOriginalInstances()
+	if len(originalInstances) > 0 {
+		state.logger.Infof("Uncordoning original instances: %s", strings.Join(originalInstances, ","))
+		err := uncordonNodesInAsg(ec2Svc, kubectlOptions, originalInstances)
+		if err != nil {
+			state.logger.Errorf("Error while uncordoning nodes.")
+			state.logger.Errorf("Either resume the roll back with the recovery file or uncordon the nodes that failed manually.")
+			return err
+		}
+	}
+	state.RollbackUncordonDone = true
+	return state.persist()
+}
+
+// rollbackReregisterInstances undoes the deregistration of the original instances of the interrupted wave from the
+// external load balancers, if it was started: the label excluding the original nodes from the load balancers is removed
+// with includeNodes, and the instances are registered again and waited on until they are healthy, before the new nodes
+// are drained.
+func (state *DeployState) rollbackReregisterInstances(
+	elbSvc elbiface.ELBAPI,
+	elbv2Svc elbv2iface.ELBV2API,
+	includeNodes func(nodeNames []string) error,
+) error {
+	if state.RollbackReregisterDone {
+		state.logger.Debug("Original instances already registered to load balancers - skipping")
+		return nil
+	}
+	if state.DeregisterInstancesStarted {
+		if len(state.ExcludedNodes) > 0 {
+			state.logger.Infof("Including original nodes in external load balancers: %s", strings.Join(state.ExcludedNodes, ","))
+			if err := includeNodes(state.ExcludedNodes); err != nil {
+				state.logger.Errorf("Error while removing the label excluding the original nodes from the load balancers.")
+				state.logger.Errorf("Either resume the roll back with the recovery file or remove the label manually.")
+				return err
+			}
+		}
+		err := registerLoadBalancerTargets(elbSvc, elbv2Svc, state.DeregisteredTargets, state.maxRetries, state.sleepBetweenRetries)
+		if err != nil {
+			state.logger.Errorf("Error while registering the original instances to the load balancers again.")
+			state.logger.Errorf("Either resume the roll back with the recovery file or register the instances manually.")
+			return err
+		}
+	}
+	state.RollbackReregisterDone = true
+	return state.persist()
+}
+
+// rollbackCordonNodes cordons the new nodes of the interrupted wave so that Kubernetes won't schedule new Pods on them.
+func (state *DeployState) rollbackCordonNodes(ec2Svc *ec2.EC2, kubectlOptions *kubectl.KubectlOptions) error {
+	if state.RollbackCordonDone {
+		state.logger.Debug("New nodes already cordoned - skipping")
+		return nil
+	}
+	nodeNames, err := state.registeredRollbackNodeNames(ec2Svc, kubectlOptions)
+	if err != nil {
+		return err
+	}
+	if len(nodeNames) > 0 {
+		state.logger.Infof("Cordoning new nodes: %s", strings.Join(nodeNames, ","))
+		err = kubectl.CordonNodes(kubectlOptions, nodeNames)
+		if err != nil {
+			state.logger.Errorf("Error while cordoning nodes.")
+			state.logger.Errorf("Either resume the roll back with the recovery file or cordon the nodes that failed manually.")
+			return err
+		}
+	}
+	state.RollbackCordonDone = true
+	return state.persist()
+}
+
+// rollbackDrainNodes drains the new nodes of the interrupted wave, so that the Pods are rescheduled on the original
+// nodes.
+func (state *DeployState) rollbackDrainNodes(
+	ec2Svc *ec2.EC2,
+	kubectlOptions *kubectl.KubectlOptions,
+	drainOptions kubectl.DrainOptions,
+) error {
+	if state.RollbackDrainDone {
+		state.logger.Debug("New nodes already drained - skipping")
+		return nil
+	}
+	nodeNames, err := state.registeredRollbackNodeNames(ec2Svc, kubectlOptions)
+	if err != nil {
+		return err
+	}
+	if len(nodeNames) > 0 {
+		state.logger.Infof("Draining Pods on new nodes: %s", strings.Join(nodeNames, ","))
+		_, err = kubectl.DrainNodes(kubectlOptions, nodeNames, drainOptions)
+		if err != nil {
+			state.logger.Errorf("Error while draining nodes.")
+			state.logger.Errorf("Either resume the roll back with the recovery file or drain the nodes that failed manually.")
+			return err
+		}
+	}
+	state.RollbackDrainDone = true
+	return state.persist()
+}
+
+// rollbackDetachInstances detaches the new instances of the interrupted wave from the ASGs, decrementing the desired
+// capacity back to the original value.
+func (state *DeployState) rollbackDetachInstances(asgSvc *autoscaling.AutoScaling) error {
+	if state.RollbackDetachDone {
+		state.logger.Debug("New instances already detached - skipping")
+		return nil
+	}
+	for _, asg := range state.ASGs {
+		if len(asg.RollbackInstances) == 0 {
+			continue
+		}
+		state.logger.Infof("Removing new nodes from ASG %s: %s", asg.Name, strings.Join(asg.RollbackInstances, ","))
+		err := detachInstances(asgSvc, asg.Name, asg.RollbackInstances)
+		if err != nil {
+			state.logger.Errorf("Error while detaching the new instances.")
+			state.logger.Errorf("Either resume the roll back with the recovery file or detach the new instances manually.")
+			return err
+		}
+	}
+	state.RollbackDetachDone = true
+	return state.persist()
+}
+
+// rollbackTerminateInstances terminates the new instances of the interrupted wave.
+func (state *DeployState) rollbackTerminateInstances(ec2Svc *ec2.EC2) error {
+	if state.RollbackTerminateDone {
+		state.logger.Debug("New instances already terminated - skipping")
+		return nil
+	}
+	rollbackInstances := state.rollbackInstances()
+	if len(rollbackInstances) > 0 {
+		state.logger.Infof("Terminating new nodes: %s", strings.Join(rollbackInstances, ","))
+		err := terminateInstances(ec2Svc, rollbackInstances)
+		if err != nil {
+			state.logger.Errorf("Error while terminating the new instances.")
+			state.logger.Errorf("Either resume the roll back with the recovery file or terminate the new instances manually.")
+			return err
+		}
+	}
+	state.RollbackTerminateDone = true
+	return state.persist()
+}
+
+// rollbackRestoreCapacity restores the original desired capacity and max size of the ASGs.
+func (state *DeployState) rollbackRestoreCapacity(asgSvc *autoscaling.AutoScaling) error {
+	if state.RollbackRestoreCapacityDone {
+		state.logger.Debug("Capacity already restored - skipping")
+		return nil
+	}
+	for _, asg := range state.waveASGs() {
+		err := setAsgCapacity(asgSvc, asg.Name, asg.OriginalCapacity)
+		if err != nil {
+			state.logger.Errorf("Error while restoring ASG %s desired capacity to %v.", asg.Name, asg.OriginalCapacity)
+			state.logger.Errorf("Either resume the roll back with the recovery file or adjust ASG desired capacity manually.")
+			return err
+		}
+	}
+	for _, asg := range state.ASGs {
+		err := setAsgMaxSize(asgSvc, asg.Name, asg.OriginalMaxCapacity)
+		if err != nil {
+			state.logger.Errorf("Error while restoring ASG %s max size to %v.", asg.Name, asg.OriginalMaxCapacity)
+			state.logger.Errorf("Either resume the roll back with the recovery file or adjust ASG max size manually.")
+			return err
+		}
+	}
+	state.RollbackRestoreCapacityDone = true
+	return state.persist()
+}
+
+// rollbackInstances returns the instances that are removed by the roll back across all the ASGs.
+func (state *DeployState) rollbackInstances() []string {
+	instanceIDs := []string{}
+	for _, asg := range state.ASGs {
+		instanceIDs = append(instanceIDs, asg.RollbackInstances...)
+	}
+	return instanceIDs
+}
+
+// registeredRollbackNodeNames returns the Kubernetes node names of the instances removed by the roll back that have
+// joined the cluster. Instances that never joined the cluster have nothing to cordon or drain.
+func (state *DeployState) registeredRollbackNodeNames(ec2Svc *ec2.EC2, kubectlOptions *kubectl.KubectlOptions) ([]string, error) {
+	rollbackInstances := state.rollbackInstances()
+	if len(rollbackInstances) == 0 {
+		return []string{}, nil
+	}
+	instances, err := instanceDetailsFromIds(ec2Svc, rollbackInstances)
+	if err != nil {
+		return nil, err
+	}
+	return kubectl.FilterRegisteredNodes(kubectlOptions, kubeNodeNamesFromInstances(instances))
+}
@@ -0,0 +1,145 @@
+package eks
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/elb"
+	"github.com/aws/aws-sdk-go/service/elbv2"
+	"github.com/gruntwork-io/go-commons/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPlanRollbackUsesNewInstancesOfInterruptedWave(t *testing.T) {
+	t.Parallel()
+
+	state := newTestMultiASGDeployState(t, ParallelASGRollout)
+	defer state.delete()
+
+	// Complete the first wave, and interrupt the second wave after the scale up.
+	hasWave, err := state.startWave()
+	require.NoError(t, err)
+	require.True(t, hasWave)
+	state.ASGs[0].Waves[0].NewInstances = []string{"a-3"}
+	state.ASGs[0].NewInstances = []string{"a-3"}
+	state.ASGs[1].Waves[0].NewInstances = []string{"b-2"}
+	state.ASGs[1].NewInstances = []string{"b-2"}
+	require.NoError(t, state.finishWave())
+
+	hasWave, err = state.startWave()
+	require.NoError(t, err)
+	require.True(t, hasWave)
+	state.ASGs[0].Waves[1].NewInstances = []string{"a-4"}
+	state.ASGs[0].NewInstances = append(state.ASGs[0].NewInstances, "a-4")
+	state.ScaleUpDone = true
+	state.CordonNodesDone = true
+
+	require.NoError(t, state.planRollback(nil))
+	assert.True(t, state.RollbackPlanDone)
+	assert.Equal(t, []string{"a-4"}, state.ASGs[0].RollbackInstances)
+	assert.Empty(t, state.ASGs[1].RollbackInstances)
+	assert.Equal(t, []string{"a-4"}, state.rollbackInstances())
+	assert.Equal(t, []string{"a-2"}, state.waveOriginalInstances())
+}
+
+func TestRollbackReregistersInstancesOnceDeregistrationStarted(t *testing.T) {
+	t.Parallel()
+
+	state := newTestMultiASGDeployState(t, ParallelASGRollout)
+	defer state.delete()
+	state.maxRetries = 3
+	state.sleepBetweenRetries = time.Millisecond
+
+	// Interrupt the first wave while the original instances are being deregistered from the load balancers.
+	hasWave, err := state.startWave()
+	require.NoError(t, err)
+	require.True(t, hasWave)
+	state.ScaleUpDone = true
+	state.DrainNodesDone = true
+	state.DeregisterInstancesStarted = true
+	state.DeregisteredTargets = []LoadBalancerTarget{
+		{LoadBalancerName: "clb", InstanceID: "a-1"},
+		{LoadBalancerName: "nlb", TargetGroupArn: "arn:instance-tg", InstanceID: "a-1", Port: 30080},
+	}
+	state.ExcludedNodes = []string{"node-a-1"}
+
+	require.NoError(t, state.planRollback(nil))
+	elbSvc := &fakeELB{
+		instanceStates: map[string][]*elb.InstanceState{
+			"clb": {{InstanceId: aws.String("a-1"), State: aws.String(clbOutOfServiceState)}},
+		},
+	}
+	elbv2Svc := &fakeELBV2{
+		targets: map[string][]*elbv2.TargetHealthDescription{"arn:instance-tg": {}},
+	}
+	includedNodes := []string{}
+	includeNodes := func(nodeNames []string) error {
+		includedNodes = append(includedNodes, nodeNames...)
+		return nil
+	}
+	require.NoError(t, state.rollbackReregisterInstances(elbSvc, elbv2Svc, includeNodes))
+	assert.True(t, state.RollbackReregisterDone)
+	assert.Equal(t, []string{"node-a-1"}, includedNodes)
+	assert.Equal(t, []string{"a-1"}, elbSvc.registered)
+	assert.Equal(t, []string{"a-1"}, elbv2Svc.registered)
+	assert.Equal(t, clbInServiceState, aws.StringValue(elbSvc.instanceStates["clb"][0].State))
+	assert.Equal(t, elbv2.TargetHealthStateEnumHealthy, aws.StringValue(elbv2Svc.targets["arn:instance-tg"][0].TargetHealth.State))
+
+	// The stage is not repeated when the roll back is resumed.
+	require.NoError(t, state.rollbackReregisterInstances(elbSvc, elbv2Svc, includeNodes))
+	assert.Equal(t, []string{"a-1"}, elbSvc.registered)
+}
+
+func TestRollbackSkipsReregisterBeforeDeregistration(t *testing.T) {
+	t.Parallel()
+
+	state := newTestMultiASGDeployState(t, ParallelASGRollout)
+	defer state.delete()
+
+	hasWave, err := state.startWave()
+	require.NoError(t, err)
+	require.True(t, hasWave)
+	state.ScaleUpDone = true
+
+	includeNodes := func(nodeNames []string) error {
+		return fmt.Errorf("unexpected call to include nodes %v", nodeNames)
+	}
+	elbSvc := &fakeELB{}
+	require.NoError(t, state.rollbackReregisterInstances(elbSvc, &fakeELBV2{}, includeNodes))
+	assert.True(t, state.RollbackReregisterDone)
+	assert.Empty(t, elbSvc.registered)
+}
+
+func TestPlanRollbackFailsAfterDetach(t *testing.T) {
+	t.Parallel()
+
+	state := newTestMultiASGDeployState(t, ParallelASGRollout)
+	defer state.delete()
+
+	hasWave, err := state.startWave()
+	require.NoError(t, err)
+	require.True(t, hasWave)
+	state.ScaleUpDone = true
+	state.DetachInstancesDone = true
+
+	err = state.planRollback(nil)
+	require.Error(t, err)
+	_, isRollbackNotPossibleErr := errors.Unwrap(err).(RollbackNotPossibleErr)
+	assert.True(t, isRollbackNotPossibleErr)
+	assert.False(t, state.RollbackPlanDone)
+}
+
+func TestPlanRollbackOfLegacyDeployStateAfterScaleUp(t *testing.T) {
+	t.Parallel()
+
+	state := newTestLegacyDeployState(t)
+	defer state.delete()
+
+	require.NoError(t, state.planRollback(nil))
+	assert.True(t, state.RollbackPlanDone)
+	assert.Equal(t, []string{"instance-3", "instance-4"}, state.rollbackInstances())
+	assert.Equal(t, []string{"instance-1", "instance-2"}, state.waveOriginalInstances())
+}
@@ -4,20 +4,24 @@ import (
 	"github.com/aws/aws-sdk-go/service/autoscaling"
 	"github.com/aws/aws-sdk-go/service/ec2"
 	"github.com/aws/aws-sdk-go/service/elb"
+	"github.com/aws/aws-sdk-go/service/elb/elbiface"
 	"github.com/aws/aws-sdk-go/service/elbv2"
+	"github.com/aws/aws-sdk-go/service/elbv2/elbv2iface"
+	"github.com/gruntwork-io/go-commons/collections"
 	"github.com/gruntwork-io/go-commons/errors"
 	"github.com/gruntwork-io/kubergrunt/kubectl"
 	"github.com/gruntwork-io/kubergrunt/logging"
 	"github.com/sirupsen/logrus"
-	"io/ioutil"
 	"k8s.io/apimachinery/pkg/util/json"
-	"os"
+	"math"
+	"strconv"
 	"strings"
 	"time"
 )
 
-// Store the state to current directory by default
-const defaultStateFile = "./.kubergrunt.state"
+// DefaultStateFile is the path where the deploy state is stored by the local state backend by default, which is in the
+// current directory.
+const DefaultStateFile = "./.kubergrunt.state"
 
 // DeployState is a basic state machine representing current state of eks deploy subcommand.
 // The entire deploy flow is split into multiple sub-stages and state is persisted after each stage.
@@ -26,19 +30,64 @@ type DeployState struct {
 	SetMaxCapacityDone     bool
 	ScaleUpDone            bool
 	WaitForNodesDone       bool
+	NodesHealthGatesDone   bool
 	CordonNodesDone        bool
 	DrainNodesDone         bool
+	DrainHealthGatesDone   bool
 	DetachInstancesDone    bool
 	TerminateInstancesDone bool
 	RestoreCapacityDone    bool
 
-	Path string
+	// DeregisterInstancesDone tracks whether the original instances of the current wave were deregistered from the
+	// external load balancers, which happens between draining and detaching them. DeregisterInstancesStarted is set
+	// before any change is made to deregister them, along with the registrations of the instances to the load
+	// balancers (DeregisteredTargets) and the nodes to label to be excluded from the load balancers (ExcludedNodes), so
+	// that a roll back can restore them.
+	DeregisterInstancesDone    bool
+	DeregisterInstancesStarted bool
+	DeregisteredTargets        []LoadBalancerTarget
+	ExcludedNodes              []string
+
+	// WaitForPodTargetsDone tracks whether the Pods rescheduled by the drain of the current wave are healthy in the
+	// external load balancers using the IP target type, which is checked before deregistering the original instances.
+	WaitForPodTargetsDone bool
+
+	// CurrentWave is the index of the wave that is currently being rolled out. The stage flags above (from ScaleUpDone
+	// to TerminateInstancesDone) track the progress of the current wave, and are reset when the wave completes.
+	CurrentWave int
+
+	// RolloutMode determines whether the ASGs are rolled out together (each wave covers all the ASGs) or in sequence
+	// (each ASG is fully rolled out before moving on to the next).
+	RolloutMode ASGRolloutMode
+
+	// Strategy is the deploy strategy used for the roll out. The stage flags for the waves above are only used by the
+	// surge strategy, while the following track the progress of the instance-refresh strategy.
+	Strategy                 DeployStrategy
+	PutLifecycleHooksDone    bool
+	DeleteLifecycleHooksDone bool
+
+	// The following track the progress of rolling back an interrupted roll out with `eks deploy rollback`.
+	RollbackPlanDone            bool
+	RollbackUncordonDone        bool
+	RollbackReregisterDone      bool
+	RollbackCordonDone          bool
+	RollbackDrainDone           bool
+	RollbackDetachDone          bool
+	RollbackTerminateDone       bool
+	RollbackRestoreCapacityDone bool
+
 	ASGs []ASG
 
 	maxRetries          int
 	sleepBetweenRetries time.Duration
+	maxSurge            string
+	batchSize           string
+
+	backend DeployStateBackend
+	logger  *logrus.Entry
 
-	logger *logrus.Entry
+	// lock is the cluster lock held during the roll out, which is checked before each checkpoint is persisted.
+	lock *ClusterLock
 }
 
 // ASG represents the Auto Scaling Group currently being worked on.
@@ -49,23 +98,73 @@ type ASG struct {
 	OriginalMaxCapacity  int64
 	OriginalInstances    []string
 	NewInstances         []string
+
+	// MaxSurge is the maximum number of instances that can be launched above the original capacity at any given time,
+	// and BatchSize is the maximum number of original instances that are replaced in a single wave. Both are resolved
+	// to absolute numbers when the ASG info is gathered.
+	MaxSurge  int64
+	BatchSize int64
+	Waves     []DeployWave
+
+	// RolloutDone is set once all the original instances of the ASG have been replaced.
+	RolloutDone bool
+
+	// RollbackInstances are the instances launched by the interrupted wave, which are removed when rolling back.
+	RollbackInstances []string
+
+	// InstanceRefreshID is the ID of the Instance Refresh in progress on the ASG, and DrainedInstances are the original
+	// instances that were drained and released to the refresh. Both are only used by the instance-refresh strategy.
+	InstanceRefreshID string
+	DrainedInstances  []string
 }
 
-// initDeployState initializes DeployState struct by either reading existing state file from disk,
-// or if one doesn't exist, create a new one. Does not persist the state to disk.
-func initDeployState(file string, ignoreExistingFile bool, maxRetries int, sleepBetweenRetries time.Duration) (*DeployState, error) {
+// DeployWave represents a single wave of the roll out for an ASG: the original instances that are replaced in the wave,
+// and the new instances that were launched to replace them.
+type DeployWave struct {
+	OriginalInstances []string
+	NewInstances      []string
+}
+
+// ASGRolloutMode represents how multiple ASGs are rolled out in a single deploy.
+type ASGRolloutMode string
+
+const (
+	// ParallelASGRollout rolls out all the ASGs together, so that each wave covers every ASG.
+	ParallelASGRollout ASGRolloutMode = "parallel"
+	// SequentialASGRollout fully rolls out each ASG before starting on the next one.
+	SequentialASGRollout ASGRolloutMode = "sequential"
+)
+
+// ASGRolloutModes lists all the supported ASGRolloutMode values.
+var ASGRolloutModes = []string{string(ParallelASGRollout), string(SequentialASGRollout)}
+
+// initDeployState initializes DeployState struct by either reading existing state from the backend,
+// or if one doesn't exist, create a new one. Does not persist the state to the backend.
+func initDeployState(
+	backend DeployStateBackend,
+	ignoreExistingFile bool,
+	maxRetries int,
+	sleepBetweenRetries time.Duration,
+	maxSurge string,
+	batchSize string,
+	rolloutMode ASGRolloutMode,
+	strategy DeployStrategy,
+) (*DeployState, error) {
 	logger := logging.GetProjectLogger()
 	var deployState *DeployState
 
 	if ignoreExistingFile {
 		logger.Info("Ignore existing state file.")
-		deployState = newDeployState(file)
+		deployState = newDeployState(backend)
 	} else {
-		logger.Debugf("Looking for existing recovery file %s", file)
-		data, err := ioutil.ReadFile(file)
+		logger.Debugf("Looking for existing recovery state in %s", backend.Location())
+		data, err := backend.Load()
 		if err != nil {
-			logger.Debugf("No state present, creating new: %s", err.Error())
-			deployState = newDeployState(file)
+			return nil, err
+		}
+		if data == nil {
+			logger.Debugf("No state present in %s, creating new", backend.Location())
+			deployState = newDeployState(backend)
 		} else {
 			var parsedState DeployState
 			err = json.Unmarshal(data, &parsedState)
@@ -73,20 +172,35 @@ func initDeployState(file string, ignoreExistingFile bool, maxRetries int, sleep
 				return nil, err
 			}
 			deployState = &parsedState
+			deployState.backend = backend
 		}
 	}
 
 	deployState.logger = logger
 	deployState.maxRetries = maxRetries
 	deployState.sleepBetweenRetries = sleepBetweenRetries
+	deployState.maxSurge = maxSurge
+	deployState.batchSize = batchSize
+	if deployState.RolloutMode == "" {
+		deployState.RolloutMode = rolloutMode
+	}
+	if !deployState.GatherASGInfoDone {
+		deployState.Strategy = strategy
+	} else if deployState.Strategy == "" {
+		// Recovery states recorded before deploy strategies were introduced are always for the surge strategy.
+		deployState.Strategy = SurgeDeployStrategy
+	}
 
 	return deployState, nil
 }
 
-// persist saves the DeployState struct to disk
+// persist saves the DeployState struct to the state backend. This fails without saving if the cluster lock was lost,
+// since whoever took over the lock may be working from the same state.
 func (state *DeployState) persist() error {
-	file := state.Path
-	state.logger.Debugf("storing state file %s", file)
+	if err := state.lock.CheckHeld(); err != nil {
+		return err
+	}
+	state.logger.Debugf("storing state in %s", state.backend.Location())
 
 	data, err := json.Marshal(state)
 
@@ -94,73 +208,89 @@ func (state *DeployState) persist() error {
 		return errors.WithStackTrace(err)
 	}
 
-	err = ioutil.WriteFile(file, data, 0644)
-	if err != nil {
-		return errors.WithStackTrace(err)
-	}
-	return nil
+	return state.backend.Save(data)
 }
 
-// delete deletes the DeployState struct from disk
+// delete deletes the DeployState struct from the state backend
 func (state *DeployState) delete() error {
-	file := state.Path
-	state.logger.Debugf("Deleting state file %s", file)
-
-	err := os.Remove(file)
-
-	if err != nil {
-		return errors.WithStackTrace(err)
-	}
-
-	return nil
+	state.logger.Debugf("Deleting state in %s", state.backend.Location())
+	return state.backend.Delete()
 }
 
 // newDeployState creates an empty DeployState struct
-func newDeployState(path string) *DeployState {
+func newDeployState(backend DeployStateBackend) *DeployState {
 	return &DeployState{
-		Path: path,
-		ASGs: []ASG{},
+		ASGs:    []ASG{},
+		backend: backend,
 	}
 }
 
-// gatherASGInfo gathers information about the Auto Scaling group currently being worked on. It ensures
-// that the ASG is fully operational with all requested instances running and saves the original configuration
+// gatherASGInfo gathers information about the Auto Scaling groups currently being worked on. It ensures
+// that the ASGs are fully operational with all requested instances running and saves the original configuration
 // (incl. max size, original capacity, instance IDs, etc.) that will be used in subsequent stages
 func (state *DeployState) gatherASGInfo(asgSvc *autoscaling.AutoScaling, eksAsgNames []string) error {
 	if state.GatherASGInfoDone {
+		if !state.hasASGs(eksAsgNames) {
+			return errors.WithStackTrace(RecoveryFileASGMismatchErr{requested: eksAsgNames, recorded: state.asgNames()})
+		}
 		// Even when we've collected the ASG info, we have to ensure max retries is set
-		state.maxRetries = ensureMaxRetries(state.maxRetries, state.sleepBetweenRetries, state.ASGs[0].OriginalCapacity)
+		state.maxRetries = ensureMaxRetries(state.maxRetries, state.sleepBetweenRetries, state.largestOriginalCapacity())
 		state.logger.Debug("ASG Info already gathered - skipping")
 		return nil
 	}
-	eksAsgName := eksAsgNames[0]
+
+	asgs := []ASG{}
+	for _, eksAsgName := range eksAsgNames {
+		asgInfo, err := state.gatherSingleASGInfo(asgSvc, eksAsgName)
+		if err != nil {
+			return err
+		}
+		asgs = append(asgs, asgInfo)
+	}
+
+	state.GatherASGInfoDone = true
+	state.ASGs = asgs
+	state.maxRetries = ensureMaxRetries(state.maxRetries, state.sleepBetweenRetries, state.largestOriginalCapacity())
+	return state.persist()
+}
+
+// gatherSingleASGInfo retrieves the original configuration of a single ASG, waiting for it to reach a steady state
+// if necessary.
+func (state *DeployState) gatherSingleASGInfo(asgSvc *autoscaling.AutoScaling, eksAsgName string) (ASG, error) {
 	// Retrieve the ASG object and gather required info we will need later
 	asgInfo, err := getAsgInfo(asgSvc, eksAsgName)
 	if err != nil {
-		return err
+		return ASG{}, err
 	}
 
-	// Calculate default max retries
-	state.maxRetries = ensureMaxRetries(state.maxRetries, state.sleepBetweenRetries, asgInfo.OriginalCapacity)
-
 	// Make sure ASG is in steady state
 	if asgInfo.OriginalCapacity != int64(len(asgInfo.OriginalInstances)) {
-		state.logger.Infof("Ensuring ASG is in steady state (current capacity = desired capacity)")
-		err = waitForCapacity(asgSvc, eksAsgName, state.maxRetries, state.sleepBetweenRetries)
+		state.logger.Infof("Ensuring ASG %s is in steady state (current capacity = desired capacity)", eksAsgName)
+		maxRetries := ensureMaxRetries(state.maxRetries, state.sleepBetweenRetries, asgInfo.OriginalCapacity)
+		err = waitForCapacity(asgSvc, eksAsgName, maxRetries, state.sleepBetweenRetries)
 		if err != nil {
 			state.logger.Error("Error waiting for ASG to reach steady state. Try again after the ASG is in a steady state.")
-			return err
+			return ASG{}, err
 		}
-		state.logger.Infof("Verified ASG is in steady state (current capacity = desired capacity)")
+		state.logger.Infof("Verified ASG %s is in steady state (current capacity = desired capacity)", eksAsgName)
 		asgInfo, err = getAsgInfo(asgSvc, eksAsgName)
 		if err != nil {
-			return err
+			return ASG{}, err
 		}
 	}
 
-	state.GatherASGInfoDone = true
-	state.ASGs = append(state.ASGs, asgInfo)
-	return state.persist()
+	// Resolve the batch configuration against the original capacity, so that resumed runs keep using the same wave
+	// sizes.
+	asgInfo.MaxSurge, err = resolveRolloutCount(state.maxSurge, asgInfo.OriginalCapacity)
+	if err != nil {
+		return ASG{}, err
+	}
+	asgInfo.BatchSize, err = resolveRolloutCount(state.batchSize, asgInfo.OriginalCapacity)
+	if err != nil {
+		return ASG{}, err
+	}
+	state.logger.Infof("Rolling out ASG %s with max surge of %d and batch size of %d", eksAsgName, asgInfo.MaxSurge, asgInfo.BatchSize)
+	return asgInfo, nil
 }
 
 // ensureMaxRetries ensures we always have a proper value for maxRetries, either set by the end user
@@ -182,158 +312,579 @@ func ensureMaxRetries(maxRetries int, sleepBetweenRetries time.Duration, origina
 	}
 }
 
-// setMaxCapacity will set the max size of the auto scaling group.
+// setMaxCapacity will set the max size of the auto scaling groups so that they can fit the surge instances.
 func (state *DeployState) setMaxCapacity(asgSvc *autoscaling.AutoScaling) error {
 	if state.SetMaxCapacityDone {
 		state.logger.Debug("Max capacity already set - skipping")
 		return nil
 	}
-	asg := &state.ASGs[0]
-	maxCapacityForUpdate := asg.OriginalCapacity * 2
-	if asg.OriginalMaxCapacity < maxCapacityForUpdate {
-		err := setAsgMaxSize(asgSvc, asg.Name, maxCapacityForUpdate)
-		if err != nil {
-			return err
+	for i := range state.ASGs {
+		asg := &state.ASGs[i]
+		maxCapacityForUpdate := asg.OriginalCapacity + asg.MaxSurge
+		if asg.OriginalMaxCapacity < maxCapacityForUpdate {
+			err := setAsgMaxSize(asgSvc, asg.Name, maxCapacityForUpdate)
+			if err != nil {
+				return err
+			}
 		}
+		asg.MaxCapacityForUpdate = maxCapacityForUpdate
 	}
-	asg.MaxCapacityForUpdate = maxCapacityForUpdate
 	state.SetMaxCapacityDone = true
 	return state.persist()
 }
 
-// scaleUp will scale up the ASG and wait until all the nodes are available.
+// startWave plans the next wave of the roll out by selecting the original instances of each active ASG that will be
+// replaced in the wave. Returns false when there are no original instances left to replace in the active ASGs.
+func (state *DeployState) startWave() (bool, error) {
+	activeASGs := state.activeASGs()
+	for _, asg := range activeASGs {
+		if state.CurrentWave < len(asg.Waves) {
+			state.logger.Debugf("Wave %d already planned - skipping", state.CurrentWave+1)
+			return true, nil
+		}
+	}
+
+	hasWave := false
+	for _, asg := range activeASGs {
+		wave, hasRemaining := asg.planWave()
+		if !hasRemaining {
+			continue
+		}
+		if state.ScaleUpDone && len(asg.Waves) == 0 {
+			// Recovery files from older versions record the new instances of the interrupted roll out on the ASG
+			// only, so carry them over to the single wave that replaces all the original instances.
+			wave.NewInstances = asg.NewInstances
+		}
+		state.logger.Infof(
+			"Starting wave %d of roll out for ASG %s: replacing %d of %d original instances",
+			state.CurrentWave+1,
+			asg.Name,
+			len(wave.OriginalInstances),
+			len(asg.OriginalInstances),
+		)
+		asg.Waves = append(asg.Waves, wave)
+		hasWave = true
+	}
+	if !hasWave {
+		return false, nil
+	}
+	return true, state.persist()
+}
+
+// finishWave marks the current wave as complete, resetting the stage flags so that the next wave can be started.
+func (state *DeployState) finishWave() error {
+	state.logger.Infof("Successfully finished wave %d of roll out", state.CurrentWave+1)
+	state.CurrentWave++
+	state.resetWaveStages()
+	return state.persist()
+}
+
+// finishActiveASGs marks the active ASGs as rolled out once all their waves are complete. Returns true if there are
+// ASGs left to roll out, which is only the case when the ASGs are rolled out in sequence.
+func (state *DeployState) finishActiveASGs() (bool, error) {
+	for _, asg := range state.activeASGs() {
+		state.logger.Infof("Successfully rolled out all instances in ASG %s", asg.Name)
+		asg.RolloutDone = true
+	}
+	state.CurrentWave = 0
+	state.resetWaveStages()
+	if err := state.persist(); err != nil {
+		return false, err
+	}
+	return len(state.activeASGs()) > 0, nil
+}
+
+// resetWaveStages resets the flags for the stages that are repeated for each wave.
+func (state *DeployState) resetWaveStages() {
+	state.ScaleUpDone = false
+	state.WaitForNodesDone = false
+	state.NodesHealthGatesDone = false
+	state.CordonNodesDone = false
+	state.DrainNodesDone = false
+	state.DrainHealthGatesDone = false
+	state.WaitForPodTargetsDone = false
+	state.DeregisterInstancesDone = false
+	state.DeregisterInstancesStarted = false
+	state.DeregisteredTargets = nil
+	state.ExcludedNodes = nil
+	state.DetachInstancesDone = false
+	state.TerminateInstancesDone = false
+}
+
+// scaleUp will scale up the ASGs to launch the replacement instances for the current wave and wait until all the
+// nodes are available.
 func (state *DeployState) scaleUp(asgSvc *autoscaling.AutoScaling) error {
 	if state.ScaleUpDone {
 		state.logger.Debug("Scale up already done - skipping")
 		return nil
 	}
-	asg := &state.ASGs[0]
-	state.logger.Info("Starting with the following list of instances in ASG:")
-	state.logger.Infof("%s", strings.Join(asg.OriginalInstances, ","))
+	waveASGs := state.waveASGs()
+
+	// Request the new capacity on all the ASGs before waiting on any of them, so that the instances launch together.
+	for _, asg := range waveASGs {
+		wave := &asg.Waves[state.CurrentWave]
+		state.logger.Infof("Replacing the following list of instances in ASG %s:", asg.Name)
+		state.logger.Infof("%s", strings.Join(wave.OriginalInstances, ","))
+
+		// Every prior wave detaches its original instances while decrementing the desired capacity, so the ASG is
+		// always back at its original capacity when a wave starts.
+		desiredCapacity := asg.OriginalCapacity + int64(len(wave.OriginalInstances))
+		state.logger.Infof("Launching new nodes with new launch config on ASG %s", asg.Name)
+		err := scaleUp(asgSvc, asg.Name, desiredCapacity)
+		if err != nil {
+			return err
+		}
+	}
 
-	state.logger.Infof("Launching new nodes with new launch config on ASG %s", asg.Name)
-	newInstanceIds, err := scaleUp(asgSvc, asg.Name, asg.OriginalInstances, asg.MaxCapacityForUpdate, state.maxRetries, state.sleepBetweenRetries)
-	if err != nil {
-		return err
+	for _, asg := range waveASGs {
+		wave := &asg.Waves[state.CurrentWave]
+		knownInstanceIds := append(append([]string{}, asg.OriginalInstances...), asg.NewInstances...)
+		newInstanceIds, err := waitForLaunchedInstances(asgSvc, asg.Name, knownInstanceIds, state.maxRetries, state.sleepBetweenRetries)
+		if err != nil {
+			return err
+		}
+		state.logger.Infof("Successfully launched new nodes with new launch config on ASG %s", asg.Name)
+		wave.NewInstances = newInstanceIds
+		asg.NewInstances = append(asg.NewInstances, newInstanceIds...)
 	}
-	state.logger.Infof("Successfully launched new nodes with new launch config on ASG %s", asg.Name)
 	state.ScaleUpDone = true
-	asg.NewInstances = newInstanceIds
 	return state.persist()
 }
 
-// waitForNodes will wait until all the new nodes are available. Specifically:
+// waitForNodes will wait until all the new nodes of the current wave are available. Specifically:
 // - Wait for the capacity in the ASG to meet the desired capacity (instances are launched)
 // - Wait for the new instances to be ready in Kubernetes
-// - Wait for the new instances to be registered with external load balancers
-func (state *DeployState) waitForNodes(ec2Svc *ec2.EC2, elbSvc *elb.ELB, elbv2Svc *elbv2.ELBV2, kubectlOptions *kubectl.KubectlOptions) error {
+// - Wait for the new instances to be healthy in external load balancers, as determined by the registration policy
+func (state *DeployState) waitForNodes(
+	ec2Svc *ec2.EC2,
+	elbSvc *elb.ELB,
+	elbv2Svc *elbv2.ELBV2,
+	kubectlOptions *kubectl.KubectlOptions,
+	elbRegistrationPolicy ELBRegistrationPolicy,
+) error {
 	if state.WaitForNodesDone {
 		state.logger.Debug("Wait for nodes already done - skipping")
 		return nil
 	}
-	asg := &state.ASGs[0]
-	err := waitAndVerifyNewInstances(ec2Svc, elbSvc, elbv2Svc, asg.NewInstances, kubectlOptions, state.maxRetries, state.sleepBetweenRetries)
+	newInstances := state.waveNewInstances()
+	err := waitAndVerifyNewInstances(
+		ec2Svc,
+		elbSvc,
+		elbv2Svc,
+		newInstances,
+		kubectlOptions,
+		elbRegistrationPolicy,
+		state.maxRetries,
+		state.sleepBetweenRetries,
+	)
 	if err != nil {
 		state.logger.Errorf("Error while waiting for new nodes to be ready.")
 		state.logger.Errorf("Either resume with the recovery file or terminate the new instances.")
 		return err
 	}
-	state.logger.Infof("Successfully confirmed new nodes were launched with new launch config on ASG %s", asg.Name)
+	state.logger.Infof("Successfully confirmed new nodes were launched with new launch config on ASGs %s", strings.Join(state.waveASGNames(), ","))
 	state.WaitForNodesDone = true
 	return state.persist()
 }
 
-// cordonNodes will cordon all the original nodes in the ASG so that Kubernetes won't schedule new Pods on them.
+// checkNodesHealthGates checks the health gates once the new nodes of the current wave are available, before any of the
+// original nodes are cordoned.
+func (state *DeployState) checkNodesHealthGates(gates []HealthGate) error {
+	if state.NodesHealthGatesDone {
+		state.logger.Debug("Health gates after waiting for nodes already passed - skipping")
+		return nil
+	}
+	err := checkHealthGates(gates, AfterWaitForNodesStage, state.maxRetries, state.sleepBetweenRetries)
+	if err != nil {
+		state.logger.Errorf("Health gate failed after waiting for new nodes.")
+		state.logger.Errorf("Either resume with the recovery file once the application is healthy, or roll back the new instances.")
+		return err
+	}
+	state.NodesHealthGatesDone = true
+	return state.persist()
+}
+
+// cordonNodes will cordon the original nodes of the current wave so that Kubernetes won't schedule new Pods on them.
 func (state *DeployState) cordonNodes(ec2Svc *ec2.EC2, kubectlOptions *kubectl.KubectlOptions) error {
 	if state.CordonNodesDone {
 		state.logger.Debug("Nodes already cordoned - skipping")
 		return nil
 	}
-	asg := &state.ASGs[0]
-	state.logger.Infof("Cordoning old instances in cluster ASG %s to prevent Pod scheduling", asg.Name)
-	err := cordonNodesInAsg(ec2Svc, kubectlOptions, asg.OriginalInstances)
+	asgNames := strings.Join(state.waveASGNames(), ",")
+	state.logger.Infof("Cordoning old instances in cluster ASGs %s to prevent Pod scheduling", asgNames)
+	err := cordonNodesInAsg(ec2Svc, kubectlOptions, state.waveOriginalInstances())
 	if err != nil {
 		state.logger.Errorf("Error while cordoning nodes.")
 		state.logger.Errorf("Either resume with the recovery file or continue to cordon nodes that failed manually, and then terminate the underlying instances to complete the rollout.")
 		return err
 	}
-	state.logger.Infof("Successfully cordoned old instances in cluster ASG %s", asg.Name)
+	state.logger.Infof("Successfully cordoned old instances in cluster ASGs %s", asgNames)
 	state.CordonNodesDone = true
 	return state.persist()
 }
 
-// drainNodes drains all the original nodes in Kubernetes.
-func (state *DeployState) drainNodes(ec2Svc *ec2.EC2, kubectlOptions *kubectl.KubectlOptions, drainTimeout time.Duration, deleteEmptyDirData bool) error {
+// drainNodes drains the original nodes of the current wave in Kubernetes.
+func (state *DeployState) drainNodes(
+	ec2Svc *ec2.EC2,
+	kubectlOptions *kubectl.KubectlOptions,
+	drainOptions kubectl.DrainOptions,
+	reporter *drainReporter,
+) error {
 	if state.DrainNodesDone {
 		state.logger.Debug("Nodes already drained - skipping")
 		return nil
 	}
-	asg := &state.ASGs[0]
-	state.logger.Infof("Draining Pods on old instances in cluster ASG %s", asg.Name)
-	err := drainNodesInAsg(ec2Svc, kubectlOptions, asg.OriginalInstances, drainTimeout, deleteEmptyDirData)
+	asgNames := strings.Join(state.waveASGNames(), ",")
+	state.logger.Infof("Draining Pods on old instances in cluster ASGs %s", asgNames)
+	err := drainNodesInAsg(ec2Svc, kubectlOptions, state.waveOriginalInstances(), drainOptions, reporter)
 	if err != nil {
 		state.logger.Errorf("Error while draining nodes.")
 		state.logger.Errorf("Either resume with the recovery file or continue to drain nodes that failed manually, and then terminate the underlying instances to complete the rollout.")
 		return err
 	}
-	state.logger.Infof("Successfully drained all scheduled Pods on old instances in cluster ASG %s", asg.Name)
+	state.logger.Infof("Successfully drained all scheduled Pods on old instances in cluster ASGs %s", asgNames)
 	state.DrainNodesDone = true
 	return state.persist()
 }
 
-// detachInstances detaches the original instances from the ASG and auto decrements the ASG desired capacity
+// checkDrainHealthGates checks the health gates once the original nodes of the current wave are drained, before the
+// original instances are detached and terminated.
+func (state *DeployState) checkDrainHealthGates(gates []HealthGate) error {
+	if state.DrainHealthGatesDone {
+		state.logger.Debug("Health gates after draining nodes already passed - skipping")
+		return nil
+	}
+	err := checkHealthGates(gates, AfterDrainNodesStage, state.maxRetries, state.sleepBetweenRetries)
+	if err != nil {
+		state.logger.Errorf("Health gate failed after draining old nodes.")
+		state.logger.Errorf("Either resume with the recovery file once the application is healthy, or roll back to the original instances.")
+		return err
+	}
+	state.DrainHealthGatesDone = true
+	return state.persist()
+}
+
+// waitForPodTargets waits for the Pods rescheduled by the drain of the current wave to be healthy in the external load
+// balancers that use the IP target type, as determined by the registration policy. These load balancers route to Pods
+// instead of instances, so this is the equivalent of waiting for the new instances to be registered.
+func (state *DeployState) waitForPodTargets(
+	ec2Svc *ec2.EC2,
+	elbv2Svc elbv2iface.ELBV2API,
+	kubectlOptions *kubectl.KubectlOptions,
+	elbRegistrationPolicy ELBRegistrationPolicy,
+) error {
+	if state.WaitForPodTargetsDone {
+		state.logger.Debug("Pod targets already healthy in load balancers - skipping")
+		return nil
+	}
+	instances, err := instanceDetailsFromIds(ec2Svc, state.waveOriginalInstances())
+	if err != nil {
+		return err
+	}
+	oldNodeNames := kubeNodeNamesFromInstances(instances)
+	elbs, err := kubectl.GetAWSLoadBalancers(kubectlOptions)
+	if err != nil {
+		state.logger.Errorf("Error retrieving associated ELB names of the Kubernetes services.")
+		state.logger.Errorf("Resume with the recovery file to try again.")
+		return err
+	}
+	listPodIPs := func() ([]string, error) {
+		return kubectl.GetPodIPsOutsideNodes(kubectlOptions, oldNodeNames)
+	}
+	err = waitForPodTargetsHealthyInELBs(elbv2Svc, elbs, listPodIPs, elbRegistrationPolicy, state.maxRetries, state.sleepBetweenRetries)
+	if err != nil {
+		state.logger.Errorf("Timed out waiting for the rescheduled Pods to be healthy in the load balancers.")
+		state.logger.Errorf("Either resume with the recovery file once the Pods are healthy, or roll back to the original instances.")
+		return err
+	}
+	state.WaitForPodTargetsDone = true
+	return state.persist()
+}
+
+// deregisterInstances explicitly deregisters the original instances of the current wave from the external load
+// balancers, and waits for connection draining to finish, so that in-flight requests are not dropped when the instances
+// are terminated. The original nodes are also labeled with node.kubernetes.io/exclude-from-external-load-balancers, so
+// that the controllers managing the load balancers do not register them again. The registrations and the labeled nodes
+// are recorded before any change is made, so that a roll back can restore them.
+func (state *DeployState) deregisterInstances(
+	ec2Svc *ec2.EC2,
+	elbSvc elbiface.ELBAPI,
+	elbv2Svc elbv2iface.ELBV2API,
+	kubectlOptions *kubectl.KubectlOptions,
+) error {
+	if state.DeregisterInstancesDone {
+		state.logger.Debug("Instances already deregistered from load balancers - skipping")
+		return nil
+	}
+	elbs, err := kubectl.GetAWSLoadBalancers(kubectlOptions)
+	if err != nil {
+		state.logger.Errorf("Error retrieving associated ELB names of the Kubernetes services.")
+		state.logger.Errorf("Resume with the recovery file to try again.")
+		return err
+	}
+	if !state.DeregisterInstancesStarted {
+		targets, err := getLoadBalancerTargets(elbSvc, elbv2Svc, elbs, state.waveOriginalInstances())
+		if err != nil {
+			return err
+		}
+		instances, err := instanceDetailsFromIds(ec2Svc, state.waveOriginalInstances())
+		if err != nil {
+			return err
+		}
+		// Leave alone the nodes that were already excluded, so that a roll back does not include them.
+		excludedNodes, err := kubectl.FilterNodesIncludedInLoadBalancers(kubectlOptions, kubeNodeNamesFromInstances(instances))
+		if err != nil {
+			return err
+		}
+		state.DeregisteredTargets = targets
+		state.ExcludedNodes = excludedNodes
+		state.DeregisterInstancesStarted = true
+		if err := state.persist(); err != nil {
+			return err
+		}
+	}
+	if len(state.ExcludedNodes) > 0 {
+		state.logger.Infof("Excluding old nodes from external load balancers: %s", strings.Join(state.ExcludedNodes, ","))
+		if err := kubectl.SetNodesExcludedFromLoadBalancers(kubectlOptions, state.ExcludedNodes, true); err != nil {
+			state.logger.Errorf("Error while labeling the old nodes to be excluded from the load balancers.")
+			state.logger.Errorf("Either resume with the recovery file or roll back to the original instances.")
+			return err
+		}
+	}
+	err = deregisterInstancesFromELBs(elbSvc, elbv2Svc, elbs, state.waveOriginalInstances(), state.maxRetries, state.sleepBetweenRetries)
+	if err != nil {
+		state.logger.Errorf("Error while deregistering the old instances from the load balancers.")
+		state.logger.Errorf("Either resume with the recovery file or deregister the old instances manually, and then terminate the underlying instances to complete the rollout.")
+		return err
+	}
+	state.logger.Infof("Successfully deregistered old instances in cluster ASGs %s from load balancers", strings.Join(state.waveASGNames(), ","))
+	state.DeregisterInstancesDone = true
+	return state.persist()
+}
+
+// detachInstances detaches the original instances of the current wave from the ASGs and auto decrements the ASG
+// desired capacity
 func (state *DeployState) detachInstances(asgSvc *autoscaling.AutoScaling) error {
 	if state.DetachInstancesDone {
 		state.logger.Debug("Instances already detached - skipping")
 		return nil
 	}
-	asg := &state.ASGs[0]
-	state.logger.Infof("Removing old nodes from ASG %s: %s", asg.Name, strings.Join(asg.OriginalInstances, ","))
-	err := detachInstances(asgSvc, asg.Name, asg.OriginalInstances)
-	if err != nil {
-		state.logger.Errorf("Error while detaching the old instances.")
-		state.logger.Errorf("Either resume with the recovery file or continue to detach the old instances and then terminate the underlying instances to complete the rollout.")
-		return err
+	for _, asg := range state.waveASGs() {
+		wave := &asg.Waves[state.CurrentWave]
+		state.logger.Infof("Removing old nodes from ASG %s: %s", asg.Name, strings.Join(wave.OriginalInstances, ","))
+		err := detachInstances(asgSvc, asg.Name, wave.OriginalInstances)
+		if err != nil {
+			state.logger.Errorf("Error while detaching the old instances.")
+			state.logger.Errorf("Either resume with the recovery file or continue to detach the old instances and then terminate the underlying instances to complete the rollout.")
+			return err
+		}
 	}
 	state.DetachInstancesDone = true
 	return state.persist()
 }
 
-// terminateInstances terminates the original instances in the ASG.
+// terminateInstances terminates the original instances of the current wave.
 func (state *DeployState) terminateInstances(ec2Svc *ec2.EC2) error {
 	if state.TerminateInstancesDone {
 		state.logger.Debug("Instances already terminated - skipping")
 		return nil
 	}
-	asg := &state.ASGs[0]
-	state.logger.Infof("Terminating old nodes: %s", strings.Join(asg.OriginalInstances, ","))
-	err := terminateInstances(ec2Svc, asg.OriginalInstances)
+	originalInstances := state.waveOriginalInstances()
+	state.logger.Infof("Terminating old nodes: %s", strings.Join(originalInstances, ","))
+	err := terminateInstances(ec2Svc, originalInstances)
 	if err != nil {
 		state.logger.Errorf("Error while terminating the old instances.")
 		state.logger.Errorf("Either resume with the recovery file or continue to terminate the underlying instances to complete the rollout.")
 		return err
 	}
-	state.logger.Infof("Successfully removed old nodes from ASG %s", asg.Name)
+	state.logger.Infof("Successfully removed old nodes from ASGs %s", strings.Join(state.waveASGNames(), ","))
 	state.TerminateInstancesDone = true
 	return state.persist()
 }
 
-// restoreCapacity restores the max size of the ASG to its original value.
+// restoreCapacity restores the max size of the ASGs to their original value.
 func (state *DeployState) restoreCapacity(asgSvc *autoscaling.AutoScaling) error {
 	if state.RestoreCapacityDone {
 		state.logger.Debug("Capacity already restored - skipping")
 		return nil
 	}
-	asg := &state.ASGs[0]
-	err := setAsgMaxSize(asgSvc, asg.Name, asg.OriginalMaxCapacity)
-	if err != nil {
-		state.logger.Errorf("Error while restoring ASG %s max size to %v.", asg.Name, asg.OriginalMaxCapacity)
-		state.logger.Errorf("Either resume with the recovery file or adjust ASG max size manually to complete the rollout.")
-		return err
+	for _, asg := range state.ASGs {
+		err := setAsgMaxSize(asgSvc, asg.Name, asg.OriginalMaxCapacity)
+		if err != nil {
+			state.logger.Errorf("Error while restoring ASG %s max size to %v.", asg.Name, asg.OriginalMaxCapacity)
+			state.logger.Errorf("Either resume with the recovery file or adjust ASG max size manually to complete the rollout.")
+			return err
+		}
 	}
+	state.RestoreCapacityDone = true
 	return state.persist()
 }
 
+// activeASGs returns the ASGs that are currently being rolled out. When rolling out in parallel, this is every ASG that
+// is not yet done, while in sequence this is only the first ASG that is not yet done.
+func (state *DeployState) activeASGs() []*ASG {
+	out := []*ASG{}
+	for i := range state.ASGs {
+		asg := &state.ASGs[i]
+		if asg.RolloutDone {
+			continue
+		}
+		out = append(out, asg)
+		if state.RolloutMode == SequentialASGRollout {
+			break
+		}
+	}
+	return out
+}
+
+// waveASGs returns the active ASGs that have instances to replace in the current wave.
+func (state *DeployState) waveASGs() []*ASG {
+	out := []*ASG{}
+	for _, asg := range state.activeASGs() {
+		if state.CurrentWave < len(asg.Waves) {
+			out = append(out, asg)
+		}
+	}
+	return out
+}
+
+// waveASGNames returns the names of the ASGs that have instances to replace in the current wave.
+func (state *DeployState) waveASGNames() []string {
+	names := []string{}
+	for _, asg := range state.waveASGs() {
+		names = append(names, asg.Name)
+	}
+	return names
+}
+
+// waveOriginalInstances returns the original instances that are replaced in the current wave across all the ASGs.
+func (state *DeployState) waveOriginalInstances() []string {
+	instanceIDs := []string{}
+	for _, asg := range state.waveASGs() {
+		instanceIDs = append(instanceIDs, asg.Waves[state.CurrentWave].OriginalInstances...)
+	}
+	return instanceIDs
+}
+
+// waveNewInstances returns the instances that were launched in the current wave across all the ASGs.
+func (state *DeployState) waveNewInstances() []string {
+	instanceIDs := []string{}
+	for _, asg := range state.waveASGs() {
+		instanceIDs = append(instanceIDs, asg.Waves[state.CurrentWave].NewInstances...)
+	}
+	return instanceIDs
+}
+
+// remainingOriginalInstances returns the original instances that have not yet been replaced, which includes the
+// instances of the current wave.
+func (state *DeployState) remainingOriginalInstances() []string {
+	instanceIDs := []string{}
+	for _, asg := range state.ASGs {
+		if asg.RolloutDone {
+			continue
+		}
+		replaced := append([]string{}, asg.DrainedInstances...)
+		for i := 0; i < state.CurrentWave && i < len(asg.Waves); i++ {
+			replaced = append(replaced, asg.Waves[i].OriginalInstances...)
+		}
+		for _, instanceID := range asg.OriginalInstances {
+			if !collections.ListContainsElement(replaced, instanceID) {
+				instanceIDs = append(instanceIDs, instanceID)
+			}
+		}
+	}
+	return instanceIDs
+}
+
+// asgNames returns the names of all the ASGs recorded in the state.
+func (state *DeployState) asgNames() []string {
+	names := []string{}
+	for _, asg := range state.ASGs {
+		names = append(names, asg.Name)
+	}
+	return names
+}
+
+// hasASGs returns true if the state records exactly the given set of ASGs.
+func (state *DeployState) hasASGs(asgNames []string) bool {
+	recorded := state.asgNames()
+	if len(recorded) != len(asgNames) {
+		return false
+	}
+	for _, name := range asgNames {
+		if !collections.ListContainsElement(recorded, name) {
+			return false
+		}
+	}
+	return true
+}
+
+// largestOriginalCapacity returns the largest original capacity across all the ASGs, which is used to calculate the
+// default max retries.
+func (state *DeployState) largestOriginalCapacity() int64 {
+	var largest int64
+	for _, asg := range state.ASGs {
+		if asg.OriginalCapacity > largest {
+			largest = asg.OriginalCapacity
+		}
+	}
+	return largest
+}
+
+// planWave returns the next wave for the ASG, containing the original instances that have not been replaced in a
+// previous wave, limited by the batch size and max surge. The second return value is false if all the original
+// instances have already been replaced.
+func (asg *ASG) planWave() (DeployWave, bool) {
+	replaced := []string{}
+	for _, wave := range asg.Waves {
+		replaced = append(replaced, wave.OriginalInstances...)
+	}
+	remaining := []string{}
+	for _, instanceID := range asg.OriginalInstances {
+		if !collections.ListContainsElement(replaced, instanceID) {
+			remaining = append(remaining, instanceID)
+		}
+	}
+	if len(remaining) == 0 {
+		return DeployWave{}, false
+	}
+
+	waveSize := asg.BatchSize
+	if asg.MaxSurge < waveSize {
+		waveSize = asg.MaxSurge
+	}
+	if waveSize <= 0 || waveSize > int64(len(remaining)) {
+		// Recovery files from older versions do not have a batch configuration, in which case all the instances are
+		// replaced in a single wave.
+		waveSize = int64(len(remaining))
+	}
+	return DeployWave{OriginalInstances: remaining[:waveSize]}, true
+}
+
+// resolveRolloutCount converts a max surge or batch size value, expressed either as an absolute number of instances
+// (e.g. 5) or as a percentage of the original capacity (e.g. 25%), into an absolute number of instances. Percentages
+// are rounded up so that each wave replaces at least one instance.
+func resolveRolloutCount(value string, originalCapacity int64) (int64, error) {
+	trimmed := strings.TrimSpace(value)
+	if strings.HasSuffix(trimmed, "%") {
+		percentage, err := strconv.ParseInt(strings.TrimSuffix(trimmed, "%"), 10, 64)
+		if err != nil || percentage <= 0 || percentage > 100 {
+			return 0, errors.WithStackTrace(InvalidRolloutCountErr{value})
+		}
+		count := int64(math.Ceil(float64(originalCapacity) * float64(percentage) / 100))
+		if count < 1 {
+			count = 1
+		}
+		return count, nil
+	}
+
+	count, err := strconv.ParseInt(trimmed, 10, 64)
+	if err != nil || count <= 0 {
+		return 0, errors.WithStackTrace(InvalidRolloutCountErr{value})
+	}
+	return count, nil
+}
+
 // Retrieves current state of the ASG and returns the original Capacity and the IDs of the instances currently
 // associated with it.
 func getAsgInfo(asgSvc *autoscaling.AutoScaling, asgName string) (ASG, error) {
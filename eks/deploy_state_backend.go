@@ -0,0 +1,338 @@
+package eks
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3iface"
+	"github.com/gruntwork-io/go-commons/errors"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+
+	"github.com/gruntwork-io/kubergrunt/eksawshelper"
+	"github.com/gruntwork-io/kubergrunt/kubectl"
+)
+
+// DeployStateBackendType represents where the deploy recovery state is stored.
+type DeployStateBackendType string
+
+const (
+	// LocalDeployStateBackend stores the deploy state in a file on the local disk.
+	LocalDeployStateBackend DeployStateBackendType = "local"
+	// ConfigMapDeployStateBackend stores the deploy state in a ConfigMap in the target Kubernetes cluster.
+	ConfigMapDeployStateBackend DeployStateBackendType = "configmap"
+	// SecretDeployStateBackend stores the deploy state in a Secret in the target Kubernetes cluster.
+	SecretDeployStateBackend DeployStateBackendType = "secret"
+	// S3DeployStateBackend stores the deploy state as an object in an S3 bucket.
+	S3DeployStateBackend DeployStateBackendType = "s3"
+)
+
+// DeployStateBackendTypes lists all the supported DeployStateBackendType values.
+var DeployStateBackendTypes = []string{
+	string(LocalDeployStateBackend),
+	string(ConfigMapDeployStateBackend),
+	string(SecretDeployStateBackend),
+	string(S3DeployStateBackend),
+}
+
+const (
+	// The key in the ConfigMap or Secret data where the deploy state is stored.
+	deployStateDataKey = "state"
+
+	// Defaults for the remote deploy state backends.
+	DefaultDeployStateNamespace = "kube-system"
+	DefaultDeployStateName      = "kubergrunt-deploy-state"
+	DefaultDeployStateS3Key     = "kubergrunt/deploy.state"
+)
+
+// DeployStateBackend is the interface for storing the raw deploy recovery state, so that a deploy that was interrupted
+// can be resumed from a different machine.
+type DeployStateBackend interface {
+	// Load returns the stored state, or nil if no state has been stored yet.
+	Load() ([]byte, error)
+	// Save stores the state, overwriting any existing state.
+	Save(data []byte) error
+	// Delete removes the stored state.
+	Delete() error
+	// Location returns a human readable description of where the state is stored, for use in log messages.
+	Location() string
+}
+
+// DeployStateBackendConfig represents the user provided configuration for selecting and constructing the deploy state
+// backend.
+type DeployStateBackendConfig struct {
+	Type DeployStateBackendType
+
+	// Used by the local backend.
+	Path string
+
+	// Used by the configmap and secret backends.
+	Namespace string
+	Name      string
+
+	// Used by the s3 backend.
+	S3Bucket string
+	S3Key    string
+	S3Region string
+}
+
+// NewDeployStateBackend constructs the DeployStateBackend requested in the config. The region is used for the S3
+// backend if no explicit region is configured, and the kubectl options are used to authenticate to the cluster for the
+// configmap and secret backends.
+func NewDeployStateBackend(
+	config DeployStateBackendConfig,
+	region string,
+	kubectlOptions *kubectl.KubectlOptions,
+) (DeployStateBackend, error) {
+	switch config.Type {
+	case LocalDeployStateBackend, "":
+		path := config.Path
+		if path == "" {
+			path = DefaultStateFile
+		}
+		return &LocalFileStateBackend{Path: path}, nil
+	case ConfigMapDeployStateBackend, SecretDeployStateBackend:
+		clientset, err := kubectl.GetKubernetesClientFromOptions(kubectlOptions)
+		if err != nil {
+			return nil, err
+		}
+		namespace, name := config.Namespace, config.Name
+		if namespace == "" {
+			namespace = DefaultDeployStateNamespace
+		}
+		if name == "" {
+			name = DefaultDeployStateName
+		}
+		if config.Type == SecretDeployStateBackend {
+			return &SecretStateBackend{Clientset: clientset, Namespace: namespace, Name: name}, nil
+		}
+		return &ConfigMapStateBackend{Clientset: clientset, Namespace: namespace, Name: name}, nil
+	case S3DeployStateBackend:
+		if config.S3Bucket == "" {
+			return nil, errors.WithStackTrace(MissingDeployStateBackendOptionErr{backendType: config.Type, option: "S3 bucket"})
+		}
+		s3Region := config.S3Region
+		if s3Region == "" {
+			s3Region = region
+		}
+		sess, err := eksawshelper.NewAuthenticatedSession(s3Region)
+		if err != nil {
+			return nil, errors.WithStackTrace(err)
+		}
+		key := config.S3Key
+		if key == "" {
+			key = DefaultDeployStateS3Key
+		}
+		return &S3StateBackend{Svc: s3.New(sess), Bucket: config.S3Bucket, Key: key}, nil
+	}
+	return nil, errors.WithStackTrace(UnsupportedDeployStateBackendErr{backendType: config.Type})
+}
+
+// LocalFileStateBackend stores the deploy state in a file on the local disk.
+type LocalFileStateBackend struct {
+	Path string
+}
+
+func (backend *LocalFileStateBackend) Load() ([]byte, error) {
+	data, err := ioutil.ReadFile(backend.Path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, errors.WithStackTrace(err)
+	}
+	return data, nil
+}
+
+func (backend *LocalFileStateBackend) Save(data []byte) error {
+	return errors.WithStackTrace(ioutil.WriteFile(backend.Path, data, 0644))
+}
+
+func (backend *LocalFileStateBackend) Delete() error {
+	return errors.WithStackTrace(os.Remove(backend.Path))
+}
+
+func (backend *LocalFileStateBackend) Location() string {
+	return backend.Path
+}
+
+// ConfigMapStateBackend stores the deploy state in a ConfigMap in the target Kubernetes cluster.
+type ConfigMapStateBackend struct {
+	Clientset kubernetes.Interface
+	Namespace string
+	Name      string
+}
+
+func (backend *ConfigMapStateBackend) Load() ([]byte, error) {
+	configMap, err := backend.Clientset.CoreV1().ConfigMaps(backend.Namespace).Get(context.Background(), backend.Name, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, errors.WithStackTrace(err)
+	}
+	data, hasData := configMap.Data[deployStateDataKey]
+	if !hasData {
+		return nil, nil
+	}
+	return []byte(data), nil
+}
+
+func (backend *ConfigMapStateBackend) Save(data []byte) error {
+	configMaps := backend.Clientset.CoreV1().ConfigMaps(backend.Namespace)
+	configMap, err := configMaps.Get(context.Background(), backend.Name, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		newConfigMap := &corev1.ConfigMap{
+			ObjectMeta: kubergruntObjectMeta(backend.Namespace, backend.Name),
+			Data:       map[string]string{deployStateDataKey: string(data)},
+		}
+		_, err = configMaps.Create(context.Background(), newConfigMap, metav1.CreateOptions{})
+		return errors.WithStackTrace(err)
+	}
+	if err != nil {
+		return errors.WithStackTrace(err)
+	}
+	if configMap.Data == nil {
+		configMap.Data = map[string]string{}
+	}
+	configMap.Data[deployStateDataKey] = string(data)
+	_, err = configMaps.Update(context.Background(), configMap, metav1.UpdateOptions{})
+	return errors.WithStackTrace(err)
+}
+
+func (backend *ConfigMapStateBackend) Delete() error {
+	err := backend.Clientset.CoreV1().ConfigMaps(backend.Namespace).Delete(context.Background(), backend.Name, metav1.DeleteOptions{})
+	return errors.WithStackTrace(err)
+}
+
+func (backend *ConfigMapStateBackend) Location() string {
+	return fmt.Sprintf("ConfigMap %s/%s", backend.Namespace, backend.Name)
+}
+
+// SecretStateBackend stores the deploy state in a Secret in the target Kubernetes cluster.
+type SecretStateBackend struct {
+	Clientset kubernetes.Interface
+	Namespace string
+	Name      string
+}
+
+func (backend *SecretStateBackend) Load() ([]byte, error) {
+	secret, err := backend.Clientset.CoreV1().Secrets(backend.Namespace).Get(context.Background(), backend.Name, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, errors.WithStackTrace(err)
+	}
+	data, hasData := secret.Data[deployStateDataKey]
+	if !hasData {
+		return nil, nil
+	}
+	return data, nil
+}
+
+func (backend *SecretStateBackend) Save(data []byte) error {
+	secrets := backend.Clientset.CoreV1().Secrets(backend.Namespace)
+	secret, err := secrets.Get(context.Background(), backend.Name, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		newSecret := kubectl.PrepareSecret(backend.Namespace, backend.Name, kubergruntLabels(), nil)
+		kubectl.AddToSecretFromData(newSecret, deployStateDataKey, data)
+		_, err = secrets.Create(context.Background(), newSecret, metav1.CreateOptions{})
+		return errors.WithStackTrace(err)
+	}
+	if err != nil {
+		return errors.WithStackTrace(err)
+	}
+	if secret.Data == nil {
+		secret.Data = map[string][]byte{}
+	}
+	kubectl.AddToSecretFromData(secret, deployStateDataKey, data)
+	_, err = secrets.Update(context.Background(), secret, metav1.UpdateOptions{})
+	return errors.WithStackTrace(err)
+}
+
+func (backend *SecretStateBackend) Delete() error {
+	err := backend.Clientset.CoreV1().Secrets(backend.Namespace).Delete(context.Background(), backend.Name, metav1.DeleteOptions{})
+	return errors.WithStackTrace(err)
+}
+
+func (backend *SecretStateBackend) Location() string {
+	return fmt.Sprintf("Secret %s/%s", backend.Namespace, backend.Name)
+}
+
+// S3StateBackend stores the deploy state as an object in an S3 bucket.
+type S3StateBackend struct {
+	Svc    s3iface.S3API
+	Bucket string
+	Key    string
+}
+
+func (backend *S3StateBackend) Load() ([]byte, error) {
+	output, err := backend.Svc.GetObject(&s3.GetObjectInput{
+		Bucket: aws.String(backend.Bucket),
+		Key:    aws.String(backend.Key),
+	})
+	if isS3NotFoundErr(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, errors.WithStackTrace(err)
+	}
+	defer output.Body.Close()
+	data, err := ioutil.ReadAll(output.Body)
+	if err != nil {
+		return nil, errors.WithStackTrace(err)
+	}
+	return data, nil
+}
+
+func (backend *S3StateBackend) Save(data []byte) error {
+	_, err := backend.Svc.PutObject(&s3.PutObjectInput{
+		Bucket: aws.String(backend.Bucket),
+		Key:    aws.String(backend.Key),
+		Body:   bytes.NewReader(data),
+	})
+	return errors.WithStackTrace(err)
+}
+
+func (backend *S3StateBackend) Delete() error {
+	_, err := backend.Svc.DeleteObject(&s3.DeleteObjectInput{
+		Bucket: aws.String(backend.Bucket),
+		Key:    aws.String(backend.Key),
+	})
+	return errors.WithStackTrace(err)
+}
+
+func (backend *S3StateBackend) Location() string {
+	return fmt.Sprintf("s3://%s/%s", backend.Bucket, backend.Key)
+}
+
+// isS3NotFoundErr returns true if the error indicates that the requested S3 object does not exist.
+func isS3NotFoundErr(err error) bool {
+	if awsErr, isAwsErr := err.(awserr.RequestFailure); isAwsErr {
+		return awsErr.StatusCode() == 404 || awsErr.Code() == s3.ErrCodeNoSuchKey
+	}
+	return false
+}
+
+// kubergruntObjectMeta returns the metadata for the Kubernetes objects that kubergrunt manages.
+func kubergruntObjectMeta(namespace string, name string) metav1.ObjectMeta {
+	return metav1.ObjectMeta{
+		Namespace: namespace,
+		Name:      name,
+		Labels:    kubergruntLabels(),
+	}
+}
+
+// kubergruntLabels returns the labels that mark the Kubernetes objects as managed by kubergrunt.
+func kubergruntLabels() map[string]string {
+	return map[string]string{"app.kubernetes.io/managed-by": "kubergrunt"}
+}
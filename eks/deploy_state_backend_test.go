@@ -0,0 +1,167 @@
+package eks
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestDeployStateBackends(t *testing.T) {
+	t.Parallel()
+
+	s3Server := newFakeS3Server()
+	t.Cleanup(s3Server.Close)
+
+	testCases := []struct {
+		name    string
+		backend func(t *testing.T) DeployStateBackend
+	}{
+		{
+			"local",
+			func(t *testing.T) DeployStateBackend {
+				return &LocalFileStateBackend{Path: filepath.Join(t.TempDir(), ".kubergrunt.state")}
+			},
+		},
+		{
+			"configmap",
+			func(t *testing.T) DeployStateBackend {
+				return &ConfigMapStateBackend{Clientset: fake.NewSimpleClientset(), Namespace: "kube-system", Name: "state"}
+			},
+		},
+		{
+			"secret",
+			func(t *testing.T) DeployStateBackend {
+				return &SecretStateBackend{Clientset: fake.NewSimpleClientset(), Namespace: "kube-system", Name: "state"}
+			},
+		},
+		{
+			"s3",
+			func(t *testing.T) DeployStateBackend {
+				return &S3StateBackend{Svc: newFakeS3Client(t, s3Server.URL), Bucket: "my-bucket", Key: t.Name()}
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			backend := tc.backend(t)
+
+			data, err := backend.Load()
+			require.NoError(t, err)
+			assert.Nil(t, data)
+
+			require.NoError(t, backend.Save([]byte("first")))
+			data, err = backend.Load()
+			require.NoError(t, err)
+			assert.Equal(t, "first", string(data))
+
+			require.NoError(t, backend.Save([]byte("second")))
+			data, err = backend.Load()
+			require.NoError(t, err)
+			assert.Equal(t, "second", string(data))
+
+			require.NoError(t, backend.Delete())
+			data, err = backend.Load()
+			require.NoError(t, err)
+			assert.Nil(t, data)
+		})
+	}
+}
+
+func TestResumeDeployStateFromConfigMap(t *testing.T) {
+	t.Parallel()
+
+	clientset := fake.NewSimpleClientset()
+	backend := &ConfigMapStateBackend{Clientset: clientset, Namespace: "kube-system", Name: "state"}
+
+	state, err := initDeployState(backend, false, 3, 30*time.Second, "100%", "100%", SequentialASGRollout, SurgeDeployStrategy)
+	require.NoError(t, err)
+	state.GatherASGInfoDone = true
+	state.ASGs = []ASG{{Name: "my-test-asg", OriginalCapacity: 2, OriginalInstances: []string{"instance-1", "instance-2"}}}
+	require.NoError(t, state.persist())
+
+	// Resume with a new backend pointing to the same ConfigMap, as would happen on a different machine.
+	resumedBackend := &ConfigMapStateBackend{Clientset: clientset, Namespace: "kube-system", Name: "state"}
+	resumed, err := initDeployState(resumedBackend, false, 3, 30*time.Second, "100%", "100%", ParallelASGRollout, SurgeDeployStrategy)
+	require.NoError(t, err)
+	assert.True(t, resumed.GatherASGInfoDone)
+	assert.Equal(t, SequentialASGRollout, resumed.RolloutMode)
+	require.Equal(t, 1, len(resumed.ASGs))
+	assert.Equal(t, "my-test-asg", resumed.ASGs[0].Name)
+	assert.Equal(t, []string{"instance-1", "instance-2"}, resumed.ASGs[0].OriginalInstances)
+}
+
+func TestNewDeployStateBackendRequiresS3Bucket(t *testing.T) {
+	t.Parallel()
+
+	_, err := NewDeployStateBackend(DeployStateBackendConfig{Type: S3DeployStateBackend}, "us-east-1", nil)
+	assert.Error(t, err)
+}
+
+func TestNewDeployStateBackendRejectsUnknownType(t *testing.T) {
+	t.Parallel()
+
+	_, err := NewDeployStateBackend(DeployStateBackendConfig{Type: "etcd"}, "us-east-1", nil)
+	assert.Error(t, err)
+}
+
+// newFakeS3Server returns a minimal stand-in for S3 that supports getting, putting and deleting objects using path
+// style addressing.
+func newFakeS3Server() *httptest.Server {
+	var mutex sync.Mutex
+	objects := map[string][]byte{}
+
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mutex.Lock()
+		defer mutex.Unlock()
+
+		switch r.Method {
+		case http.MethodGet:
+			data, exists := objects[r.URL.Path]
+			if !exists {
+				w.Header().Set("Content-Type", "application/xml")
+				w.WriteHeader(http.StatusNotFound)
+				w.Write([]byte(`<?xml version="1.0" encoding="UTF-8"?><Error><Code>NoSuchKey</Code><Message>The specified key does not exist.</Message></Error>`))
+				return
+			}
+			w.Write(data)
+		case http.MethodPut:
+			data, err := ioutil.ReadAll(r.Body)
+			if err != nil {
+				w.WriteHeader(http.StatusInternalServerError)
+				return
+			}
+			objects[r.URL.Path] = data
+		case http.MethodDelete:
+			delete(objects, r.URL.Path)
+			w.WriteHeader(http.StatusNoContent)
+		default:
+			w.WriteHeader(http.StatusMethodNotAllowed)
+		}
+	}))
+}
+
+func newFakeS3Client(t *testing.T, endpoint string) *s3.S3 {
+	sess, err := session.NewSession(&aws.Config{
+		Region:           aws.String("us-east-1"),
+		Endpoint:         aws.String(endpoint),
+		S3ForcePathStyle: aws.Bool(true),
+		Credentials:      credentials.NewStaticCredentials("fake-id", "fake-secret", ""),
+	})
+	require.NoError(t, err)
+	return s3.New(sess)
+}
@@ -15,11 +15,11 @@ import (
 func TestParseNonExistingDeployState(t *testing.T) {
 	t.Parallel()
 	fileName := "./.na"
-	state, err := initDeployState(fileName, false, 3, 30*time.Second)
+	state, err := initDeployState(&LocalFileStateBackend{Path: fileName}, false, 3, 30*time.Second, "100%", "100%", ParallelASGRollout, SurgeDeployStrategy)
 	require.NoError(t, err)
 	defer os.Remove(fileName)
 
-	assert.Equal(t, fileName, state.Path)
+	assert.Equal(t, fileName, state.backend.Location())
 	assert.Equal(t, 3, state.maxRetries)
 	assert.Equal(t, 30*time.Second, state.sleepBetweenRetries)
 
@@ -38,7 +38,7 @@ func TestParseExistingDeployState(t *testing.T) {
 	t.Parallel()
 
 	stateFile := generateTempStateFile(t)
-	state, err := initDeployState(stateFile, false, 3, 30*time.Second)
+	state, err := initDeployState(&LocalFileStateBackend{Path: stateFile}, false, 3, 30*time.Second, "100%", "100%", ParallelASGRollout, SurgeDeployStrategy)
 	require.NoError(t, err)
 	defer os.Remove(stateFile)
 
@@ -61,7 +61,7 @@ func TestParseExistingDeployStateIgnoreCurrent(t *testing.T) {
 	t.Parallel()
 
 	stateFile := generateTempStateFile(t)
-	state, err := initDeployState(stateFile, true, 3, 30*time.Second)
+	state, err := initDeployState(&LocalFileStateBackend{Path: stateFile}, true, 3, 30*time.Second, "100%", "100%", ParallelASGRollout, SurgeDeployStrategy)
 	require.NoError(t, err)
 	defer os.Remove(stateFile)
 
@@ -91,10 +91,312 @@ func generateTempStateFile(t *testing.T) string {
 	state := &DeployState{
 		logger:            logging.GetProjectLogger(),
 		GatherASGInfoDone: true,
-		Path:              tmpfile.Name(),
+		backend:           &LocalFileStateBackend{Path: tmpfile.Name()},
 		ASGs:              []ASG{asg},
 	}
 
 	state.persist()
 	return tmpfile.Name()
 }
+
+func TestResolveRolloutCount(t *testing.T) {
+	t.Parallel()
+
+	testCases := []struct {
+		value            string
+		originalCapacity int64
+		expected         int64
+		expectErr        bool
+	}{
+		{"100%", 80, 80, false},
+		{"25%", 80, 20, false},
+		{"10%", 5, 1, false},
+		{"33%", 10, 4, false},
+		{"10", 80, 10, false},
+		{"200", 80, 200, false},
+		{"0", 80, 0, true},
+		{"-1", 80, 0, true},
+		{"0%", 80, 0, true},
+		{"101%", 80, 0, true},
+		{"ten", 80, 0, true},
+		{"", 80, 0, true},
+	}
+
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.value, func(t *testing.T) {
+			t.Parallel()
+
+			count, err := resolveRolloutCount(tc.value, tc.originalCapacity)
+			if tc.expectErr {
+				assert.Error(t, err)
+			} else {
+				require.NoError(t, err)
+				assert.Equal(t, tc.expected, count)
+			}
+		})
+	}
+}
+
+func TestPlanWaveBatches(t *testing.T) {
+	t.Parallel()
+
+	asg := ASG{
+		Name:              "my-test-asg",
+		OriginalCapacity:  5,
+		OriginalInstances: []string{"instance-1", "instance-2", "instance-3", "instance-4", "instance-5"},
+		MaxSurge:          3,
+		BatchSize:         2,
+	}
+
+	wave, hasWave := asg.planWave()
+	require.True(t, hasWave)
+	assert.Equal(t, []string{"instance-1", "instance-2"}, wave.OriginalInstances)
+	asg.Waves = append(asg.Waves, wave)
+
+	wave, hasWave = asg.planWave()
+	require.True(t, hasWave)
+	assert.Equal(t, []string{"instance-3", "instance-4"}, wave.OriginalInstances)
+	asg.Waves = append(asg.Waves, wave)
+
+	wave, hasWave = asg.planWave()
+	require.True(t, hasWave)
+	assert.Equal(t, []string{"instance-5"}, wave.OriginalInstances)
+	asg.Waves = append(asg.Waves, wave)
+
+	_, hasWave = asg.planWave()
+	assert.False(t, hasWave)
+}
+
+func TestPlanWaveLimitedByMaxSurge(t *testing.T) {
+	t.Parallel()
+
+	asg := ASG{
+		Name:              "my-test-asg",
+		OriginalCapacity:  3,
+		OriginalInstances: []string{"instance-1", "instance-2", "instance-3"},
+		MaxSurge:          1,
+		BatchSize:         3,
+	}
+
+	wave, hasWave := asg.planWave()
+	require.True(t, hasWave)
+	assert.Equal(t, []string{"instance-1"}, wave.OriginalInstances)
+}
+
+func TestPlanWaveWithoutBatchConfigReplacesAllInstances(t *testing.T) {
+	t.Parallel()
+
+	asg := ASG{
+		Name:              "my-test-asg",
+		OriginalCapacity:  2,
+		OriginalInstances: []string{"instance-1", "instance-2"},
+	}
+
+	wave, hasWave := asg.planWave()
+	require.True(t, hasWave)
+	assert.Equal(t, []string{"instance-1", "instance-2"}, wave.OriginalInstances)
+}
+
+func TestParallelRolloutCoversAllASGsInEachWave(t *testing.T) {
+	t.Parallel()
+
+	state := newTestMultiASGDeployState(t, ParallelASGRollout)
+	defer state.delete()
+
+	hasWave, err := state.startWave()
+	require.NoError(t, err)
+	require.True(t, hasWave)
+	assert.Equal(t, []string{"asg-a", "asg-b"}, state.waveASGNames())
+	assert.Equal(t, []string{"a-1", "b-1"}, state.waveOriginalInstances())
+	require.NoError(t, state.finishWave())
+
+	// asg-b only has one instance, so the second wave only covers asg-a.
+	hasWave, err = state.startWave()
+	require.NoError(t, err)
+	require.True(t, hasWave)
+	assert.Equal(t, []string{"asg-a"}, state.waveASGNames())
+	assert.Equal(t, []string{"a-2"}, state.waveOriginalInstances())
+	require.NoError(t, state.finishWave())
+
+	hasWave, err = state.startWave()
+	require.NoError(t, err)
+	assert.False(t, hasWave)
+
+	hasMoreASGs, err := state.finishActiveASGs()
+	require.NoError(t, err)
+	assert.False(t, hasMoreASGs)
+	assert.True(t, state.ASGs[0].RolloutDone)
+	assert.True(t, state.ASGs[1].RolloutDone)
+}
+
+func TestSequentialRolloutCoversOneASGAtATime(t *testing.T) {
+	t.Parallel()
+
+	state := newTestMultiASGDeployState(t, SequentialASGRollout)
+	defer state.delete()
+
+	for _, expectedInstance := range []string{"a-1", "a-2"} {
+		hasWave, err := state.startWave()
+		require.NoError(t, err)
+		require.True(t, hasWave)
+		assert.Equal(t, []string{"asg-a"}, state.waveASGNames())
+		assert.Equal(t, []string{expectedInstance}, state.waveOriginalInstances())
+		require.NoError(t, state.finishWave())
+	}
+
+	hasWave, err := state.startWave()
+	require.NoError(t, err)
+	require.False(t, hasWave)
+	hasMoreASGs, err := state.finishActiveASGs()
+	require.NoError(t, err)
+	require.True(t, hasMoreASGs)
+	assert.Equal(t, 0, state.CurrentWave)
+
+	hasWave, err = state.startWave()
+	require.NoError(t, err)
+	require.True(t, hasWave)
+	assert.Equal(t, []string{"asg-b"}, state.waveASGNames())
+	assert.Equal(t, []string{"b-1"}, state.waveOriginalInstances())
+	require.NoError(t, state.finishWave())
+
+	hasWave, err = state.startWave()
+	require.NoError(t, err)
+	require.False(t, hasWave)
+	hasMoreASGs, err = state.finishActiveASGs()
+	require.NoError(t, err)
+	assert.False(t, hasMoreASGs)
+}
+
+func TestResumedWaveIsNotReplanned(t *testing.T) {
+	t.Parallel()
+
+	state := newTestMultiASGDeployState(t, ParallelASGRollout)
+	defer state.delete()
+
+	hasWave, err := state.startWave()
+	require.NoError(t, err)
+	require.True(t, hasWave)
+
+	resumed, err := initDeployState(state.backend, false, 3, 30*time.Second, "100%", "100%", SequentialASGRollout, SurgeDeployStrategy)
+	require.NoError(t, err)
+	// The roll out mode of the recovery file takes precedence over the requested one.
+	assert.Equal(t, ParallelASGRollout, resumed.RolloutMode)
+	hasWave, err = resumed.startWave()
+	require.NoError(t, err)
+	require.True(t, hasWave)
+	assert.Equal(t, 1, len(resumed.ASGs[0].Waves))
+	assert.Equal(t, 1, len(resumed.ASGs[1].Waves))
+}
+
+func TestHasASGs(t *testing.T) {
+	t.Parallel()
+
+	state := &DeployState{ASGs: []ASG{{Name: "asg-a"}, {Name: "asg-b"}}}
+	assert.True(t, state.hasASGs([]string{"asg-a", "asg-b"}))
+	assert.True(t, state.hasASGs([]string{"asg-b", "asg-a"}))
+	assert.False(t, state.hasASGs([]string{"asg-a"}))
+	assert.False(t, state.hasASGs([]string{"asg-a", "asg-c"}))
+}
+
+func newTestMultiASGDeployState(t *testing.T, rolloutMode ASGRolloutMode) *DeployState {
+	escapedTestName := url.PathEscape(t.Name())
+	tmpfile, err := ioutil.TempFile("", escapedTestName)
+	require.NoError(t, err)
+	require.NoError(t, tmpfile.Close())
+
+	return &DeployState{
+		logger:            logging.GetProjectLogger(),
+		GatherASGInfoDone: true,
+		backend:           &LocalFileStateBackend{Path: tmpfile.Name()},
+		RolloutMode:       rolloutMode,
+		ASGs: []ASG{
+			{
+				Name:              "asg-a",
+				OriginalCapacity:  2,
+				OriginalInstances: []string{"a-1", "a-2"},
+				MaxSurge:          1,
+				BatchSize:         1,
+			},
+			{
+				Name:              "asg-b",
+				OriginalCapacity:  1,
+				OriginalInstances: []string{"b-1"},
+				MaxSurge:          1,
+				BatchSize:         1,
+			},
+		},
+	}
+}
+
+func TestRemainingOriginalInstancesSkipsCompletedWaves(t *testing.T) {
+	t.Parallel()
+
+	state := newTestMultiASGDeployState(t, ParallelASGRollout)
+	defer state.delete()
+	assert.Equal(t, []string{"a-1", "a-2", "b-1"}, state.remainingOriginalInstances())
+
+	hasWave, err := state.startWave()
+	require.NoError(t, err)
+	require.True(t, hasWave)
+	// Instances of the current wave have not been replaced yet.
+	assert.Equal(t, []string{"a-1", "a-2", "b-1"}, state.remainingOriginalInstances())
+
+	require.NoError(t, state.finishWave())
+	assert.Equal(t, []string{"a-2"}, state.remainingOriginalInstances())
+}
+
+// legacyScaledUpDeployState is a recovery file, in the format used before batched roll outs were introduced, of a roll
+// out that was interrupted after the scale up.
+const legacyScaledUpDeployState = `{
+	"GatherASGInfoDone": true,
+	"SetMaxCapacityDone": true,
+	"ScaleUpDone": true,
+	"WaitForNodesDone": false,
+	"CordonNodesDone": false,
+	"DrainNodesDone": false,
+	"DetachInstancesDone": false,
+	"TerminateInstancesDone": false,
+	"RestoreCapacityDone": false,
+	"Path": "./.kubergrunt.state",
+	"ASGs": [
+		{
+			"Name": "my-test-asg",
+			"OriginalCapacity": 2,
+			"MaxCapacityForUpdate": 4,
+			"OriginalMaxCapacity": 4,
+			"OriginalInstances": ["instance-1", "instance-2"],
+			"NewInstances": ["instance-3", "instance-4"]
+		}
+	]
+}`
+
+func newTestLegacyDeployState(t *testing.T) *DeployState {
+	escapedTestName := url.PathEscape(t.Name())
+	tmpfile, err := ioutil.TempFile("", escapedTestName)
+	require.NoError(t, err)
+	_, err = tmpfile.WriteString(legacyScaledUpDeployState)
+	require.NoError(t, err)
+	require.NoError(t, tmpfile.Close())
+
+	state, err := initDeployState(&LocalFileStateBackend{Path: tmpfile.Name()}, false, 3, 30*time.Second, "100%", "100%", ParallelASGRollout, SurgeDeployStrategy)
+	require.NoError(t, err)
+	return state
+}
+
+func TestResumeLegacyDeployStateAfterScaleUp(t *testing.T) {
+	t.Parallel()
+
+	state := newTestLegacyDeployState(t)
+	defer state.delete()
+	assert.Equal(t, SurgeDeployStrategy, state.Strategy)
+
+	hasWave, err := state.startWave()
+	require.NoError(t, err)
+	require.True(t, hasWave)
+	require.Equal(t, 1, len(state.ASGs[0].Waves))
+	wave := state.ASGs[0].Waves[0]
+	assert.Equal(t, []string{"instance-1", "instance-2"}, wave.OriginalInstances)
+	assert.Equal(t, []string{"instance-3", "instance-4"}, wave.NewInstances)
+	assert.Equal(t, []string{"instance-3", "instance-4"}, state.waveNewInstances())
+}
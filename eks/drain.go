@@ -1,8 +1,6 @@
 package eks
 
 import (
-	"time"
-
 	"github.com/aws/aws-sdk-go/service/autoscaling"
 	"github.com/aws/aws-sdk-go/service/ec2"
 	"github.com/gruntwork-io/go-commons/errors"
@@ -11,15 +9,30 @@ import (
 	"github.com/gruntwork-io/kubergrunt/logging"
 )
 
-// DrainASG will cordon and drain all the instances associated with the given ASGs at the time of running.
+// DrainASG will cordon and drain all the instances associated with the given ASGs at the time of running, while holding
+// the cluster lock so that only one deploy or drain runs at a time. Before cordoning, the Pods on the instances are
+// checked against the PodDisruptionBudgets in the cluster, and pdbPreflight determines whether evictions that can never
+// succeed fail the drain or are only reported. When drainReportOptions has a path, a report of where the evicted Pods
+// were rescheduled is written to it once the drain is done.
 func DrainASG(
 	region string,
 	asgNames []string,
 	kubectlOptions *kubectl.KubectlOptions,
-	drainTimeout time.Duration,
-	deleteEmptyDirData bool,
+	drainOptions kubectl.DrainOptions,
+	lockOptions ClusterLockOptions,
+	pdbPreflight PDBPreflightMode,
+	drainReportOptions DrainReportOptions,
 ) error {
 	logger := logging.GetProjectLogger()
+	if err := validatePDBPreflightMode(pdbPreflight); err != nil {
+		return err
+	}
+	if err := kubectl.ValidateDrainOrder(drainOptions.Order); err != nil {
+		return err
+	}
+	if err := validateDrainReportOptions(drainReportOptions); err != nil {
+		return err
+	}
 	logger.Infof("All instances in the following worker groups will be drained:")
 	for _, asgName := range asgNames {
 		logger.Infof("\t- %s", asgName)
@@ -34,6 +47,12 @@ func DrainASG(
 	ec2Svc := ec2.New(sess)
 	logger.Infof("Successfully authenticated with AWS")
 
+	lock, err := AcquireClusterLock(kubectlOptions, lockOptions)
+	if err != nil {
+		return err
+	}
+	defer ReleaseClusterLock(lock)
+
 	// Retrieve instance IDs for each ASG requested.
 	allInstanceIDs := []string{}
 	for _, asgName := range asgNames {
@@ -45,7 +64,14 @@ func DrainASG(
 	}
 	logger.Infof("Found %d instances across all requested ASGs.", len(allInstanceIDs))
 
+	if err := runPDBPreflight(ec2Svc, kubectlOptions, allInstanceIDs, pdbPreflight); err != nil {
+		return err
+	}
+
 	// Cordon instances in the ASG to avoid scheduling evicted workloads on the instances being drained.
+	if err := lock.CheckHeld(); err != nil {
+		return err
+	}
 	logger.Info("Cordoning instances in requested ASGs.")
 	if err := cordonNodesInAsg(ec2Svc, kubectlOptions, allInstanceIDs); err != nil {
 		return err
@@ -53,8 +79,11 @@ func DrainASG(
 	logger.Info("Successfully cordoned all instances in requested ASGs.")
 
 	// Now drain the pods from all the instances.
+	if err := lock.CheckHeld(); err != nil {
+		return err
+	}
 	logger.Info("Draining Pods scheduled on instances in requested ASGs.")
-	if err := drainNodesInAsg(ec2Svc, kubectlOptions, allInstanceIDs, drainTimeout, deleteEmptyDirData); err != nil {
+	if err := drainNodesInAsg(ec2Svc, kubectlOptions, allInstanceIDs, drainOptions, newDrainReporter(drainReportOptions, kubectlOptions)); err != nil {
 		return err
 	}
 	logger.Info("Successfully drained pods from all instances in requested ASGs.")
@@ -0,0 +1,353 @@
+package eks
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/gruntwork-io/go-commons/collections"
+	"github.com/gruntwork-io/go-commons/errors"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes"
+
+	"github.com/gruntwork-io/kubergrunt/kubectl"
+	"github.com/gruntwork-io/kubergrunt/logging"
+)
+
+// DrainReportFormat represents the output format of a drain report.
+type DrainReportFormat string
+
+const (
+	JSONDrainReportFormat     DrainReportFormat = "json"
+	MarkdownDrainReportFormat DrainReportFormat = "markdown"
+
+	drainReportPollInterval = 10 * time.Second
+)
+
+// DrainReportFormats lists all the supported DrainReportFormat values.
+var DrainReportFormats = []string{string(JSONDrainReportFormat), string(MarkdownDrainReportFormat)}
+
+// DrainReportOptions configures the report of where the evicted Pods landed after a drain.
+type DrainReportOptions struct {
+	// Path is the file to write the report to. No report is written when empty.
+	Path string
+
+	// Format is the format of the report.
+	Format DrainReportFormat
+
+	// WaitForReady is the maximum amount of time to wait for the evicted Pods to be Ready again on their new node
+	// before writing the report. The command does not move on until the report is written, so this can add up to
+	// WaitForReady to each drain.
+	WaitForReady time.Duration
+}
+
+// DrainReport describes where the Pods evicted by a drain were rescheduled.
+type DrainReport struct {
+	Nodes []NodeDrainReport `json:"nodes"`
+
+	// PendingPods lists the Pods in the cluster that are still Pending once the drain is done.
+	PendingPods []string `json:"pendingPods"`
+}
+
+// NodeDrainReport describes the Pods evicted from a single node.
+type NodeDrainReport struct {
+	InstanceID  string             `json:"instanceId"`
+	NodeName    string             `json:"nodeName"`
+	EvictedPods []EvictedPodReport `json:"evictedPods"`
+	SkippedPods []string           `json:"skippedPods"`
+	FailedPods  []string           `json:"failedPods"`
+
+	// ForceDeletedPods lists the Pods that were force deleted after their eviction timed out.
+	ForceDeletedPods []string `json:"forceDeletedPods"`
+}
+
+// EvictedPodReport describes where an evicted Pod was rescheduled. ReplacementPod and RescheduledNode are empty when no
+// replacement was found, and SecondsToReady is nil when the replacement is not Ready yet.
+type EvictedPodReport struct {
+	Pod             string   `json:"pod"`
+	ReplacementPod  string   `json:"replacementPod,omitempty"`
+	RescheduledNode string   `json:"rescheduledNode,omitempty"`
+	SecondsToReady  *float64 `json:"secondsToReady,omitempty"`
+}
+
+// validateDrainReportOptions returns an error if the report format is not supported.
+func validateDrainReportOptions(options DrainReportOptions) error {
+	if options.Path != "" && !collections.ListContainsElement(DrainReportFormats, string(options.Format)) {
+		return errors.WithStackTrace(UnsupportedDrainReportFormatErr{format: options.Format})
+	}
+	return nil
+}
+
+// drainReporter accumulates the results of all the drains of a command into a single report, which is rewritten after
+// each drain so that the report is available even if the command fails later on.
+type drainReporter struct {
+	options        DrainReportOptions
+	kubectlOptions *kubectl.KubectlOptions
+	report         DrainReport
+}
+
+// newDrainReporter returns a drainReporter for the options, or nil if no report was requested.
+func newDrainReporter(options DrainReportOptions, kubectlOptions *kubectl.KubectlOptions) *drainReporter {
+	if options.Path == "" {
+		return nil
+	}
+	return &drainReporter{
+		options:        options,
+		kubectlOptions: kubectlOptions,
+		report:         DrainReport{Nodes: []NodeDrainReport{}, PendingPods: []string{}},
+	}
+}
+
+// record adds the results of a drain to the report and writes it out. This is a no-op on a nil reporter.
+func (reporter *drainReporter) record(instances []*ec2.Instance, results []kubectl.NodeDrainResult) error {
+	if reporter == nil || len(results) == 0 {
+		return nil
+	}
+	logger := logging.GetProjectLogger()
+
+	clientset, err := kubectl.GetKubernetesClientFromOptions(reporter.kubectlOptions)
+	if err != nil {
+		return err
+	}
+	report, err := waitForDrainReport(
+		clientset,
+		instanceIDsByNodeName(instances),
+		results,
+		reporter.options.WaitForReady,
+		drainReportPollInterval,
+	)
+	if err != nil {
+		return err
+	}
+	reporter.report.Nodes = append(reporter.report.Nodes, report.Nodes...)
+	reporter.report.PendingPods = report.PendingPods
+
+	file, err := os.Create(reporter.options.Path)
+	if err != nil {
+		return errors.WithStackTrace(err)
+	}
+	defer file.Close()
+	if err := reporter.report.Write(file, reporter.options.Format); err != nil {
+		return err
+	}
+	logger.Infof("Wrote drain report to %s", reporter.options.Path)
+	return nil
+}
+
+// waitForDrainReport builds the drain report, waiting up to waitForReady for the replacements of the evicted Pods to
+// be Ready.
+func waitForDrainReport(
+	clientset kubernetes.Interface,
+	instanceIDs map[string]string,
+	results []kubectl.NodeDrainResult,
+	waitForReady time.Duration,
+	pollInterval time.Duration,
+) (*DrainReport, error) {
+	logger := logging.GetProjectLogger()
+	deadline := time.Now().Add(waitForReady)
+	for {
+		report, err := newDrainReport(clientset, instanceIDs, results)
+		if err != nil {
+			return nil, err
+		}
+		if report.allReplacementsReady() || !time.Now().Before(deadline) {
+			return report, nil
+		}
+		logger.Infof("Waiting for evicted Pods to be Ready on their new nodes before writing the drain report")
+		time.Sleep(pollInterval)
+	}
+}
+
+// newDrainReport matches the evicted Pods to the Pods that replaced them, based on the current state of the cluster.
+// A replacement is a Pod managed by the same controller as the evicted Pod that was created after the eviction.
+func newDrainReport(
+	clientset kubernetes.Interface,
+	instanceIDs map[string]string,
+	results []kubectl.NodeDrainResult,
+) (*DrainReport, error) {
+	podList, err := clientset.CoreV1().Pods(metav1.NamespaceAll).List(context.Background(), metav1.ListOptions{})
+	if err != nil {
+		return nil, errors.WithStackTrace(err)
+	}
+	pods := podList.Items
+	sort.SliceStable(pods, func(i, j int) bool {
+		return pods[i].CreationTimestamp.Before(&pods[j].CreationTimestamp)
+	})
+
+	report := &DrainReport{Nodes: []NodeDrainReport{}, PendingPods: []string{}}
+	matched := map[types.UID]bool{}
+	for _, result := range results {
+		nodeReport := NodeDrainReport{
+			InstanceID:  instanceIDs[result.NodeName],
+			NodeName:    result.NodeName,
+			EvictedPods: []EvictedPodReport{},
+			SkippedPods: podDrainResultNames(result.Skipped),
+			FailedPods:  podDrainResultNames(result.Failed),
+
+			ForceDeletedPods: podDrainResultNames(result.ForceDeleted),
+		}
+		for _, evicted := range result.Evicted {
+			podReport := EvictedPodReport{Pod: fmt.Sprintf("%s/%s", evicted.Namespace, evicted.Name)}
+			if replacement := findReplacementPod(pods, evicted, matched); replacement != nil {
+				matched[replacement.UID] = true
+				podReport.ReplacementPod = fmt.Sprintf("%s/%s", replacement.Namespace, replacement.Name)
+				podReport.RescheduledNode = replacement.Spec.NodeName
+				podReport.SecondsToReady = secondsToReady(*replacement, evicted.EvictedAt)
+			}
+			nodeReport.EvictedPods = append(nodeReport.EvictedPods, podReport)
+		}
+		report.Nodes = append(report.Nodes, nodeReport)
+	}
+	for _, pod := range pods {
+		if pod.Status.Phase == corev1.PodPending {
+			report.PendingPods = append(report.PendingPods, fmt.Sprintf("%s/%s", pod.Namespace, pod.Name))
+		}
+	}
+	return report, nil
+}
+
+// findReplacementPod returns the oldest Pod that is not matched yet, is managed by the same controller as the evicted
+// Pod, and was created after the eviction.
+func findReplacementPod(pods []corev1.Pod, evicted kubectl.PodDrainResult, matched map[types.UID]bool) *corev1.Pod {
+	if evicted.ControllerUID == "" {
+		return nil
+	}
+	// The creation timestamp only has a precision of seconds.
+	evictedAt := metav1.NewTime(evicted.EvictedAt.Truncate(time.Second))
+	for i, pod := range pods {
+		controller := metav1.GetControllerOf(&pod)
+		if controller == nil || controller.UID != evicted.ControllerUID {
+			continue
+		}
+		if pod.UID == evicted.UID || matched[pod.UID] || pod.CreationTimestamp.Before(&evictedAt) {
+			continue
+		}
+		return &pods[i]
+	}
+	return nil
+}
+
+// secondsToReady returns how long after the eviction the Pod became Ready, or nil if the Pod is not Ready.
+func secondsToReady(pod corev1.Pod, evictedAt time.Time) *float64 {
+	if !kubectl.IsPodReady(pod) {
+		return nil
+	}
+	for _, condition := range pod.Status.Conditions {
+		if condition.Type == corev1.PodReady {
+			seconds := condition.LastTransitionTime.Sub(evictedAt).Seconds()
+			if seconds < 0 {
+				seconds = 0
+			}
+			return &seconds
+		}
+	}
+	return nil
+}
+
+// allReplacementsReady returns true when every evicted Pod has a replacement that is Ready.
+func (report *DrainReport) allReplacementsReady() bool {
+	for _, node := range report.Nodes {
+		for _, pod := range node.EvictedPods {
+			if pod.SecondsToReady == nil {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// Write renders the report to the given writer in the requested format.
+func (report *DrainReport) Write(w io.Writer, format DrainReportFormat) error {
+	switch format {
+	case JSONDrainReportFormat:
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		return errors.WithStackTrace(encoder.Encode(report))
+	case MarkdownDrainReportFormat:
+		_, err := io.WriteString(w, report.markdown())
+		return errors.WithStackTrace(err)
+	}
+	return errors.WithStackTrace(UnsupportedDrainReportFormatErr{format: format})
+}
+
+// markdown renders the report as a Markdown document.
+func (report *DrainReport) markdown() string {
+	var out strings.Builder
+	out.WriteString("# Drain report\n")
+	for _, node := range report.Nodes {
+		fmt.Fprintf(&out, "\n## Node %s (%s)\n\n", node.NodeName, node.InstanceID)
+		if len(node.EvictedPods) == 0 {
+			out.WriteString("No Pods were evicted.\n")
+		} else {
+			out.WriteString("| Evicted Pod | Replacement Pod | Rescheduled on | Time to Ready |\n")
+			out.WriteString("| --- | --- | --- | --- |\n")
+			for _, pod := range node.EvictedPods {
+				fmt.Fprintf(
+					&out,
+					"| %s | %s | %s | %s |\n",
+					pod.Pod,
+					markdownOrNone(pod.ReplacementPod),
+					markdownOrNone(pod.RescheduledNode),
+					formatSecondsToReady(pod.SecondsToReady),
+				)
+			}
+		}
+		if len(node.SkippedPods) > 0 {
+			fmt.Fprintf(&out, "\nSkipped: %s\n", strings.Join(node.SkippedPods, ", "))
+		}
+		if len(node.FailedPods) > 0 {
+			fmt.Fprintf(&out, "\nFailed to evict: %s\n", strings.Join(node.FailedPods, ", "))
+		}
+		if len(node.ForceDeletedPods) > 0 {
+			fmt.Fprintf(&out, "\nForce deleted: %s\n", strings.Join(node.ForceDeletedPods, ", "))
+		}
+	}
+	out.WriteString("\n## Pending Pods\n\n")
+	if len(report.PendingPods) == 0 {
+		out.WriteString("None.\n")
+	}
+	for _, pod := range report.PendingPods {
+		fmt.Fprintf(&out, "- %s\n", pod)
+	}
+	return out.String()
+}
+
+func markdownOrNone(value string) string {
+	if value == "" {
+		return "-"
+	}
+	return value
+}
+
+func formatSecondsToReady(seconds *float64) string {
+	if seconds == nil {
+		return "not Ready"
+	}
+	return time.Duration(*seconds * float64(time.Second)).Round(time.Second).String()
+}
+
+// instanceIDsByNodeName maps the Kubernetes node names of the instances to their instance IDs.
+func instanceIDsByNodeName(instances []*ec2.Instance) map[string]string {
+	instanceIDs := map[string]string{}
+	for i, nodeName := range kubeNodeNamesFromInstances(instances) {
+		instanceIDs[nodeName] = aws.StringValue(instances[i].InstanceId)
+	}
+	return instanceIDs
+}
+
+func podDrainResultNames(results []kubectl.PodDrainResult) []string {
+	names := []string{}
+	for _, result := range results {
+		names = append(names, fmt.Sprintf("%s/%s", result.Namespace, result.Name))
+	}
+	return names
+}
@@ -0,0 +1,145 @@
+package eks
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes/fake"
+
+	"github.com/gruntwork-io/kubergrunt/kubectl"
+)
+
+func TestNewDrainReport(t *testing.T) {
+	t.Parallel()
+
+	evictedAt := time.Date(2021, 1, 1, 12, 0, 0, 0, time.UTC)
+	clientset := fake.NewSimpleClientset(
+		// Created before the eviction, so it can not be a replacement.
+		newDrainReportTestPod("web-old", "rs-uid", "node-c", evictedAt.Add(-time.Hour), &metav1.Time{Time: evictedAt.Add(-time.Hour)}),
+		newDrainReportTestPod("web-new", "rs-uid", "node-b", evictedAt.Add(2*time.Second), &metav1.Time{Time: evictedAt.Add(30 * time.Second)}),
+		newDrainReportTestPod("db-0", "sts-uid", "", evictedAt.Add(time.Second), nil),
+	)
+	results := []kubectl.NodeDrainResult{
+		{
+			NodeName: "node-a",
+			Evicted: []kubectl.PodDrainResult{
+				{Namespace: "default", Name: "web-evicted", UID: "web-evicted-uid", ControllerUID: "rs-uid", EvictedAt: evictedAt},
+				{Namespace: "default", Name: "db-0", UID: "db-0-old-uid", ControllerUID: "sts-uid", EvictedAt: evictedAt},
+				{Namespace: "default", Name: "gone", UID: "gone-uid", ControllerUID: "other-uid", EvictedAt: evictedAt},
+			},
+			Skipped:      []kubectl.PodDrainResult{{Namespace: "kube-system", Name: "aws-node-abc"}},
+			Failed:       []kubectl.PodDrainResult{},
+			ForceDeleted: []kubectl.PodDrainResult{{Namespace: "default", Name: "stuck"}},
+		},
+	}
+
+	report, err := newDrainReport(clientset, map[string]string{"node-a": "i-1"}, results)
+	require.NoError(t, err)
+	require.Equal(t, 1, len(report.Nodes))
+	node := report.Nodes[0]
+	assert.Equal(t, "i-1", node.InstanceID)
+	assert.Equal(t, []string{"kube-system/aws-node-abc"}, node.SkippedPods)
+	assert.Equal(t, []string{}, node.FailedPods)
+	assert.Equal(t, []string{"default/stuck"}, node.ForceDeletedPods)
+
+	thirtySeconds := float64(30)
+	assert.Equal(
+		t,
+		[]EvictedPodReport{
+			{Pod: "default/web-evicted", ReplacementPod: "default/web-new", RescheduledNode: "node-b", SecondsToReady: &thirtySeconds},
+			{Pod: "default/db-0", ReplacementPod: "default/db-0"},
+			{Pod: "default/gone"},
+		},
+		node.EvictedPods,
+	)
+	assert.Equal(t, []string{"default/db-0"}, report.PendingPods)
+	assert.False(t, report.allReplacementsReady())
+}
+
+func TestDrainReportWrite(t *testing.T) {
+	t.Parallel()
+
+	seconds := float64(42)
+	report := &DrainReport{
+		Nodes: []NodeDrainReport{
+			{
+				InstanceID: "i-1",
+				NodeName:   "ip-10-0-0-1.ec2.internal",
+				EvictedPods: []EvictedPodReport{
+					{Pod: "default/web-1", ReplacementPod: "default/web-2", RescheduledNode: "ip-10-0-0-2.ec2.internal", SecondsToReady: &seconds},
+					{Pod: "default/db-0"},
+				},
+				SkippedPods: []string{"kube-system/aws-node-abc"},
+				FailedPods:  []string{},
+
+				ForceDeletedPods: []string{"default/stuck"},
+			},
+		},
+		PendingPods: []string{"default/db-0"},
+	}
+
+	var markdown bytes.Buffer
+	require.NoError(t, report.Write(&markdown, MarkdownDrainReportFormat))
+	text := markdown.String()
+	assert.Contains(t, text, "## Node ip-10-0-0-1.ec2.internal (i-1)")
+	assert.Contains(t, text, "| default/web-1 | default/web-2 | ip-10-0-0-2.ec2.internal | 42s |")
+	assert.Contains(t, text, "| default/db-0 | - | - | not Ready |")
+	assert.Contains(t, text, "Skipped: kube-system/aws-node-abc")
+	assert.Contains(t, text, "Force deleted: default/stuck")
+	assert.Contains(t, text, "- default/db-0")
+
+	var out bytes.Buffer
+	require.NoError(t, report.Write(&out, JSONDrainReportFormat))
+	var parsed DrainReport
+	require.NoError(t, json.Unmarshal(out.Bytes(), &parsed))
+	assert.Equal(t, *report, parsed)
+
+	assert.Error(t, report.Write(&out, "yaml"))
+}
+
+func TestInstanceIDsByNodeName(t *testing.T) {
+	t.Parallel()
+
+	instances := []*ec2.Instance{
+		{InstanceId: aws.String("i-1"), PrivateDnsName: aws.String("ip-10-0-0-1.ec2.internal")},
+		{InstanceId: aws.String("i-2"), PrivateDnsName: aws.String("ip-10-0-0-2.ec2.internal")},
+	}
+	assert.Equal(
+		t,
+		map[string]string{"ip-10-0-0-1.ec2.internal": "i-1", "ip-10-0-0-2.ec2.internal": "i-2"},
+		instanceIDsByNodeName(instances),
+	)
+}
+
+// newDrainReportTestPod returns a Pod controlled by the given owner. The Pod is Ready since readySince when set, and
+// Pending otherwise.
+func newDrainReportTestPod(name string, ownerUID string, nodeName string, createdAt time.Time, readySince *metav1.Time) *corev1.Pod {
+	isController := true
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace:         "default",
+			Name:              name,
+			UID:               types.UID(name + "-uid"),
+			CreationTimestamp: metav1.NewTime(createdAt),
+			OwnerReferences:   []metav1.OwnerReference{{Kind: "ReplicaSet", UID: types.UID(ownerUID), Controller: &isController}},
+		},
+		Spec:   corev1.PodSpec{NodeName: nodeName},
+		Status: corev1.PodStatus{Phase: corev1.PodPending},
+	}
+	if readySince != nil {
+		pod.Status.Phase = corev1.PodRunning
+		pod.Status.Conditions = []corev1.PodCondition{
+			{Type: corev1.PodReady, Status: corev1.ConditionTrue, LastTransitionTime: *readySince},
+		}
+	}
+	return pod
+}
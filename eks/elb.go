@@ -2,12 +2,15 @@ package eks
 
 import (
 	"fmt"
+	"strings"
 	"sync"
 	"time"
 
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/service/elb"
+	"github.com/aws/aws-sdk-go/service/elb/elbiface"
 	"github.com/aws/aws-sdk-go/service/elbv2"
+	"github.com/aws/aws-sdk-go/service/elbv2/elbv2iface"
 	"github.com/gruntwork-io/go-commons/collections"
 	"github.com/gruntwork-io/go-commons/errors"
 	"github.com/gruntwork-io/go-commons/retry"
@@ -15,16 +18,140 @@ import (
 	"github.com/sirupsen/logrus"
 
 	"github.com/gruntwork-io/kubergrunt/commonerrors"
+	"github.com/gruntwork-io/kubergrunt/kubectl"
+	"github.com/gruntwork-io/kubergrunt/logging"
 )
 
-// waitForAnyInstancesRegisteredToALBOrNLB implements the logic to wait for instance registration to Application and
-// Network Load Balancers. Refer to function docs for waitForAnyInstancesRegisteredToELB for more info.
-// NOTE: this assumes the ELB is using the instance target type.
-func waitForAnyInstancesRegisteredToALBOrNLB(logger *logrus.Entry, elbv2Svc *elbv2.ELBV2, lbName string, instanceIDsToWaitFor []string) error {
-	targetGroups, err := getELBTargetGroups(elbv2Svc, lbName)
+const (
+	// clbInServiceState is the state of healthy instances in a Classic Load Balancer.
+	clbInServiceState = "InService"
+	// clbOutOfServiceState is the state of instances that the Classic Load Balancer no longer routes to.
+	clbOutOfServiceState = "OutOfService"
+)
+
+// podIPLister returns the IP addresses of the Pods outside the old nodes, which are the targets of IP target type ELBs
+// once the old nodes are drained.
+type podIPLister func() ([]string, error)
+
+// ELBRegistrationPolicy determines how many of the new targets must be healthy in each load balancer before the old
+// nodes are drained: any of them, all of them, or a percentage of them (e.g 50%).
+type ELBRegistrationPolicy string
+
+const (
+	// AnyELBRegistrationPolicy requires at least one of the new targets to be healthy.
+	AnyELBRegistrationPolicy ELBRegistrationPolicy = "any"
+	// AllELBRegistrationPolicy requires all of the new targets to be healthy.
+	AllELBRegistrationPolicy ELBRegistrationPolicy = "all"
+)
+
+// ValidateELBRegistrationPolicy returns an error if the registration policy is neither any, all, nor a percentage
+// between 1% and 100%. An empty policy is the same as AnyELBRegistrationPolicy.
+func ValidateELBRegistrationPolicy(policy ELBRegistrationPolicy) error {
+	_, err := policy.requiredHealthyCount(1)
+	return err
+}
+
+// requiredHealthyCount returns how many of the given number of new targets must be healthy to satisfy the policy.
+// Percentages are rounded up. At least one target must be healthy whatever the policy, so that the wait does not pass
+// when there are no new targets at all.
+func (policy ELBRegistrationPolicy) requiredHealthyCount(numTargets int) (int, error) {
+	switch policy {
+	case "", AnyELBRegistrationPolicy:
+		return 1, nil
+	case AllELBRegistrationPolicy:
+		if numTargets == 0 {
+			return 1, nil
+		}
+		return numTargets, nil
+	}
+	if !strings.HasSuffix(string(policy), "%") {
+		return 0, errors.WithStackTrace(InvalidELBRegistrationPolicyErr{policy})
+	}
+	count, err := resolveRolloutCount(string(policy), int64(numTargets))
+	if err != nil {
+		return 0, errors.WithStackTrace(InvalidELBRegistrationPolicyErr{policy})
+	}
+	if numTargets == 0 {
+		return 1, nil
+	}
+	return int(count), nil
+}
+
+// registrationProgress records which of the new targets of a load balancer (or of one of its target groups) are
+// healthy.
+type registrationProgress struct {
+	healthy   []string
+	unhealthy []string
+}
+
+// waitForHealthyTargets repeatedly checks the progress of the new targets until enough of them are healthy to satisfy
+// the policy, logging the progress on each check. Times out with an ELBRegistrationTimeoutErr listing the targets that
+// are still unhealthy.
+func waitForHealthyTargets(
+	logger *logrus.Entry,
+	description string,
+	policy ELBRegistrationPolicy,
+	maxRetries int,
+	sleepBetweenRetries time.Duration,
+	getProgress func() (registrationProgress, error),
+) error {
+	lastProgress := registrationProgress{}
+	err := retry.DoWithRetry(
+		logger.Logger,
+		fmt.Sprintf("wait for new targets to be healthy in %s", description),
+		maxRetries, sleepBetweenRetries,
+		func() error {
+			progress, err := getProgress()
+			if err != nil {
+				return retry.FatalError{Underlying: err}
+			}
+			lastProgress = progress
+			numTargets := len(progress.healthy) + len(progress.unhealthy)
+			required, err := policy.requiredHealthyCount(numTargets)
+			if err != nil {
+				return retry.FatalError{Underlying: err}
+			}
+			logger.Infof("%s: %d of %d new targets healthy (%d required)", description, len(progress.healthy), numTargets, required)
+			if len(progress.healthy) >= required {
+				return nil
+			}
+			return fmt.Errorf("Only %d of the %d required targets are healthy", len(progress.healthy), required)
+		},
+	)
+	if fatalErr, isFatalErr := err.(retry.FatalError); isFatalErr {
+		return fatalErr.Underlying
+	} else if err != nil {
+		return errors.WithStackTrace(ELBRegistrationTimeoutErr{description: description, unhealthy: lastProgress.unhealthy})
+	}
+	return nil
+}
+
+// waitForInstancesRegisteredToALBOrNLB implements the logic to wait for instance registration to Application and
+// Network Load Balancers. Refer to function docs for waitForInstancesRegisteredToELB for more info.
+// The target groups using the IP target type are skipped, as their targets are Pods instead of instances, which are
+// only moved to the new nodes when the old nodes are drained (see waitForPodTargetsHealthyInELBs).
+func waitForInstancesRegisteredToALBOrNLB(
+	logger *logrus.Entry,
+	elbv2Svc elbv2iface.ELBV2API,
+	lb kubectl.AWSLoadBalancer,
+	instanceIDsToWaitFor []string,
+	registrationOnly bool,
+	policy ELBRegistrationPolicy,
+	maxRetries int,
+	sleepBetweenRetries time.Duration,
+) error {
+	allTargetGroups, err := getELBTargetGroups(elbv2Svc, lb.Name)
 	if err != nil {
 		return err
 	}
+	targetGroups := []*elbv2.TargetGroup{}
+	for _, targetGroup := range allTargetGroups {
+		if getTargetGroupTargetType(targetGroup, lb.TargetType) == kubectl.IPTarget {
+			logger.Infof("Target group %s of load balancer %s routes to Pods: waiting on it once the old nodes are drained", aws.StringValue(targetGroup.TargetGroupName), lb.Name)
+			continue
+		}
+		targetGroups = append(targetGroups, targetGroup)
+	}
 
 	// Asynchronously wait for instances to be registered to each target group, collecting each goroutine error in
 	// channels.
@@ -34,7 +161,16 @@ func waitForAnyInstancesRegisteredToALBOrNLB(logger *logrus.Entry, elbv2Svc *elb
 	for _, targetGroup := range targetGroups {
 		errChan := make(chan error, 1)
 		errChans[aws.StringValue(targetGroup.TargetGroupName)] = errChan
-		go asyncWaitForAnyInstancesRegisteredToTargetGroup(wg, errChan, logger, elbv2Svc, lbName, targetGroup, instanceIDsToWaitFor)
+
+		targetGroup := targetGroup
+		description := fmt.Sprintf("target group %s of load balancer %s", aws.StringValue(targetGroup.TargetGroupName), lb.Name)
+		getProgress := func() (registrationProgress, error) {
+			return getInstanceRegistrationProgress(elbv2Svc, targetGroup, instanceIDsToWaitFor, registrationOnly)
+		}
+		go func() {
+			defer wg.Done()
+			errChan <- waitForHealthyTargets(logger, description, policy, maxRetries, sleepBetweenRetries, getProgress)
+		}()
 	}
 	wg.Wait()
 
@@ -50,80 +186,237 @@ func waitForAnyInstancesRegisteredToALBOrNLB(logger *logrus.Entry, elbv2Svc *elb
 	return errors.WithStackTrace(finalErr)
 }
 
-// asyncWaitForAnyInstancesRegisteredToTargetGroup waits for any instance to register to a single TargetGroup with
-// retry. This function is intended to be run in a goroutine.
-func asyncWaitForAnyInstancesRegisteredToTargetGroup(
-	wg *sync.WaitGroup,
-	errChan chan error,
-	logger *logrus.Entry,
-	elbv2Svc *elbv2.ELBV2,
-	lbName string,
+// getInstanceRegistrationProgress returns which of the instances are healthy targets of the TargetGroup. Instances that
+// are not registered yet count as unhealthy. When registrationOnly is set, all the registered instances count as
+// healthy, except for the ones being deregistered.
+func getInstanceRegistrationProgress(
+	elbv2Svc elbv2iface.ELBV2API,
 	targetGroup *elbv2.TargetGroup,
 	instanceIDsToWaitFor []string,
-) {
-	defer wg.Done()
+	registrationOnly bool,
+) (registrationProgress, error) {
+	targetsResp, err := elbv2Svc.DescribeTargetHealth(&elbv2.DescribeTargetHealthInput{TargetGroupArn: targetGroup.TargetGroupArn})
+	if err != nil {
+		return registrationProgress{}, errors.WithStackTrace(err)
+	}
+	healthyTargets := getHealthyTargetIDs(targetsResp.TargetHealthDescriptions)
+	if registrationOnly {
+		healthyTargets = getRegisteredTargetIDs(targetsResp.TargetHealthDescriptions)
+	}
+
+	progress := registrationProgress{healthy: []string{}, unhealthy: []string{}}
+	for _, instanceID := range instanceIDsToWaitFor {
+		if collections.ListContainsElement(healthyTargets, instanceID) {
+			progress.healthy = append(progress.healthy, instanceID)
+		} else {
+			progress.unhealthy = append(progress.unhealthy, instanceID)
+		}
+	}
+	return progress, nil
+}
+
+// waitForPodTargetsHealthyInELBs waits until enough of the Pods outside the old nodes (listed with listPodIPs) are
+// healthy targets of each target group using the IP target type, as determined by the policy. This runs once the old
+// nodes are drained, so that the Pods that were rescheduled are serving before the old nodes are deregistered. Target
+// groups without any targets (e.g for a Service scaled down to zero) are skipped with a warning, as there is nothing to
+// wait for.
+func waitForPodTargetsHealthyInELBs(
+	elbv2Svc elbv2iface.ELBV2API,
+	elbs []kubectl.AWSLoadBalancer,
+	listPodIPs podIPLister,
+	policy ELBRegistrationPolicy,
+	maxRetries int,
+	sleepBetweenRetries time.Duration,
+) error {
+	logger := logging.GetProjectLogger()
+
+	var multipleErrs *multierror.Error
+	for _, lb := range elbs {
+		if lb.Type != kubectl.NLB && lb.Type != kubectl.ALB {
+			// Classic Load Balancers can only route to instances.
+			continue
+		}
+		targetGroups, err := getELBTargetGroups(elbv2Svc, lb.Name)
+		if err != nil {
+			multipleErrs = multierror.Append(multipleErrs, err)
+			continue
+		}
+		for _, targetGroup := range targetGroups {
+			if getTargetGroupTargetType(targetGroup, lb.TargetType) != kubectl.IPTarget {
+				continue
+			}
+			targetGroup := targetGroup
+			description := fmt.Sprintf("target group %s of load balancer %s", aws.StringValue(targetGroup.TargetGroupName), lb.Name)
 
-	// Retry up to 10 minutes with 15 second retry sleep
-	waitErr := retry.DoWithRetry(
-		logger.Logger,
-		fmt.Sprintf(
-			"wait for expected targets to be registered to target group %s of load balancer %s",
-			aws.StringValue(targetGroup.TargetGroupName),
-			lbName,
-		),
-		40, 15*time.Second,
-		func() error {
 			targetsResp, err := elbv2Svc.DescribeTargetHealth(&elbv2.DescribeTargetHealthInput{TargetGroupArn: targetGroup.TargetGroupArn})
 			if err != nil {
-				return retry.FatalError{Underlying: err}
+				multipleErrs = multierror.Append(multipleErrs, errors.WithStackTrace(err))
+				continue
+			}
+			if len(targetsResp.TargetHealthDescriptions) == 0 {
+				logger.Warnf("%s has no targets - skipping", description)
+				continue
 			}
 
-			// Check each target to see if it is one of the instances we are waiting for, and return without error to
-			// stop the retry loop if that is the case since condition is met.
-			for _, targetHealth := range targetsResp.TargetHealthDescriptions {
-				if targetHealth.Target == nil || targetHealth.Target.Id == nil {
-					continue
-				}
-				instanceID := *targetHealth.Target.Id
-				if collections.ListContainsElement(instanceIDsToWaitFor, instanceID) {
-					return nil
+			err = waitForHealthyTargets(logger, description, policy, maxRetries, sleepBetweenRetries, func() (registrationProgress, error) {
+				podIPs, err := listPodIPs()
+				if err != nil {
+					return registrationProgress{}, err
 				}
+				return getPodIPRegistrationProgress(elbv2Svc, targetGroup, podIPs)
+			})
+			if err != nil {
+				multipleErrs = multierror.Append(multipleErrs, err)
 			}
-			return fmt.Errorf("No expected instances registered yet")
-		},
-	)
-	if fatalWaitErr, isFatalErr := waitErr.(retry.FatalError); isFatalErr {
-		errChan <- fatalWaitErr.Underlying
+		}
 	}
-	errChan <- waitErr
+	return errors.WithStackTrace(multipleErrs.ErrorOrNil())
 }
 
-// waitForAnyInstancesRegisteredToCLB implements the logic to wait for instance registration to Classic Load Balancers.
-// Refer to function docs for waitForAnyInstancesRegisteredToELB for more info.
-func waitForAnyInstancesRegisteredToCLB(logger *logrus.Entry, elbSvc *elb.ELB, lbName string, instanceIds []string) error {
-	instances := []*elb.Instance{}
-	for _, instanceID := range instanceIds {
-		instances = append(instances, &elb.Instance{InstanceId: aws.String(instanceID)})
+// getPodIPRegistrationProgress returns which of the targets of the TargetGroup that are Pods outside the old nodes are
+// healthy. The targets that are still on the old nodes (e.g the Pods being deregistered after the drain) are ignored.
+func getPodIPRegistrationProgress(
+	elbv2Svc elbv2iface.ELBV2API,
+	targetGroup *elbv2.TargetGroup,
+	podIPs []string,
+) (registrationProgress, error) {
+	targetsResp, err := elbv2Svc.DescribeTargetHealth(&elbv2.DescribeTargetHealthInput{TargetGroupArn: targetGroup.TargetGroupArn})
+	if err != nil {
+		return registrationProgress{}, errors.WithStackTrace(err)
 	}
+	healthyTargets := getHealthyTargetIDs(targetsResp.TargetHealthDescriptions)
 
-	logger.Infof("Waiting for at least one instance to be in service for elb %s", lbName)
-	params := &elb.DescribeInstanceHealthInput{
-		LoadBalancerName: aws.String(lbName),
-		Instances:        instances,
+	progress := registrationProgress{healthy: []string{}, unhealthy: []string{}}
+	for _, targetHealth := range targetsResp.TargetHealthDescriptions {
+		if targetHealth.Target == nil || targetHealth.Target.Id == nil {
+			continue
+		}
+		targetID := *targetHealth.Target.Id
+		if !collections.ListContainsElement(podIPs, targetID) ||
+			collections.ListContainsElement(progress.healthy, targetID) ||
+			collections.ListContainsElement(progress.unhealthy, targetID) {
+			continue
+		}
+		if collections.ListContainsElement(healthyTargets, targetID) {
+			progress.healthy = append(progress.healthy, targetID)
+		} else {
+			progress.unhealthy = append(progress.unhealthy, targetID)
+		}
 	}
-	err := elbSvc.WaitUntilAnyInstanceInService(params)
+	return progress, nil
+}
+
+// getHealthyTargetIDs returns the IDs of the healthy targets. A target registered on multiple ports is healthy as long
+// as it is healthy on any of them.
+func getHealthyTargetIDs(targetHealthDescriptions []*elbv2.TargetHealthDescription) []string {
+	out := []string{}
+	for _, targetHealth := range targetHealthDescriptions {
+		if targetHealth.Target == nil || targetHealth.Target.Id == nil || targetHealth.TargetHealth == nil {
+			continue
+		}
+		if aws.StringValue(targetHealth.TargetHealth.State) == elbv2.TargetHealthStateEnumHealthy {
+			out = append(out, *targetHealth.Target.Id)
+		}
+	}
+	return out
+}
+
+// getRegisteredTargetIDs returns the IDs of the targets that are registered, whatever their health. The targets that
+// are being deregistered (draining) or that are no longer routed to (unused) are excluded.
+func getRegisteredTargetIDs(targetHealthDescriptions []*elbv2.TargetHealthDescription) []string {
+	out := []string{}
+	for _, targetHealth := range targetHealthDescriptions {
+		if targetHealth.Target == nil || targetHealth.Target.Id == nil || targetHealth.TargetHealth == nil {
+			continue
+		}
+		switch aws.StringValue(targetHealth.TargetHealth.State) {
+		case elbv2.TargetHealthStateEnumDraining, elbv2.TargetHealthStateEnumUnused:
+		default:
+			out = append(out, *targetHealth.Target.Id)
+		}
+	}
+	return out
+}
+
+// getTargetGroupTargetType returns the target type of the TargetGroup. The target type of the ELB is used when the
+// TargetGroup does not report one. Note that the target groups of a single ALB can use different target types, when
+// the ALB is shared by the Ingress resources of an IngressGroup.
+func getTargetGroupTargetType(targetGroup *elbv2.TargetGroup, lbTargetType kubectl.ELBTargetType) kubectl.ELBTargetType {
+	switch aws.StringValue(targetGroup.TargetType) {
+	case elbv2.TargetTypeEnumInstance:
+		return kubectl.InstanceTarget
+	case elbv2.TargetTypeEnumIp:
+		return kubectl.IPTarget
+	}
+	return lbTargetType
+}
+
+// waitForInstancesRegisteredToCLB implements the logic to wait for instance registration to Classic Load Balancers.
+// Refer to function docs for waitForInstancesRegisteredToELB for more info.
+func waitForInstancesRegisteredToCLB(
+	logger *logrus.Entry,
+	elbSvc elbiface.ELBAPI,
+	lbName string,
+	instanceIds []string,
+	registrationOnly bool,
+	policy ELBRegistrationPolicy,
+	maxRetries int,
+	sleepBetweenRetries time.Duration,
+) error {
+	err := waitForHealthyTargets(
+		logger,
+		fmt.Sprintf("elb %s", lbName),
+		policy,
+		maxRetries,
+		sleepBetweenRetries,
+		func() (registrationProgress, error) {
+			return getCLBRegistrationProgress(elbSvc, lbName, instanceIds, registrationOnly)
+		},
+	)
 	if err != nil {
-		logger.Errorf("error waiting for any instance to be in service for elb %s", lbName)
+		logger.Errorf("error waiting for instances to be in service for elb %s", lbName)
 		return err
 	}
-	logger.Infof("At least one instance in service for elb %s", lbName)
+	logger.Infof("Enough instances in service for elb %s", lbName)
 	return nil
 }
 
+// getCLBRegistrationProgress returns which of the instances are InService in the Classic Load Balancer. Instances that
+// are not registered yet count as unhealthy. When registrationOnly is set, all the registered instances count as
+// healthy, whatever their state.
+func getCLBRegistrationProgress(
+	elbSvc elbiface.ELBAPI,
+	lbName string,
+	instanceIds []string,
+	registrationOnly bool,
+) (registrationProgress, error) {
+	// Describing specific instances fails for the instances that are not registered, so list all of them instead.
+	resp, err := elbSvc.DescribeInstanceHealth(&elb.DescribeInstanceHealthInput{LoadBalancerName: aws.String(lbName)})
+	if err != nil {
+		return registrationProgress{}, errors.WithStackTrace(err)
+	}
+	inService := []string{}
+	for _, instanceState := range resp.InstanceStates {
+		if registrationOnly || aws.StringValue(instanceState.State) == clbInServiceState {
+			inService = append(inService, aws.StringValue(instanceState.InstanceId))
+		}
+	}
+
+	progress := registrationProgress{healthy: []string{}, unhealthy: []string{}}
+	for _, instanceID := range instanceIds {
+		if collections.ListContainsElement(inService, instanceID) {
+			progress.healthy = append(progress.healthy, instanceID)
+		} else {
+			progress.unhealthy = append(progress.unhealthy, instanceID)
+		}
+	}
+	return progress, nil
+}
+
 // getELBTargetGroups looks up the associated TargetGroup of the given ELB. Note that this assumes lbName refers to a v2
 // ELB (ALB or NLB).
 // NOTE: You can have multiple target groups on a given ELB if the service or ingress has multiple ports to listen on.
-func getELBTargetGroups(elbv2Svc *elbv2.ELBV2, lbName string) ([]*elbv2.TargetGroup, error) {
+func getELBTargetGroups(elbv2Svc elbv2iface.ELBV2API, lbName string) ([]*elbv2.TargetGroup, error) {
 	resp, err := elbv2Svc.DescribeLoadBalancers(&elbv2.DescribeLoadBalancersInput{Names: aws.StringSlice([]string{lbName})})
 	if err != nil {
 		return nil, errors.WithStackTrace(err)
@@ -150,3 +443,365 @@ func getELBTargetGroups(elbv2Svc *elbv2.ELBV2, lbName string) ([]*elbv2.TargetGr
 	}
 	return targetGroupsResp.TargetGroups, nil
 }
+
+// LoadBalancerTarget records the registration of an instance to an external load balancer, so that it can be
+// registered again when rolling back: either to a Classic Load Balancer, or to a target group of an Application or
+// Network Load Balancer (identified by TargetGroupArn) on the given port. RegistrationOnly is set for the load balancers
+// of Services with externalTrafficPolicy set to Local, where the instance is not expected to be healthy until the Pods
+// of the Service are rescheduled on it.
+type LoadBalancerTarget struct {
+	LoadBalancerName string
+	TargetGroupArn   string
+	InstanceID       string
+	Port             int64
+	RegistrationOnly bool
+}
+
+// getLoadBalancerTargets returns the registrations of the instances to all the ELBs provided. The ELBs using the IP
+// target type are skipped, as the instances are not targets of those.
+func getLoadBalancerTargets(
+	elbSvc elbiface.ELBAPI,
+	elbv2Svc elbv2iface.ELBV2API,
+	elbs []kubectl.AWSLoadBalancer,
+	instanceIds []string,
+) ([]LoadBalancerTarget, error) {
+	out := []LoadBalancerTarget{}
+	for _, lb := range elbs {
+		switch lb.Type {
+		case kubectl.CLB:
+			registered, err := getInstancesRegisteredToCLB(elbSvc, lb.Name, instanceIds, false)
+			if err != nil {
+				return nil, err
+			}
+			for _, instanceID := range registered {
+				out = append(out, LoadBalancerTarget{
+					LoadBalancerName: lb.Name,
+					InstanceID:       instanceID,
+					RegistrationOnly: lb.LocalTrafficPolicy,
+				})
+			}
+		case kubectl.NLB, kubectl.ALB:
+			targetGroups, err := getELBTargetGroups(elbv2Svc, lb.Name)
+			if err != nil {
+				return nil, err
+			}
+			for _, targetGroup := range targetGroups {
+				if getTargetGroupTargetType(targetGroup, lb.TargetType) != kubectl.InstanceTarget {
+					continue
+				}
+				targets, err := getInstanceTargetsInTargetGroup(elbv2Svc, targetGroup, instanceIds, true)
+				if err != nil {
+					return nil, err
+				}
+				for _, target := range targets {
+					out = append(out, LoadBalancerTarget{
+						LoadBalancerName: lb.Name,
+						TargetGroupArn:   aws.StringValue(targetGroup.TargetGroupArn),
+						InstanceID:       aws.StringValue(target.Id),
+						Port:             aws.Int64Value(target.Port),
+						RegistrationOnly: lb.LocalTrafficPolicy,
+					})
+				}
+			}
+		default:
+			// This should never happen, so we return a generic error that indicates this is an impossible condition and
+			// almost 100% a bug with kubergrunt.
+			return nil, errors.WithStackTrace(commonerrors.ImpossibleErr("UNKNOWN_ELB_TYPE_IN_LIST_TARGETS"))
+		}
+	}
+	return out, nil
+}
+
+// registerLoadBalancerTargets registers the instances to the load balancers again, as recorded by
+// getLoadBalancerTargets, and waits until all of them are healthy (InService for Classic Load Balancers), or only
+// registered for the targets marked RegistrationOnly.
+func registerLoadBalancerTargets(
+	elbSvc elbiface.ELBAPI,
+	elbv2Svc elbv2iface.ELBV2API,
+	targets []LoadBalancerTarget,
+	maxRetries int,
+	sleepBetweenRetries time.Duration,
+) error {
+	logger := logging.GetProjectLogger()
+
+	// Group the targets by Classic Load Balancer name or target group ARN, keeping the order they were recorded in.
+	keys := []string{}
+	targetsByKey := map[string][]LoadBalancerTarget{}
+	for _, target := range targets {
+		key := target.TargetGroupArn
+		if key == "" {
+			key = target.LoadBalancerName
+		}
+		if _, hasKey := targetsByKey[key]; !hasKey {
+			keys = append(keys, key)
+		}
+		targetsByKey[key] = append(targetsByKey[key], target)
+	}
+
+	for _, key := range keys {
+		group := targetsByKey[key]
+		instanceIds := []string{}
+		for _, target := range group {
+			instanceIds = append(instanceIds, target.InstanceID)
+		}
+
+		var getProgress func() (registrationProgress, error)
+		description := ""
+		if group[0].TargetGroupArn == "" {
+			description = fmt.Sprintf("elb %s", group[0].LoadBalancerName)
+			logger.Infof("Registering instances %s to %s", strings.Join(instanceIds, ","), description)
+			instances := []*elb.Instance{}
+			for _, instanceID := range instanceIds {
+				instances = append(instances, &elb.Instance{InstanceId: aws.String(instanceID)})
+			}
+			_, err := elbSvc.RegisterInstancesWithLoadBalancer(&elb.RegisterInstancesWithLoadBalancerInput{
+				LoadBalancerName: aws.String(group[0].LoadBalancerName),
+				Instances:        instances,
+			})
+			if err != nil {
+				return errors.WithStackTrace(err)
+			}
+			getProgress = func() (registrationProgress, error) {
+				return getCLBRegistrationProgress(elbSvc, group[0].LoadBalancerName, instanceIds, group[0].RegistrationOnly)
+			}
+		} else {
+			description = fmt.Sprintf("target group %s of load balancer %s", group[0].TargetGroupArn, group[0].LoadBalancerName)
+			logger.Infof("Registering instances %s to %s", strings.Join(instanceIds, ","), description)
+			targetDescriptions := []*elbv2.TargetDescription{}
+			for _, target := range group {
+				targetDescription := &elbv2.TargetDescription{Id: aws.String(target.InstanceID)}
+				if target.Port != 0 {
+					targetDescription.Port = aws.Int64(target.Port)
+				}
+				targetDescriptions = append(targetDescriptions, targetDescription)
+			}
+			_, err := elbv2Svc.RegisterTargets(&elbv2.RegisterTargetsInput{
+				TargetGroupArn: aws.String(group[0].TargetGroupArn),
+				Targets:        targetDescriptions,
+			})
+			if err != nil {
+				return errors.WithStackTrace(err)
+			}
+			targetGroup := &elbv2.TargetGroup{TargetGroupArn: aws.String(group[0].TargetGroupArn)}
+			getProgress = func() (registrationProgress, error) {
+				return getInstanceRegistrationProgress(elbv2Svc, targetGroup, instanceIds, group[0].RegistrationOnly)
+			}
+		}
+
+		err := waitForHealthyTargets(logger, description, AllELBRegistrationPolicy, maxRetries, sleepBetweenRetries, getProgress)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// deregisterInstancesFromELBs explicitly deregisters the instances from all the ELBs provided, and waits until the
+// ELBs stop routing to them. This gives in-flight requests a chance to complete through connection draining (or the
+// deregistration delay of the target groups) before the instances are terminated. The ELBs using the IP target type are
+// skipped, as the instances are not targets of those.
+func deregisterInstancesFromELBs(
+	elbSvc elbiface.ELBAPI,
+	elbv2Svc elbv2iface.ELBV2API,
+	elbs []kubectl.AWSLoadBalancer,
+	instanceIds []string,
+	maxRetries int,
+	sleepBetweenRetries time.Duration,
+) error {
+	logger := logging.GetProjectLogger()
+	logger.Infof("Deregistering old instances from external load balancers.")
+
+	var multipleErrs *multierror.Error
+	for _, lb := range elbs {
+		var err error
+		switch lb.Type {
+		case kubectl.CLB:
+			err = deregisterInstancesFromCLB(logger, elbSvc, lb.Name, instanceIds, maxRetries, sleepBetweenRetries)
+		case kubectl.NLB, kubectl.ALB:
+			err = deregisterInstancesFromALBOrNLB(logger, elbv2Svc, lb, instanceIds, maxRetries, sleepBetweenRetries)
+		default:
+			// This should never happen, so we return a generic error that indicates this is an impossible condition and
+			// almost 100% a bug with kubergrunt.
+			err = commonerrors.ImpossibleErr("UNKNOWN_ELB_TYPE_IN_DEREGISTER")
+		}
+		if err != nil {
+			multipleErrs = multierror.Append(multipleErrs, err)
+		}
+	}
+	return multipleErrs.ErrorOrNil()
+}
+
+// deregisterInstancesFromCLB deregisters the instances from the Classic Load Balancer, and waits until they are
+// OutOfService (or no longer registered), which is once connection draining is done.
+func deregisterInstancesFromCLB(
+	logger *logrus.Entry,
+	elbSvc elbiface.ELBAPI,
+	lbName string,
+	instanceIds []string,
+	maxRetries int,
+	sleepBetweenRetries time.Duration,
+) error {
+	registered, err := getInstancesRegisteredToCLB(elbSvc, lbName, instanceIds, false)
+	if err != nil {
+		return err
+	}
+	if len(registered) == 0 {
+		logger.Infof("None of the old instances are registered to elb %s", lbName)
+		return nil
+	}
+
+	logger.Infof("Deregistering instances %s from elb %s", strings.Join(registered, ","), lbName)
+	instances := []*elb.Instance{}
+	for _, instanceID := range registered {
+		instances = append(instances, &elb.Instance{InstanceId: aws.String(instanceID)})
+	}
+	_, err = elbSvc.DeregisterInstancesFromLoadBalancer(&elb.DeregisterInstancesFromLoadBalancerInput{
+		LoadBalancerName: aws.String(lbName),
+		Instances:        instances,
+	})
+	if err != nil {
+		return errors.WithStackTrace(err)
+	}
+
+	err = retry.DoWithRetry(
+		logger.Logger,
+		fmt.Sprintf("wait for instances to be out of service in elb %s", lbName),
+		maxRetries, sleepBetweenRetries,
+		func() error {
+			inService, err := getInstancesRegisteredToCLB(elbSvc, lbName, registered, true)
+			if err != nil {
+				return retry.FatalError{Underlying: err}
+			}
+			if len(inService) > 0 {
+				return fmt.Errorf("Instances %s are still in service", strings.Join(inService, ","))
+			}
+			return nil
+		},
+	)
+	if fatalErr, isFatalErr := err.(retry.FatalError); isFatalErr {
+		return fatalErr.Underlying
+	} else if err != nil {
+		return errors.WithStackTrace(err)
+	}
+	logger.Infof("Successfully deregistered instances from elb %s", lbName)
+	return nil
+}
+
+// getInstancesRegisteredToCLB returns the instances that are registered to the Classic Load Balancer. When inService is
+// set, only the instances that are not OutOfService yet are returned.
+func getInstancesRegisteredToCLB(elbSvc elbiface.ELBAPI, lbName string, instanceIds []string, inService bool) ([]string, error) {
+	// Describing specific instances fails for the instances that are not registered, so list all of them instead.
+	resp, err := elbSvc.DescribeInstanceHealth(&elb.DescribeInstanceHealthInput{LoadBalancerName: aws.String(lbName)})
+	if err != nil {
+		return nil, errors.WithStackTrace(err)
+	}
+	out := []string{}
+	for _, instanceState := range resp.InstanceStates {
+		instanceID := aws.StringValue(instanceState.InstanceId)
+		if !collections.ListContainsElement(instanceIds, instanceID) {
+			continue
+		}
+		if inService && aws.StringValue(instanceState.State) == clbOutOfServiceState {
+			continue
+		}
+		out = append(out, instanceID)
+	}
+	return out, nil
+}
+
+// deregisterInstancesFromALBOrNLB deregisters the instances from all the target groups of the Application or Network
+// Load Balancer that use the instance target type, and waits until they are unused (or no longer registered), which is
+// once the deregistration delay has elapsed.
+func deregisterInstancesFromALBOrNLB(
+	logger *logrus.Entry,
+	elbv2Svc elbv2iface.ELBV2API,
+	lb kubectl.AWSLoadBalancer,
+	instanceIds []string,
+	maxRetries int,
+	sleepBetweenRetries time.Duration,
+) error {
+	targetGroups, err := getELBTargetGroups(elbv2Svc, lb.Name)
+	if err != nil {
+		return err
+	}
+
+	for _, targetGroup := range targetGroups {
+		if getTargetGroupTargetType(targetGroup, lb.TargetType) != kubectl.InstanceTarget {
+			continue
+		}
+		targetGroupName := aws.StringValue(targetGroup.TargetGroupName)
+		targets, err := getInstanceTargetsInTargetGroup(elbv2Svc, targetGroup, instanceIds, false)
+		if err != nil {
+			return err
+		}
+		if len(targets) == 0 {
+			logger.Infof("None of the old instances are registered to target group %s of load balancer %s", targetGroupName, lb.Name)
+			continue
+		}
+		logger.Infof("Deregistering %d targets from target group %s of load balancer %s", len(targets), targetGroupName, lb.Name)
+		_, err = elbv2Svc.DeregisterTargets(&elbv2.DeregisterTargetsInput{
+			TargetGroupArn: targetGroup.TargetGroupArn,
+			Targets:        targets,
+		})
+		if err != nil {
+			return errors.WithStackTrace(err)
+		}
+	}
+
+	// Wait on all the target groups after deregistering from each of them, so that the deregistration delays elapse
+	// concurrently.
+	for _, targetGroup := range targetGroups {
+		if getTargetGroupTargetType(targetGroup, lb.TargetType) != kubectl.InstanceTarget {
+			continue
+		}
+		targetGroupName := aws.StringValue(targetGroup.TargetGroupName)
+		err := retry.DoWithRetry(
+			logger.Logger,
+			fmt.Sprintf("wait for instances to be unused in target group %s of load balancer %s", targetGroupName, lb.Name),
+			maxRetries, sleepBetweenRetries,
+			func() error {
+				targets, err := getInstanceTargetsInTargetGroup(elbv2Svc, targetGroup, instanceIds, true)
+				if err != nil {
+					return retry.FatalError{Underlying: err}
+				}
+				if len(targets) > 0 {
+					return fmt.Errorf("%d targets are still in use", len(targets))
+				}
+				return nil
+			},
+		)
+		if fatalErr, isFatalErr := err.(retry.FatalError); isFatalErr {
+			return fatalErr.Underlying
+		} else if err != nil {
+			return errors.WithStackTrace(err)
+		}
+	}
+	logger.Infof("Successfully deregistered instances from load balancer %s", lb.Name)
+	return nil
+}
+
+// getInstanceTargetsInTargetGroup returns the targets of the TargetGroup that are one of the instances. When inUse is
+// set, only the targets that are not unused yet are returned.
+func getInstanceTargetsInTargetGroup(
+	elbv2Svc elbv2iface.ELBV2API,
+	targetGroup *elbv2.TargetGroup,
+	instanceIds []string,
+	inUse bool,
+) ([]*elbv2.TargetDescription, error) {
+	resp, err := elbv2Svc.DescribeTargetHealth(&elbv2.DescribeTargetHealthInput{TargetGroupArn: targetGroup.TargetGroupArn})
+	if err != nil {
+		return nil, errors.WithStackTrace(err)
+	}
+	out := []*elbv2.TargetDescription{}
+	for _, targetHealth := range resp.TargetHealthDescriptions {
+		if targetHealth.Target == nil || !collections.ListContainsElement(instanceIds, aws.StringValue(targetHealth.Target.Id)) {
+			continue
+		}
+		if inUse && targetHealth.TargetHealth != nil && aws.StringValue(targetHealth.TargetHealth.State) == elbv2.TargetHealthStateEnumUnused {
+			continue
+		}
+		// Keep the port, as an instance can be registered to the same target group on multiple ports.
+		out = append(out, targetHealth.Target)
+	}
+	return out, nil
+}
@@ -0,0 +1,503 @@
+package eks
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/elb"
+	"github.com/aws/aws-sdk-go/service/elb/elbiface"
+	"github.com/aws/aws-sdk-go/service/elbv2"
+	"github.com/aws/aws-sdk-go/service/elbv2/elbv2iface"
+	"github.com/gruntwork-io/go-commons/collections"
+	"github.com/gruntwork-io/go-commons/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/gruntwork-io/kubergrunt/kubectl"
+	"github.com/gruntwork-io/kubergrunt/logging"
+)
+
+// fakeELBV2 fakes the ELBv2 API calls used to wait for targets to register and deregister. Each load balancer has the
+// target groups listed in targetGroups, and the targets of each target group are looked up by ARN in targets.
+// Deregistered targets are draining until the next time the target health is described, after which they are unused.
+// Registered targets are healthy right away.
+type fakeELBV2 struct {
+	elbv2iface.ELBV2API
+
+	mutex        sync.Mutex
+	targetGroups map[string][]*elbv2.TargetGroup
+	targets      map[string][]*elbv2.TargetHealthDescription
+	deregistered []string
+	registered   []string
+}
+
+func (fake *fakeELBV2) DescribeLoadBalancers(input *elbv2.DescribeLoadBalancersInput) (*elbv2.DescribeLoadBalancersOutput, error) {
+	lbs := []*elbv2.LoadBalancer{}
+	for _, name := range aws.StringValueSlice(input.Names) {
+		if _, hasLB := fake.targetGroups[name]; hasLB {
+			lbs = append(lbs, &elbv2.LoadBalancer{LoadBalancerName: aws.String(name), LoadBalancerArn: aws.String("arn:" + name)})
+		}
+	}
+	return &elbv2.DescribeLoadBalancersOutput{LoadBalancers: lbs}, nil
+}
+
+func (fake *fakeELBV2) DescribeTargetGroups(input *elbv2.DescribeTargetGroupsInput) (*elbv2.DescribeTargetGroupsOutput, error) {
+	lbName := aws.StringValue(input.LoadBalancerArn)[len("arn:"):]
+	return &elbv2.DescribeTargetGroupsOutput{TargetGroups: fake.targetGroups[lbName]}, nil
+}
+
+func (fake *fakeELBV2) DescribeTargetHealth(input *elbv2.DescribeTargetHealthInput) (*elbv2.DescribeTargetHealthOutput, error) {
+	fake.mutex.Lock()
+	defer fake.mutex.Unlock()
+	out := []*elbv2.TargetHealthDescription{}
+	for _, target := range fake.targets[aws.StringValue(input.TargetGroupArn)] {
+		out = append(out, newFakeTarget(aws.StringValue(target.Target.Id), aws.StringValue(target.TargetHealth.State)))
+		if aws.StringValue(target.TargetHealth.State) == elbv2.TargetHealthStateEnumDraining {
+			target.TargetHealth.State = aws.String(elbv2.TargetHealthStateEnumUnused)
+		}
+	}
+	return &elbv2.DescribeTargetHealthOutput{TargetHealthDescriptions: out}, nil
+}
+
+func (fake *fakeELBV2) DeregisterTargets(input *elbv2.DeregisterTargetsInput) (*elbv2.DeregisterTargetsOutput, error) {
+	fake.mutex.Lock()
+	defer fake.mutex.Unlock()
+	for _, deregisteredTarget := range input.Targets {
+		fake.deregistered = append(fake.deregistered, aws.StringValue(deregisteredTarget.Id))
+		for _, target := range fake.targets[aws.StringValue(input.TargetGroupArn)] {
+			if aws.StringValue(target.Target.Id) == aws.StringValue(deregisteredTarget.Id) {
+				target.TargetHealth.State = aws.String(elbv2.TargetHealthStateEnumDraining)
+			}
+		}
+	}
+	return &elbv2.DeregisterTargetsOutput{}, nil
+}
+
+func (fake *fakeELBV2) RegisterTargets(input *elbv2.RegisterTargetsInput) (*elbv2.RegisterTargetsOutput, error) {
+	fake.mutex.Lock()
+	defer fake.mutex.Unlock()
+	targetGroupArn := aws.StringValue(input.TargetGroupArn)
+	for _, registeredTarget := range input.Targets {
+		fake.registered = append(fake.registered, aws.StringValue(registeredTarget.Id))
+		found := false
+		for _, target := range fake.targets[targetGroupArn] {
+			if aws.StringValue(target.Target.Id) == aws.StringValue(registeredTarget.Id) {
+				target.TargetHealth.State = aws.String(elbv2.TargetHealthStateEnumHealthy)
+				found = true
+			}
+		}
+		if !found {
+			fake.targets[targetGroupArn] = append(
+				fake.targets[targetGroupArn],
+				newFakeTarget(aws.StringValue(registeredTarget.Id), elbv2.TargetHealthStateEnumHealthy),
+			)
+		}
+	}
+	return &elbv2.RegisterTargetsOutput{}, nil
+}
+
+// fakeELB fakes the Classic Load Balancer API calls used to deregister instances. Deregistered instances stay
+// InService until the next time the instance health is described, after which they are OutOfService. Registered
+// instances are InService right away.
+type fakeELB struct {
+	elbiface.ELBAPI
+
+	instanceStates map[string][]*elb.InstanceState
+	deregistered   []string
+	registered     []string
+}
+
+func (fake *fakeELB) DescribeInstanceHealth(input *elb.DescribeInstanceHealthInput) (*elb.DescribeInstanceHealthOutput, error) {
+	out := []*elb.InstanceState{}
+	for _, instanceState := range fake.instanceStates[aws.StringValue(input.LoadBalancerName)] {
+		out = append(out, &elb.InstanceState{InstanceId: instanceState.InstanceId, State: instanceState.State})
+		if collections.ListContainsElement(fake.deregistered, aws.StringValue(instanceState.InstanceId)) {
+			instanceState.State = aws.String(clbOutOfServiceState)
+		}
+	}
+	return &elb.DescribeInstanceHealthOutput{InstanceStates: out}, nil
+}
+
+func (fake *fakeELB) DeregisterInstancesFromLoadBalancer(input *elb.DeregisterInstancesFromLoadBalancerInput) (*elb.DeregisterInstancesFromLoadBalancerOutput, error) {
+	for _, instance := range input.Instances {
+		fake.deregistered = append(fake.deregistered, aws.StringValue(instance.InstanceId))
+	}
+	return &elb.DeregisterInstancesFromLoadBalancerOutput{}, nil
+}
+
+func (fake *fakeELB) RegisterInstancesWithLoadBalancer(input *elb.RegisterInstancesWithLoadBalancerInput) (*elb.RegisterInstancesWithLoadBalancerOutput, error) {
+	lbName := aws.StringValue(input.LoadBalancerName)
+	for _, instance := range input.Instances {
+		instanceID := aws.StringValue(instance.InstanceId)
+		fake.registered = append(fake.registered, instanceID)
+		deregistered := []string{}
+		for _, deregisteredID := range fake.deregistered {
+			if deregisteredID != instanceID {
+				deregistered = append(deregistered, deregisteredID)
+			}
+		}
+		fake.deregistered = deregistered
+
+		found := false
+		for _, instanceState := range fake.instanceStates[lbName] {
+			if aws.StringValue(instanceState.InstanceId) == instanceID {
+				instanceState.State = aws.String(clbInServiceState)
+				found = true
+			}
+		}
+		if !found {
+			fake.instanceStates[lbName] = append(
+				fake.instanceStates[lbName],
+				&elb.InstanceState{InstanceId: aws.String(instanceID), State: aws.String(clbInServiceState)},
+			)
+		}
+	}
+	return &elb.RegisterInstancesWithLoadBalancerOutput{}, nil
+}
+
+func newFakeTargetGroup(name string, targetType string) *elbv2.TargetGroup {
+	return &elbv2.TargetGroup{
+		TargetGroupName: aws.String(name),
+		TargetGroupArn:  aws.String("arn:" + name),
+		TargetType:      aws.String(targetType),
+	}
+}
+
+func newFakeTarget(id string, state string) *elbv2.TargetHealthDescription {
+	return &elbv2.TargetHealthDescription{
+		Target:       &elbv2.TargetDescription{Id: aws.String(id)},
+		TargetHealth: &elbv2.TargetHealth{State: aws.String(state)},
+	}
+}
+
+func TestWaitForInstancesRegisteredToALBOrNLB(t *testing.T) {
+	t.Parallel()
+
+	elbv2Svc := &fakeELBV2{
+		targetGroups: map[string][]*elbv2.TargetGroup{
+			"instance-nlb": {newFakeTargetGroup("instance-tg", elbv2.TargetTypeEnumInstance)},
+			"ip-nlb":       {newFakeTargetGroup("ip-tg", elbv2.TargetTypeEnumIp)},
+			// An ALB shared by an IngressGroup, with target groups of both target types.
+			"shared-alb": {
+				newFakeTargetGroup("shared-instance-tg", elbv2.TargetTypeEnumInstance),
+				newFakeTargetGroup("shared-ip-tg", elbv2.TargetTypeEnumIp),
+			},
+			"local-nlb":    {newFakeTargetGroup("local-tg", elbv2.TargetTypeEnumInstance)},
+			"draining-nlb": {newFakeTargetGroup("draining-tg", elbv2.TargetTypeEnumInstance)},
+		},
+		targets: map[string][]*elbv2.TargetHealthDescription{
+			"arn:instance-tg": {
+				newFakeTarget("i-new-1", elbv2.TargetHealthStateEnumHealthy),
+				newFakeTarget("i-new-2", elbv2.TargetHealthStateEnumInitial),
+			},
+			"arn:ip-tg": {newFakeTarget("10.0.0.1", elbv2.TargetHealthStateEnumHealthy)},
+			"arn:shared-instance-tg": {
+				newFakeTarget("i-old", elbv2.TargetHealthStateEnumHealthy),
+				newFakeTarget("i-new-1", elbv2.TargetHealthStateEnumHealthy),
+				newFakeTarget("i-new-2", elbv2.TargetHealthStateEnumHealthy),
+			},
+			"arn:shared-ip-tg": {newFakeTarget("10.0.0.9", elbv2.TargetHealthStateEnumHealthy)},
+			// The new instances of a Service with externalTrafficPolicy Local fail the health checks until the Pods
+			// are rescheduled on them.
+			"arn:local-tg": {
+				newFakeTarget("i-new-1", elbv2.TargetHealthStateEnumUnhealthy),
+				newFakeTarget("i-new-2", elbv2.TargetHealthStateEnumInitial),
+			},
+			"arn:draining-tg": {
+				newFakeTarget("i-new-1", elbv2.TargetHealthStateEnumUnhealthy),
+				newFakeTarget("i-new-2", elbv2.TargetHealthStateEnumDraining),
+			},
+		},
+	}
+	testCases := []struct {
+		name             string
+		lb               kubectl.AWSLoadBalancer
+		policy           ELBRegistrationPolicy
+		registrationOnly bool
+		expectErr        bool
+	}{
+		{"InstanceTargetAny", kubectl.AWSLoadBalancer{Name: "instance-nlb", Type: kubectl.NLB, TargetType: kubectl.InstanceTarget}, AnyELBRegistrationPolicy, false, false},
+		{"InstanceTargetHalf", kubectl.AWSLoadBalancer{Name: "instance-nlb", Type: kubectl.NLB, TargetType: kubectl.InstanceTarget}, "50%", false, false},
+		{"InstanceTargetAll", kubectl.AWSLoadBalancer{Name: "instance-nlb", Type: kubectl.NLB, TargetType: kubectl.InstanceTarget}, AllELBRegistrationPolicy, false, true},
+		// The target groups routing to Pods are waited on once the old nodes are drained instead.
+		{"IPTargetSkipped", kubectl.AWSLoadBalancer{Name: "ip-nlb", Type: kubectl.NLB, TargetType: kubectl.IPTarget}, AllELBRegistrationPolicy, false, false},
+		{"LocalTrafficPolicyRegistered", kubectl.AWSLoadBalancer{Name: "local-nlb", Type: kubectl.NLB, TargetType: kubectl.InstanceTarget}, AllELBRegistrationPolicy, true, false},
+		// Draining targets are being deregistered, so they don't count as registered.
+		{"DrainingNotRegistered", kubectl.AWSLoadBalancer{Name: "draining-nlb", Type: kubectl.NLB, TargetType: kubectl.InstanceTarget}, AllELBRegistrationPolicy, true, true},
+		{"MixedTargetTypesAll", kubectl.AWSLoadBalancer{Name: "shared-alb", Type: kubectl.ALB, TargetType: kubectl.InstanceTarget}, AllELBRegistrationPolicy, false, false},
+	}
+
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			err := waitForInstancesRegisteredToALBOrNLB(
+				logging.GetProjectLogger(),
+				elbv2Svc,
+				tc.lb,
+				[]string{"i-new-1", "i-new-2"},
+				tc.registrationOnly,
+				tc.policy,
+				2,
+				time.Millisecond,
+			)
+			if tc.expectErr {
+				require.Error(t, err)
+				assert.Contains(t, err.Error(), "Targets that are still unhealthy: i-new-2")
+			} else {
+				require.NoError(t, err)
+			}
+		})
+	}
+}
+
+func TestWaitForInstancesRegisteredToCLB(t *testing.T) {
+	t.Parallel()
+
+	elbSvc := &fakeELB{
+		instanceStates: map[string][]*elb.InstanceState{
+			"clb": {
+				{InstanceId: aws.String("i-old"), State: aws.String(clbInServiceState)},
+				{InstanceId: aws.String("i-new-1"), State: aws.String(clbInServiceState)},
+				{InstanceId: aws.String("i-new-2"), State: aws.String(clbOutOfServiceState)},
+			},
+		},
+	}
+	instanceIds := []string{"i-new-1", "i-new-2", "i-new-3"}
+	logger := logging.GetProjectLogger()
+
+	require.NoError(t, waitForInstancesRegisteredToCLB(logger, elbSvc, "clb", instanceIds, false, AnyELBRegistrationPolicy, 2, time.Millisecond))
+	require.NoError(t, waitForInstancesRegisteredToCLB(logger, elbSvc, "clb", instanceIds, false, "30%", 2, time.Millisecond))
+
+	err := waitForInstancesRegisteredToCLB(logger, elbSvc, "clb", instanceIds, false, AllELBRegistrationPolicy, 2, time.Millisecond)
+	require.Error(t, err)
+	timeoutErr, isTimeoutErr := errors.Unwrap(err).(ELBRegistrationTimeoutErr)
+	require.True(t, isTimeoutErr)
+	assert.Equal(t, []string{"i-new-2", "i-new-3"}, timeoutErr.unhealthy)
+
+	// For a Service with externalTrafficPolicy Local, the OutOfService instances count as well, but not the instances
+	// that are not registered yet.
+	err = waitForInstancesRegisteredToCLB(logger, elbSvc, "clb", instanceIds, true, AllELBRegistrationPolicy, 2, time.Millisecond)
+	require.Error(t, err)
+	timeoutErr, isTimeoutErr = errors.Unwrap(err).(ELBRegistrationTimeoutErr)
+	require.True(t, isTimeoutErr)
+	assert.Equal(t, []string{"i-new-3"}, timeoutErr.unhealthy)
+	require.NoError(t, waitForInstancesRegisteredToCLB(logger, elbSvc, "clb", instanceIds[:2], true, AllELBRegistrationPolicy, 2, time.Millisecond))
+}
+
+func TestELBRegistrationPolicyRequiredHealthyCount(t *testing.T) {
+	t.Parallel()
+
+	testCases := []struct {
+		policy     ELBRegistrationPolicy
+		numTargets int
+		expected   int
+		expectErr  bool
+	}{
+		{"", 20, 1, false},
+		{AnyELBRegistrationPolicy, 20, 1, false},
+		{AnyELBRegistrationPolicy, 0, 1, false},
+		{AllELBRegistrationPolicy, 0, 1, false},
+		{AllELBRegistrationPolicy, 20, 20, false},
+		{"50%", 20, 10, false},
+		{"25%", 3, 1, false},
+		{"50%", 0, 1, false},
+		{"0%", 20, 0, true},
+		{"150%", 20, 0, true},
+		{"5", 20, 0, true},
+		{"most", 20, 0, true},
+	}
+
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(fmt.Sprintf("%s-%d", tc.policy, tc.numTargets), func(t *testing.T) {
+			t.Parallel()
+
+			required, err := tc.policy.requiredHealthyCount(tc.numTargets)
+			if tc.expectErr {
+				assert.Error(t, err)
+				assert.Error(t, ValidateELBRegistrationPolicy(tc.policy))
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tc.expected, required)
+		})
+	}
+}
+
+func TestGetPodIPRegistrationProgress(t *testing.T) {
+	t.Parallel()
+
+	elbv2Svc := &fakeELBV2{
+		targets: map[string][]*elbv2.TargetHealthDescription{
+			"arn:ip-tg": {
+				newFakeTarget("10.0.0.9", elbv2.TargetHealthStateEnumHealthy),
+				newFakeTarget("10.0.0.1", elbv2.TargetHealthStateEnumHealthy),
+				newFakeTarget("10.0.0.2", elbv2.TargetHealthStateEnumInitial),
+			},
+		},
+	}
+
+	// Only the targets that are Pods on the new nodes count, and the Pods that are not targets are ignored.
+	progress, err := getPodIPRegistrationProgress(
+		elbv2Svc,
+		newFakeTargetGroup("ip-tg", elbv2.TargetTypeEnumIp),
+		[]string{"10.0.0.1", "10.0.0.2", "10.0.0.3"},
+	)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"10.0.0.1"}, progress.healthy)
+	assert.Equal(t, []string{"10.0.0.2"}, progress.unhealthy)
+}
+
+func TestWaitForPodTargetsHealthyInELBs(t *testing.T) {
+	t.Parallel()
+
+	elbv2Svc := &fakeELBV2{
+		targetGroups: map[string][]*elbv2.TargetGroup{
+			"rescheduled-nlb": {newFakeTargetGroup("rescheduled-tg", elbv2.TargetTypeEnumIp)},
+			"old-only-nlb":    {newFakeTargetGroup("old-only-tg", elbv2.TargetTypeEnumIp)},
+			"empty-nlb":       {newFakeTargetGroup("empty-tg", elbv2.TargetTypeEnumIp)},
+			"instance-nlb":    {newFakeTargetGroup("instance-tg", elbv2.TargetTypeEnumInstance)},
+		},
+		targets: map[string][]*elbv2.TargetHealthDescription{
+			"arn:rescheduled-tg": {
+				newFakeTarget("10.0.0.9", elbv2.TargetHealthStateEnumDraining),
+				newFakeTarget("10.0.0.1", elbv2.TargetHealthStateEnumHealthy),
+			},
+			// Only the Pods evicted from the old nodes are targets, and the rescheduled Pods are not registered yet.
+			"arn:old-only-tg": {newFakeTarget("10.0.0.9", elbv2.TargetHealthStateEnumHealthy)},
+			"arn:empty-tg":    {},
+			"arn:instance-tg": {newFakeTarget("i-old", elbv2.TargetHealthStateEnumUnhealthy)},
+		},
+	}
+	// The IPs of the Pods outside the old nodes.
+	listPodIPs := func() ([]string, error) { return []string{"10.0.0.1", "10.0.0.2"}, nil }
+
+	testCases := []struct {
+		name      string
+		lb        kubectl.AWSLoadBalancer
+		expectErr bool
+	}{
+		{"RescheduledPodsHealthy", kubectl.AWSLoadBalancer{Name: "rescheduled-nlb", Type: kubectl.NLB, TargetType: kubectl.IPTarget}, false},
+		{"NoTargetsOutsideOldNodes", kubectl.AWSLoadBalancer{Name: "old-only-nlb", Type: kubectl.NLB, TargetType: kubectl.IPTarget}, true},
+		{"NoTargets", kubectl.AWSLoadBalancer{Name: "empty-nlb", Type: kubectl.NLB, TargetType: kubectl.IPTarget}, false},
+		{"InstanceTarget", kubectl.AWSLoadBalancer{Name: "instance-nlb", Type: kubectl.NLB, TargetType: kubectl.InstanceTarget}, false},
+		{"CLB", kubectl.AWSLoadBalancer{Name: "clb", Type: kubectl.CLB, TargetType: kubectl.InstanceTarget}, false},
+	}
+
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			err := waitForPodTargetsHealthyInELBs(
+				elbv2Svc,
+				[]kubectl.AWSLoadBalancer{tc.lb},
+				listPodIPs,
+				AnyELBRegistrationPolicy,
+				2,
+				time.Millisecond,
+			)
+			if tc.expectErr {
+				require.Error(t, err)
+				assert.Contains(t, err.Error(), "old-only-tg")
+			} else {
+				require.NoError(t, err)
+			}
+		})
+	}
+}
+
+func TestDeregisterInstancesFromELBs(t *testing.T) {
+	t.Parallel()
+
+	elbSvc := &fakeELB{
+		instanceStates: map[string][]*elb.InstanceState{
+			"clb": {
+				{InstanceId: aws.String("i-old"), State: aws.String("InService")},
+				{InstanceId: aws.String("i-new"), State: aws.String("InService")},
+			},
+		},
+	}
+	elbv2Svc := &fakeELBV2{
+		targetGroups: map[string][]*elbv2.TargetGroup{
+			"shared-alb": {
+				newFakeTargetGroup("instance-tg", elbv2.TargetTypeEnumInstance),
+				newFakeTargetGroup("ip-tg", elbv2.TargetTypeEnumIp),
+			},
+		},
+		targets: map[string][]*elbv2.TargetHealthDescription{
+			"arn:instance-tg": {
+				newFakeTarget("i-old", elbv2.TargetHealthStateEnumHealthy),
+				newFakeTarget("i-new", elbv2.TargetHealthStateEnumHealthy),
+			},
+			"arn:ip-tg": {newFakeTarget("10.0.0.1", elbv2.TargetHealthStateEnumHealthy)},
+		},
+	}
+	elbs := []kubectl.AWSLoadBalancer{
+		{Name: "clb", Type: kubectl.CLB, TargetType: kubectl.InstanceTarget},
+		{Name: "shared-alb", Type: kubectl.ALB, TargetType: kubectl.InstanceTarget},
+	}
+
+	err := deregisterInstancesFromELBs(elbSvc, elbv2Svc, elbs, []string{"i-old", "i-gone"}, 3, time.Millisecond)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"i-old"}, elbSvc.deregistered)
+	assert.Equal(t, []string{"i-old"}, elbv2Svc.deregistered)
+	assert.Equal(t, clbOutOfServiceState, aws.StringValue(elbSvc.instanceStates["clb"][0].State))
+	assert.Equal(t, elbv2.TargetHealthStateEnumUnused, aws.StringValue(elbv2Svc.targets["arn:instance-tg"][0].TargetHealth.State))
+	assert.Equal(t, elbv2.TargetHealthStateEnumHealthy, aws.StringValue(elbv2Svc.targets["arn:instance-tg"][1].TargetHealth.State))
+}
+
+func TestGetAndRegisterLoadBalancerTargets(t *testing.T) {
+	t.Parallel()
+
+	elbSvc := &fakeELB{
+		instanceStates: map[string][]*elb.InstanceState{
+			"clb": {
+				{InstanceId: aws.String("i-old"), State: aws.String(clbInServiceState)},
+				{InstanceId: aws.String("i-new"), State: aws.String(clbInServiceState)},
+			},
+		},
+	}
+	elbv2Svc := &fakeELBV2{
+		targetGroups: map[string][]*elbv2.TargetGroup{
+			"shared-alb": {
+				newFakeTargetGroup("instance-tg", elbv2.TargetTypeEnumInstance),
+				newFakeTargetGroup("ip-tg", elbv2.TargetTypeEnumIp),
+			},
+		},
+		targets: map[string][]*elbv2.TargetHealthDescription{
+			"arn:instance-tg": {
+				newFakeTarget("i-old", elbv2.TargetHealthStateEnumHealthy),
+				newFakeTarget("i-new", elbv2.TargetHealthStateEnumHealthy),
+			},
+			"arn:ip-tg": {newFakeTarget("10.0.0.1", elbv2.TargetHealthStateEnumHealthy)},
+		},
+	}
+	elbs := []kubectl.AWSLoadBalancer{
+		{Name: "clb", Type: kubectl.CLB, TargetType: kubectl.InstanceTarget},
+		{Name: "shared-alb", Type: kubectl.ALB, TargetType: kubectl.InstanceTarget},
+	}
+
+	targets, err := getLoadBalancerTargets(elbSvc, elbv2Svc, elbs, []string{"i-old"})
+	require.NoError(t, err)
+	assert.Equal(
+		t,
+		[]LoadBalancerTarget{
+			{LoadBalancerName: "clb", InstanceID: "i-old"},
+			{LoadBalancerName: "shared-alb", TargetGroupArn: "arn:instance-tg", InstanceID: "i-old"},
+		},
+		targets,
+	)
+
+	require.NoError(t, deregisterInstancesFromELBs(elbSvc, elbv2Svc, elbs, []string{"i-old"}, 3, time.Millisecond))
+	require.NoError(t, registerLoadBalancerTargets(elbSvc, elbv2Svc, targets, 3, time.Millisecond))
+	assert.Equal(t, []string{"i-old"}, elbSvc.registered)
+	assert.Equal(t, []string{"i-old"}, elbv2Svc.registered)
+	assert.Equal(t, clbInServiceState, aws.StringValue(elbSvc.instanceStates["clb"][0].State))
+	assert.Equal(t, elbv2.TargetHealthStateEnumHealthy, aws.StringValue(elbv2Svc.targets["arn:instance-tg"][0].TargetHealth.State))
+}
@@ -3,6 +3,9 @@ package eks
 import (
 	"fmt"
 	"strings"
+	"time"
+
+	"github.com/gruntwork-io/kubergrunt/kubectl"
 )
 
 // EKSClusterNotReady is returned when the EKS cluster is detected to not be in the ready state
@@ -180,3 +183,433 @@ func (err CouldNotFindLoadBalancerErr) Error() string {
 		err.name,
 	)
 }
+
+// InvalidELBRegistrationPolicyErr is returned when the requested ELB registration policy is not supported.
+type InvalidELBRegistrationPolicyErr struct {
+	policy ELBRegistrationPolicy
+}
+
+func (err InvalidELBRegistrationPolicyErr) Error() string {
+	return fmt.Sprintf(
+		"Invalid ELB registration policy %s: must be any, all, or a percentage between 1%% and 100%% (e.g. 50%%).",
+		err.policy,
+	)
+}
+
+// ELBRegistrationTimeoutErr is returned when not enough of the new instances become healthy in a load balancer in time.
+type ELBRegistrationTimeoutErr struct {
+	description string
+	unhealthy   []string
+}
+
+func (err ELBRegistrationTimeoutErr) Error() string {
+	return fmt.Sprintf(
+		"Timed out waiting for new targets to be healthy in %s. Targets that are still unhealthy: %s",
+		err.description,
+		strings.Join(err.unhealthy, ", "),
+	)
+}
+
+// InvalidRolloutCountErr is returned when the max surge or batch size of a roll out is neither a positive number of
+// instances nor a percentage between 1% and 100%.
+type InvalidRolloutCountErr struct {
+	value string
+}
+
+func (err InvalidRolloutCountErr) Error() string {
+	return fmt.Sprintf(
+		"Invalid value %s: must be a positive number of instances (e.g. 5) or a percentage between 1%% and 100%% (e.g. 25%%).",
+		err.value,
+	)
+}
+
+// InvalidASGRolloutModeErr is returned when the requested ASG roll out mode is not supported.
+type InvalidASGRolloutModeErr struct {
+	mode string
+}
+
+func (err InvalidASGRolloutModeErr) Error() string {
+	return fmt.Sprintf(
+		"Invalid ASG roll out mode %s: must be one of %s.",
+		err.mode,
+		strings.Join(ASGRolloutModes, ", "),
+	)
+}
+
+// RecoveryFileASGMismatchErr is returned when the ASGs recorded in the recovery file do not match the ASGs requested
+// for the roll out.
+type RecoveryFileASGMismatchErr struct {
+	requested []string
+	recorded  []string
+}
+
+func (err RecoveryFileASGMismatchErr) Error() string {
+	return fmt.Sprintf(
+		"The recovery file is for a roll out of ASGs %s, but ASGs %s were requested. Either pass in the same ASGs to resume the roll out, or pass in --ignore-recovery-file to start a new roll out.",
+		strings.Join(err.recorded, ","),
+		strings.Join(err.requested, ","),
+	)
+}
+
+// UnsupportedDeployStateBackendErr is returned when the requested deploy state backend is not supported.
+type UnsupportedDeployStateBackendErr struct {
+	backendType DeployStateBackendType
+}
+
+func (err UnsupportedDeployStateBackendErr) Error() string {
+	return fmt.Sprintf(
+		"Unsupported deploy state backend %s: must be one of %s.",
+		err.backendType,
+		strings.Join(DeployStateBackendTypes, ", "),
+	)
+}
+
+// MissingDeployStateBackendOptionErr is returned when a required option for the selected deploy state backend is not
+// provided.
+type MissingDeployStateBackendOptionErr struct {
+	backendType DeployStateBackendType
+	option      string
+}
+
+func (err MissingDeployStateBackendOptionErr) Error() string {
+	return fmt.Sprintf("The %s deploy state backend requires the %s to be set.", err.backendType, err.option)
+}
+
+// LockHeldErr is returned when the cluster lock is held by someone else.
+type LockHeldErr struct {
+	lockName  string
+	holder    string
+	expiresAt time.Time
+}
+
+func (err LockHeldErr) Error() string {
+	return fmt.Sprintf(
+		"The cluster lock %s is held by %s until %s. Wait for the other operation to finish, or pass in --force-unlock if you are sure %s is no longer running.",
+		err.lockName,
+		err.holder,
+		err.expiresAt.Format(time.RFC3339),
+		err.holder,
+	)
+}
+
+// LockLostErr is returned when the cluster lock was taken over by someone else while it was held.
+type LockLostErr struct {
+	lockName string
+	holder   string
+}
+
+func (err LockLostErr) Error() string {
+	return fmt.Sprintf("The cluster lock %s was taken over by %s.", err.lockName, err.holder)
+}
+
+// NoDeployStateToRollbackErr is returned when there is no recovery state for an interrupted roll out to roll back.
+type NoDeployStateToRollbackErr struct {
+	location string
+}
+
+func (err NoDeployStateToRollbackErr) Error() string {
+	return fmt.Sprintf("Could not find the recovery state of an interrupted roll out in %s, so there is nothing to roll back.", err.location)
+}
+
+// RollbackNotPossibleErr is returned when the interrupted roll out has progressed too far to be rolled back.
+type RollbackNotPossibleErr struct {
+	wave int
+}
+
+func (err RollbackNotPossibleErr) Error() string {
+	return fmt.Sprintf(
+		"Can not roll back: the original instances of wave %d have already been detached from the ASGs. Resume the roll out instead.",
+		err.wave,
+	)
+}
+
+// RollbackInProgressErr is returned when attempting to resume a roll out that is being rolled back.
+type RollbackInProgressErr struct {
+	location string
+}
+
+func (err RollbackInProgressErr) Error() string {
+	return fmt.Sprintf(
+		"The recovery state in %s is for a roll out that is being rolled back. Finish the roll back with `kubergrunt eks deploy rollback`, or pass in --ignore-recovery-file to start a new roll out.",
+		err.location,
+	)
+}
+
+// UnsupportedPlanFormatErr is returned when the requested plan output format is not supported.
+type UnsupportedPlanFormatErr struct {
+	format PlanFormat
+}
+
+func (err UnsupportedPlanFormatErr) Error() string {
+	return fmt.Sprintf("Unsupported plan format %s: must be one of %s.", err.format, strings.Join(PlanFormats, ", "))
+}
+
+// UnsupportedDrainReportFormatErr is returned when the requested drain report format is not supported.
+type UnsupportedDrainReportFormatErr struct {
+	format DrainReportFormat
+}
+
+func (err UnsupportedDrainReportFormatErr) Error() string {
+	return fmt.Sprintf(
+		"Unsupported drain report format %s: must be one of %s.",
+		err.format,
+		strings.Join(DrainReportFormats, ", "),
+	)
+}
+
+// InvalidPDBPreflightModeErr is returned when the requested PodDisruptionBudget preflight mode is not supported.
+type InvalidPDBPreflightModeErr struct {
+	mode PDBPreflightMode
+}
+
+func (err InvalidPDBPreflightModeErr) Error() string {
+	return fmt.Sprintf(
+		"Invalid PodDisruptionBudget preflight mode %s: must be one of %s.",
+		err.mode,
+		strings.Join(PDBPreflightModes, ", "),
+	)
+}
+
+// PDBBlockedEvictionsErr is returned when the PodDisruptionBudget preflight check finds Pods that can never be evicted.
+type PDBBlockedEvictionsErr struct {
+	blocked []kubectl.BlockedEviction
+}
+
+func (err PDBBlockedEvictionsErr) Error() string {
+	blockedStrs := []string{}
+	for _, blocked := range err.blocked {
+		blockedStrs = append(blockedStrs, blocked.String())
+	}
+	return fmt.Sprintf(
+		"Found %d Pods that can never be evicted due to PodDisruptionBudgets:\n\t- %s\nAdjust the PodDisruptionBudgets or scale up the workloads before trying again, or pass in --pdb-preflight=warn to continue anyway.",
+		len(err.blocked),
+		strings.Join(blockedStrs, "\n\t- "),
+	)
+}
+
+// InvalidHealthGateWorkloadErr is returned when a health gate workload is not in the format KIND/NAMESPACE/NAME, or is
+// not a Deployment or StatefulSet.
+type InvalidHealthGateWorkloadErr struct {
+	workload string
+}
+
+func (err InvalidHealthGateWorkloadErr) Error() string {
+	return fmt.Sprintf(
+		"Invalid health gate workload %s: must be in the format KIND/NAMESPACE/NAME, where KIND is one of deployment or statefulset.",
+		err.workload,
+	)
+}
+
+// HealthGateFailedErr is returned when a health gate does not pass during a roll out.
+type HealthGateFailedErr struct {
+	gate          string
+	stage         HealthGateStage
+	underlyingErr error
+}
+
+func (err HealthGateFailedErr) Error() string {
+	return fmt.Sprintf(
+		"Health gate %s failed at stage %s: %s. Fix the application and resume the roll out with the recovery state, or roll it back with `kubergrunt eks deploy rollback`.",
+		err.gate,
+		err.stage,
+		err.underlyingErr,
+	)
+}
+
+// InvalidDeployStrategyErr is returned when the requested deploy strategy is not supported.
+type InvalidDeployStrategyErr struct {
+	strategy DeployStrategy
+}
+
+func (err InvalidDeployStrategyErr) Error() string {
+	return fmt.Sprintf("Invalid deploy strategy %s: must be one of %s.", err.strategy, strings.Join(DeployStrategies, ", "))
+}
+
+// DeployStrategyMismatchErr is returned when resuming a roll out with a different deploy strategy than the one recorded
+// in the recovery state.
+type DeployStrategyMismatchErr struct {
+	requested DeployStrategy
+	recorded  DeployStrategy
+}
+
+func (err DeployStrategyMismatchErr) Error() string {
+	return fmt.Sprintf(
+		"The recovery state is for a roll out with the %s strategy, but the %s strategy was requested. Resume with --strategy=%s, or pass in --ignore-recovery-file to start a new roll out.",
+		err.recorded,
+		err.requested,
+		err.recorded,
+	)
+}
+
+// InstanceRefreshFailedErr is returned when an Instance Refresh does not complete successfully.
+type InstanceRefreshFailedErr struct {
+	asgName   string
+	refreshID string
+	status    string
+	reason    string
+}
+
+func (err InstanceRefreshFailedErr) Error() string {
+	return fmt.Sprintf("Instance Refresh %s of ASG %s is %s: %s", err.refreshID, err.asgName, err.status, err.reason)
+}
+
+// InstanceRefreshTimeoutErr is returned when Instance Refreshes are still in progress or cancelling after the maximum
+// number of retries.
+type InstanceRefreshTimeoutErr struct {
+	refreshes []string
+}
+
+func (err InstanceRefreshTimeoutErr) Error() string {
+	return fmt.Sprintf(
+		"Timed out waiting for Instance Refreshes: %s. Resume with the recovery file, or increase --max-retries.",
+		strings.Join(err.refreshes, ", "),
+	)
+}
+
+// NodegroupWithoutLaunchTemplateErr is returned when requesting a launch template version bump on a managed node group
+// that does not use a launch template.
+type NodegroupWithoutLaunchTemplateErr struct {
+	nodegroupName string
+}
+
+func (err NodegroupWithoutLaunchTemplateErr) Error() string {
+	return fmt.Sprintf("Node group %s does not use a launch template, so its launch template version can not be bumped.", err.nodegroupName)
+}
+
+// NodegroupUpdateFailedErr is returned when the update of a managed node group does not complete successfully.
+type NodegroupUpdateFailedErr struct {
+	report       NodegroupUpdateReport
+	updateErrors []string
+}
+
+func (err NodegroupUpdateFailedErr) Error() string {
+	msg := fmt.Sprintf("Update %s of node group %s is %s", err.report.UpdateID, err.report.NodegroupName, err.report.Status)
+	if len(err.updateErrors) > 0 {
+		msg = fmt.Sprintf("%s:\n\t- %s", msg, strings.Join(err.updateErrors, "\n\t- "))
+	}
+	if len(err.report.PDBFailures) > 0 {
+		msg += "\nAdjust the PodDisruptionBudgets that block the eviction, or pass in --nodegroup-force to update the node group regardless."
+	}
+	return msg
+}
+
+// AddonNotInstalledErr is returned when a core component is expected to be managed as an EKS add-on, but the add-on is
+// not installed on the cluster.
+type AddonNotInstalledErr struct {
+	addonName string
+}
+
+func (err AddonNotInstalledErr) Error() string {
+	return fmt.Sprintf("The %s add-on is not installed on the cluster. Run kubergrunt eks migrate-to-addons to adopt the self managed component as an EKS add-on first.", err.addonName)
+}
+
+// NoCompatibleAddonVersionErr is returned when there is no version of an EKS add-on that is compatible with the
+// Kubernetes version of the cluster.
+type NoCompatibleAddonVersionErr struct {
+	addonName  string
+	k8sVersion string
+}
+
+func (err NoCompatibleAddonVersionErr) Error() string {
+	return fmt.Sprintf("Could not find a version of the %s add-on that is compatible with Kubernetes version %s.", err.addonName, err.k8sVersion)
+}
+
+// AddonDowngradeErr is returned when all the versions of an EKS add-on that are compatible with the Kubernetes version
+// of the cluster are older than the version of the component running on the cluster.
+type AddonDowngradeErr struct {
+	addonName      string
+	k8sVersion     string
+	currentVersion string
+	latestVersion  string
+	option         string
+}
+
+func (err AddonDowngradeErr) Error() string {
+	return fmt.Sprintf(
+		"The latest version of the %s add-on that is compatible with Kubernetes version %s (%s) is older than the current version %s. Pin the add-on version with --%s to use it anyway.",
+		err.addonName,
+		err.k8sVersion,
+		err.latestVersion,
+		err.currentVersion,
+		err.option,
+	)
+}
+
+// UnknownComponentVersionErr is returned when the version of a self managed core component can not be determined from
+// the tag of its image.
+type UnknownComponentVersionErr struct {
+	addonName string
+	image     string
+	option    string
+}
+
+func (err UnknownComponentVersionErr) Error() string {
+	return fmt.Sprintf(
+		"Could not determine the version of %s from its image %s. Pin the add-on version with --%s instead.",
+		err.addonName,
+		err.image,
+		err.option,
+	)
+}
+
+// AddonFailedErr is returned when the creation or update of an EKS add-on fails.
+type AddonFailedErr struct {
+	addonName string
+	status    string
+	issues    []string
+}
+
+func (err AddonFailedErr) Error() string {
+	msg := fmt.Sprintf("The %s add-on is %s", err.addonName, err.status)
+	if len(err.issues) > 0 {
+		msg = fmt.Sprintf("%s:\n\t- %s", msg, strings.Join(err.issues, "\n\t- "))
+	}
+	return msg
+}
+
+// InvalidVersionCatalogErr is returned when a version catalog file can not be parsed, or does not pass validation.
+type InvalidVersionCatalogErr struct {
+	path     string
+	problems []string
+}
+
+func (err InvalidVersionCatalogErr) Error() string {
+	return fmt.Sprintf("Invalid version catalog %s:\n\t- %s", err.path, strings.Join(err.problems, "\n\t- "))
+}
+
+// NoCoreComponentsSnapshotsErr is returned when there are no snapshots of the core components to roll back to.
+type NoCoreComponentsSnapshotsErr struct {
+	location string
+}
+
+func (err NoCoreComponentsSnapshotsErr) Error() string {
+	return fmt.Sprintf("Could not find any snapshots of the core components in %s. Snapshots are taken by kubergrunt eks sync-core-components.", err.location)
+}
+
+// CoreComponentsSnapshotNotFoundErr is returned when the requested snapshot of the core components does not exist.
+type CoreComponentsSnapshotNotFoundErr struct {
+	id        string
+	location  string
+	available []string
+}
+
+func (err CoreComponentsSnapshotNotFoundErr) Error() string {
+	return fmt.Sprintf(
+		"Could not find snapshot %s of the core components in %s. Available snapshots: [%s]",
+		err.id,
+		err.location,
+		strings.Join(err.available, ", "),
+	)
+}
+
+// CoreComponentsSnapshotClusterMismatchErr is returned when the requested snapshot of the core components was taken
+// from a different cluster than the one being rolled back.
+type CoreComponentsSnapshotClusterMismatchErr struct {
+	id                 string
+	snapshotClusterArn string
+	eksClusterArn      string
+}
+
+func (err CoreComponentsSnapshotClusterMismatchErr) Error() string {
+	return fmt.Sprintf("Snapshot %s of the core components was taken from cluster %s, not %s.", err.id, err.snapshotClusterArn, err.eksClusterArn)
+}
@@ -0,0 +1,206 @@
+package eks
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gruntwork-io/go-commons/errors"
+	"github.com/gruntwork-io/go-commons/retry"
+	"github.com/gruntwork-io/go-commons/shell"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+
+	"github.com/gruntwork-io/kubergrunt/kubectl"
+	"github.com/gruntwork-io/kubergrunt/logging"
+)
+
+// HealthGateStage identifies the point in the roll out where the health gates are checked.
+type HealthGateStage string
+
+const (
+	// AfterWaitForNodesStage is checked once the new nodes of a wave are ready, before any old node is cordoned.
+	AfterWaitForNodesStage HealthGateStage = "after-wait-for-nodes"
+	// AfterDrainNodesStage is checked once the old nodes of a wave are drained, before they are terminated.
+	AfterDrainNodesStage HealthGateStage = "after-drain-nodes"
+)
+
+const (
+	deploymentWorkloadKind  = "deployment"
+	statefulSetWorkloadKind = "statefulset"
+)
+
+// HealthGatesConfig declares the application health checks that must pass between the stages of a roll out.
+type HealthGatesConfig struct {
+	// Commands are shell commands (run with `sh -c`) that must exit with 0.
+	Commands []string
+	// Workloads are Deployments or StatefulSets that must be fully available, in the format KIND/NAMESPACE/NAME (e.g
+	// deployment/default/web).
+	Workloads []string
+	// URLs are HTTP endpoints that must return a 2xx status code to a GET request.
+	URLs []string
+}
+
+// IsEmpty returns true if no health gates are declared.
+func (config HealthGatesConfig) IsEmpty() bool {
+	return len(config.Commands) == 0 && len(config.Workloads) == 0 && len(config.URLs) == 0
+}
+
+// HealthGate is a single application health check. Check returns an error when the gate is not passing.
+type HealthGate interface {
+	Check() error
+	String() string
+}
+
+// CommandHealthGate passes when the shell command exits with 0.
+type CommandHealthGate struct {
+	Command string
+}
+
+func (gate CommandHealthGate) Check() error {
+	return shell.RunShellCommand(shell.NewShellOptions(), "sh", "-c", gate.Command)
+}
+
+func (gate CommandHealthGate) String() string {
+	return fmt.Sprintf("command %q", gate.Command)
+}
+
+// WorkloadHealthGate passes when all the replicas of the Deployment or StatefulSet are updated and available.
+type WorkloadHealthGate struct {
+	Clientset kubernetes.Interface
+	Kind      string
+	Namespace string
+	Name      string
+}
+
+func (gate WorkloadHealthGate) Check() error {
+	isAvailable := false
+	switch gate.Kind {
+	case deploymentWorkloadKind:
+		deployment, err := gate.Clientset.AppsV1().Deployments(gate.Namespace).Get(context.Background(), gate.Name, metav1.GetOptions{})
+		if err != nil {
+			return errors.WithStackTrace(err)
+		}
+		isAvailable = kubectl.IsDeploymentAvailable(*deployment)
+	case statefulSetWorkloadKind:
+		statefulSet, err := gate.Clientset.AppsV1().StatefulSets(gate.Namespace).Get(context.Background(), gate.Name, metav1.GetOptions{})
+		if err != nil {
+			return errors.WithStackTrace(err)
+		}
+		isAvailable = kubectl.IsStatefulSetAvailable(*statefulSet)
+	default:
+		return retry.FatalError{Underlying: InvalidHealthGateWorkloadErr{workload: gate.String()}}
+	}
+	if !isAvailable {
+		return fmt.Errorf("%s is not fully available yet", gate)
+	}
+	return nil
+}
+
+func (gate WorkloadHealthGate) String() string {
+	return fmt.Sprintf("%s/%s/%s", gate.Kind, gate.Namespace, gate.Name)
+}
+
+// HTTPHealthGate passes when a GET request to the URL returns a 2xx status code.
+type HTTPHealthGate struct {
+	URL    string
+	Client *http.Client
+}
+
+func (gate HTTPHealthGate) Check() error {
+	client := gate.Client
+	if client == nil {
+		client = &http.Client{Timeout: 30 * time.Second}
+	}
+	resp, err := client.Get(gate.URL)
+	if err != nil {
+		return errors.WithStackTrace(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("%s returned status code %d", gate.URL, resp.StatusCode)
+	}
+	return nil
+}
+
+func (gate HTTPHealthGate) String() string {
+	return fmt.Sprintf("HTTP endpoint %s", gate.URL)
+}
+
+// NewHealthGates constructs the health gates declared in the provided config. Returns an empty list if no gates are
+// declared, without connecting to the cluster.
+func NewHealthGates(config HealthGatesConfig, kubectlOptions *kubectl.KubectlOptions) ([]HealthGate, error) {
+	gates := []HealthGate{}
+	if config.IsEmpty() {
+		return gates, nil
+	}
+
+	for _, command := range config.Commands {
+		gates = append(gates, CommandHealthGate{Command: command})
+	}
+
+	if len(config.Workloads) > 0 {
+		clientset, err := kubectl.GetKubernetesClientFromOptions(kubectlOptions)
+		if err != nil {
+			return nil, err
+		}
+		for _, workload := range config.Workloads {
+			gate, err := parseWorkloadHealthGate(clientset, workload)
+			if err != nil {
+				return nil, err
+			}
+			gates = append(gates, gate)
+		}
+	}
+
+	for _, url := range config.URLs {
+		gates = append(gates, HTTPHealthGate{URL: url})
+	}
+	return gates, nil
+}
+
+// parseWorkloadHealthGate parses a workload in the format KIND/NAMESPACE/NAME into a WorkloadHealthGate.
+func parseWorkloadHealthGate(clientset kubernetes.Interface, workload string) (WorkloadHealthGate, error) {
+	parts := strings.Split(workload, "/")
+	if len(parts) != 3 || parts[1] == "" || parts[2] == "" {
+		return WorkloadHealthGate{}, errors.WithStackTrace(InvalidHealthGateWorkloadErr{workload: workload})
+	}
+	kind := strings.ToLower(parts[0])
+	if kind != deploymentWorkloadKind && kind != statefulSetWorkloadKind {
+		return WorkloadHealthGate{}, errors.WithStackTrace(InvalidHealthGateWorkloadErr{workload: workload})
+	}
+	return WorkloadHealthGate{Clientset: clientset, Kind: kind, Namespace: parts[1], Name: parts[2]}, nil
+}
+
+// checkHealthGates checks each gate in turn, retrying each one up to maxRetries times with sleepBetweenRetries between
+// attempts so that the application has time to settle. Returns a HealthGateFailedErr naming the first gate that does not
+// pass.
+func checkHealthGates(gates []HealthGate, stage HealthGateStage, maxRetries int, sleepBetweenRetries time.Duration) error {
+	logger := logging.GetProjectLogger()
+	for _, gate := range gates {
+		logger.Infof("Checking health gate %s (%s)", gate, stage)
+		// Keep track of the last error so that the failure reports why the gate did not pass, and not just that it ran
+		// out of retries.
+		var lastErr error
+		err := retry.DoWithRetry(
+			logger.Logger,
+			fmt.Sprintf("check health gate %s", gate),
+			maxRetries,
+			sleepBetweenRetries,
+			func() error {
+				lastErr = gate.Check()
+				return lastErr
+			},
+		)
+		if err != nil {
+			if fatalErr, isFatalErr := err.(retry.FatalError); isFatalErr {
+				lastErr = fatalErr.Underlying
+			}
+			return errors.WithStackTrace(HealthGateFailedErr{gate: gate.String(), stage: stage, underlyingErr: lastErr})
+		}
+		logger.Infof("Health gate %s passed", gate)
+	}
+	return nil
+}
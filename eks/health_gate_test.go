@@ -0,0 +1,139 @@
+package eks
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gruntwork-io/go-commons/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	appsv1 "k8s.io/api/apps/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestWorkloadHealthGate(t *testing.T) {
+	t.Parallel()
+
+	three := int32(3)
+	clientset := fake.NewSimpleClientset(
+		&appsv1.Deployment{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "available", Generation: 2},
+			Spec:       appsv1.DeploymentSpec{Replicas: &three},
+			Status:     appsv1.DeploymentStatus{ObservedGeneration: 2, Replicas: 3, UpdatedReplicas: 3, AvailableReplicas: 3},
+		},
+		&appsv1.Deployment{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "rolling", Generation: 2},
+			Spec:       appsv1.DeploymentSpec{Replicas: &three},
+			Status:     appsv1.DeploymentStatus{ObservedGeneration: 2, Replicas: 4, UpdatedReplicas: 2, AvailableReplicas: 3},
+		},
+		&appsv1.StatefulSet{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "available", Generation: 1},
+			Spec:       appsv1.StatefulSetSpec{Replicas: &three},
+			Status:     appsv1.StatefulSetStatus{ObservedGeneration: 1, Replicas: 3, UpdatedReplicas: 3, ReadyReplicas: 3, AvailableReplicas: 3},
+		},
+		&appsv1.StatefulSet{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "unready", Generation: 1},
+			Spec:       appsv1.StatefulSetSpec{Replicas: &three},
+			Status:     appsv1.StatefulSetStatus{ObservedGeneration: 1, Replicas: 3, UpdatedReplicas: 3, ReadyReplicas: 2, AvailableReplicas: 2},
+		},
+	)
+
+	testCases := []struct {
+		workload  string
+		expectErr bool
+	}{
+		{"deployment/default/available", false},
+		{"Deployment/default/available", false},
+		{"deployment/default/rolling", true},
+		{"statefulset/default/available", false},
+		{"statefulset/default/unready", true},
+		{"deployment/default/missing", true},
+	}
+
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.workload, func(t *testing.T) {
+			t.Parallel()
+
+			gate, err := parseWorkloadHealthGate(clientset, tc.workload)
+			require.NoError(t, err)
+			err = gate.Check()
+			if tc.expectErr {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
+
+func TestParseWorkloadHealthGateRejectsInvalidWorkloads(t *testing.T) {
+	t.Parallel()
+
+	for _, workload := range []string{"web", "deployment/web", "daemonset/default/web", "deployment//web"} {
+		_, err := parseWorkloadHealthGate(fake.NewSimpleClientset(), workload)
+		require.Error(t, err)
+		_, isInvalidWorkloadErr := errors.Unwrap(err).(InvalidHealthGateWorkloadErr)
+		assert.True(t, isInvalidWorkloadErr, workload)
+	}
+}
+
+func TestHTTPHealthGate(t *testing.T) {
+	t.Parallel()
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/healthy" {
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	t.Cleanup(server.Close)
+
+	assert.NoError(t, HTTPHealthGate{URL: server.URL + "/healthy"}.Check())
+	assert.Error(t, HTTPHealthGate{URL: server.URL + "/unhealthy"}.Check())
+}
+
+func TestCommandHealthGate(t *testing.T) {
+	t.Parallel()
+
+	assert.NoError(t, CommandHealthGate{Command: "exit 0"}.Check())
+	assert.Error(t, CommandHealthGate{Command: "exit 1"}.Check())
+}
+
+func TestFailingHealthGateKeepsDeployState(t *testing.T) {
+	t.Parallel()
+
+	state := newTestMultiASGDeployState(t, ParallelASGRollout)
+	defer state.delete()
+
+	hasWave, err := state.startWave()
+	require.NoError(t, err)
+	require.True(t, hasWave)
+	state.ScaleUpDone = true
+	state.WaitForNodesDone = true
+	require.NoError(t, state.persist())
+
+	gates := []HealthGate{CommandHealthGate{Command: "exit 0"}, CommandHealthGate{Command: "exit 1"}}
+	err = state.checkNodesHealthGates(gates)
+	require.Error(t, err)
+	healthGateErr, isHealthGateErr := errors.Unwrap(err).(HealthGateFailedErr)
+	require.True(t, isHealthGateErr)
+	assert.Equal(t, `command "exit 1"`, healthGateErr.gate)
+	assert.Equal(t, AfterWaitForNodesStage, healthGateErr.stage)
+	assert.False(t, state.NodesHealthGatesDone)
+
+	// The recovery state records the progress up to the failing gate, so that resuming checks the gate again.
+	data, err := state.backend.Load()
+	require.NoError(t, err)
+	var storedState DeployState
+	require.NoError(t, json.Unmarshal(data, &storedState))
+	assert.True(t, storedState.WaitForNodesDone)
+	assert.False(t, storedState.NodesHealthGatesDone)
+
+	require.NoError(t, state.checkNodesHealthGates(gates[:1]))
+	assert.True(t, state.NodesHealthGatesDone)
+}
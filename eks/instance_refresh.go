@@ -0,0 +1,426 @@
+package eks
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/autoscaling"
+	"github.com/aws/aws-sdk-go/service/autoscaling/autoscalingiface"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/gruntwork-io/go-commons/collections"
+	"github.com/gruntwork-io/go-commons/errors"
+
+	"github.com/gruntwork-io/kubergrunt/kubectl"
+	"github.com/gruntwork-io/kubergrunt/logging"
+)
+
+// DeployStrategy determines how the instances of the ASGs are replaced during a roll out.
+type DeployStrategy string
+
+const (
+	// SurgeDeployStrategy launches the replacement instances by scaling up the ASGs, and then drains and detaches the
+	// original instances, in waves.
+	SurgeDeployStrategy DeployStrategy = "surge"
+	// InstanceRefreshDeployStrategy drives the native Instance Refresh of the ASGs, and drains each original instance
+	// before it is terminated using a lifecycle hook.
+	InstanceRefreshDeployStrategy DeployStrategy = "instance-refresh"
+)
+
+// DeployStrategies lists all the supported DeployStrategy values.
+var DeployStrategies = []string{string(SurgeDeployStrategy), string(InstanceRefreshDeployStrategy)}
+
+// validateDeployStrategy returns an error if the strategy is not supported.
+func validateDeployStrategy(strategy DeployStrategy) error {
+	if !collections.ListContainsElement(DeployStrategies, string(strategy)) {
+		return errors.WithStackTrace(InvalidDeployStrategyErr{strategy: strategy})
+	}
+	return nil
+}
+
+// InstanceRefreshLifecycleHookName is the name of the lifecycle hook kubergrunt adds to the ASGs during an Instance
+// Refresh, to hold each instance in the Terminating:Wait state until its node is drained.
+const InstanceRefreshLifecycleHookName = "kubergrunt-drain"
+
+// maxLifecycleHookHeartbeatTimeout is the longest heartbeat timeout supported by AWS for lifecycle hooks.
+const maxLifecycleHookHeartbeatTimeout = 2 * time.Hour
+
+// InstanceRefreshOptions configures the Instance Refresh started for each ASG. Zero values fall back to the defaults of
+// the ASG.
+type InstanceRefreshOptions struct {
+	// MinHealthyPercentage is the percentage of the desired capacity that must remain healthy during the refresh.
+	MinHealthyPercentage int64
+	// MaxHealthyPercentage is the percentage of the desired capacity the ASG can grow to during the refresh. Values
+	// above 100 launch the replacement instances before terminating the original ones.
+	MaxHealthyPercentage int64
+	// InstanceWarmup is how long to wait after a new instance is healthy before moving on to the next instance.
+	InstanceWarmup time.Duration
+	// CheckpointPercentages are the percentages of the refresh at which to pause for CheckpointDelay.
+	CheckpointPercentages []int64
+	CheckpointDelay       time.Duration
+}
+
+// refreshPreferences converts the options into the preferences of the Instance Refresh API.
+func (options InstanceRefreshOptions) refreshPreferences() *autoscaling.RefreshPreferences {
+	preferences := &autoscaling.RefreshPreferences{}
+	if options.MinHealthyPercentage > 0 {
+		preferences.MinHealthyPercentage = aws.Int64(options.MinHealthyPercentage)
+	}
+	if options.MaxHealthyPercentage > 0 {
+		preferences.MaxHealthyPercentage = aws.Int64(options.MaxHealthyPercentage)
+	}
+	if options.InstanceWarmup > 0 {
+		preferences.InstanceWarmup = aws.Int64(int64(options.InstanceWarmup.Seconds()))
+	}
+	if len(options.CheckpointPercentages) > 0 {
+		preferences.CheckpointPercentages = aws.Int64Slice(options.CheckpointPercentages)
+		if options.CheckpointDelay > 0 {
+			preferences.CheckpointDelay = aws.Int64(int64(options.CheckpointDelay.Seconds()))
+		}
+	}
+	return preferences
+}
+
+// putDrainLifecycleHooks adds the lifecycle hook that holds terminating instances until they are drained to all the
+// ASGs.
+func (state *DeployState) putDrainLifecycleHooks(asgSvc autoscalingiface.AutoScalingAPI, drainTimeout time.Duration) error {
+	if state.PutLifecycleHooksDone {
+		state.logger.Debug("Lifecycle hooks already added - skipping")
+		return nil
+	}
+	for _, asg := range state.ASGs {
+		err := putDrainLifecycleHook(asgSvc, asg.Name, drainLifecycleHookHeartbeatTimeout(drainTimeout))
+		if err != nil {
+			return err
+		}
+	}
+	state.PutLifecycleHooksDone = true
+	return state.persist()
+}
+
+// startInstanceRefreshes starts an Instance Refresh on each active ASG that doesn't have one yet.
+func (state *DeployState) startInstanceRefreshes(asgSvc autoscalingiface.AutoScalingAPI, options InstanceRefreshOptions) error {
+	for _, asg := range state.activeASGs() {
+		if asg.InstanceRefreshID != "" {
+			state.logger.Debugf("Instance Refresh %s already started on ASG %s - skipping", asg.InstanceRefreshID, asg.Name)
+			continue
+		}
+		refreshID, err := startInstanceRefresh(asgSvc, asg.Name, options)
+		if err != nil {
+			return err
+		}
+		asg.InstanceRefreshID = refreshID
+		if err := state.persist(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// waitForInstanceRefreshes tracks the Instance Refresh of each active ASG until they all succeed. Along the way, the
+// instances that the refresh is about to terminate are drained with drainInstances, checked against the health gates,
+// and then released to the refresh by completing their lifecycle action. Returns an error if any of the refreshes
+// fail or are cancelled, or if they are still in progress after maxRetries checks.
+func (state *DeployState) waitForInstanceRefreshes(
+	asgSvc autoscalingiface.AutoScalingAPI,
+	drainInstances func(instanceIds []string) error,
+	healthGates []HealthGate,
+) error {
+	for retries := 1; ; retries++ {
+		pending := []string{}
+		for _, asg := range state.activeASGs() {
+			// With sequential roll outs, the next ASG becomes active once the previous refresh succeeds. Skip it here, so
+			// that its refresh is started by the caller once this returns.
+			if asg.InstanceRefreshID == "" {
+				continue
+			}
+			err := state.drainTerminatingInstances(asgSvc, asg, drainInstances, healthGates)
+			if err != nil {
+				return err
+			}
+
+			refresh, err := getInstanceRefresh(asgSvc, asg.Name, asg.InstanceRefreshID)
+			if err != nil {
+				return err
+			}
+			status := aws.StringValue(refresh.Status)
+			switch status {
+			case autoscaling.InstanceRefreshStatusSuccessful:
+				state.logger.Infof("Instance Refresh %s of ASG %s completed successfully", asg.InstanceRefreshID, asg.Name)
+				asg.RolloutDone = true
+				if err := state.persist(); err != nil {
+					return err
+				}
+			case autoscaling.InstanceRefreshStatusPending, autoscaling.InstanceRefreshStatusInProgress:
+				state.logger.Infof(
+					"Instance Refresh %s of ASG %s is %s (%d%% complete)",
+					asg.InstanceRefreshID,
+					asg.Name,
+					status,
+					aws.Int64Value(refresh.PercentageComplete),
+				)
+				pending = append(pending, formatInstanceRefresh(asg, status))
+			default:
+				state.logger.Errorf("Instance Refresh %s of ASG %s did not succeed.", asg.InstanceRefreshID, asg.Name)
+				state.logger.Errorf("Either resume with the recovery file to start a new Instance Refresh, or cancel the roll out with `kubergrunt eks deploy rollback`.")
+				// Forget the refresh so that resuming starts a new one.
+				refreshID := asg.InstanceRefreshID
+				asg.InstanceRefreshID = ""
+				if err := state.persist(); err != nil {
+					return err
+				}
+				return errors.WithStackTrace(InstanceRefreshFailedErr{
+					asgName:   asg.Name,
+					refreshID: refreshID,
+					status:    status,
+					reason:    aws.StringValue(refresh.StatusReason),
+				})
+			}
+		}
+		if len(pending) == 0 {
+			return nil
+		}
+		if retries >= state.maxRetries {
+			return errors.WithStackTrace(InstanceRefreshTimeoutErr{refreshes: pending})
+		}
+		time.Sleep(state.sleepBetweenRetries)
+	}
+}
+
+// drainTerminatingInstances drains the instances of the ASG that are held in the Terminating:Wait state by the lifecycle
+// hook, and then completes their lifecycle action so that the Instance Refresh can terminate them.
+func (state *DeployState) drainTerminatingInstances(
+	asgSvc autoscalingiface.AutoScalingAPI,
+	asg *ASG,
+	drainInstances func(instanceIds []string) error,
+	healthGates []HealthGate,
+) error {
+	waitingInstances, err := getInstancesWaitingForTermination(asgSvc, asg.Name)
+	if err != nil {
+		return err
+	}
+	if len(waitingInstances) == 0 {
+		return nil
+	}
+
+	// Instances that were drained but whose lifecycle action was not completed (e.g because the command was interrupted)
+	// are drained again, which is a no-op for the already evicted Pods.
+	state.logger.Infof("Draining instances of ASG %s before they are terminated by the Instance Refresh: %v", asg.Name, waitingInstances)
+	err = drainInstances(waitingInstances)
+	if err != nil {
+		state.logger.Errorf("Error while draining nodes.")
+		state.logger.Errorf("Either resume with the recovery file, or drain the nodes manually. The instances are terminated when the lifecycle hook times out.")
+		return err
+	}
+	err = checkHealthGates(healthGates, AfterDrainNodesStage, state.maxRetries, state.sleepBetweenRetries)
+	if err != nil {
+		return err
+	}
+	err = completeDrainLifecycleActions(asgSvc, asg.Name, waitingInstances)
+	if err != nil {
+		return err
+	}
+	for _, instanceID := range waitingInstances {
+		if !collections.ListContainsElement(asg.DrainedInstances, instanceID) {
+			asg.DrainedInstances = append(asg.DrainedInstances, instanceID)
+		}
+	}
+	return state.persist()
+}
+
+// deleteDrainLifecycleHooks removes the lifecycle hook added by putDrainLifecycleHooks from all the ASGs.
+func (state *DeployState) deleteDrainLifecycleHooks(asgSvc autoscalingiface.AutoScalingAPI) error {
+	if state.DeleteLifecycleHooksDone {
+		state.logger.Debug("Lifecycle hooks already removed - skipping")
+		return nil
+	}
+	for _, asg := range state.ASGs {
+		err := deleteDrainLifecycleHook(asgSvc, asg.Name)
+		if err != nil {
+			return err
+		}
+	}
+	state.DeleteLifecycleHooksDone = true
+	return state.persist()
+}
+
+// cancelInstanceRefreshes cancels the in progress Instance Refreshes. Instances that are already held by the
+// lifecycle hook are drained and released, since they are terminated regardless of the cancellation. Instances that
+// were already replaced keep running with the new launch configuration. Returns an error if a refresh is still
+// cancelling after maxRetries checks.
+func (state *DeployState) cancelInstanceRefreshes(
+	asgSvc autoscalingiface.AutoScalingAPI,
+	drainInstances func(instanceIds []string) error,
+) error {
+	for i := range state.ASGs {
+		asg := &state.ASGs[i]
+		if asg.RolloutDone || asg.InstanceRefreshID == "" {
+			continue
+		}
+		state.logger.Infof("Cancelling Instance Refresh %s of ASG %s", asg.InstanceRefreshID, asg.Name)
+		err := cancelInstanceRefresh(asgSvc, asg.Name)
+		if err != nil {
+			return err
+		}
+
+		isCancelled := false
+		for retries := 1; !isCancelled; retries++ {
+			err := state.drainTerminatingInstances(asgSvc, asg, drainInstances, nil)
+			if err != nil {
+				return err
+			}
+			refresh, err := getInstanceRefresh(asgSvc, asg.Name, asg.InstanceRefreshID)
+			if err != nil {
+				return err
+			}
+			status := aws.StringValue(refresh.Status)
+			if status != autoscaling.InstanceRefreshStatusCancelling {
+				state.logger.Infof("Instance Refresh %s of ASG %s is %s", asg.InstanceRefreshID, asg.Name, status)
+				isCancelled = true
+			} else if retries >= state.maxRetries {
+				return errors.WithStackTrace(InstanceRefreshTimeoutErr{refreshes: []string{formatInstanceRefresh(asg, status)}})
+			} else {
+				time.Sleep(state.sleepBetweenRetries)
+			}
+		}
+		asg.InstanceRefreshID = ""
+		if err := state.persist(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// formatInstanceRefresh returns a human readable description of the Instance Refresh of the ASG and its status.
+func formatInstanceRefresh(asg *ASG, status string) string {
+	return fmt.Sprintf("%s of ASG %s (%s)", asg.InstanceRefreshID, asg.Name, status)
+}
+
+// newInstanceDrainer returns a function that cordons and drains the nodes of the provided instances, for draining the
+// instances held by the lifecycle hook.
+func newInstanceDrainer(
+	ec2Svc *ec2.EC2,
+	kubectlOptions *kubectl.KubectlOptions,
+	drainOptions kubectl.DrainOptions,
+	reporter *drainReporter,
+) func(instanceIds []string) error {
+	return func(instanceIds []string) error {
+		if err := cordonNodesInAsg(ec2Svc, kubectlOptions, instanceIds); err != nil {
+			return err
+		}
+		return drainNodesInAsg(ec2Svc, kubectlOptions, instanceIds, drainOptions, reporter)
+	}
+}
+
+// drainLifecycleHookHeartbeatTimeout returns how long the lifecycle hook holds an instance before it is terminated
+// anyway. This leaves some buffer on top of the drain timeout for the health gates, capped at the AWS maximum.
+func drainLifecycleHookHeartbeatTimeout(drainTimeout time.Duration) time.Duration {
+	if drainTimeout == 0 || drainTimeout+15*time.Minute > maxLifecycleHookHeartbeatTimeout {
+		return maxLifecycleHookHeartbeatTimeout
+	}
+	return drainTimeout + 15*time.Minute
+}
+
+// putDrainLifecycleHook adds the termination lifecycle hook to the ASG. If kubergrunt stops before the node is drained,
+// the instance is terminated once the heartbeat timeout expires.
+func putDrainLifecycleHook(asgSvc autoscalingiface.AutoScalingAPI, asgName string, heartbeatTimeout time.Duration) error {
+	logger := logging.GetProjectLogger()
+	logger.Infof("Adding lifecycle hook %s to ASG %s", InstanceRefreshLifecycleHookName, asgName)
+	_, err := asgSvc.PutLifecycleHook(&autoscaling.PutLifecycleHookInput{
+		AutoScalingGroupName: aws.String(asgName),
+		LifecycleHookName:    aws.String(InstanceRefreshLifecycleHookName),
+		LifecycleTransition:  aws.String("autoscaling:EC2_INSTANCE_TERMINATING"),
+		HeartbeatTimeout:     aws.Int64(int64(heartbeatTimeout.Seconds())),
+		DefaultResult:        aws.String("CONTINUE"),
+	})
+	return errors.WithStackTrace(err)
+}
+
+// deleteDrainLifecycleHook removes the termination lifecycle hook from the ASG.
+func deleteDrainLifecycleHook(asgSvc autoscalingiface.AutoScalingAPI, asgName string) error {
+	logger := logging.GetProjectLogger()
+	logger.Infof("Removing lifecycle hook %s from ASG %s", InstanceRefreshLifecycleHookName, asgName)
+	_, err := asgSvc.DeleteLifecycleHook(&autoscaling.DeleteLifecycleHookInput{
+		AutoScalingGroupName: aws.String(asgName),
+		LifecycleHookName:    aws.String(InstanceRefreshLifecycleHookName),
+	})
+	return errors.WithStackTrace(err)
+}
+
+// startInstanceRefresh starts an Instance Refresh on the ASG, returning the ID of the refresh.
+func startInstanceRefresh(asgSvc autoscalingiface.AutoScalingAPI, asgName string, options InstanceRefreshOptions) (string, error) {
+	logger := logging.GetProjectLogger()
+	output, err := asgSvc.StartInstanceRefresh(&autoscaling.StartInstanceRefreshInput{
+		AutoScalingGroupName: aws.String(asgName),
+		Preferences:          options.refreshPreferences(),
+	})
+	if err != nil {
+		return "", errors.WithStackTrace(err)
+	}
+	refreshID := aws.StringValue(output.InstanceRefreshId)
+	logger.Infof("Started Instance Refresh %s on ASG %s", refreshID, asgName)
+	return refreshID, nil
+}
+
+// getInstanceRefresh looks up the Instance Refresh with the given ID on the ASG.
+func getInstanceRefresh(asgSvc autoscalingiface.AutoScalingAPI, asgName string, refreshID string) (*autoscaling.InstanceRefresh, error) {
+	output, err := asgSvc.DescribeInstanceRefreshes(&autoscaling.DescribeInstanceRefreshesInput{
+		AutoScalingGroupName: aws.String(asgName),
+		InstanceRefreshIds:   aws.StringSlice([]string{refreshID}),
+	})
+	if err != nil {
+		return nil, errors.WithStackTrace(err)
+	}
+	if len(output.InstanceRefreshes) == 0 {
+		return nil, errors.WithStackTrace(NewLookupError("Instance Refresh", refreshID, "status"))
+	}
+	return output.InstanceRefreshes[0], nil
+}
+
+// getInstancesWaitingForTermination returns the IDs of the instances in the ASG that are held in the Terminating:Wait
+// state by a lifecycle hook.
+func getInstancesWaitingForTermination(asgSvc autoscalingiface.AutoScalingAPI, asgName string) ([]string, error) {
+	output, err := asgSvc.DescribeAutoScalingGroups(&autoscaling.DescribeAutoScalingGroupsInput{
+		AutoScalingGroupNames: aws.StringSlice([]string{asgName}),
+	})
+	if err != nil {
+		return nil, errors.WithStackTrace(err)
+	}
+	if len(output.AutoScalingGroups) == 0 {
+		return nil, errors.WithStackTrace(NewLookupError("ASG", asgName, "instances"))
+	}
+	instanceIds := []string{}
+	for _, instance := range output.AutoScalingGroups[0].Instances {
+		if aws.StringValue(instance.LifecycleState) == autoscaling.LifecycleStateTerminatingWait {
+			instanceIds = append(instanceIds, aws.StringValue(instance.InstanceId))
+		}
+	}
+	return instanceIds, nil
+}
+
+// completeDrainLifecycleActions releases the instances held by the lifecycle hook, so that they can be terminated.
+func completeDrainLifecycleActions(asgSvc autoscalingiface.AutoScalingAPI, asgName string, instanceIds []string) error {
+	for _, instanceID := range instanceIds {
+		_, err := asgSvc.CompleteLifecycleAction(&autoscaling.CompleteLifecycleActionInput{
+			AutoScalingGroupName:  aws.String(asgName),
+			LifecycleHookName:     aws.String(InstanceRefreshLifecycleHookName),
+			LifecycleActionResult: aws.String("CONTINUE"),
+			InstanceId:            aws.String(instanceID),
+		})
+		if err != nil {
+			return errors.WithStackTrace(err)
+		}
+	}
+	return nil
+}
+
+// cancelInstanceRefresh cancels the in progress Instance Refresh of the ASG. This is a no-op if there is no refresh in
+// progress.
+func cancelInstanceRefresh(asgSvc autoscalingiface.AutoScalingAPI, asgName string) error {
+	_, err := asgSvc.CancelInstanceRefresh(&autoscaling.CancelInstanceRefreshInput{AutoScalingGroupName: aws.String(asgName)})
+	if awsErr, isAwsErr := err.(awserr.Error); isAwsErr && awsErr.Code() == autoscaling.ErrCodeActiveInstanceRefreshNotFoundFault {
+		return nil
+	}
+	return errors.WithStackTrace(err)
+}
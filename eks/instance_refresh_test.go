@@ -0,0 +1,239 @@
+package eks
+
+import (
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/autoscaling"
+	"github.com/aws/aws-sdk-go/service/autoscaling/autoscalingiface"
+	"github.com/gruntwork-io/go-commons/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeInstanceRefreshASG fakes the Auto Scaling API calls used by the instance-refresh strategy. Each call to
+// DescribeInstanceRefreshes pops the next status, and the instances waiting for termination are returned until their
+// lifecycle action is completed.
+type fakeInstanceRefreshASG struct {
+	autoscalingiface.AutoScalingAPI
+
+	statuses            map[string][]string
+	waitingInstances    map[string][]string
+	startedRefreshes    []*autoscaling.StartInstanceRefreshInput
+	completedInstances  []string
+	cancelledRefreshes  []string
+	lifecycleHooksAdded []string
+}
+
+func newFakeInstanceRefreshASG() *fakeInstanceRefreshASG {
+	return &fakeInstanceRefreshASG{
+		statuses:         map[string][]string{},
+		waitingInstances: map[string][]string{},
+	}
+}
+
+func (fake *fakeInstanceRefreshASG) PutLifecycleHook(input *autoscaling.PutLifecycleHookInput) (*autoscaling.PutLifecycleHookOutput, error) {
+	fake.lifecycleHooksAdded = append(fake.lifecycleHooksAdded, aws.StringValue(input.AutoScalingGroupName))
+	return &autoscaling.PutLifecycleHookOutput{}, nil
+}
+
+func (fake *fakeInstanceRefreshASG) DeleteLifecycleHook(input *autoscaling.DeleteLifecycleHookInput) (*autoscaling.DeleteLifecycleHookOutput, error) {
+	return &autoscaling.DeleteLifecycleHookOutput{}, nil
+}
+
+func (fake *fakeInstanceRefreshASG) StartInstanceRefresh(input *autoscaling.StartInstanceRefreshInput) (*autoscaling.StartInstanceRefreshOutput, error) {
+	fake.startedRefreshes = append(fake.startedRefreshes, input)
+	return &autoscaling.StartInstanceRefreshOutput{
+		InstanceRefreshId: aws.String("refresh-" + aws.StringValue(input.AutoScalingGroupName)),
+	}, nil
+}
+
+func (fake *fakeInstanceRefreshASG) CancelInstanceRefresh(input *autoscaling.CancelInstanceRefreshInput) (*autoscaling.CancelInstanceRefreshOutput, error) {
+	asgName := aws.StringValue(input.AutoScalingGroupName)
+	fake.cancelledRefreshes = append(fake.cancelledRefreshes, asgName)
+	return &autoscaling.CancelInstanceRefreshOutput{}, nil
+}
+
+func (fake *fakeInstanceRefreshASG) DescribeInstanceRefreshes(input *autoscaling.DescribeInstanceRefreshesInput) (*autoscaling.DescribeInstanceRefreshesOutput, error) {
+	asgName := aws.StringValue(input.AutoScalingGroupName)
+	statuses := fake.statuses[asgName]
+	status := statuses[0]
+	if len(statuses) > 1 {
+		fake.statuses[asgName] = statuses[1:]
+	}
+	return &autoscaling.DescribeInstanceRefreshesOutput{
+		InstanceRefreshes: []*autoscaling.InstanceRefresh{
+			{
+				AutoScalingGroupName: input.AutoScalingGroupName,
+				InstanceRefreshId:    input.InstanceRefreshIds[0],
+				Status:               aws.String(status),
+				StatusReason:         aws.String("test reason"),
+			},
+		},
+	}, nil
+}
+
+func (fake *fakeInstanceRefreshASG) DescribeAutoScalingGroups(input *autoscaling.DescribeAutoScalingGroupsInput) (*autoscaling.DescribeAutoScalingGroupsOutput, error) {
+	asgName := aws.StringValue(input.AutoScalingGroupNames[0])
+	instances := []*autoscaling.Instance{
+		{InstanceId: aws.String("new-instance"), LifecycleState: aws.String(autoscaling.LifecycleStateInService)},
+	}
+	for _, instanceID := range fake.waitingInstances[asgName] {
+		instances = append(instances, &autoscaling.Instance{
+			InstanceId:     aws.String(instanceID),
+			LifecycleState: aws.String(autoscaling.LifecycleStateTerminatingWait),
+		})
+	}
+	return &autoscaling.DescribeAutoScalingGroupsOutput{
+		AutoScalingGroups: []*autoscaling.Group{{AutoScalingGroupName: aws.String(asgName), Instances: instances}},
+	}, nil
+}
+
+func (fake *fakeInstanceRefreshASG) CompleteLifecycleAction(input *autoscaling.CompleteLifecycleActionInput) (*autoscaling.CompleteLifecycleActionOutput, error) {
+	asgName := aws.StringValue(input.AutoScalingGroupName)
+	instanceID := aws.StringValue(input.InstanceId)
+	fake.completedInstances = append(fake.completedInstances, instanceID)
+	remaining := []string{}
+	for _, waitingInstanceID := range fake.waitingInstances[asgName] {
+		if waitingInstanceID != instanceID {
+			remaining = append(remaining, waitingInstanceID)
+		}
+	}
+	fake.waitingInstances[asgName] = remaining
+	return &autoscaling.CompleteLifecycleActionOutput{}, nil
+}
+
+func TestRollOutWithInstanceRefreshDrainsBeforeTermination(t *testing.T) {
+	t.Parallel()
+
+	state := newTestMultiASGDeployState(t, SequentialASGRollout)
+	state.Strategy = InstanceRefreshDeployStrategy
+	state.maxRetries = 3
+	defer state.delete()
+
+	fake := newFakeInstanceRefreshASG()
+	fake.waitingInstances["asg-a"] = []string{"a-1"}
+	fake.statuses["asg-a"] = []string{autoscaling.InstanceRefreshStatusInProgress, autoscaling.InstanceRefreshStatusSuccessful}
+	fake.statuses["asg-b"] = []string{autoscaling.InstanceRefreshStatusSuccessful}
+
+	drained := []string{}
+	drainInstances := func(instanceIds []string) error {
+		// The instance must still be held by the lifecycle hook when it is drained.
+		assert.NotContains(t, fake.completedInstances, instanceIds[0])
+		drained = append(drained, instanceIds...)
+		return nil
+	}
+
+	options := InstanceRefreshOptions{MinHealthyPercentage: 90, CheckpointPercentages: []int64{50}, CheckpointDelay: time.Minute}
+	err := rollOutWithInstanceRefresh(state, fake, 15*time.Minute, options, drainInstances, nil)
+	require.NoError(t, err)
+
+	assert.Equal(t, []string{"asg-a", "asg-b"}, fake.lifecycleHooksAdded)
+	assert.Equal(t, []string{"a-1"}, drained)
+	assert.Equal(t, []string{"a-1"}, fake.completedInstances)
+	assert.Equal(t, []string{"a-1"}, state.ASGs[0].DrainedInstances)
+	assert.True(t, state.ASGs[0].RolloutDone)
+	assert.True(t, state.ASGs[1].RolloutDone)
+	assert.True(t, state.DeleteLifecycleHooksDone)
+
+	// Sequential roll outs start the refresh of the second ASG once the first one succeeds.
+	require.Equal(t, 2, len(fake.startedRefreshes))
+	assert.Equal(t, "asg-a", aws.StringValue(fake.startedRefreshes[0].AutoScalingGroupName))
+	assert.Equal(t, "asg-b", aws.StringValue(fake.startedRefreshes[1].AutoScalingGroupName))
+	preferences := fake.startedRefreshes[0].Preferences
+	assert.Equal(t, int64(90), aws.Int64Value(preferences.MinHealthyPercentage))
+	assert.Equal(t, []int64{50}, aws.Int64ValueSlice(preferences.CheckpointPercentages))
+	assert.Equal(t, int64(60), aws.Int64Value(preferences.CheckpointDelay))
+	assert.Nil(t, preferences.InstanceWarmup)
+}
+
+func TestRollOutWithInstanceRefreshFailsWhenRefreshIsCancelled(t *testing.T) {
+	t.Parallel()
+
+	state := newTestMultiASGDeployState(t, ParallelASGRollout)
+	state.Strategy = InstanceRefreshDeployStrategy
+	defer state.delete()
+
+	fake := newFakeInstanceRefreshASG()
+	fake.statuses["asg-a"] = []string{autoscaling.InstanceRefreshStatusSuccessful}
+	fake.statuses["asg-b"] = []string{autoscaling.InstanceRefreshStatusCancelled}
+
+	err := rollOutWithInstanceRefresh(state, fake, 15*time.Minute, InstanceRefreshOptions{}, func([]string) error { return nil }, nil)
+	require.Error(t, err)
+	refreshErr, isRefreshErr := errors.Unwrap(err).(InstanceRefreshFailedErr)
+	require.True(t, isRefreshErr)
+	assert.Equal(t, "asg-b", refreshErr.asgName)
+	assert.Equal(t, autoscaling.InstanceRefreshStatusCancelled, refreshErr.status)
+
+	// The failed refresh is forgotten, so that resuming starts a new one.
+	assert.True(t, state.ASGs[0].RolloutDone)
+	assert.False(t, state.ASGs[1].RolloutDone)
+	assert.Equal(t, "", state.ASGs[1].InstanceRefreshID)
+	assert.False(t, state.DeleteLifecycleHooksDone)
+}
+
+func TestRollbackInstanceRefreshCancelsRefreshes(t *testing.T) {
+	t.Parallel()
+
+	state := newTestMultiASGDeployState(t, ParallelASGRollout)
+	state.Strategy = InstanceRefreshDeployStrategy
+	state.PutLifecycleHooksDone = true
+	state.ASGs[0].InstanceRefreshID = "refresh-asg-a"
+	state.ASGs[1].RolloutDone = true
+	state.maxRetries = 3
+	defer state.delete()
+
+	fake := newFakeInstanceRefreshASG()
+	fake.waitingInstances["asg-a"] = []string{"a-2"}
+	fake.statuses["asg-a"] = []string{autoscaling.InstanceRefreshStatusCancelling, autoscaling.InstanceRefreshStatusCancelled}
+
+	drained := []string{}
+	err := rollbackInstanceRefresh(state, fake, func(instanceIds []string) error {
+		drained = append(drained, instanceIds...)
+		return nil
+	})
+	require.NoError(t, err)
+
+	assert.Equal(t, []string{"asg-a"}, fake.cancelledRefreshes)
+	assert.Equal(t, []string{"a-2"}, drained)
+	assert.Equal(t, []string{"a-2"}, fake.completedInstances)
+	assert.Equal(t, "", state.ASGs[0].InstanceRefreshID)
+	assert.True(t, state.RollbackPlanDone)
+	assert.True(t, state.DeleteLifecycleHooksDone)
+}
+
+func TestInstanceRefreshWaitsAreBounded(t *testing.T) {
+	t.Parallel()
+
+	state := newTestMultiASGDeployState(t, ParallelASGRollout)
+	state.Strategy = InstanceRefreshDeployStrategy
+	state.maxRetries = 2
+	state.ASGs[0].InstanceRefreshID = "refresh-asg-a"
+	state.ASGs[1].RolloutDone = true
+	defer state.delete()
+
+	fake := newFakeInstanceRefreshASG()
+	fake.statuses["asg-a"] = []string{autoscaling.InstanceRefreshStatusInProgress}
+	err := state.waitForInstanceRefreshes(fake, func([]string) error { return nil }, nil)
+	require.Error(t, err)
+	timeoutErr, isTimeoutErr := errors.Unwrap(err).(InstanceRefreshTimeoutErr)
+	require.True(t, isTimeoutErr)
+	assert.Equal(t, []string{"refresh-asg-a of ASG asg-a (InProgress)"}, timeoutErr.refreshes)
+
+	fake.statuses["asg-a"] = []string{autoscaling.InstanceRefreshStatusCancelling}
+	err = state.cancelInstanceRefreshes(fake, func([]string) error { return nil })
+	require.Error(t, err)
+	_, isTimeoutErr = errors.Unwrap(err).(InstanceRefreshTimeoutErr)
+	assert.True(t, isTimeoutErr)
+	// The refresh is still tracked, so that the roll back can be resumed.
+	assert.Equal(t, "refresh-asg-a", state.ASGs[0].InstanceRefreshID)
+}
+
+func TestDrainLifecycleHookHeartbeatTimeout(t *testing.T) {
+	t.Parallel()
+
+	assert.Equal(t, 30*time.Minute, drainLifecycleHookHeartbeatTimeout(15*time.Minute))
+	assert.Equal(t, 2*time.Hour, drainLifecycleHookHeartbeatTimeout(0))
+	assert.Equal(t, 2*time.Hour, drainLifecycleHookHeartbeatTimeout(3*time.Hour))
+}
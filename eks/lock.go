@@ -0,0 +1,353 @@
+package eks
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/gruntwork-io/go-commons/errors"
+	"github.com/sirupsen/logrus"
+	coordinationv1 "k8s.io/api/coordination/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+
+	"github.com/gruntwork-io/kubergrunt/kubectl"
+	"github.com/gruntwork-io/kubergrunt/logging"
+)
+
+const (
+	// The namespace and name of the Lease object used to lock the cluster for deploy and drain operations.
+	clusterLockNamespace = "kube-system"
+	clusterLockName      = "kubergrunt-lock"
+	// The annotation on the Lease recording the nonce of the process holding the lock. The holder identity is user
+	// provided and not necessarily unique (e.g two runs of the same CI job), so the nonce is what identifies the holder.
+	clusterLockNonceAnnotation = "kubergrunt.gruntwork.io/lock-nonce"
+
+	// DefaultClusterLockTTL is the default amount of time a lock is held without being renewed before it is considered
+	// abandoned.
+	DefaultClusterLockTTL = 5 * time.Minute
+)
+
+// ClusterLockOptions represents the user provided configuration for the cluster lock.
+type ClusterLockOptions struct {
+	// HolderIdentity identifies the holder of the lock in error messages. Defaults to the hostname and process ID.
+	HolderIdentity string
+
+	// TTL is how long the lock is valid for without renewal. The lock is renewed every third of the TTL while held.
+	TTL time.Duration
+
+	// ForceUnlock takes over the lock even if it is held by someone else.
+	ForceUnlock bool
+}
+
+// ClusterLock is a lock on the EKS cluster, backed by a coordination.k8s.io Lease, that prevents multiple deploy or drain
+// operations from running against the cluster at the same time.
+type ClusterLock struct {
+	clientset      kubernetes.Interface
+	namespace      string
+	name           string
+	holderIdentity string
+	nonce          string
+	ttl            time.Duration
+
+	// now returns the current time, and can be overridden in tests.
+	now func() time.Time
+
+	stopRenewal chan struct{}
+	renewalDone sync.WaitGroup
+	logger      *logrus.Entry
+
+	// lost is closed when the background renewal finds that the lock was taken over, with the reason in lostErr.
+	lost     chan struct{}
+	lostOnce sync.Once
+	lostErr  error
+}
+
+// AcquireClusterLock constructs a ClusterLock for the cluster targeted by the kubectl options, and acquires it.
+func AcquireClusterLock(kubectlOptions *kubectl.KubectlOptions, options ClusterLockOptions) (*ClusterLock, error) {
+	clientset, err := kubectl.GetKubernetesClientFromOptions(kubectlOptions)
+	if err != nil {
+		return nil, err
+	}
+	lock := newClusterLock(clientset, options)
+	if err := lock.Acquire(options.ForceUnlock); err != nil {
+		return nil, err
+	}
+	return lock, nil
+}
+
+// ReleaseClusterLock releases the lock, logging a warning instead of failing if the lock could not be released, since
+// the lock will expire on its own.
+func ReleaseClusterLock(lock *ClusterLock) {
+	if err := lock.Release(); err != nil {
+		lock.logger.Warnf("Error releasing cluster lock %s: %s", lock.description(), err)
+		lock.logger.Warn("The lock will expire on its own once the TTL passes, or you can remove it with --force-unlock")
+	}
+}
+
+// newClusterLock constructs a new ClusterLock using the provided options, filling in defaults where necessary.
+func newClusterLock(clientset kubernetes.Interface, options ClusterLockOptions) *ClusterLock {
+	holderIdentity := options.HolderIdentity
+	if holderIdentity == "" {
+		holderIdentity = defaultLockHolderIdentity()
+	}
+	ttl := options.TTL
+	if ttl <= 0 {
+		ttl = DefaultClusterLockTTL
+	}
+	return &ClusterLock{
+		clientset:      clientset,
+		namespace:      clusterLockNamespace,
+		name:           clusterLockName,
+		holderIdentity: holderIdentity,
+		nonce:          newLockNonce(),
+		ttl:            ttl,
+		now:            time.Now,
+		logger:         logging.GetProjectLogger(),
+		lost:           make(chan struct{}),
+	}
+}
+
+// Acquire takes the lock, failing with a LockHeldErr if it is held by someone else and has not yet expired. The lock is
+// only considered to be held by this lock when the nonce matches, so another process with the same holder identity is
+// treated as someone else. When forceUnlock is set, the lock is taken over regardless of who holds it. Once acquired,
+// the lock is renewed in the background until Release is called.
+func (lock *ClusterLock) Acquire(forceUnlock bool) error {
+	leases := lock.clientset.CoordinationV1().Leases(lock.namespace)
+	now := metav1.NewMicroTime(lock.now())
+	ttlSeconds := int32(lock.ttl.Seconds())
+
+	lease, err := leases.Get(context.Background(), lock.name, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		newLease := &coordinationv1.Lease{
+			ObjectMeta: lock.leaseObjectMeta(),
+			Spec: coordinationv1.LeaseSpec{
+				HolderIdentity:       &lock.holderIdentity,
+				LeaseDurationSeconds: &ttlSeconds,
+				AcquireTime:          &now,
+				RenewTime:            &now,
+			},
+		}
+		_, err = leases.Create(context.Background(), newLease, metav1.CreateOptions{})
+		if apierrors.IsAlreadyExists(err) {
+			// Someone else created the lock in between the get and create, so retry to report who holds it.
+			return lock.Acquire(forceUnlock)
+		}
+		if err != nil {
+			return errors.WithStackTrace(err)
+		}
+		lock.logger.Infof("Acquired cluster lock %s as %s", lock.description(), lock.holderIdentity)
+		lock.startRenewal()
+		return nil
+	}
+	if err != nil {
+		return errors.WithStackTrace(err)
+	}
+
+	holder := leaseHolder(lease)
+	isOwnLease := lock.isOwnLease(lease)
+	if holder != "" && !isOwnLease && !lock.isExpired(lease) {
+		if !forceUnlock {
+			return errors.WithStackTrace(LockHeldErr{
+				lockName:  lock.description(),
+				holder:    holder,
+				expiresAt: leaseExpiry(lease),
+			})
+		}
+		lock.logger.Warnf("Forcefully taking over cluster lock %s from %s", lock.description(), holder)
+	} else if holder != "" && !isOwnLease {
+		lock.logger.Warnf("Taking over expired cluster lock %s from %s", lock.description(), holder)
+	}
+
+	transitions := int32(0)
+	if lease.Spec.LeaseTransitions != nil {
+		transitions = *lease.Spec.LeaseTransitions
+	}
+	if !isOwnLease {
+		transitions++
+	}
+	if lease.Annotations == nil {
+		lease.Annotations = map[string]string{}
+	}
+	lease.Annotations[clusterLockNonceAnnotation] = lock.nonce
+	lease.Spec.HolderIdentity = &lock.holderIdentity
+	lease.Spec.LeaseDurationSeconds = &ttlSeconds
+	lease.Spec.AcquireTime = &now
+	lease.Spec.RenewTime = &now
+	lease.Spec.LeaseTransitions = &transitions
+	// The update is rejected with a conflict if someone else updated the lease since we read it, which means they
+	// acquired the lock first.
+	_, err = leases.Update(context.Background(), lease, metav1.UpdateOptions{})
+	if apierrors.IsConflict(err) {
+		return lock.Acquire(forceUnlock)
+	}
+	if err != nil {
+		return errors.WithStackTrace(err)
+	}
+	lock.logger.Infof("Acquired cluster lock %s as %s", lock.description(), lock.holderIdentity)
+	lock.startRenewal()
+	return nil
+}
+
+// Renew extends the lock by updating the renew time of the Lease. Returns a LockLostErr if the lock has been taken
+// over by someone else.
+func (lock *ClusterLock) Renew() error {
+	leases := lock.clientset.CoordinationV1().Leases(lock.namespace)
+	lease, err := leases.Get(context.Background(), lock.name, metav1.GetOptions{})
+	if err != nil {
+		return errors.WithStackTrace(err)
+	}
+	if !lock.isOwnLease(lease) {
+		return errors.WithStackTrace(LockLostErr{lockName: lock.description(), holder: leaseHolder(lease)})
+	}
+	now := metav1.NewMicroTime(lock.now())
+	lease.Spec.RenewTime = &now
+	_, err = leases.Update(context.Background(), lease, metav1.UpdateOptions{})
+	return errors.WithStackTrace(err)
+}
+
+// Release stops renewing the lock and deletes the Lease, as long as it is still held by this lock.
+func (lock *ClusterLock) Release() error {
+	if lock.stopRenewal != nil {
+		close(lock.stopRenewal)
+		lock.renewalDone.Wait()
+		lock.stopRenewal = nil
+	}
+
+	leases := lock.clientset.CoordinationV1().Leases(lock.namespace)
+	lease, err := leases.Get(context.Background(), lock.name, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		return nil
+	}
+	if err != nil {
+		return errors.WithStackTrace(err)
+	}
+	if !lock.isOwnLease(lease) {
+		lock.logger.Warnf("Cluster lock %s is now held by %s - not releasing", lock.description(), leaseHolder(lease))
+		return nil
+	}
+	err = leases.Delete(
+		context.Background(),
+		lock.name,
+		metav1.DeleteOptions{Preconditions: &metav1.Preconditions{ResourceVersion: &lease.ResourceVersion}},
+	)
+	if err != nil && !apierrors.IsNotFound(err) {
+		return errors.WithStackTrace(err)
+	}
+	lock.logger.Infof("Released cluster lock %s", lock.description())
+	return nil
+}
+
+// CheckHeld returns the LockLostErr if the background renewal found that the lock was taken over by someone else. This
+// is checked at each checkpoint of the operations holding the lock, so that they stop changing the cluster once the
+// lock is lost. A nil lock is always considered held.
+func (lock *ClusterLock) CheckHeld() error {
+	if lock == nil {
+		return nil
+	}
+	select {
+	case <-lock.lost:
+		return lock.lostErr
+	default:
+		return nil
+	}
+}
+
+// startRenewal starts a goroutine that renews the lock every third of the TTL until the lock is released. Renewal stops
+// if the lock was taken over by someone else, which is reported by CheckHeld.
+func (lock *ClusterLock) startRenewal() {
+	if lock.stopRenewal != nil {
+		// Already renewing, since the lock was re-acquired by the same process.
+		return
+	}
+	lock.stopRenewal = make(chan struct{})
+	lock.renewalDone.Add(1)
+	go func() {
+		defer lock.renewalDone.Done()
+		ticker := time.NewTicker(lock.ttl / 3)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-lock.stopRenewal:
+				return
+			case <-ticker.C:
+				err := lock.Renew()
+				if _, isLockLostErr := errors.Unwrap(err).(LockLostErr); isLockLostErr {
+					lock.logger.Errorf("Lost cluster lock %s: %s", lock.description(), err)
+					lock.lostOnce.Do(func() {
+						lock.lostErr = err
+						close(lock.lost)
+					})
+					return
+				}
+				if err != nil {
+					lock.logger.Errorf("Error renewing cluster lock %s: %s", lock.description(), err)
+				}
+			}
+		}
+	}()
+}
+
+// isOwnLease returns true if the Lease is held by this lock, as opposed to someone else with the same holder identity.
+func (lock *ClusterLock) isOwnLease(lease *coordinationv1.Lease) bool {
+	return leaseHolder(lease) == lock.holderIdentity && lease.Annotations[clusterLockNonceAnnotation] == lock.nonce
+}
+
+// leaseObjectMeta returns the metadata for a new Lease held by this lock.
+func (lock *ClusterLock) leaseObjectMeta() metav1.ObjectMeta {
+	meta := kubergruntObjectMeta(lock.namespace, lock.name)
+	meta.Annotations = map[string]string{clusterLockNonceAnnotation: lock.nonce}
+	return meta
+}
+
+// isExpired returns true if the Lease has not been renewed within its duration.
+func (lock *ClusterLock) isExpired(lease *coordinationv1.Lease) bool {
+	expiresAt := leaseExpiry(lease)
+	return expiresAt.IsZero() || lock.now().After(expiresAt)
+}
+
+// description returns a human readable name of the lock for use in logs and errors.
+func (lock *ClusterLock) description() string {
+	return fmt.Sprintf("Lease %s/%s", lock.namespace, lock.name)
+}
+
+// leaseHolder returns the holder identity of the Lease, or empty string if it is not held.
+func leaseHolder(lease *coordinationv1.Lease) string {
+	if lease.Spec.HolderIdentity == nil {
+		return ""
+	}
+	return *lease.Spec.HolderIdentity
+}
+
+// leaseExpiry returns the time at which the Lease expires if it is not renewed, or zero time if the Lease is missing
+// the renew time or duration.
+func leaseExpiry(lease *coordinationv1.Lease) time.Time {
+	if lease.Spec.RenewTime == nil || lease.Spec.LeaseDurationSeconds == nil {
+		return time.Time{}
+	}
+	return lease.Spec.RenewTime.Add(time.Duration(*lease.Spec.LeaseDurationSeconds) * time.Second)
+}
+
+// defaultLockHolderIdentity returns an identity for the lock holder based on the hostname and process ID.
+func defaultLockHolderIdentity() string {
+	hostname, err := os.Hostname()
+	if err != nil {
+		hostname = "unknown"
+	}
+	return fmt.Sprintf("%s-%d", hostname, os.Getpid())
+}
+
+// newLockNonce returns a nonce that is unique to this process, built from the hostname, process ID and random bytes.
+func newLockNonce() string {
+	randomBytes := make([]byte, 8)
+	if _, err := rand.Read(randomBytes); err != nil {
+		// The hostname and process ID are still unique enough on their own.
+		return defaultLockHolderIdentity()
+	}
+	return fmt.Sprintf("%s-%s", defaultLockHolderIdentity(), hex.EncodeToString(randomBytes))
+}
@@ -0,0 +1,157 @@
+package eks
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/gruntwork-io/go-commons/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestClusterLockAcquireAndRelease(t *testing.T) {
+	t.Parallel()
+
+	clientset := fake.NewSimpleClientset()
+	lock := newClusterLock(clientset, ClusterLockOptions{HolderIdentity: "pipeline-a", TTL: time.Hour})
+	require.NoError(t, lock.Acquire(false))
+
+	lease, err := clientset.CoordinationV1().Leases(clusterLockNamespace).Get(context.Background(), clusterLockName, metav1.GetOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, "pipeline-a", leaseHolder(lease))
+	assert.Equal(t, int32(3600), *lease.Spec.LeaseDurationSeconds)
+
+	require.NoError(t, lock.Release())
+	_, err = clientset.CoordinationV1().Leases(clusterLockNamespace).Get(context.Background(), clusterLockName, metav1.GetOptions{})
+	assert.True(t, apierrors.IsNotFound(err))
+}
+
+func TestClusterLockHeldBySomeoneElse(t *testing.T) {
+	t.Parallel()
+
+	clientset := fake.NewSimpleClientset()
+	holderLock := newClusterLock(clientset, ClusterLockOptions{HolderIdentity: "pipeline-a", TTL: time.Hour})
+	require.NoError(t, holderLock.Acquire(false))
+	defer holderLock.Release()
+
+	lock := newClusterLock(clientset, ClusterLockOptions{HolderIdentity: "pipeline-b", TTL: time.Hour})
+	err := lock.Acquire(false)
+	require.Error(t, err)
+	lockHeldErr, isLockHeldErr := errors.Unwrap(err).(LockHeldErr)
+	require.True(t, isLockHeldErr)
+	assert.Equal(t, "pipeline-a", lockHeldErr.holder)
+	assert.Contains(t, err.Error(), "pipeline-a")
+}
+
+func TestClusterLockTakesOverExpiredLock(t *testing.T) {
+	t.Parallel()
+
+	clientset := fake.NewSimpleClientset()
+	holderLock := newClusterLock(clientset, ClusterLockOptions{HolderIdentity: "pipeline-a", TTL: time.Hour})
+	require.NoError(t, holderLock.Acquire(false))
+	defer holderLock.Release()
+
+	lock := newClusterLock(clientset, ClusterLockOptions{HolderIdentity: "pipeline-b", TTL: time.Hour})
+	lock.now = func() time.Time { return time.Now().Add(2 * time.Hour) }
+	require.NoError(t, lock.Acquire(false))
+	defer lock.Release()
+
+	lease, err := clientset.CoordinationV1().Leases(clusterLockNamespace).Get(context.Background(), clusterLockName, metav1.GetOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, "pipeline-b", leaseHolder(lease))
+	assert.Equal(t, int32(1), *lease.Spec.LeaseTransitions)
+}
+
+func TestClusterLockForceUnlock(t *testing.T) {
+	t.Parallel()
+
+	clientset := fake.NewSimpleClientset()
+	holderLock := newClusterLock(clientset, ClusterLockOptions{HolderIdentity: "pipeline-a", TTL: time.Hour})
+	require.NoError(t, holderLock.Acquire(false))
+
+	lock := newClusterLock(clientset, ClusterLockOptions{HolderIdentity: "pipeline-b", TTL: time.Hour})
+	require.NoError(t, lock.Acquire(true))
+
+	// The original holder loses the lock, and must not release it out from under the new holder.
+	err := holderLock.Renew()
+	require.Error(t, err)
+	_, isLockLostErr := errors.Unwrap(err).(LockLostErr)
+	assert.True(t, isLockLostErr)
+	require.NoError(t, holderLock.Release())
+
+	lease, err := clientset.CoordinationV1().Leases(clusterLockNamespace).Get(context.Background(), clusterLockName, metav1.GetOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, "pipeline-b", leaseHolder(lease))
+	require.NoError(t, lock.Release())
+}
+
+func TestClusterLockRenewal(t *testing.T) {
+	t.Parallel()
+
+	clientset := fake.NewSimpleClientset()
+	lock := newClusterLock(clientset, ClusterLockOptions{HolderIdentity: "pipeline-a", TTL: 3 * time.Second})
+	require.NoError(t, lock.Acquire(false))
+	defer lock.Release()
+
+	lease, err := clientset.CoordinationV1().Leases(clusterLockNamespace).Get(context.Background(), clusterLockName, metav1.GetOptions{})
+	require.NoError(t, err)
+	acquiredAt := lease.Spec.RenewTime.Time
+
+	// The lock is renewed every third of the TTL.
+	time.Sleep(1500 * time.Millisecond)
+	lease, err = clientset.CoordinationV1().Leases(clusterLockNamespace).Get(context.Background(), clusterLockName, metav1.GetOptions{})
+	require.NoError(t, err)
+	assert.True(t, lease.Spec.RenewTime.Time.After(acquiredAt))
+}
+
+func TestClusterLockSameHolderIdentity(t *testing.T) {
+	t.Parallel()
+
+	clientset := fake.NewSimpleClientset()
+	holderLock := newClusterLock(clientset, ClusterLockOptions{HolderIdentity: "pipeline-a", TTL: time.Hour})
+	require.NoError(t, holderLock.Acquire(false))
+	defer holderLock.Release()
+
+	// The same process can re-acquire the lock.
+	require.NoError(t, holderLock.Acquire(false))
+
+	// A concurrent run of the same CI job has the same identity, but a different nonce.
+	lock := newClusterLock(clientset, ClusterLockOptions{HolderIdentity: "pipeline-a", TTL: time.Hour})
+	err := lock.Acquire(false)
+	require.Error(t, err)
+	_, isLockHeldErr := errors.Unwrap(err).(LockHeldErr)
+	assert.True(t, isLockHeldErr)
+}
+
+func TestClusterLockLostStopsCheckpoints(t *testing.T) {
+	t.Parallel()
+
+	clientset := fake.NewSimpleClientset()
+	holderLock := newClusterLock(clientset, ClusterLockOptions{HolderIdentity: "pipeline-a", TTL: 3 * time.Second})
+	require.NoError(t, holderLock.Acquire(false))
+	defer holderLock.Release()
+	require.NoError(t, holderLock.CheckHeld())
+
+	lock := newClusterLock(clientset, ClusterLockOptions{HolderIdentity: "pipeline-b", TTL: time.Hour})
+	require.NoError(t, lock.Acquire(true))
+	defer lock.Release()
+
+	// The loss is detected by the background renewal, which runs every third of the TTL.
+	time.Sleep(1500 * time.Millisecond)
+	err := holderLock.CheckHeld()
+	require.Error(t, err)
+	_, isLockLostErr := errors.Unwrap(err).(LockLostErr)
+	assert.True(t, isLockLostErr)
+
+	// The deploy state is not persisted once the lock is lost.
+	statePath := filepath.Join(t.TempDir(), ".kubergrunt.state")
+	state := newDeployState(&LocalFileStateBackend{Path: statePath})
+	state.lock = holderLock
+	require.Error(t, state.persist())
+	assert.NoFileExists(t, statePath)
+}
@@ -0,0 +1,537 @@
+package eks
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/autoscaling"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/aws/aws-sdk-go/service/ec2/ec2iface"
+	"github.com/aws/aws-sdk-go/service/eks"
+	"github.com/aws/aws-sdk-go/service/eks/eksiface"
+	"github.com/gruntwork-io/go-commons/errors"
+	"github.com/gruntwork-io/go-commons/retry"
+	corev1 "k8s.io/api/core/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+
+	"github.com/gruntwork-io/kubergrunt/eksawshelper"
+	"github.com/gruntwork-io/kubergrunt/kubectl"
+	"github.com/gruntwork-io/kubergrunt/logging"
+)
+
+// LatestLaunchTemplateVersion can be passed in as the launch template version of NodegroupUpdateOptions to bump the
+// node group to the latest version of its launch template.
+const LatestLaunchTemplateVersion = "$Latest"
+
+// nodegroupUpdateBatchTimeout is the time budgeted for EKS to replace each batch of nodes of a managed node group, which
+// covers launching the new nodes and draining the old ones (EKS force evicts the Pods after 15 minutes).
+const nodegroupUpdateBatchTimeout = 20 * time.Minute
+
+// The Pods on the original nodes of a node group are recorded in a ConfigMap in the kube-system namespace with this
+// name prefix before the update starts, so that the report of a resumed update covers the original nodes.
+const nodegroupPodsConfigMapPrefix = "kubergrunt-nodegroup-pods-"
+
+// NodegroupUpdateOptions configures the update triggered on EKS managed node groups. Set KubernetesVersion and/or
+// ReleaseVersion to update the AMI of node groups that use the EKS optimized AMI, or LaunchTemplateVersion to bump the
+// launch template version of node groups that use a launch template.
+type NodegroupUpdateOptions struct {
+	KubernetesVersion     string
+	ReleaseVersion        string
+	LaunchTemplateVersion string
+	// Force the update even if Pods can not be evicted due to PodDisruptionBudgets.
+	Force bool
+}
+
+// NodegroupUpdateReport summarizes the result of updating a managed node group.
+type NodegroupUpdateReport struct {
+	NodegroupName string
+	UpdateID      string
+	Status        string
+	EvictedPods   []PodRef
+	PDBFailures   []string
+}
+
+// PodRef identifies a Pod that was scheduled on a node.
+type PodRef struct {
+	NodeName  string
+	Namespace string
+	Name      string
+	UID       string
+}
+
+func (pod PodRef) String() string {
+	return fmt.Sprintf("%s/%s (node %s)", pod.Namespace, pod.Name, pod.NodeName)
+}
+
+// ResolveNodegroupASGs looks up the names of the ASGs backing the provided EKS managed node groups.
+func ResolveNodegroupASGs(region string, clusterName string, nodegroupNames []string) ([]string, error) {
+	eksSvc, err := eksawshelper.NewEksClient(region)
+	if err != nil {
+		return nil, err
+	}
+	asgNames := []string{}
+	for _, nodegroupName := range nodegroupNames {
+		nodegroup, err := getNodegroup(eksSvc, clusterName, nodegroupName)
+		if err != nil {
+			return nil, err
+		}
+		asgNames = append(asgNames, nodegroupASGNames(nodegroup)...)
+	}
+	return asgNames, nil
+}
+
+// DeployNodegroups rolls out an update to the provided EKS managed node groups, one at a time. For each node group,
+// this will:
+// 1. Resolve the ASGs backing the node group and their instances, and map them to Kubernetes node names.
+// 2. Run the PodDisruptionBudget preflight check against the Pods on those nodes.
+// 3. Record the Pods on those nodes, and trigger UpdateNodegroupVersion, or pick up the update that is in progress.
+// 4. Track the update until it completes.
+// 5. Report the Pods that were evicted from the original nodes, along with any PodDisruptionBudget eviction failures.
+// EKS replaces and drains the nodes of a managed node group itself, so unlike RollOutDeployment, there is no recovery
+// state: an interrupted deploy resumes tracking the update in progress when it is run again, and reports on the Pods
+// recorded in the kube-system namespace before the update started. Unless maxRetries is set, the update timeout is
+// derived from the number of nodes and the update config of the node group, since EKS replaces maxUnavailable nodes at
+// a time.
+func DeployNodegroups(
+	region string,
+	clusterName string,
+	nodegroupNames []string,
+	kubectlOptions *kubectl.KubectlOptions,
+	options NodegroupUpdateOptions,
+	maxRetries int,
+	sleepBetweenRetries time.Duration,
+	lockOptions ClusterLockOptions,
+	pdbPreflight PDBPreflightMode,
+) error {
+	logger := logging.GetProjectLogger()
+	if err := validatePDBPreflightMode(pdbPreflight); err != nil {
+		return err
+	}
+	logger.Infof("Beginning roll out for EKS managed node groups %s of cluster %s in %s", strings.Join(nodegroupNames, ","), clusterName, region)
+
+	sess, err := eksawshelper.NewAuthenticatedSession(region)
+	if err != nil {
+		return errors.WithStackTrace(err)
+	}
+	eksSvc := eks.New(sess)
+	asgSvc := autoscaling.New(sess)
+	ec2Svc := ec2.New(sess)
+	logger.Infof("Successfully authenticated with AWS")
+
+	lock, err := AcquireClusterLock(kubectlOptions, lockOptions)
+	if err != nil {
+		return err
+	}
+	defer ReleaseClusterLock(lock)
+
+	clientset, err := kubectl.GetKubernetesClientFromOptions(kubectlOptions)
+	if err != nil {
+		return err
+	}
+
+	for _, nodegroupName := range nodegroupNames {
+		if err := lock.CheckHeld(); err != nil {
+			return err
+		}
+		nodegroup, err := getNodegroup(eksSvc, clusterName, nodegroupName)
+		if err != nil {
+			return err
+		}
+
+		// Reuse the ASG and instance lookups of the self managed roll out to find the nodes of the node group.
+		instanceIds := []string{}
+		for _, asgName := range nodegroupASGNames(nodegroup) {
+			asgInfo, err := getAsgInfo(asgSvc, asgName)
+			if err != nil {
+				return err
+			}
+			instanceIds = append(instanceIds, asgInfo.OriginalInstances...)
+		}
+		nodeNames := []string{}
+		if len(instanceIds) > 0 {
+			instances, err := instanceDetailsFromIds(ec2Svc, instanceIds)
+			if err != nil {
+				return err
+			}
+			nodeNames = kubeNodeNamesFromInstances(instances)
+		}
+		logger.Infof("Found %d nodes in node group %s", len(nodeNames), nodegroupName)
+
+		err = runPDBPreflight(ec2Svc, kubectlOptions, instanceIds, pdbPreflight)
+		if err != nil {
+			return err
+		}
+		podsStore := newNodegroupPodsStore(clientset, nodegroupName)
+		updateID, err := findInProgressNodegroupUpdate(eksSvc, clusterName, nodegroupName)
+		if err != nil {
+			return err
+		}
+		var podsBeforeUpdate []PodRef
+		if updateID != "" {
+			logger.Infof("Found update %s in progress for node group %s - resuming", updateID, nodegroupName)
+			podsBeforeUpdate, err = loadPodsBeforeUpdate(podsStore)
+			if err != nil {
+				return err
+			}
+		}
+		if podsBeforeUpdate == nil {
+			if updateID != "" {
+				logger.Warnf("No record of the Pods before update %s in %s. Some of the nodes may already be replaced, so the report may be incomplete.", updateID, podsStore.Location())
+			}
+			podsBeforeUpdate, err = listEvictablePodsOnNodes(clientset, nodeNames)
+			if err != nil {
+				return err
+			}
+		}
+		if updateID == "" {
+			// Record the Pods before starting the update, so that a resumed update reports on the original nodes.
+			if err := savePodsBeforeUpdate(podsStore, podsBeforeUpdate); err != nil {
+				return err
+			}
+			updateID, err = startNodegroupUpdate(eksSvc, ec2Svc, nodegroup, options)
+			if err != nil {
+				return err
+			}
+		}
+
+		updateMaxRetries := maxRetries
+		if updateMaxRetries == 0 {
+			updateMaxRetries = getNodegroupUpdateMaxRetries(nodegroup, int64(len(nodeNames)), sleepBetweenRetries)
+			logger.Infof("No max retries set. Defaulted to %d based on the size and update config of node group %s.", updateMaxRetries, nodegroupName)
+		}
+		update, err := waitForNodegroupUpdate(
+			eksSvc,
+			clusterName,
+			nodegroupName,
+			updateID,
+			updateMaxRetries,
+			sleepBetweenRetries,
+		)
+		if err != nil {
+			return err
+		}
+
+		report, err := newNodegroupUpdateReport(clientset, nodegroupName, update, podsBeforeUpdate)
+		if err != nil {
+			return err
+		}
+		report.log()
+		if err := podsStore.Delete(); err != nil && !k8serrors.IsNotFound(errors.Unwrap(err)) {
+			logger.Warnf("Error deleting the record of the Pods before the update in %s: %s", podsStore.Location(), err)
+		}
+		if report.Status != eks.UpdateStatusSuccessful {
+			return errors.WithStackTrace(NodegroupUpdateFailedErr{report: report, updateErrors: formatNodegroupUpdateErrors(update)})
+		}
+	}
+
+	logger.Infof("Successfully finished roll out for EKS managed node groups %s", strings.Join(nodegroupNames, ","))
+	return nil
+}
+
+// getNodegroup looks up the EKS managed node group.
+func getNodegroup(eksSvc eksiface.EKSAPI, clusterName string, nodegroupName string) (*eks.Nodegroup, error) {
+	output, err := eksSvc.DescribeNodegroup(&eks.DescribeNodegroupInput{
+		ClusterName:   aws.String(clusterName),
+		NodegroupName: aws.String(nodegroupName),
+	})
+	if err != nil {
+		return nil, errors.WithStackTrace(err)
+	}
+	return output.Nodegroup, nil
+}
+
+// nodegroupASGNames returns the names of the ASGs backing the managed node group.
+func nodegroupASGNames(nodegroup *eks.Nodegroup) []string {
+	asgNames := []string{}
+	if nodegroup.Resources == nil {
+		return asgNames
+	}
+	for _, asg := range nodegroup.Resources.AutoScalingGroups {
+		asgNames = append(asgNames, aws.StringValue(asg.Name))
+	}
+	return asgNames
+}
+
+// findInProgressNodegroupUpdate returns the ID of the update in progress for the node group, or an empty string if there
+// is none.
+func findInProgressNodegroupUpdate(eksSvc eksiface.EKSAPI, clusterName string, nodegroupName string) (string, error) {
+	input := &eks.ListUpdatesInput{
+		Name:          aws.String(clusterName),
+		NodegroupName: aws.String(nodegroupName),
+	}
+	for {
+		output, err := eksSvc.ListUpdates(input)
+		if err != nil {
+			return "", errors.WithStackTrace(err)
+		}
+		for _, updateID := range output.UpdateIds {
+			update, err := describeNodegroupUpdate(eksSvc, clusterName, nodegroupName, aws.StringValue(updateID))
+			if err != nil {
+				return "", err
+			}
+			if aws.StringValue(update.Status) == eks.UpdateStatusInProgress {
+				return aws.StringValue(updateID), nil
+			}
+		}
+		if output.NextToken == nil {
+			return "", nil
+		}
+		input.NextToken = output.NextToken
+	}
+}
+
+// startNodegroupUpdate triggers UpdateNodegroupVersion on the node group, returning the ID of the update. When the
+// launch template version is LatestLaunchTemplateVersion, it is resolved to the latest version number of the launch
+// template of the node group.
+func startNodegroupUpdate(
+	eksSvc eksiface.EKSAPI,
+	ec2Svc ec2iface.EC2API,
+	nodegroup *eks.Nodegroup,
+	options NodegroupUpdateOptions,
+) (string, error) {
+	logger := logging.GetProjectLogger()
+	nodegroupName := aws.StringValue(nodegroup.NodegroupName)
+
+	input := &eks.UpdateNodegroupVersionInput{
+		ClusterName:   nodegroup.ClusterName,
+		NodegroupName: nodegroup.NodegroupName,
+		Force:         aws.Bool(options.Force),
+	}
+	if options.KubernetesVersion != "" {
+		input.Version = aws.String(options.KubernetesVersion)
+	}
+	if options.ReleaseVersion != "" {
+		input.ReleaseVersion = aws.String(options.ReleaseVersion)
+	}
+	if options.LaunchTemplateVersion != "" {
+		if nodegroup.LaunchTemplate == nil {
+			return "", errors.WithStackTrace(NodegroupWithoutLaunchTemplateErr{nodegroupName: nodegroupName})
+		}
+		version := options.LaunchTemplateVersion
+		if version == LatestLaunchTemplateVersion {
+			latestVersion, err := getLatestLaunchTemplateVersion(ec2Svc, nodegroup.LaunchTemplate)
+			if err != nil {
+				return "", err
+			}
+			version = latestVersion
+		}
+		logger.Infof("Bumping launch template of node group %s to version %s", nodegroupName, version)
+		input.LaunchTemplate = &eks.LaunchTemplateSpecification{
+			Id:      nodegroup.LaunchTemplate.Id,
+			Version: aws.String(version),
+		}
+	}
+
+	output, err := eksSvc.UpdateNodegroupVersion(input)
+	if err != nil {
+		return "", errors.WithStackTrace(err)
+	}
+	updateID := aws.StringValue(output.Update.Id)
+	logger.Infof("Started update %s of node group %s", updateID, nodegroupName)
+	return updateID, nil
+}
+
+// getLatestLaunchTemplateVersion returns the latest version number of the launch template.
+func getLatestLaunchTemplateVersion(ec2Svc ec2iface.EC2API, launchTemplate *eks.LaunchTemplateSpecification) (string, error) {
+	input := &ec2.DescribeLaunchTemplatesInput{}
+	if launchTemplate.Id != nil {
+		input.LaunchTemplateIds = []*string{launchTemplate.Id}
+	} else {
+		input.LaunchTemplateNames = []*string{launchTemplate.Name}
+	}
+	output, err := ec2Svc.DescribeLaunchTemplates(input)
+	if err != nil {
+		return "", errors.WithStackTrace(err)
+	}
+	if len(output.LaunchTemplates) == 0 {
+		return "", errors.WithStackTrace(NewLookupError("launch template", aws.StringValue(launchTemplate.Id), "latest version"))
+	}
+	return strconv.FormatInt(aws.Int64Value(output.LaunchTemplates[0].LatestVersionNumber), 10), nil
+}
+
+// waitForNodegroupUpdate polls the update until it is no longer in progress, returning the final state of the update.
+func waitForNodegroupUpdate(
+	eksSvc eksiface.EKSAPI,
+	clusterName string,
+	nodegroupName string,
+	updateID string,
+	maxRetries int,
+	sleepBetweenRetries time.Duration,
+) (*eks.Update, error) {
+	logger := logging.GetProjectLogger()
+	var update *eks.Update
+	err := retry.DoWithRetry(
+		logger.Logger,
+		fmt.Sprintf("wait for update %s of node group %s", updateID, nodegroupName),
+		maxRetries,
+		sleepBetweenRetries,
+		func() error {
+			var err error
+			update, err = describeNodegroupUpdate(eksSvc, clusterName, nodegroupName, updateID)
+			if err != nil {
+				return retry.FatalError{Underlying: err}
+			}
+			if aws.StringValue(update.Status) == eks.UpdateStatusInProgress {
+				return fmt.Errorf("update %s of node group %s is still in progress", updateID, nodegroupName)
+			}
+			return nil
+		},
+	)
+	if fatalErr, isFatalErr := err.(retry.FatalError); isFatalErr {
+		return nil, fatalErr.Underlying
+	}
+	return update, errors.WithStackTrace(err)
+}
+
+// getNodegroupUpdateMaxRetries calculates the default max retries for waiting on the update of the node group. EKS
+// replaces up to maxUnavailable nodes at a time (1 by default), so this budgets nodegroupUpdateBatchTimeout for each
+// batch of nodes, plus one more for setting up and scaling down the node group.
+func getNodegroupUpdateMaxRetries(nodegroup *eks.Nodegroup, nodeCount int64, sleepBetweenRetries time.Duration) int {
+	if nodegroup.ScalingConfig != nil && aws.Int64Value(nodegroup.ScalingConfig.DesiredSize) > nodeCount {
+		nodeCount = aws.Int64Value(nodegroup.ScalingConfig.DesiredSize)
+	}
+	maxUnavailable := int64(1)
+	if updateConfig := nodegroup.UpdateConfig; updateConfig != nil {
+		if aws.Int64Value(updateConfig.MaxUnavailable) > 0 {
+			maxUnavailable = aws.Int64Value(updateConfig.MaxUnavailable)
+		} else if aws.Int64Value(updateConfig.MaxUnavailablePercentage) > 0 {
+			percentage := float64(aws.Int64Value(updateConfig.MaxUnavailablePercentage))
+			maxUnavailable = int64(math.Max(1, math.Ceil(float64(nodeCount)*percentage/100)))
+		}
+	}
+	numBatches := int64(math.Ceil(float64(nodeCount) / float64(maxUnavailable)))
+
+	if sleepBetweenRetries <= 0 {
+		sleepBetweenRetries = time.Second
+	}
+	timeout := time.Duration(numBatches+1) * nodegroupUpdateBatchTimeout
+	return int(timeout / sleepBetweenRetries)
+}
+
+// newNodegroupPodsStore returns the store recording the Pods on the original nodes of the node group, which is a
+// ConfigMap in the kube-system namespace.
+func newNodegroupPodsStore(clientset kubernetes.Interface, nodegroupName string) *ConfigMapStateBackend {
+	// Node group names can have upper case letters and underscores, which are not allowed in ConfigMap names.
+	name := strings.ReplaceAll(strings.ToLower(nodegroupName), "_", "-")
+	return &ConfigMapStateBackend{Clientset: clientset, Namespace: clusterLockNamespace, Name: nodegroupPodsConfigMapPrefix + name}
+}
+
+// savePodsBeforeUpdate records the Pods on the original nodes of the node group in the store.
+func savePodsBeforeUpdate(store DeployStateBackend, pods []PodRef) error {
+	data, err := json.Marshal(pods)
+	if err != nil {
+		return errors.WithStackTrace(err)
+	}
+	return store.Save(data)
+}
+
+// loadPodsBeforeUpdate returns the Pods recorded in the store, or nil if none were recorded.
+func loadPodsBeforeUpdate(store DeployStateBackend) ([]PodRef, error) {
+	data, err := store.Load()
+	if err != nil || data == nil {
+		return nil, err
+	}
+	pods := []PodRef{}
+	if err := json.Unmarshal(data, &pods); err != nil {
+		return nil, errors.WithStackTrace(err)
+	}
+	return pods, nil
+}
+
+// describeNodegroupUpdate looks up the update of the node group.
+func describeNodegroupUpdate(eksSvc eksiface.EKSAPI, clusterName string, nodegroupName string, updateID string) (*eks.Update, error) {
+	output, err := eksSvc.DescribeUpdate(&eks.DescribeUpdateInput{
+		Name:          aws.String(clusterName),
+		NodegroupName: aws.String(nodegroupName),
+		UpdateId:      aws.String(updateID),
+	})
+	if err != nil {
+		return nil, errors.WithStackTrace(err)
+	}
+	return output.Update, nil
+}
+
+// listEvictablePodsOnNodes returns the Pods on the provided nodes that would be evicted by a drain.
+func listEvictablePodsOnNodes(clientset kubernetes.Interface, nodeNames []string) ([]PodRef, error) {
+	pods := []PodRef{}
+	for _, nodeName := range nodeNames {
+		podsOnNode, err := kubectl.ListPodsOnNodeWithClientset(context.Background(), clientset, nodeName)
+		if err != nil {
+			return nil, err
+		}
+		for _, pod := range kubectl.FilterEvictablePods(podsOnNode) {
+			pods = append(pods, newPodRef(pod))
+		}
+	}
+	return pods, nil
+}
+
+func newPodRef(pod corev1.Pod) PodRef {
+	return PodRef{NodeName: pod.Spec.NodeName, Namespace: pod.Namespace, Name: pod.Name, UID: string(pod.UID)}
+}
+
+// newNodegroupUpdateReport builds the report of the update, by checking which of the Pods that were on the original
+// nodes before the update are gone, and collecting the PodDisruptionBudget eviction failures reported by EKS.
+func newNodegroupUpdateReport(
+	clientset kubernetes.Interface,
+	nodegroupName string,
+	update *eks.Update,
+	podsBeforeUpdate []PodRef,
+) (NodegroupUpdateReport, error) {
+	report := NodegroupUpdateReport{
+		NodegroupName: nodegroupName,
+		UpdateID:      aws.StringValue(update.Id),
+		Status:        aws.StringValue(update.Status),
+		EvictedPods:   []PodRef{},
+		PDBFailures:   []string{},
+	}
+	for _, podRef := range podsBeforeUpdate {
+		pod, err := clientset.CoreV1().Pods(podRef.Namespace).Get(context.Background(), podRef.Name, metav1.GetOptions{})
+		if err != nil && !k8serrors.IsNotFound(err) {
+			return report, errors.WithStackTrace(err)
+		}
+		// A Pod is evicted if it no longer exists, or was replaced by a new Pod with the same name (e.g StatefulSets).
+		if err != nil || string(pod.UID) != podRef.UID {
+			report.EvictedPods = append(report.EvictedPods, podRef)
+		}
+	}
+	for _, updateErr := range update.Errors {
+		if aws.StringValue(updateErr.ErrorCode) == eks.ErrorCodePodEvictionFailure {
+			report.PDBFailures = append(report.PDBFailures, aws.StringValue(updateErr.ErrorMessage))
+		}
+	}
+	return report, nil
+}
+
+// formatNodegroupUpdateErrors returns a human readable description of each error reported by EKS for the update.
+func formatNodegroupUpdateErrors(update *eks.Update) []string {
+	out := []string{}
+	for _, updateErr := range update.Errors {
+		out = append(out, fmt.Sprintf("%s: %s", aws.StringValue(updateErr.ErrorCode), aws.StringValue(updateErr.ErrorMessage)))
+	}
+	return out
+}
+
+// log prints the report using the project logger.
+func (report NodegroupUpdateReport) log() {
+	logger := logging.GetProjectLogger()
+	logger.Infof("Update %s of node group %s is %s", report.UpdateID, report.NodegroupName, report.Status)
+	logger.Infof("Evicted %d Pods from the original nodes:", len(report.EvictedPods))
+	for _, pod := range report.EvictedPods {
+		logger.Infof("\t- %s", pod)
+	}
+	if len(report.PDBFailures) > 0 {
+		logger.Errorf("Failed to evict Pods due to PodDisruptionBudgets:")
+		for _, failure := range report.PDBFailures {
+			logger.Errorf("\t- %s", failure)
+		}
+	}
+}
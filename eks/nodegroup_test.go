@@ -0,0 +1,242 @@
+package eks
+
+import (
+	"sort"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/aws/aws-sdk-go/service/ec2/ec2iface"
+	"github.com/aws/aws-sdk-go/service/eks"
+	"github.com/aws/aws-sdk-go/service/eks/eksiface"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+// fakeNodegroupEKS fakes the EKS API calls used to update managed node groups. Each call to DescribeUpdate pops the
+// next status of the update.
+type fakeNodegroupEKS struct {
+	eksiface.EKSAPI
+
+	updateStatuses map[string][]string
+	updateErrors   []*eks.ErrorDetail
+	updateInputs   []*eks.UpdateNodegroupVersionInput
+}
+
+// ListUpdates returns one update per page, to exercise the pagination.
+func (fake *fakeNodegroupEKS) ListUpdates(input *eks.ListUpdatesInput) (*eks.ListUpdatesOutput, error) {
+	updateIds := []string{}
+	for updateID := range fake.updateStatuses {
+		updateIds = append(updateIds, updateID)
+	}
+	sort.Strings(updateIds)
+	if len(updateIds) == 0 {
+		return &eks.ListUpdatesOutput{}, nil
+	}
+
+	page := 0
+	if input.NextToken != nil {
+		page, _ = strconv.Atoi(aws.StringValue(input.NextToken))
+	}
+	output := &eks.ListUpdatesOutput{UpdateIds: aws.StringSlice(updateIds[page : page+1])}
+	if page+1 < len(updateIds) {
+		output.NextToken = aws.String(strconv.Itoa(page + 1))
+	}
+	return output, nil
+}
+
+func (fake *fakeNodegroupEKS) DescribeUpdate(input *eks.DescribeUpdateInput) (*eks.DescribeUpdateOutput, error) {
+	updateID := aws.StringValue(input.UpdateId)
+	statuses := fake.updateStatuses[updateID]
+	status := statuses[0]
+	if len(statuses) > 1 {
+		fake.updateStatuses[updateID] = statuses[1:]
+	}
+	return &eks.DescribeUpdateOutput{
+		Update: &eks.Update{Id: input.UpdateId, Status: aws.String(status), Errors: fake.updateErrors},
+	}, nil
+}
+
+func (fake *fakeNodegroupEKS) UpdateNodegroupVersion(input *eks.UpdateNodegroupVersionInput) (*eks.UpdateNodegroupVersionOutput, error) {
+	fake.updateInputs = append(fake.updateInputs, input)
+	return &eks.UpdateNodegroupVersionOutput{Update: &eks.Update{Id: aws.String("new-update")}}, nil
+}
+
+type fakeLaunchTemplateEC2 struct {
+	ec2iface.EC2API
+}
+
+func (fake *fakeLaunchTemplateEC2) DescribeLaunchTemplates(input *ec2.DescribeLaunchTemplatesInput) (*ec2.DescribeLaunchTemplatesOutput, error) {
+	return &ec2.DescribeLaunchTemplatesOutput{
+		LaunchTemplates: []*ec2.LaunchTemplate{{LaunchTemplateId: input.LaunchTemplateIds[0], LatestVersionNumber: aws.Int64(7)}},
+	}, nil
+}
+
+func TestNodegroupASGNames(t *testing.T) {
+	t.Parallel()
+
+	nodegroup := &eks.Nodegroup{
+		Resources: &eks.NodegroupResources{
+			AutoScalingGroups: []*eks.AutoScalingGroup{{Name: aws.String("eks-asg-a")}, {Name: aws.String("eks-asg-b")}},
+		},
+	}
+	assert.Equal(t, []string{"eks-asg-a", "eks-asg-b"}, nodegroupASGNames(nodegroup))
+	assert.Equal(t, []string{}, nodegroupASGNames(&eks.Nodegroup{}))
+}
+
+func TestStartNodegroupUpdateBumpsLaunchTemplateToLatest(t *testing.T) {
+	t.Parallel()
+
+	fakeEKS := &fakeNodegroupEKS{}
+	nodegroup := &eks.Nodegroup{
+		ClusterName:    aws.String("cluster"),
+		NodegroupName:  aws.String("workers"),
+		LaunchTemplate: &eks.LaunchTemplateSpecification{Id: aws.String("lt-123"), Version: aws.String("6")},
+	}
+	updateID, err := startNodegroupUpdate(fakeEKS, &fakeLaunchTemplateEC2{}, nodegroup, NodegroupUpdateOptions{LaunchTemplateVersion: LatestLaunchTemplateVersion})
+	require.NoError(t, err)
+	assert.Equal(t, "new-update", updateID)
+
+	require.Equal(t, 1, len(fakeEKS.updateInputs))
+	input := fakeEKS.updateInputs[0]
+	assert.Equal(t, "lt-123", aws.StringValue(input.LaunchTemplate.Id))
+	assert.Equal(t, "7", aws.StringValue(input.LaunchTemplate.Version))
+	assert.Nil(t, input.Version)
+	assert.Nil(t, input.ReleaseVersion)
+}
+
+func TestStartNodegroupUpdateRequiresLaunchTemplateForBump(t *testing.T) {
+	t.Parallel()
+
+	nodegroup := &eks.Nodegroup{ClusterName: aws.String("cluster"), NodegroupName: aws.String("workers")}
+	_, err := startNodegroupUpdate(&fakeNodegroupEKS{}, &fakeLaunchTemplateEC2{}, nodegroup, NodegroupUpdateOptions{LaunchTemplateVersion: "2"})
+	assert.Error(t, err)
+}
+
+func TestFindAndWaitForInProgressNodegroupUpdate(t *testing.T) {
+	t.Parallel()
+
+	fakeEKS := &fakeNodegroupEKS{
+		updateStatuses: map[string][]string{
+			"old-update":     {eks.UpdateStatusSuccessful},
+			"running-update": {eks.UpdateStatusInProgress, eks.UpdateStatusInProgress, eks.UpdateStatusSuccessful},
+		},
+	}
+	updateID, err := findInProgressNodegroupUpdate(fakeEKS, "cluster", "workers")
+	require.NoError(t, err)
+	assert.Equal(t, "running-update", updateID)
+
+	update, err := waitForNodegroupUpdate(fakeEKS, "cluster", "workers", updateID, 3, 0)
+	require.NoError(t, err)
+	assert.Equal(t, eks.UpdateStatusSuccessful, aws.StringValue(update.Status))
+}
+
+func TestFindInProgressNodegroupUpdateOnLaterPage(t *testing.T) {
+	t.Parallel()
+
+	fakeEKS := &fakeNodegroupEKS{
+		updateStatuses: map[string][]string{
+			"a-update": {eks.UpdateStatusSuccessful},
+			"b-update": {eks.UpdateStatusFailed},
+			"c-update": {eks.UpdateStatusInProgress},
+		},
+	}
+	updateID, err := findInProgressNodegroupUpdate(fakeEKS, "cluster", "workers")
+	require.NoError(t, err)
+	assert.Equal(t, "c-update", updateID)
+
+	updateID, err = findInProgressNodegroupUpdate(&fakeNodegroupEKS{}, "cluster", "workers")
+	require.NoError(t, err)
+	assert.Equal(t, "", updateID)
+}
+
+func TestGetNodegroupUpdateMaxRetries(t *testing.T) {
+	t.Parallel()
+
+	testCases := []struct {
+		name            string
+		nodegroup       *eks.Nodegroup
+		nodeCount       int64
+		expectedRetries int
+	}{
+		// 10 batches of 1 node, plus setup.
+		{"default", &eks.Nodegroup{}, 10, 11 * 20},
+		{"maxUnavailable", &eks.Nodegroup{UpdateConfig: &eks.NodegroupUpdateConfig{MaxUnavailable: aws.Int64(4)}}, 10, 4 * 20},
+		{"maxUnavailablePercentage", &eks.Nodegroup{UpdateConfig: &eks.NodegroupUpdateConfig{MaxUnavailablePercentage: aws.Int64(50)}}, 10, 3 * 20},
+		{"desiredSizeAboveNodeCount", &eks.Nodegroup{ScalingConfig: &eks.NodegroupScalingConfig{DesiredSize: aws.Int64(3)}}, 1, 4 * 20},
+	}
+
+	for _, tc := range testCases {
+		// Capture range variable to bring it in scope for the for loop.
+		tc := tc
+
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			assert.Equal(t, tc.expectedRetries, getNodegroupUpdateMaxRetries(tc.nodegroup, tc.nodeCount, time.Minute))
+		})
+	}
+}
+
+func TestPodsBeforeUpdateStore(t *testing.T) {
+	t.Parallel()
+
+	store := newNodegroupPodsStore(fake.NewSimpleClientset(), "Workers_A")
+	assert.Equal(t, "kubergrunt-nodegroup-pods-workers-a", store.Name)
+
+	pods, err := loadPodsBeforeUpdate(store)
+	require.NoError(t, err)
+	assert.Nil(t, pods)
+
+	podsBeforeUpdate := []PodRef{{NodeName: "node-a", Namespace: "default", Name: "web-abc", UID: "web-uid"}}
+	require.NoError(t, savePodsBeforeUpdate(store, podsBeforeUpdate))
+	pods, err = loadPodsBeforeUpdate(store)
+	require.NoError(t, err)
+	assert.Equal(t, podsBeforeUpdate, pods)
+
+	require.NoError(t, store.Delete())
+	pods, err = loadPodsBeforeUpdate(store)
+	require.NoError(t, err)
+	assert.Nil(t, pods)
+}
+
+func TestNewNodegroupUpdateReport(t *testing.T) {
+	t.Parallel()
+
+	clientset := fake.NewSimpleClientset(
+		// Still running on the original node.
+		&corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "stuck", UID: types.UID("stuck-uid")},
+			Spec:       corev1.PodSpec{NodeName: "node-a"},
+		},
+		// Replaced by a new Pod with the same name, like StatefulSet Pods.
+		&corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "db-0", UID: types.UID("new-uid")},
+			Spec:       corev1.PodSpec{NodeName: "node-b"},
+		},
+	)
+	podsBeforeUpdate := []PodRef{
+		{NodeName: "node-a", Namespace: "default", Name: "stuck", UID: "stuck-uid"},
+		{NodeName: "node-a", Namespace: "default", Name: "db-0", UID: "old-uid"},
+		{NodeName: "node-a", Namespace: "default", Name: "web-abc", UID: "web-uid"},
+	}
+	update := &eks.Update{
+		Id:     aws.String("update"),
+		Status: aws.String(eks.UpdateStatusFailed),
+		Errors: []*eks.ErrorDetail{
+			{ErrorCode: aws.String(eks.ErrorCodePodEvictionFailure), ErrorMessage: aws.String("Reached max retries while trying to evict pods from nodes")},
+			{ErrorCode: aws.String(eks.ErrorCodeAccessDenied), ErrorMessage: aws.String("denied")},
+		},
+	}
+
+	report, err := newNodegroupUpdateReport(clientset, "workers", update, podsBeforeUpdate)
+	require.NoError(t, err)
+	assert.Equal(t, eks.UpdateStatusFailed, report.Status)
+	assert.Equal(t, []PodRef{podsBeforeUpdate[1], podsBeforeUpdate[2]}, report.EvictedPods)
+	assert.Equal(t, []string{"Reached max retries while trying to evict pods from nodes"}, report.PDBFailures)
+}
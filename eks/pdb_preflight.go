@@ -0,0 +1,76 @@
+package eks
+
+import (
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/gruntwork-io/go-commons/collections"
+	"github.com/gruntwork-io/go-commons/errors"
+
+	"github.com/gruntwork-io/kubergrunt/kubectl"
+	"github.com/gruntwork-io/kubergrunt/logging"
+)
+
+// PDBPreflightMode determines what happens when the PodDisruptionBudget preflight check finds evictions that can never
+// succeed.
+type PDBPreflightMode string
+
+const (
+	// FailPDBPreflight fails the command before any changes are made.
+	FailPDBPreflight PDBPreflightMode = "fail"
+	// WarnPDBPreflight logs a warning for each blocked eviction and continues.
+	WarnPDBPreflight PDBPreflightMode = "warn"
+	// SkipPDBPreflight does not run the preflight check.
+	SkipPDBPreflight PDBPreflightMode = "off"
+)
+
+// PDBPreflightModes lists all the supported PDBPreflightMode values.
+var PDBPreflightModes = []string{string(FailPDBPreflight), string(WarnPDBPreflight), string(SkipPDBPreflight)}
+
+// validatePDBPreflightMode returns an error if the mode is not supported.
+func validatePDBPreflightMode(mode PDBPreflightMode) error {
+	if !collections.ListContainsElement(PDBPreflightModes, string(mode)) {
+		return errors.WithStackTrace(InvalidPDBPreflightModeErr{mode: mode})
+	}
+	return nil
+}
+
+// runPDBPreflight checks that the Pods on the nodes of the provided instances can be evicted without being blocked
+// forever by a PodDisruptionBudget, so that we don't wait for the entire drain timeout only to fail. Depending on the
+// mode, blocked evictions either fail the check or are reported as warnings.
+func runPDBPreflight(
+	ec2Svc *ec2.EC2,
+	kubectlOptions *kubectl.KubectlOptions,
+	instanceIds []string,
+	mode PDBPreflightMode,
+) error {
+	logger := logging.GetProjectLogger()
+	if mode == SkipPDBPreflight || len(instanceIds) == 0 {
+		return nil
+	}
+
+	logger.Info("Checking PodDisruptionBudgets for Pods that can not be evicted.")
+	instances, err := instanceDetailsFromIds(ec2Svc, instanceIds)
+	if err != nil {
+		return err
+	}
+	clientset, err := kubectl.GetKubernetesClientFromOptions(kubectlOptions)
+	if err != nil {
+		return err
+	}
+	blocked, err := kubectl.FindBlockedEvictions(clientset, kubeNodeNamesFromInstances(instances))
+	if err != nil {
+		return err
+	}
+	if len(blocked) == 0 {
+		logger.Info("Verified PodDisruptionBudgets allow all Pods to be evicted.")
+		return nil
+	}
+
+	if mode == FailPDBPreflight {
+		return errors.WithStackTrace(PDBBlockedEvictionsErr{blocked: blocked})
+	}
+	logger.Warnf("Found %d Pods that can never be evicted due to PodDisruptionBudgets. Draining the nodes will time out:", len(blocked))
+	for _, blockedEviction := range blocked {
+		logger.Warnf("\t- %s", blockedEviction)
+	}
+	return nil
+}
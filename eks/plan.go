@@ -0,0 +1,324 @@
+package eks
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/autoscaling"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/gruntwork-io/go-commons/collections"
+	"github.com/gruntwork-io/go-commons/errors"
+
+	"github.com/gruntwork-io/kubergrunt/eksawshelper"
+	"github.com/gruntwork-io/kubergrunt/kubectl"
+	"github.com/gruntwork-io/kubergrunt/logging"
+)
+
+// PlanFormat represents the output format of a dry run plan.
+type PlanFormat string
+
+const (
+	TextPlanFormat PlanFormat = "text"
+	JSONPlanFormat PlanFormat = "json"
+)
+
+// PlanFormats lists all the supported PlanFormat values.
+var PlanFormats = []string{string(TextPlanFormat), string(JSONPlanFormat)}
+
+// Plan describes the actions a deploy or drain would take, without making any changes.
+type Plan struct {
+	Command       string             `json:"command"`
+	RolloutMode   ASGRolloutMode     `json:"rolloutMode,omitempty"`
+	ASGs          []ASGPlan          `json:"asgs"`
+	LoadBalancers []LoadBalancerPlan `json:"loadBalancers,omitempty"`
+}
+
+// ASGPlan describes the actions that would be taken on a single ASG. Waves is set for deploys, while Nodes is set for
+// drains.
+type ASGPlan struct {
+	Name                 string `json:"name"`
+	CurrentCapacity      int64  `json:"currentCapacity"`
+	CurrentMaxSize       int64  `json:"currentMaxSize"`
+	MaxSizeDuringRollout int64  `json:"maxSizeDuringRollout,omitempty"`
+	MaxSurge             int64  `json:"maxSurge,omitempty"`
+	BatchSize            int64  `json:"batchSize,omitempty"`
+
+	// SteadyState is false when the ASG has not yet launched all the instances for its desired capacity, in which
+	// case the command would wait for it to reach steady state first.
+	SteadyState bool `json:"steadyState"`
+
+	Waves []WavePlan `json:"waves,omitempty"`
+	Nodes []NodePlan `json:"nodes,omitempty"`
+}
+
+// WavePlan describes a single wave of a roll out.
+type WavePlan struct {
+	Number          int        `json:"number"`
+	DesiredCapacity int64      `json:"desiredCapacity"`
+	Nodes           []NodePlan `json:"nodes"`
+}
+
+// NodePlan describes an instance that would be cordoned and drained, along with the Pods that would be evicted.
+type NodePlan struct {
+	InstanceID  string   `json:"instanceId"`
+	NodeName    string   `json:"nodeName"`
+	PodsToEvict []string `json:"podsToEvict"`
+}
+
+// LoadBalancerPlan describes a load balancer that the roll out would wait on for the new instances to register.
+type LoadBalancerPlan struct {
+	Name       string `json:"name"`
+	Type       string `json:"type"`
+	TargetType string `json:"targetType"`
+}
+
+// PlanDeployment runs all the read only lookups of RollOutDeployment and returns the plan of what the roll out would
+// do, without making any changes.
+func PlanDeployment(
+	region string,
+	eksAsgNames []string,
+	kubectlOptions *kubectl.KubectlOptions,
+	maxSurge string,
+	batchSize string,
+	rolloutMode ASGRolloutMode,
+) (*Plan, error) {
+	if !collections.ListContainsElement(ASGRolloutModes, string(rolloutMode)) {
+		return nil, errors.WithStackTrace(InvalidASGRolloutModeErr{mode: string(rolloutMode)})
+	}
+	asgSvc, ec2Svc, err := newPlanClients(region)
+	if err != nil {
+		return nil, err
+	}
+
+	plan := &Plan{Command: "deploy", RolloutMode: rolloutMode, ASGs: []ASGPlan{}}
+	for _, asgName := range eksAsgNames {
+		asgInfo, err := getAsgInfo(asgSvc, asgName)
+		if err != nil {
+			return nil, err
+		}
+		asgInfo.MaxSurge, err = resolveRolloutCount(maxSurge, asgInfo.OriginalCapacity)
+		if err != nil {
+			return nil, err
+		}
+		asgInfo.BatchSize, err = resolveRolloutCount(batchSize, asgInfo.OriginalCapacity)
+		if err != nil {
+			return nil, err
+		}
+		nodes, err := planNodes(ec2Svc, kubectlOptions, asgInfo.OriginalInstances)
+		if err != nil {
+			return nil, err
+		}
+
+		asgPlan := newASGPlan(asgInfo)
+		asgPlan.MaxSurge = asgInfo.MaxSurge
+		asgPlan.BatchSize = asgInfo.BatchSize
+		asgPlan.MaxSizeDuringRollout = asgInfo.OriginalMaxCapacity
+		if maxSizeForUpdate := asgInfo.OriginalCapacity + asgInfo.MaxSurge; maxSizeForUpdate > asgPlan.MaxSizeDuringRollout {
+			asgPlan.MaxSizeDuringRollout = maxSizeForUpdate
+		}
+		for {
+			wave, hasWave := asgInfo.planWave()
+			if !hasWave {
+				break
+			}
+			asgInfo.Waves = append(asgInfo.Waves, wave)
+			asgPlan.Waves = append(asgPlan.Waves, WavePlan{
+				Number:          len(asgInfo.Waves),
+				DesiredCapacity: asgInfo.OriginalCapacity + int64(len(wave.OriginalInstances)),
+				Nodes:           selectNodePlans(nodes, wave.OriginalInstances),
+			})
+		}
+		plan.ASGs = append(plan.ASGs, asgPlan)
+	}
+
+	elbs, err := kubectl.GetAWSLoadBalancers(kubectlOptions)
+	if err != nil {
+		return nil, err
+	}
+	plan.LoadBalancers = []LoadBalancerPlan{}
+	for _, elb := range elbs {
+		plan.LoadBalancers = append(plan.LoadBalancers, LoadBalancerPlan{
+			Name:       elb.Name,
+			Type:       elb.Type.String(),
+			TargetType: elb.TargetType.String(),
+		})
+	}
+	return plan, nil
+}
+
+// PlanDrain runs all the read only lookups of DrainASG and returns the plan of what the drain would do, without making
+// any changes.
+func PlanDrain(region string, asgNames []string, kubectlOptions *kubectl.KubectlOptions) (*Plan, error) {
+	asgSvc, ec2Svc, err := newPlanClients(region)
+	if err != nil {
+		return nil, err
+	}
+
+	plan := &Plan{Command: "drain", ASGs: []ASGPlan{}}
+	for _, asgName := range asgNames {
+		asgInfo, err := getAsgInfo(asgSvc, asgName)
+		if err != nil {
+			return nil, err
+		}
+		nodes, err := planNodes(ec2Svc, kubectlOptions, asgInfo.OriginalInstances)
+		if err != nil {
+			return nil, err
+		}
+		asgPlan := newASGPlan(asgInfo)
+		asgPlan.Nodes = nodes
+		plan.ASGs = append(plan.ASGs, asgPlan)
+	}
+	return plan, nil
+}
+
+// ValidatePlanFormat returns an error if the plan format is not supported. An empty format is the same as
+// TextPlanFormat.
+func ValidatePlanFormat(format PlanFormat) error {
+	switch format {
+	case JSONPlanFormat, TextPlanFormat, "":
+		return nil
+	}
+	return errors.WithStackTrace(UnsupportedPlanFormatErr{format: format})
+}
+
+// Write renders the plan to the given writer in the requested format.
+func (plan *Plan) Write(w io.Writer, format PlanFormat) error {
+	if err := ValidatePlanFormat(format); err != nil {
+		return err
+	}
+	switch format {
+	case JSONPlanFormat:
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		return errors.WithStackTrace(encoder.Encode(plan))
+	default:
+		_, err := io.WriteString(w, plan.text())
+		return errors.WithStackTrace(err)
+	}
+}
+
+// text renders the plan in a human readable format.
+func (plan *Plan) text() string {
+	var out strings.Builder
+	fmt.Fprintf(&out, "Plan for eks %s (no changes will be made)\n", plan.Command)
+	if plan.RolloutMode != "" && len(plan.ASGs) > 1 {
+		fmt.Fprintf(&out, "ASGs will be rolled out in %s\n", plan.RolloutMode)
+	}
+
+	for _, asg := range plan.ASGs {
+		fmt.Fprintf(&out, "\nASG %s\n", asg.Name)
+		fmt.Fprintf(&out, "  Current capacity: %d (max size %d)\n", asg.CurrentCapacity, asg.CurrentMaxSize)
+		if !asg.SteadyState {
+			out.WriteString("  ASG is not in steady state: will wait for the desired capacity to be reached first\n")
+		}
+		if plan.Command == "deploy" {
+			fmt.Fprintf(&out, "  Max size during roll out: %d (restored to %d afterwards)\n", asg.MaxSizeDuringRollout, asg.CurrentMaxSize)
+			fmt.Fprintf(&out, "  Max surge: %d, batch size: %d, waves: %d\n", asg.MaxSurge, asg.BatchSize, len(asg.Waves))
+			for _, wave := range asg.Waves {
+				fmt.Fprintf(
+					&out,
+					"  Wave %d: scale up to %d, then cordon, drain and terminate %d instances\n",
+					wave.Number,
+					wave.DesiredCapacity,
+					len(wave.Nodes),
+				)
+				writeNodePlans(&out, wave.Nodes, "    ")
+			}
+		} else {
+			fmt.Fprintf(&out, "  Cordon and drain %d instances\n", len(asg.Nodes))
+			writeNodePlans(&out, asg.Nodes, "    ")
+		}
+	}
+
+	if plan.Command == "deploy" {
+		out.WriteString("\nLoad balancers the new instances must register to:\n")
+		if len(plan.LoadBalancers) == 0 {
+			out.WriteString("  (none)\n")
+		}
+		for _, lb := range plan.LoadBalancers {
+			fmt.Fprintf(&out, "  - %s (type %s, target type %s)\n", lb.Name, lb.Type, lb.TargetType)
+		}
+	}
+	return out.String()
+}
+
+// writeNodePlans renders the list of nodes with the Pods that will be evicted from each.
+func writeNodePlans(out *strings.Builder, nodes []NodePlan, indent string) {
+	for _, node := range nodes {
+		fmt.Fprintf(out, "%s- %s (%s)\n", indent, node.InstanceID, node.NodeName)
+		if len(node.PodsToEvict) == 0 {
+			fmt.Fprintf(out, "%s    no Pods to evict\n", indent)
+		}
+		for _, pod := range node.PodsToEvict {
+			fmt.Fprintf(out, "%s    evict %s\n", indent, pod)
+		}
+	}
+}
+
+// newPlanClients constructs the AWS clients used for the read only lookups of a plan.
+func newPlanClients(region string) (*autoscaling.AutoScaling, *ec2.EC2, error) {
+	logger := logging.GetProjectLogger()
+	sess, err := eksawshelper.NewAuthenticatedSession(region)
+	if err != nil {
+		return nil, nil, errors.WithStackTrace(err)
+	}
+	logger.Infof("Successfully authenticated with AWS")
+	return autoscaling.New(sess), ec2.New(sess), nil
+}
+
+// newASGPlan constructs the ASGPlan with the current configuration of the ASG.
+func newASGPlan(asgInfo ASG) ASGPlan {
+	return ASGPlan{
+		Name:            asgInfo.Name,
+		CurrentCapacity: asgInfo.OriginalCapacity,
+		CurrentMaxSize:  asgInfo.OriginalMaxCapacity,
+		SteadyState:     asgInfo.OriginalCapacity == int64(len(asgInfo.OriginalInstances)),
+	}
+}
+
+// planNodes maps the instances to their Kubernetes node names, and looks up the Pods that would be evicted from each
+// node when it is drained.
+func planNodes(ec2Svc *ec2.EC2, kubectlOptions *kubectl.KubectlOptions, instanceIds []string) ([]NodePlan, error) {
+	nodes := []NodePlan{}
+	if len(instanceIds) == 0 {
+		return nodes, nil
+	}
+	instances, err := instanceDetailsFromIds(ec2Svc, instanceIds)
+	if err != nil {
+		return nil, err
+	}
+	nodeNames := kubeNodeNamesFromInstances(instances)
+	for i, instance := range instances {
+		nodeName := nodeNames[i]
+		pods, err := kubectl.ListPodsOnNode(kubectlOptions, nodeName)
+		if err != nil {
+			return nil, err
+		}
+		podNames := []string{}
+		for _, pod := range kubectl.FilterEvictablePods(pods) {
+			podNames = append(podNames, fmt.Sprintf("%s/%s", pod.Namespace, pod.Name))
+		}
+		nodes = append(nodes, NodePlan{
+			InstanceID:  aws.StringValue(instance.InstanceId),
+			NodeName:    nodeName,
+			PodsToEvict: podNames,
+		})
+	}
+	return nodes, nil
+}
+
+// selectNodePlans returns the node plans for the given instances, in the order of the instances.
+func selectNodePlans(nodes []NodePlan, instanceIds []string) []NodePlan {
+	out := []NodePlan{}
+	for _, instanceID := range instanceIds {
+		for _, node := range nodes {
+			if node.InstanceID == instanceID {
+				out = append(out, node)
+			}
+		}
+	}
+	return out
+}
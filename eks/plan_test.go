@@ -0,0 +1,90 @@
+package eks
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPlanWriteText(t *testing.T) {
+	t.Parallel()
+
+	var out bytes.Buffer
+	require.NoError(t, testDeployPlan().Write(&out, TextPlanFormat))
+	text := out.String()
+
+	assert.Contains(t, text, "Plan for eks deploy")
+	assert.Contains(t, text, "ASG my-test-asg")
+	assert.Contains(t, text, "Max size during roll out: 3")
+	assert.Contains(t, text, "Wave 1: scale up to 3, then cordon, drain and terminate 1 instances")
+	assert.Contains(t, text, "- i-1 (ip-10-0-0-1.ec2.internal)")
+	assert.Contains(t, text, "evict default/app-1")
+	assert.Contains(t, text, "- my-lb (type nlb, target type instance)")
+}
+
+func TestPlanWriteJSON(t *testing.T) {
+	t.Parallel()
+
+	var out bytes.Buffer
+	require.NoError(t, testDeployPlan().Write(&out, JSONPlanFormat))
+
+	var parsed Plan
+	require.NoError(t, json.Unmarshal(out.Bytes(), &parsed))
+	assert.Equal(t, *testDeployPlan(), parsed)
+}
+
+func TestPlanWriteUnknownFormat(t *testing.T) {
+	t.Parallel()
+
+	var out bytes.Buffer
+	assert.Error(t, testDeployPlan().Write(&out, "yaml"))
+	assert.Empty(t, out.String())
+	assert.Error(t, ValidatePlanFormat("yaml"))
+	assert.NoError(t, ValidatePlanFormat(""))
+}
+
+func TestSelectNodePlansKeepsInstanceOrder(t *testing.T) {
+	t.Parallel()
+
+	nodes := []NodePlan{{InstanceID: "i-1"}, {InstanceID: "i-2"}, {InstanceID: "i-3"}}
+	selected := selectNodePlans(nodes, []string{"i-3", "i-1"})
+	assert.Equal(t, []NodePlan{{InstanceID: "i-3"}, {InstanceID: "i-1"}}, selected)
+}
+
+func testDeployPlan() *Plan {
+	return &Plan{
+		Command:     "deploy",
+		RolloutMode: ParallelASGRollout,
+		ASGs: []ASGPlan{
+			{
+				Name:                 "my-test-asg",
+				CurrentCapacity:      2,
+				CurrentMaxSize:       2,
+				MaxSizeDuringRollout: 3,
+				MaxSurge:             1,
+				BatchSize:            1,
+				SteadyState:          true,
+				Waves: []WavePlan{
+					{
+						Number:          1,
+						DesiredCapacity: 3,
+						Nodes: []NodePlan{
+							{InstanceID: "i-1", NodeName: "ip-10-0-0-1.ec2.internal", PodsToEvict: []string{"default/app-1"}},
+						},
+					},
+					{
+						Number:          2,
+						DesiredCapacity: 3,
+						Nodes: []NodePlan{
+							{InstanceID: "i-2", NodeName: "ip-10-0-0-2.ec2.internal", PodsToEvict: []string{}},
+						},
+					},
+				},
+			},
+		},
+		LoadBalancers: []LoadBalancerPlan{{Name: "my-lb", Type: "nlb", TargetType: "instance"}},
+	}
+}
@@ -36,9 +36,14 @@ const (
 	maxEKSBuild = 100
 )
 
+// exactEKSBuildRE matches versions that already include the eksbuild number (e.g 1.12.4-eksbuild.3).
+var exactEKSBuildRE = regexp.MustCompile(`eksbuild\.[0-9]+$`)
+
 var (
+	// The following tables make up the built-in version catalog (see DefaultVersionCatalog), which can be extended with
+	// a catalog file.
 	// NOTE: Ensure that there is an entry for each supported version in the following tables.
-	supportedVersions = []string{"1.34", "1.33", "1.32", "1.31", "1.30", "1.29", "1.28", "1.27", "1.26", "1.25"}
+	supportedVersions = []string{"1.34", "1.33", "1.32", "1.31", "1.30", "1.29", "1.28", "1.27", "1.26"}
 
 	// Reference: https://docs.aws.amazon.com/eks/latest/userguide/managing-coredns.html
 	coreDNSVersionLookupTable = map[string]string{
@@ -111,8 +116,17 @@ const (
 	corednsConfigMapName      = "coredns"
 	corednsConfigMapConfigKey = "Corefile"
 
+	vpcCNIDaemonSetName   = "aws-node"
+	vpcCNIContainerName   = "aws-node"
+	vpcCNIClusterRoleName = "aws-node"
+
 	endpointslicesAPIGroup = "discovery.k8s.io"
 	endpointslicesResource = "endpointslices"
+
+	// The upstream keyword was removed from the coredns configuration starting with this version.
+	corednsUpstreamRemovedVersion = "1.7.0-eksbuild.1"
+	// coredns requires permissions to list and watch endpoint slices starting with this version.
+	corednsEndpointSlicesVersion = "1.8.3-eksbuild.1"
 )
 
 // SkipComponentsConfig represents the components that should be skipped in the sync command.
@@ -145,42 +159,20 @@ func SyncClusterComponents(
 	shouldWait bool,
 	waitTimeout string,
 	skipConfig SkipComponentsConfig,
+	catalog *VersionCatalog,
+	pins ComponentVersionPins,
+	snapshotDir string,
 ) error {
 	logger := logging.GetProjectLogger()
 
-	logger.Info("Looking up deployed Kubernetes version")
-	clusterInfo, err := eksawshelper.GetClusterByArn(eksClusterArn)
+	awsRegion, targetVersions, err := getTargetComponentVersions(eksClusterArn, catalog, pins)
 	if err != nil {
 		return err
 	}
-	k8sVersion := aws.StringValue(clusterInfo.Version)
-
-	if !collections.ListContainsElement(supportedVersions, k8sVersion) {
-		return errors.WithStackTrace(UnsupportedEKSVersion{k8sVersion})
-	}
-
-	awsRegion, err := eksawshelper.GetRegionFromArn(eksClusterArn)
-	if err != nil {
-		return err
-	}
-
-	dockerToken, err := eksawshelper.GetDockerLoginToken(awsRegion)
-	if err != nil {
-		return err
-	}
-
-	repoDomain := getRepoDomain(awsRegion)
-	kubeProxyVersion, err := findLatestEKSBuild(dockerToken, repoDomain, kubeProxyRepoPath, kubeProxyVersionLookupTable[k8sVersion])
-	if err != nil {
-		return err
-	}
-
-	coreDNSVersion, err := findLatestEKSBuild(dockerToken, repoDomain, coreDNSRepoPath, coreDNSVersionLookupTable[k8sVersion])
-	if err != nil {
-		return err
-	}
-
-	amznVPCCNIVersion := amazonVPCCNIVersionLookupTable[k8sVersion]
+	repoDomain := catalog.getRepoDomain(awsRegion)
+	kubeProxyVersion := targetVersions.kubeProxy
+	coreDNSVersion := targetVersions.coreDNS
+	amznVPCCNIVersion := targetVersions.vpcCNI
 
 	logger.Info("Syncing Kubernetes Applications to:")
 	if !skipConfig.KubeProxy {
@@ -199,10 +191,19 @@ func SyncClusterComponents(
 		return err
 	}
 
+	// Snapshot the core components before changing them, so that a failed upgrade can be rolled back.
+	snapshotStore := NewCoreComponentsSnapshotStore(snapshotDir, eksClusterArn, clientset)
+	snapshot, err := saveCoreComponentsSnapshot(snapshotStore, clientset, eksClusterArn, skipConfig)
+	if err != nil {
+		return err
+	}
+	logger.Infof("Saved snapshot %s of the core components to %s.", snapshot.ID, snapshotStore.Location())
+	logger.Infof("If the sync breaks the cluster, run kubergrunt eks rollback-core-components --snapshot-id %s to restore it.", snapshot.ID)
+
 	if skipConfig.KubeProxy {
 		logger.Info("Skipping kube-proxy sync.")
 	} else {
-		if err := upgradeKubeProxy(kubectlOptions, clientset, awsRegion, kubeProxyVersion, shouldWait, waitTimeout); err != nil {
+		if err := upgradeKubeProxy(kubectlOptions, clientset, repoDomain, kubeProxyVersion, shouldWait, waitTimeout); err != nil {
 			return err
 		}
 	}
@@ -210,7 +211,7 @@ func SyncClusterComponents(
 	if skipConfig.CoreDNS {
 		logger.Info("Skipping coredns sync.")
 	} else {
-		if err := upgradeCoreDNS(kubectlOptions, clientset, awsRegion, coreDNSVersion, shouldWait, waitTimeout); err != nil {
+		if err := upgradeCoreDNS(kubectlOptions, clientset, repoDomain, coreDNSVersion, shouldWait, waitTimeout); err != nil {
 			return err
 		}
 	}
@@ -227,19 +228,75 @@ func SyncClusterComponents(
 	return nil
 }
 
+// getTargetComponentVersions looks up the Kubernetes version of the EKS cluster, and returns the region of the cluster
+// along with the versions of the core components that are expected for that Kubernetes version according to the
+// catalog. Pinned versions are used as is.
+func getTargetComponentVersions(eksClusterArn string, catalog *VersionCatalog, pins ComponentVersionPins) (string, componentVersions, error) {
+	logger := logging.GetProjectLogger()
+
+	awsRegion, err := eksawshelper.GetRegionFromArn(eksClusterArn)
+	if err != nil {
+		return "", componentVersions{}, err
+	}
+
+	versions := componentVersions{
+		kubeProxy: strings.TrimPrefix(pins.KubeProxy, "v"),
+		coreDNS:   strings.TrimPrefix(pins.CoreDNS, "v"),
+		vpcCNI:    strings.TrimPrefix(pins.VPCCNI, "v"),
+	}
+	if versions.kubeProxy != "" && versions.coreDNS != "" && versions.vpcCNI != "" {
+		logger.Info("All core component versions are pinned. Skipping version lookup.")
+		return awsRegion, versions, nil
+	}
+
+	logger.Info("Looking up deployed Kubernetes version")
+	clusterInfo, err := eksawshelper.GetClusterByArn(eksClusterArn)
+	if err != nil {
+		return "", componentVersions{}, err
+	}
+	k8sVersion := aws.StringValue(clusterInfo.Version)
+
+	if !catalog.IsSupportedVersion(k8sVersion) {
+		return "", componentVersions{}, errors.WithStackTrace(UnsupportedEKSVersion{k8sVersion})
+	}
+
+	dockerToken, err := eksawshelper.GetDockerLoginToken(awsRegion)
+	if err != nil {
+		return "", componentVersions{}, err
+	}
+
+	repoDomain := catalog.getRepoDomain(awsRegion)
+	if versions.kubeProxy == "" {
+		versions.kubeProxy, err = findLatestEKSBuild(dockerToken, repoDomain, kubeProxyRepoPath, catalog.KubeProxyVersions[k8sVersion])
+		if err != nil {
+			return "", componentVersions{}, err
+		}
+	}
+	if versions.coreDNS == "" {
+		versions.coreDNS, err = findLatestEKSBuild(dockerToken, repoDomain, coreDNSRepoPath, catalog.CoreDNSVersions[k8sVersion])
+		if err != nil {
+			return "", componentVersions{}, err
+		}
+	}
+	if versions.vpcCNI == "" {
+		versions.vpcCNI = catalog.VPCCNIVersions[k8sVersion]
+	}
+	return awsRegion, versions, nil
+}
+
 // upgradeKubeProxy will update to the latest kube-proxy version if necessary. If shouldWait is set to true, this
 // routine will wait until the new images are fully rolled out before continuing.
 func upgradeKubeProxy(
 	kubectlOptions *kubectl.KubectlOptions,
 	clientset *kubernetes.Clientset,
-	awsRegion string,
+	repoDomain string,
 	kubeProxyVersion string,
 	shouldWait bool,
 	waitTimeout string,
 ) error {
 	logger := logging.GetProjectLogger()
 
-	targetImage := fmt.Sprintf("%s/%s:v%s", getRepoDomain(awsRegion), kubeProxyRepoPath, kubeProxyVersion)
+	targetImage := getKubeProxyTargetImage(repoDomain, kubeProxyVersion)
 	currentImage, err := getCurrentDeployedKubeProxyImage(clientset)
 	if err != nil {
 		return err
@@ -270,8 +327,13 @@ func upgradeKubeProxy(
 	return nil
 }
 
+// getKubeProxyTargetImage returns the kube-proxy container image for the given version.
+func getKubeProxyTargetImage(repoDomain string, kubeProxyVersion string) string {
+	return fmt.Sprintf("%s/%s:v%s", repoDomain, kubeProxyRepoPath, kubeProxyVersion)
+}
+
 // getCurrentDeployedKubeProxyImage will return the currently configured kube-proxy image on the daemonset.
-func getCurrentDeployedKubeProxyImage(clientset *kubernetes.Clientset) (string, error) {
+func getCurrentDeployedKubeProxyImage(clientset kubernetes.Interface) (string, error) {
 	daemonset, err := clientset.AppsV1().DaemonSets(componentNamespace).Get(context.Background(), kubeProxyDaemonSetName, metav1.GetOptions{})
 	if err != nil {
 		return "", errors.WithStackTrace(err)
@@ -314,7 +376,7 @@ func updateKubeProxyDaemonsetImage(clientset *kubernetes.Clientset, targetImage
 func upgradeCoreDNS(
 	kubectlOptions *kubectl.KubectlOptions,
 	clientset *kubernetes.Clientset,
-	awsRegion string,
+	repoDomain string,
 	coreDNSVersion string,
 	shouldWait bool,
 	waitTimeout string,
@@ -324,7 +386,7 @@ func upgradeCoreDNS(
 	logger.Info("Confirming compatibility of coredns configuration with latest version.")
 	// Need to check config for backwards incompatibility if updating to version >= 1.7.0. The keyword `upstream` was
 	// removed in 1.7 series of coredns, but is used in earlier versions.
-	compareVal170, err := semverStringCompare(coreDNSVersion, "1.7.0-eksbuild.1")
+	compareVal170, err := semverStringCompare(coreDNSVersion, corednsUpstreamRemovedVersion)
 	if err != nil {
 		return err
 	}
@@ -340,7 +402,7 @@ func upgradeCoreDNS(
 	logger.Info("Confirming compatibility of coredns permissions with latest version.")
 	// Need to check permissions compatibility if updating to version >= 1.8.3. Starting with 1.8.3, coredns requires
 	// permissions to list and watch endpoint slices.
-	compareVal183, err := semverStringCompare(coreDNSVersion, "1.8.3-eksbuild.1")
+	compareVal183, err := semverStringCompare(coreDNSVersion, corednsEndpointSlicesVersion)
 	if err != nil {
 		return err
 	}
@@ -352,7 +414,7 @@ func upgradeCoreDNS(
 		logger.Info("ClusterRole permissions for coredns is up to date. Skipping adjusting ClusterRole permissions.")
 	}
 
-	targetImage := fmt.Sprintf("%s/%s:v%s", getRepoDomain(awsRegion), coreDNSRepoPath, coreDNSVersion)
+	targetImage := getCoreDNSTargetImage(repoDomain, coreDNSVersion)
 	currentImage, err := getCurrentDeployedCoreDNSImage(clientset)
 	if err != nil {
 		return err
@@ -410,14 +472,29 @@ func updateCorednsPermissionsFor183Compatibility(clientset *kubernetes.Clientset
 	if err != nil {
 		return err
 	}
-	// Check if any of the policy rules overlap with list/watch permissions for endpointslices
-	hasListEndpointSlicesRule, hasWatchEndpointSlicesRule := hasEndpointSlicesPermissions(corednsClusterRole.Rules)
-	if hasListEndpointSlicesRule && hasWatchEndpointSlicesRule {
+	newRule := getMissingEndpointSlicesRule(corednsClusterRole.Rules)
+	if newRule == nil {
 		// Already have the necessary permissions, so do nothing
 		return nil
 	}
 
 	logger.Info("coredns ClusterRole does not have enough permissions for 1.8.3. Updating ClusterRole.")
+	corednsClusterRole.Rules = append(corednsClusterRole.Rules, *newRule)
+
+	// Now save the updated ClusterRole
+	clusterRoleAPI := clientset.RbacV1().ClusterRoles()
+	_, err = clusterRoleAPI.Update(context.Background(), corednsClusterRole, metav1.UpdateOptions{})
+	return errors.WithStackTrace(err)
+}
+
+// getMissingEndpointSlicesRule returns the rule that needs to be added to the given coredns ClusterRole rules for
+// coredns to be able to list and watch endpointslices, or nil if the rules already provide these permissions.
+func getMissingEndpointSlicesRule(rules []rbacv1.PolicyRule) *rbacv1.PolicyRule {
+	// Check if any of the policy rules overlap with list/watch permissions for endpointslices
+	hasListEndpointSlicesRule, hasWatchEndpointSlicesRule := hasEndpointSlicesPermissions(rules)
+	if hasListEndpointSlicesRule && hasWatchEndpointSlicesRule {
+		return nil
+	}
 
 	// Construct new rule that contains the necessary permissions
 	newRule := rbacv1.PolicyRule{
@@ -430,12 +507,7 @@ func updateCorednsPermissionsFor183Compatibility(clientset *kubernetes.Clientset
 	if !hasWatchEndpointSlicesRule {
 		newRule.Verbs = append(newRule.Verbs, "watch")
 	}
-	corednsClusterRole.Rules = append(corednsClusterRole.Rules, newRule)
-
-	// Now save the updated ClusterRole
-	clusterRoleAPI := clientset.RbacV1().ClusterRoles()
-	_, err = clusterRoleAPI.Update(context.Background(), corednsClusterRole, metav1.UpdateOptions{})
-	return errors.WithStackTrace(err)
+	return &newRule
 }
 
 // hasEndpointSlicesPermissions checks if the given rules contain the rule for providing list and watch permissions to
@@ -459,8 +531,13 @@ func hasEndpointSlicesPermissions(rules []rbacv1.PolicyRule) (bool, bool) {
 	return hasListEndpointSlicesRule, hasWatchEndpointSlicesRule
 }
 
+// getCoreDNSTargetImage returns the coredns container image for the given version.
+func getCoreDNSTargetImage(repoDomain string, coreDNSVersion string) string {
+	return fmt.Sprintf("%s/%s:v%s", repoDomain, coreDNSRepoPath, coreDNSVersion)
+}
+
 // getCurrentDeployedCoreDNSImage will return the currently configured coredns image on the deployment.
-func getCurrentDeployedCoreDNSImage(clientset *kubernetes.Clientset) (string, error) {
+func getCurrentDeployedCoreDNSImage(clientset kubernetes.Interface) (string, error) {
 	deployment, err := clientset.AppsV1().Deployments(componentNamespace).Get(context.Background(), corednsDeploymentName, metav1.GetOptions{})
 	if err != nil {
 		return "", errors.WithStackTrace(err)
@@ -499,7 +576,7 @@ func updateCoreDNSDeploymentImage(clientset *kubernetes.Clientset, targetImage s
 }
 
 // getCorednsConfigMap returns the configmap object containing the coredns configuration for the EKS cluster.
-func getCorednsConfigMap(clientset *kubernetes.Clientset) (*corev1.ConfigMap, error) {
+func getCorednsConfigMap(clientset kubernetes.Interface) (*corev1.ConfigMap, error) {
 	configMapAPI := clientset.CoreV1().ConfigMaps(componentNamespace)
 	configMap, err := configMapAPI.Get(context.Background(), corednsConfigMapName, metav1.GetOptions{})
 	if err != nil {
@@ -509,7 +586,7 @@ func getCorednsConfigMap(clientset *kubernetes.Clientset) (*corev1.ConfigMap, er
 }
 
 // getCorednsClusterRole returns the ClusterRole object for coredns.
-func getCorednsClusterRole(clientset *kubernetes.Clientset) (*rbacv1.ClusterRole, error) {
+func getCorednsClusterRole(clientset kubernetes.Interface) (*rbacv1.ClusterRole, error) {
 	clusterRoleAPI := clientset.RbacV1().ClusterRoles()
 	corednsClusterRole, err := clusterRoleAPI.Get(context.Background(), corednsClusterRoleName, metav1.GetOptions{})
 	if err != nil {
@@ -518,6 +595,27 @@ func getCorednsClusterRole(clientset *kubernetes.Clientset) (*rbacv1.ClusterRole
 	return corednsClusterRole, nil
 }
 
+// getCurrentDeployedVPCCNIImage will return the currently configured VPC CNI plugin image on the aws-node daemonset.
+// Newer versions of the daemonset run additional containers (e.g the network policy agent), so this looks up the
+// container by name.
+func getCurrentDeployedVPCCNIImage(clientset kubernetes.Interface) (string, error) {
+	daemonset, err := clientset.AppsV1().DaemonSets(componentNamespace).Get(context.Background(), vpcCNIDaemonSetName, metav1.GetOptions{})
+	if err != nil {
+		return "", errors.WithStackTrace(err)
+	}
+
+	for _, container := range daemonset.Spec.Template.Spec.Containers {
+		if container.Name == vpcCNIContainerName {
+			return container.Image, nil
+		}
+	}
+	err = CoreComponentUnexpectedConfigurationErr{
+		component: "aws-vpc-cni",
+		reason:    fmt.Sprintf("could not find container %s", vpcCNIContainerName),
+	}
+	return "", errors.WithStackTrace(err)
+}
+
 // getBaseURLForVPCCNIManifest returns the base github URL where the manifest for the VPC CNI is located given the
 // requested version.
 func getBaseURLForVPCCNIManifest(vpcCNIVersion string) (string, error) {
@@ -530,39 +628,47 @@ func getBaseURLForVPCCNIManifest(vpcCNIVersion string) (string, error) {
 // daemonset, and thus it is better to apply the manifests directly using kubectl than to translate it into underlying
 // API calls.
 func updateVPCCNI(kubectlOptions *kubectl.KubectlOptions, region string, vpcCNIVersion string) error {
-	var manifestPath string
+	workingDir, err := ioutil.TempDir("", "kubergrunt-sync")
+	if err != nil {
+		return errors.WithStackTrace(err)
+	}
+	defer os.RemoveAll(workingDir)
+
+	manifestPath, err := getVPCCNIManifestPath(region, vpcCNIVersion, workingDir)
+	if err != nil {
+		return err
+	}
+	return kubectl.RunKubectl(kubectlOptions, "apply", "-f", manifestPath)
+}
 
+// getVPCCNIManifestPath returns the path to the manifest of the target AWS VPC CNI version for the given region. This
+// is either the URL of the manifest, or a path in workingDir when the manifest needs to be updated for the region.
+func getVPCCNIManifestPath(region string, vpcCNIVersion string, workingDir string) (string, error) {
 	// Figure out the manifest URL based on region
 	// Reference: https://docs.aws.amazon.com/eks/latest/userguide/update-cluster.html
 	baseURL, err := getBaseURLForVPCCNIManifest(vpcCNIVersion)
 	if err != nil {
-		return err
+		return "", err
 	}
 	if strings.HasPrefix(region, "cn-") {
-		manifestPath = baseURL + "aws-k8s-cni-cn.yaml"
+		return baseURL + "aws-k8s-cni-cn.yaml", nil
 	} else if region == "us-gov-east-1" {
-		manifestPath = baseURL + "aws-k8s-cni-us-gov-east-1.yaml"
+		return baseURL + "aws-k8s-cni-us-gov-east-1.yaml", nil
 	} else if region == "us-gov-west-1" {
-		manifestPath = baseURL + "aws-k8s-cni-us-gov-west-1.yaml"
+		return baseURL + "aws-k8s-cni-us-gov-west-1.yaml", nil
 	} else if region == "us-west-2" {
-		manifestPath = baseURL + "aws-k8s-cni.yaml"
-	} else {
-		// This is technically the same manifest as us-west-2, but we need to replace references to us-west-2 with the
-		// appropriate region, so we need to first download the manifest to a temporary dir and update the region before
-		// applying.
-		workingDir, err := ioutil.TempDir("", "kubergrunt-sync")
-		if err != nil {
-			return err
-		}
-		defer os.RemoveAll(workingDir)
-		manifestPath = filepath.Join(workingDir, "aws-k8s-cni.yaml")
+		return baseURL + "aws-k8s-cni.yaml", nil
+	}
 
-		manifestURL := baseURL + "aws-k8s-cni.yaml"
-		if err := downloadVPCCNIManifestAndUpdateRegion(manifestURL, manifestPath, region); err != nil {
-			return err
-		}
+	// This is technically the same manifest as us-west-2, but we need to replace references to us-west-2 with the
+	// appropriate region, so we need to first download the manifest to the working dir and update the region before
+	// applying.
+	manifestPath := filepath.Join(workingDir, "aws-k8s-cni.yaml")
+	manifestURL := baseURL + "aws-k8s-cni.yaml"
+	if err := downloadVPCCNIManifestAndUpdateRegion(manifestURL, manifestPath, region); err != nil {
+		return "", err
 	}
-	return kubectl.RunKubectl(kubectlOptions, "apply", "-f", manifestPath)
+	return manifestPath, nil
 }
 
 // downloadVPCCNIManifestAndUpdateRegion will download the VPC CNI Kubernetes manifest at the given URL, update the
@@ -618,15 +724,10 @@ func semverStringCompare(v1 string, v2 string) (int, error) {
 // removeUpstreamKeywordFromCorednsConfigMap removes the upstream keyword from the CoreDNS ConfigMap config data and
 // saves it on the cluster.
 func removeUpstreamKeywordFromCorednsConfigMap(clientset *kubernetes.Clientset, corednsConfigMap *corev1.ConfigMap) error {
-	configData := corednsConfigMap.Data[corednsConfigMapConfigKey]
-
-	// Remove the line containing "upstream". Since this can appear in any nested block, we use regex to handle the
-	// whitespace during the removal.
-	lookForUpstreamRE, err := regexp.Compile(`[\s]+upstream[\t\r\n]+`)
+	newConfigData, err := removeUpstreamKeyword(corednsConfigMap.Data[corednsConfigMapConfigKey])
 	if err != nil {
-		return errors.WithStackTrace(err)
+		return err
 	}
-	newConfigData := lookForUpstreamRE.ReplaceAllString(configData, "\n")
 	corednsConfigMap.Data[corednsConfigMapConfigKey] = newConfigData
 
 	// Now save the new configmap
@@ -635,8 +736,21 @@ func removeUpstreamKeywordFromCorednsConfigMap(clientset *kubernetes.Clientset,
 	return errors.WithStackTrace(err)
 }
 
+// removeUpstreamKeyword returns the given coredns config data (Corefile) with the lines containing the upstream keyword
+// removed.
+func removeUpstreamKeyword(configData string) (string, error) {
+	// Remove the line containing "upstream". Since this can appear in any nested block, we use regex to handle the
+	// whitespace during the removal.
+	lookForUpstreamRE, err := regexp.Compile(`[\s]+upstream[\t\r\n]+`)
+	if err != nil {
+		return "", errors.WithStackTrace(err)
+	}
+	return lookForUpstreamRE.ReplaceAllString(configData, "\n"), nil
+}
+
 // findLatestEKSBuild will continuously query the ECR repo to look for the latest eksbuild version. We do this by
-// incrementally checking one tag at a time until we reach a 404, or the maximum trials.
+// incrementally checking one tag at a time until we reach a 404, or the maximum trials. Versions that already include
+// the eksbuild number are returned as is.
 func findLatestEKSBuild(token, repoDomain, repoPath, tagBase string) (string, error) {
 	logger := logging.GetProjectLogger()
 	logger.Debugf("Looking up latest eksbuild for repo %s/%s", repoDomain, repoPath)
@@ -645,6 +759,10 @@ func findLatestEKSBuild(token, repoDomain, repoPath, tagBase string) (string, er
 		logger.Debugf("Not an eksbuild for repo %s/%s, returning %s", repoDomain, repoPath, tagBase)
 		return tagBase, nil
 	}
+	if exactEKSBuildRE.MatchString(tagBase) {
+		logger.Debugf("Exact eksbuild for repo %s/%s, returning %s", repoDomain, repoPath, tagBase)
+		return tagBase, nil
+	}
 
 	var existingTag string
 	consecutiveMisses := 0
@@ -683,12 +801,3 @@ func findLatestEKSBuild(token, repoDomain, repoPath, tagBase string) (string, er
 	// No tags found at all - this indicates the base version in the lookup table may be incorrect
 	return "", errors.WithStackTrace(fmt.Errorf("no eksbuild tags found for base version %s in repo %s/%s", tagBase, repoDomain, repoPath))
 }
-
-// getRepoDomain is a conveniency function to construct the ECR docker repo URL domain.
-func getRepoDomain(region string) string {
-	containerAccountID := defaultContainerImageAccount
-	if id, ok := containerImageAccountLookupTable[region]; ok {
-		containerAccountID = id
-	}
-	return fmt.Sprintf("%s.dkr.ecr.%s.amazonaws.com", containerAccountID, region)
-}
@@ -0,0 +1,311 @@
+package eks
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	"github.com/gruntwork-io/go-commons/errors"
+	"github.com/pmezard/go-difflib/difflib"
+	rbacv1 "k8s.io/api/rbac/v1"
+	"k8s.io/client-go/kubernetes"
+
+	"github.com/gruntwork-io/kubergrunt/kubectl"
+	"github.com/gruntwork-io/kubergrunt/logging"
+)
+
+const (
+	kubeProxyComponentName = "kube-proxy"
+	coreDNSComponentName   = "coredns"
+	vpcCNIComponentName    = "aws-vpc-cni"
+)
+
+// SyncPlan describes the changes that sync-core-components would make to the core components of an EKS cluster,
+// without making any changes.
+type SyncPlan struct {
+	Components []ComponentSyncPlan
+}
+
+// ComponentSyncPlan describes the changes that would be made to a single core component. Patches lists the
+// compatibility patches that would be applied to the configuration of the component, while ManifestDiff is the diff of
+// the manifest that would be applied (only set for the VPC CNI plugin).
+type ComponentSyncPlan struct {
+	Name         string
+	CurrentImage string
+	TargetImage  string
+	Patches      []ComponentPatch
+	ManifestDiff string
+
+	// Drifted is true when the component does not match the expected configuration, and thus would be updated.
+	Drifted bool
+}
+
+// ComponentPatch describes a compatibility patch that would be applied to the configuration of a core component.
+type ComponentPatch struct {
+	Description string
+	Diff        string
+}
+
+// PlanSyncClusterComponents runs all the read only lookups of SyncClusterComponents and returns the plan of what the
+// sync would change, without making any changes. The VPC CNI plugin manifest is compared against the cluster with
+// kubectl diff, which does a server side dry run of applying the manifest.
+func PlanSyncClusterComponents(
+	eksClusterArn string,
+	skipConfig SkipComponentsConfig,
+	catalog *VersionCatalog,
+	pins ComponentVersionPins,
+) (*SyncPlan, error) {
+	logger := logging.GetProjectLogger()
+
+	awsRegion, targetVersions, err := getTargetComponentVersions(eksClusterArn, catalog, pins)
+	if err != nil {
+		return nil, err
+	}
+	repoDomain := catalog.getRepoDomain(awsRegion)
+
+	kubectlOptions := &kubectl.KubectlOptions{EKSClusterArn: eksClusterArn}
+	clientset, err := kubectl.GetKubernetesClientFromOptions(kubectlOptions)
+	if err != nil {
+		return nil, err
+	}
+
+	plan := &SyncPlan{Components: []ComponentSyncPlan{}}
+	if skipConfig.KubeProxy {
+		logger.Info("Skipping kube-proxy sync.")
+	} else {
+		componentPlan, err := planKubeProxySync(clientset, getKubeProxyTargetImage(repoDomain, targetVersions.kubeProxy))
+		if err != nil {
+			return nil, err
+		}
+		plan.Components = append(plan.Components, *componentPlan)
+	}
+
+	if skipConfig.CoreDNS {
+		logger.Info("Skipping coredns sync.")
+	} else {
+		componentPlan, err := planCoreDNSSync(clientset, repoDomain, targetVersions.coreDNS)
+		if err != nil {
+			return nil, err
+		}
+		plan.Components = append(plan.Components, *componentPlan)
+	}
+
+	if skipConfig.VPCCNI {
+		logger.Info("Skipping aws-vpc-cni.")
+	} else {
+		componentPlan, err := planVPCCNISync(kubectlOptions, clientset, awsRegion, targetVersions.vpcCNI)
+		if err != nil {
+			return nil, err
+		}
+		plan.Components = append(plan.Components, *componentPlan)
+	}
+	return plan, nil
+}
+
+// planKubeProxySync returns the plan for syncing the kube-proxy DaemonSet to the target image.
+func planKubeProxySync(clientset kubernetes.Interface, targetImage string) (*ComponentSyncPlan, error) {
+	currentImage, err := getCurrentDeployedKubeProxyImage(clientset)
+	if err != nil {
+		return nil, err
+	}
+	componentPlan := &ComponentSyncPlan{
+		Name:         kubeProxyComponentName,
+		CurrentImage: currentImage,
+		TargetImage:  targetImage,
+		Patches:      []ComponentPatch{},
+		Drifted:      currentImage != targetImage,
+	}
+	return componentPlan, nil
+}
+
+// planCoreDNSSync returns the plan for syncing the coredns Deployment to the given version, including the Corefile and
+// ClusterRole compatibility patches that upgradeCoreDNS would apply.
+func planCoreDNSSync(clientset kubernetes.Interface, repoDomain string, coreDNSVersion string) (*ComponentSyncPlan, error) {
+	targetImage := getCoreDNSTargetImage(repoDomain, coreDNSVersion)
+	currentImage, err := getCurrentDeployedCoreDNSImage(clientset)
+	if err != nil {
+		return nil, err
+	}
+	componentPlan := &ComponentSyncPlan{
+		Name:         coreDNSComponentName,
+		CurrentImage: currentImage,
+		TargetImage:  targetImage,
+		Patches:      []ComponentPatch{},
+	}
+
+	compareVal170, err := semverStringCompare(coreDNSVersion, corednsUpstreamRemovedVersion)
+	if err != nil {
+		return nil, err
+	}
+	if compareVal170 >= 0 {
+		corednsConfigMap, err := getCorednsConfigMap(clientset)
+		if err != nil {
+			return nil, err
+		}
+		patch, err := planCorednsConfigMapFor170Compatibility(corednsConfigMap.Data[corednsConfigMapConfigKey])
+		if err != nil {
+			return nil, err
+		}
+		if patch != nil {
+			componentPlan.Patches = append(componentPlan.Patches, *patch)
+		}
+	}
+
+	compareVal183, err := semverStringCompare(coreDNSVersion, corednsEndpointSlicesVersion)
+	if err != nil {
+		return nil, err
+	}
+	if compareVal183 >= 0 {
+		corednsClusterRole, err := getCorednsClusterRole(clientset)
+		if err != nil {
+			return nil, err
+		}
+		if newRule := getMissingEndpointSlicesRule(corednsClusterRole.Rules); newRule != nil {
+			componentPlan.Patches = append(componentPlan.Patches, ComponentPatch{
+				Description: fmt.Sprintf("Add a rule to ClusterRole %s, which coredns needs starting with 1.8.3", corednsClusterRoleName),
+				Diff:        "+ " + formatPolicyRule(*newRule),
+			})
+		}
+	}
+
+	componentPlan.Drifted = currentImage != targetImage || len(componentPlan.Patches) > 0
+	return componentPlan, nil
+}
+
+// planCorednsConfigMapFor170Compatibility returns the patch that removes the upstream keyword from the given Corefile,
+// or nil if the Corefile does not use the keyword.
+func planCorednsConfigMapFor170Compatibility(corefile string) (*ComponentPatch, error) {
+	if !strings.Contains(corefile, "upstream") {
+		return nil, nil
+	}
+	newCorefile, err := removeUpstreamKeyword(corefile)
+	if err != nil {
+		return nil, err
+	}
+	diff, err := difflib.GetUnifiedDiffString(difflib.UnifiedDiff{
+		A:        difflib.SplitLines(corefile),
+		B:        difflib.SplitLines(newCorefile),
+		FromFile: "Corefile (current)",
+		ToFile:   "Corefile (patched)",
+		Context:  3,
+	})
+	if err != nil {
+		return nil, errors.WithStackTrace(err)
+	}
+	patch := &ComponentPatch{
+		Description: fmt.Sprintf("Remove the upstream keyword from the Corefile in ConfigMap %s, which is not supported starting with coredns 1.7.0", corednsConfigMapName),
+		Diff:        diff,
+	}
+	return patch, nil
+}
+
+// planVPCCNISync returns the plan for syncing the VPC CNI plugin to the given version, which includes the diff of the
+// manifest that updateVPCCNI would apply.
+func planVPCCNISync(
+	kubectlOptions *kubectl.KubectlOptions,
+	clientset kubernetes.Interface,
+	awsRegion string,
+	vpcCNIVersion string,
+) (*ComponentSyncPlan, error) {
+	currentImage, err := getCurrentDeployedVPCCNIImage(clientset)
+	if err != nil {
+		return nil, err
+	}
+
+	workingDir, err := ioutil.TempDir("", "kubergrunt-sync")
+	if err != nil {
+		return nil, errors.WithStackTrace(err)
+	}
+	defer os.RemoveAll(workingDir)
+
+	manifestPath, err := getVPCCNIManifestPath(awsRegion, vpcCNIVersion, workingDir)
+	if err != nil {
+		return nil, err
+	}
+	diff, hasDiff, err := kubectl.RunKubectlDiff(kubectlOptions, "-f", manifestPath)
+	if err != nil {
+		return nil, err
+	}
+
+	manifestSource := manifestPath
+	if strings.HasPrefix(manifestPath, workingDir) {
+		// The downloaded manifest is removed once we are done, so point to the source manifest instead.
+		baseURL, err := getBaseURLForVPCCNIManifest(vpcCNIVersion)
+		if err != nil {
+			return nil, err
+		}
+		manifestSource = fmt.Sprintf("%saws-k8s-cni.yaml, updated for region %s", baseURL, awsRegion)
+	}
+
+	componentPlan := &ComponentSyncPlan{
+		Name:         vpcCNIComponentName,
+		CurrentImage: currentImage,
+		// The image is defined in the manifest, which uses the same version for the amazon-k8s-cni image.
+		TargetImage:  fmt.Sprintf("amazon-k8s-cni:v%s (from %s)", vpcCNIVersion, manifestSource),
+		Patches:      []ComponentPatch{},
+		ManifestDiff: diff,
+		Drifted:      hasDiff,
+	}
+	return componentPlan, nil
+}
+
+// formatPolicyRule returns a single line representation of the given RBAC rule.
+func formatPolicyRule(rule rbacv1.PolicyRule) string {
+	return fmt.Sprintf(
+		"apiGroups: [%s], resources: [%s], verbs: [%s]",
+		strings.Join(rule.APIGroups, ", "),
+		strings.Join(rule.Resources, ", "),
+		strings.Join(rule.Verbs, ", "),
+	)
+}
+
+// DriftedComponents returns the names of the components that do not match the expected configuration.
+func (plan *SyncPlan) DriftedComponents() []string {
+	drifted := []string{}
+	for _, component := range plan.Components {
+		if component.Drifted {
+			drifted = append(drifted, component.Name)
+		}
+	}
+	return drifted
+}
+
+// Write prints the plan in a human readable format to the given writer.
+func (plan *SyncPlan) Write(out io.Writer) error {
+	var builder strings.Builder
+	for _, component := range plan.Components {
+		status := "up to date"
+		if component.Drifted {
+			status = "drifted"
+		}
+		fmt.Fprintf(&builder, "%s (%s):\n", component.Name, status)
+		fmt.Fprintf(&builder, "  Current image: %s\n", component.CurrentImage)
+		fmt.Fprintf(&builder, "  Target image:  %s\n", component.TargetImage)
+		for _, patch := range component.Patches {
+			fmt.Fprintf(&builder, "  Patch: %s\n", patch.Description)
+			builder.WriteString(indent(patch.Diff, "    "))
+		}
+		if component.ManifestDiff != "" {
+			builder.WriteString("  Manifest diff:\n")
+			builder.WriteString(indent(component.ManifestDiff, "    "))
+		}
+		builder.WriteString("\n")
+	}
+
+	drifted := plan.DriftedComponents()
+	if len(drifted) == 0 {
+		builder.WriteString("All core components are up to date.\n")
+	} else {
+		fmt.Fprintf(&builder, "Core components that would be updated: %s\n", strings.Join(drifted, ", "))
+	}
+	_, err := io.WriteString(out, builder.String())
+	return errors.WithStackTrace(err)
+}
+
+// indent prefixes each line of the given text with the given prefix, making sure that the text ends with a newline.
+func indent(text string, prefix string) string {
+	lines := strings.Split(strings.TrimRight(text, "\n"), "\n")
+	return prefix + strings.Join(lines, "\n"+prefix) + "\n"
+}
@@ -0,0 +1,203 @@
+package eks
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestPlanKubeProxySync(t *testing.T) {
+	t.Parallel()
+
+	repoDomain := DefaultVersionCatalog().getRepoDomain("us-east-1")
+	clientset := fake.NewSimpleClientset(
+		newSyncPlanTestDaemonSet(kubeProxyDaemonSetName, corev1.Container{Name: "kube-proxy", Image: getKubeProxyTargetImage(repoDomain, "1.29.0-eksbuild.1")}),
+	)
+
+	upToDate, err := planKubeProxySync(clientset, getKubeProxyTargetImage(repoDomain, "1.29.0-eksbuild.1"))
+	require.NoError(t, err)
+	assert.False(t, upToDate.Drifted)
+
+	drifted, err := planKubeProxySync(clientset, getKubeProxyTargetImage(repoDomain, "1.30.0-eksbuild.1"))
+	require.NoError(t, err)
+	assert.True(t, drifted.Drifted)
+	assert.Equal(t, getKubeProxyTargetImage(repoDomain, "1.29.0-eksbuild.1"), drifted.CurrentImage)
+	assert.Equal(t, getKubeProxyTargetImage(repoDomain, "1.30.0-eksbuild.1"), drifted.TargetImage)
+}
+
+func TestPlanCoreDNSSync(t *testing.T) {
+	t.Parallel()
+
+	repoDomain := DefaultVersionCatalog().getRepoDomain("us-east-1")
+	targetVersion := "1.8.4-eksbuild.1"
+	deployment := &appsv1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{Namespace: componentNamespace, Name: corednsDeploymentName},
+		Spec: appsv1.DeploymentSpec{
+			Template: corev1.PodTemplateSpec{
+				Spec: corev1.PodSpec{
+					Containers: []corev1.Container{{Name: "coredns", Image: getCoreDNSTargetImage(repoDomain, targetVersion)}},
+				},
+			},
+		},
+	}
+	clusterRole := &rbacv1.ClusterRole{
+		ObjectMeta: metav1.ObjectMeta{Name: corednsClusterRoleName},
+		Rules: []rbacv1.PolicyRule{
+			{APIGroups: []string{""}, Resources: []string{"endpoints", "services", "pods", "namespaces"}, Verbs: []string{"list", "watch"}},
+			{APIGroups: []string{endpointslicesAPIGroup}, Resources: []string{endpointslicesResource}, Verbs: []string{"list"}},
+		},
+	}
+
+	testCases := []struct {
+		name            string
+		corefile        string
+		clusterRole     *rbacv1.ClusterRole
+		expectedDrifted bool
+		expectedPatches int
+	}{
+		{"UpToDate", expectedSampleConfigData, newCorednsClusterRoleWithEndpointSlices(), false, 0},
+		{"OldCorefile", sampleConfigData, newCorednsClusterRoleWithEndpointSlices(), true, 1},
+		{"MissingClusterRolePermissions", expectedSampleConfigData, clusterRole, true, 1},
+		{"OldCorefileAndMissingClusterRolePermissions", sampleConfigData, clusterRole, true, 2},
+	}
+
+	for _, tc := range testCases {
+		// Capture range variable to bring it in scope for the for loop.
+		tc := tc
+
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			configMap := &corev1.ConfigMap{
+				ObjectMeta: metav1.ObjectMeta{Namespace: componentNamespace, Name: corednsConfigMapName},
+				Data:       map[string]string{corednsConfigMapConfigKey: tc.corefile},
+			}
+			clientset := fake.NewSimpleClientset(deployment.DeepCopy(), configMap, tc.clusterRole.DeepCopy())
+
+			plan, err := planCoreDNSSync(clientset, repoDomain, targetVersion)
+			require.NoError(t, err)
+			assert.Equal(t, tc.expectedDrifted, plan.Drifted)
+			assert.Equal(t, tc.expectedPatches, len(plan.Patches))
+			assert.Equal(t, plan.CurrentImage, plan.TargetImage)
+		})
+	}
+}
+
+func TestPlanCorednsConfigMapFor170Compatibility(t *testing.T) {
+	t.Parallel()
+
+	patch, err := planCorednsConfigMapFor170Compatibility(expectedSampleConfigData)
+	require.NoError(t, err)
+	assert.Nil(t, patch)
+
+	patch, err = planCorednsConfigMapFor170Compatibility(sampleConfigData)
+	require.NoError(t, err)
+	require.NotNil(t, patch)
+	assert.Contains(t, patch.Diff, "--- Corefile (current)")
+	assert.Contains(t, patch.Diff, "-      upstream\n")
+}
+
+func TestGetMissingEndpointSlicesRule(t *testing.T) {
+	t.Parallel()
+
+	assert.Nil(t, getMissingEndpointSlicesRule(newCorednsClusterRoleWithEndpointSlices().Rules))
+	assert.Equal(
+		t,
+		&rbacv1.PolicyRule{
+			APIGroups: []string{endpointslicesAPIGroup},
+			Resources: []string{endpointslicesResource},
+			Verbs:     []string{"watch"},
+		},
+		getMissingEndpointSlicesRule([]rbacv1.PolicyRule{
+			{APIGroups: []string{endpointslicesAPIGroup}, Resources: []string{endpointslicesResource}, Verbs: []string{"list"}},
+		}),
+	)
+}
+
+func TestGetCurrentDeployedVPCCNIImage(t *testing.T) {
+	t.Parallel()
+
+	clientset := fake.NewSimpleClientset(
+		newSyncPlanTestDaemonSet(
+			vpcCNIDaemonSetName,
+			corev1.Container{Name: vpcCNIContainerName, Image: "amazon-k8s-cni:v1.19.6"},
+			corev1.Container{Name: "aws-eks-nodeagent", Image: "aws-network-policy-agent:v1.2.0"},
+		),
+	)
+	image, err := getCurrentDeployedVPCCNIImage(clientset)
+	require.NoError(t, err)
+	assert.Equal(t, "amazon-k8s-cni:v1.19.6", image)
+
+	clientset = fake.NewSimpleClientset(newSyncPlanTestDaemonSet(vpcCNIDaemonSetName, corev1.Container{Name: "other"}))
+	_, err = getCurrentDeployedVPCCNIImage(clientset)
+	assert.Error(t, err)
+}
+
+func TestSyncPlanWrite(t *testing.T) {
+	t.Parallel()
+
+	plan := &SyncPlan{
+		Components: []ComponentSyncPlan{
+			{Name: kubeProxyComponentName, CurrentImage: "kube-proxy:v1", TargetImage: "kube-proxy:v1"},
+			{
+				Name:         coreDNSComponentName,
+				CurrentImage: "coredns:v1",
+				TargetImage:  "coredns:v2",
+				Patches:      []ComponentPatch{{Description: "Add a rule", Diff: "+ rule\n"}},
+				Drifted:      true,
+			},
+			{
+				Name:         vpcCNIComponentName,
+				CurrentImage: "amazon-k8s-cni:v1",
+				TargetImage:  "amazon-k8s-cni:v2",
+				ManifestDiff: "-image: v1\n+image: v2\n",
+				Drifted:      true,
+			},
+		},
+	}
+	assert.Equal(t, []string{coreDNSComponentName, vpcCNIComponentName}, plan.DriftedComponents())
+
+	var out bytes.Buffer
+	require.NoError(t, plan.Write(&out))
+	text := out.String()
+	assert.Contains(t, text, "kube-proxy (up to date):")
+	assert.Contains(t, text, "coredns (drifted):\n  Current image: coredns:v1\n  Target image:  coredns:v2\n")
+	assert.Contains(t, text, "  Patch: Add a rule\n    + rule\n")
+	assert.Contains(t, text, "  Manifest diff:\n    -image: v1\n    +image: v2\n")
+	assert.Contains(t, text, "Core components that would be updated: coredns, aws-vpc-cni")
+
+	upToDate := &SyncPlan{Components: plan.Components[:1]}
+	assert.Empty(t, upToDate.DriftedComponents())
+	out.Reset()
+	require.NoError(t, upToDate.Write(&out))
+	assert.Contains(t, out.String(), "All core components are up to date.")
+}
+
+// newSyncPlanTestDaemonSet returns a DaemonSet in the core component namespace that runs the given containers.
+func newSyncPlanTestDaemonSet(name string, containers ...corev1.Container) *appsv1.DaemonSet {
+	return &appsv1.DaemonSet{
+		ObjectMeta: metav1.ObjectMeta{Namespace: componentNamespace, Name: name},
+		Spec: appsv1.DaemonSetSpec{
+			Template: corev1.PodTemplateSpec{
+				Spec: corev1.PodSpec{Containers: containers},
+			},
+		},
+	}
+}
+
+// newCorednsClusterRoleWithEndpointSlices returns a coredns ClusterRole that has all the permissions needed by 1.8.3.
+func newCorednsClusterRoleWithEndpointSlices() *rbacv1.ClusterRole {
+	return &rbacv1.ClusterRole{
+		ObjectMeta: metav1.ObjectMeta{Name: corednsClusterRoleName},
+		Rules: []rbacv1.PolicyRule{
+			{APIGroups: []string{endpointslicesAPIGroup}, Resources: []string{endpointslicesResource}, Verbs: []string{"list", "watch"}},
+		},
+	}
+}
@@ -37,12 +37,12 @@ func TestGetEKSContainerImageURL(t *testing.T) {
 
 	region := "us-west-2"
 	expected := fmt.Sprintf("%s.dkr.ecr.%s.amazonaws.com", defaultContainerImageAccount, region)
-	actual := getRepoDomain(region)
+	actual := DefaultVersionCatalog().getRepoDomain(region)
 	assert.Equal(t, expected, actual)
 
 	region = "ap-east-1"
 	expected = fmt.Sprintf("%s.dkr.ecr.%s.amazonaws.com", containerImageAccountLookupTable[region], region)
-	actual = getRepoDomain(region)
+	actual = DefaultVersionCatalog().getRepoDomain(region)
 	assert.Equal(t, expected, actual)
 }
 
@@ -236,7 +236,7 @@ func TestFindLatestEKSBuilds(t *testing.T) {
 		t.Run(fmt.Sprintf("%s-%s", tc.repoPath, tc.k8sVersion), func(t *testing.T) {
 			t.Parallel()
 
-			repoDomain := getRepoDomain(tc.region)
+			repoDomain := DefaultVersionCatalog().getRepoDomain(tc.region)
 			dockerToken, err := eksawshelper.GetDockerLoginToken(tc.region)
 			require.NoError(t, err)
 
@@ -254,6 +254,31 @@ func TestFindLatestEKSBuilds(t *testing.T) {
 	}
 }
 
+func TestFindLatestEKSBuildUsesExactVersions(t *testing.T) {
+	t.Parallel()
+
+	testCase := []struct {
+		name    string
+		version string
+	}{
+		{"exact", "1.12.4-eksbuild.3"},
+		{"exactMinimal", "1.33.0-minimal-eksbuild.12"},
+		{"notEKSBuild", "1.12.4"},
+	}
+
+	for _, tc := range testCase {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			// No docker token or repo is needed, as exact versions are not looked up in the ECR repo.
+			actualVersion, err := findLatestEKSBuild("", "", coreDNSRepoPath, tc.version)
+			require.NoError(t, err)
+			assert.Equal(t, tc.version, actualVersion)
+		})
+	}
+}
+
 const sampleConfigData = `.:53 {
     errors
     health
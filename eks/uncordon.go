@@ -0,0 +1,131 @@
+package eks
+
+import (
+	"strings"
+
+	"github.com/aws/aws-sdk-go/service/autoscaling"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/gruntwork-io/go-commons/collections"
+	"github.com/gruntwork-io/go-commons/errors"
+
+	"github.com/gruntwork-io/kubergrunt/eksawshelper"
+	"github.com/gruntwork-io/kubergrunt/kubectl"
+	"github.com/gruntwork-io/kubergrunt/logging"
+)
+
+// Uncordon makes the nodes of the instances in the given ASGs, along with the nodes matching the given label selector,
+// schedulable again. This is useful for recovering from a drain that failed or was interrupted, which leaves the nodes
+// cordoned. Nodes labeled with node.kubernetes.io/exclude-from-external-load-balancers by an interrupted deploy are also
+// included in the external load balancers again by removing the label. The cluster lock is held while uncordoning, so that the nodes of a deploy or drain that is still running
+// are not uncordoned from under it.
+func Uncordon(
+	region string,
+	asgNames []string,
+	nodeSelector string,
+	kubectlOptions *kubectl.KubectlOptions,
+	lockOptions ClusterLockOptions,
+) error {
+	logger := logging.GetProjectLogger()
+
+	lock, err := AcquireClusterLock(kubectlOptions, lockOptions)
+	if err != nil {
+		return err
+	}
+	defer ReleaseClusterLock(lock)
+
+	nodeNames := []string{}
+	if len(asgNames) > 0 {
+		asgNodeNames, err := getASGNodeNames(region, asgNames)
+		if err != nil {
+			return err
+		}
+		// Instances that never joined the cluster (or have already left it) have no node to uncordon.
+		registeredNodeNames, err := kubectl.FilterRegisteredNodes(kubectlOptions, asgNodeNames)
+		if err != nil {
+			return err
+		}
+		nodeNames = append(nodeNames, registeredNodeNames...)
+	}
+	if nodeSelector != "" {
+		selectedNodeNames, err := kubectl.ListNodeNames(kubectlOptions, nodeSelector)
+		if err != nil {
+			return err
+		}
+		logger.Infof("Found %d nodes matching selector %s", len(selectedNodeNames), nodeSelector)
+		nodeNames = appendMissing(nodeNames, selectedNodeNames)
+	}
+	if len(nodeNames) == 0 {
+		logger.Warnf("Found no nodes to uncordon")
+		return nil
+	}
+
+	if err := lock.CheckHeld(); err != nil {
+		return err
+	}
+	logger.Infof("Uncordoning nodes: %s", strings.Join(nodeNames, ","))
+	if err := kubectl.UncordonNodes(kubectlOptions, nodeNames); err != nil {
+		return err
+	}
+	logger.Infof("Successfully uncordoned %d nodes", len(nodeNames))
+
+	// A drain that was interrupted during a deploy may also have excluded the nodes from the external load balancers,
+	// so remove the label to let the load balancer controllers register them again.
+	excludedNodeNames, err := kubectl.FilterNodesExcludedFromLoadBalancers(kubectlOptions, nodeNames)
+	if err != nil {
+		return err
+	}
+	if len(excludedNodeNames) == 0 {
+		return nil
+	}
+	if err := lock.CheckHeld(); err != nil {
+		return err
+	}
+	logger.Infof("Including nodes in external load balancers again: %s", strings.Join(excludedNodeNames, ","))
+	if err := kubectl.SetNodesExcludedFromLoadBalancers(kubectlOptions, excludedNodeNames, false); err != nil {
+		return err
+	}
+	logger.Infof("Successfully included %d nodes in external load balancers", len(excludedNodeNames))
+	return nil
+}
+
+// getASGNodeNames returns the Kubernetes node names of the current instances of the given ASGs.
+func getASGNodeNames(region string, asgNames []string) ([]string, error) {
+	logger := logging.GetProjectLogger()
+
+	sess, err := eksawshelper.NewAuthenticatedSession(region)
+	if err != nil {
+		return nil, errors.WithStackTrace(err)
+	}
+	asgSvc := autoscaling.New(sess)
+	ec2Svc := ec2.New(sess)
+	logger.Infof("Successfully authenticated with AWS")
+
+	allInstanceIDs := []string{}
+	for _, asgName := range asgNames {
+		asgInfo, err := getAsgInfo(asgSvc, asgName)
+		if err != nil {
+			return nil, err
+		}
+		allInstanceIDs = append(allInstanceIDs, asgInfo.OriginalInstances...)
+	}
+	logger.Infof("Found %d instances across all requested ASGs.", len(allInstanceIDs))
+	if len(allInstanceIDs) == 0 {
+		return []string{}, nil
+	}
+
+	instances, err := instanceDetailsFromIds(ec2Svc, allInstanceIDs)
+	if err != nil {
+		return nil, err
+	}
+	return kubeNodeNamesFromInstances(instances), nil
+}
+
+// appendMissing appends the values that are not in the list yet.
+func appendMissing(list []string, values []string) []string {
+	for _, value := range values {
+		if !collections.ListContainsElement(list, value) {
+			list = append(list, value)
+		}
+	}
+	return list
+}
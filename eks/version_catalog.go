@@ -0,0 +1,212 @@
+package eks
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"regexp"
+	"sort"
+	"text/tabwriter"
+
+	"github.com/blang/semver/v4"
+	"github.com/gruntwork-io/go-commons/collections"
+	"github.com/gruntwork-io/go-commons/errors"
+	"sigs.k8s.io/yaml"
+)
+
+var (
+	kubernetesVersionRE = regexp.MustCompile(`^[0-9]+\.[0-9]+$`)
+	awsAccountIDRE      = regexp.MustCompile(`^[0-9]{12}$`)
+)
+
+// VersionCatalog is the matrix of core component versions that sync-core-components deploys for each supported
+// Kubernetes version, along with the AWS accounts hosting the component container images in each region. The built-in
+// catalog (see DefaultVersionCatalog) can be extended with a catalog file (see LoadVersionCatalog), so that new EKS
+// releases and regions can be supported without a new release of kubergrunt.
+//
+// The kube-proxy and coredns versions are the base of the eksbuild tag (e.g 1.12.4-eksbuild), which is resolved to the
+// latest eksbuild available in the ECR repo, or an exact version (e.g 1.12.4-eksbuild.3).
+type VersionCatalog struct {
+	SupportedVersions            []string          `json:"supportedVersions"`
+	KubeProxyVersions            map[string]string `json:"kubeProxyVersions"`
+	CoreDNSVersions              map[string]string `json:"coreDNSVersions"`
+	VPCCNIVersions               map[string]string `json:"vpcCNIVersions"`
+	DefaultContainerImageAccount string            `json:"defaultContainerImageAccount"`
+	ContainerImageAccounts       map[string]string `json:"containerImageAccounts"`
+}
+
+// ComponentVersionPins pins the versions of the core components, overriding the versions in the version catalog. Empty
+// fields are looked up in the catalog. When syncing EKS add-ons, the pins are add-on versions (e.g
+// v1.19.6-eksbuild.2).
+type ComponentVersionPins struct {
+	KubeProxy string
+	CoreDNS   string
+	VPCCNI    string
+}
+
+// DefaultVersionCatalog returns the catalog of versions that is built into kubergrunt.
+func DefaultVersionCatalog() *VersionCatalog {
+	catalog := &VersionCatalog{
+		SupportedVersions:            append([]string{}, supportedVersions...),
+		KubeProxyVersions:            map[string]string{},
+		CoreDNSVersions:              map[string]string{},
+		VPCCNIVersions:               map[string]string{},
+		DefaultContainerImageAccount: defaultContainerImageAccount,
+		ContainerImageAccounts:       map[string]string{},
+	}
+	mergeVersionTable(catalog.KubeProxyVersions, kubeProxyVersionLookupTable)
+	mergeVersionTable(catalog.CoreDNSVersions, coreDNSVersionLookupTable)
+	mergeVersionTable(catalog.VPCCNIVersions, amazonVPCCNIVersionLookupTable)
+	mergeVersionTable(catalog.ContainerImageAccounts, containerImageAccountLookupTable)
+	return catalog
+}
+
+// LoadVersionCatalog returns the built-in catalog of versions, extended with the catalog in the given YAML or JSON file.
+// Entries in the file take precedence over the built-in entries for the same Kubernetes version or region, and the
+// supported versions in the file are added to the built-in ones. When the path is empty, this returns the built-in
+// catalog. The resulting catalog is validated before it is returned.
+func LoadVersionCatalog(path string) (*VersionCatalog, error) {
+	catalog := DefaultVersionCatalog()
+	if path == "" {
+		return catalog, nil
+	}
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, errors.WithStackTrace(err)
+	}
+	var override VersionCatalog
+	// sigs.k8s.io/yaml converts the YAML to JSON before unmarshalling, so this handles both formats.
+	if err := yaml.UnmarshalStrict(data, &override); err != nil {
+		return nil, errors.WithStackTrace(InvalidVersionCatalogErr{path: path, problems: []string{err.Error()}})
+	}
+
+	for _, version := range override.SupportedVersions {
+		if !collections.ListContainsElement(catalog.SupportedVersions, version) {
+			catalog.SupportedVersions = append(catalog.SupportedVersions, version)
+		}
+	}
+	sortKubernetesVersions(catalog.SupportedVersions)
+	mergeVersionTable(catalog.KubeProxyVersions, override.KubeProxyVersions)
+	mergeVersionTable(catalog.CoreDNSVersions, override.CoreDNSVersions)
+	mergeVersionTable(catalog.VPCCNIVersions, override.VPCCNIVersions)
+	mergeVersionTable(catalog.ContainerImageAccounts, override.ContainerImageAccounts)
+	if override.DefaultContainerImageAccount != "" {
+		catalog.DefaultContainerImageAccount = override.DefaultContainerImageAccount
+	}
+
+	if problems := catalog.Validate(); len(problems) > 0 {
+		return nil, errors.WithStackTrace(InvalidVersionCatalogErr{path: path, problems: problems})
+	}
+	return catalog, nil
+}
+
+// Validate checks that the catalog is usable by sync-core-components, returning the problems that were found. Every
+// supported Kubernetes version must have a version for each component, and all the versions and AWS account IDs must be
+// well formed.
+func (catalog *VersionCatalog) Validate() []string {
+	problems := []string{}
+	for _, k8sVersion := range catalog.SupportedVersions {
+		if !kubernetesVersionRE.MatchString(k8sVersion) {
+			problems = append(problems, fmt.Sprintf("supported version %s is not in the format MAJOR.MINOR", k8sVersion))
+		}
+	}
+	problems = append(problems, validateVersionTable("kubeProxyVersions", catalog.KubeProxyVersions, catalog.SupportedVersions)...)
+	problems = append(problems, validateVersionTable("coreDNSVersions", catalog.CoreDNSVersions, catalog.SupportedVersions)...)
+	problems = append(problems, validateVersionTable("vpcCNIVersions", catalog.VPCCNIVersions, catalog.SupportedVersions)...)
+
+	if !awsAccountIDRE.MatchString(catalog.DefaultContainerImageAccount) {
+		problems = append(problems, fmt.Sprintf("defaultContainerImageAccount %s is not a 12 digit AWS account ID", catalog.DefaultContainerImageAccount))
+	}
+	for _, region := range sortedKeys(catalog.ContainerImageAccounts) {
+		if accountID := catalog.ContainerImageAccounts[region]; !awsAccountIDRE.MatchString(accountID) {
+			problems = append(problems, fmt.Sprintf("containerImageAccounts: account %s for region %s is not a 12 digit AWS account ID", accountID, region))
+		}
+	}
+	return problems
+}
+
+// IsSupportedVersion returns whether the given Kubernetes version is supported by the catalog.
+func (catalog *VersionCatalog) IsSupportedVersion(k8sVersion string) bool {
+	return collections.ListContainsElement(catalog.SupportedVersions, k8sVersion)
+}
+
+// Write prints the matrix of component versions for each supported Kubernetes version in a human readable format to
+// the given writer.
+func (catalog *VersionCatalog) Write(out io.Writer) error {
+	writer := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(writer, "KUBERNETES\tKUBE-PROXY\tCOREDNS\tVPC CNI")
+	for _, k8sVersion := range catalog.SupportedVersions {
+		fmt.Fprintf(
+			writer,
+			"%s\t%s\t%s\t%s\n",
+			k8sVersion,
+			catalog.KubeProxyVersions[k8sVersion],
+			catalog.CoreDNSVersions[k8sVersion],
+			catalog.VPCCNIVersions[k8sVersion],
+		)
+	}
+	fmt.Fprintln(writer)
+	fmt.Fprintln(writer, "REGION\tCONTAINER IMAGE ACCOUNT")
+	fmt.Fprintf(writer, "(default)\t%s\n", catalog.DefaultContainerImageAccount)
+	for _, region := range sortedKeys(catalog.ContainerImageAccounts) {
+		fmt.Fprintf(writer, "%s\t%s\n", region, catalog.ContainerImageAccounts[region])
+	}
+	return errors.WithStackTrace(writer.Flush())
+}
+
+// getRepoDomain constructs the ECR docker repo URL domain hosting the component container images in the given region.
+func (catalog *VersionCatalog) getRepoDomain(region string) string {
+	containerAccountID := catalog.DefaultContainerImageAccount
+	if id, ok := catalog.ContainerImageAccounts[region]; ok {
+		containerAccountID = id
+	}
+	return fmt.Sprintf("%s.dkr.ecr.%s.amazonaws.com", containerAccountID, region)
+}
+
+// validateVersionTable checks that the component version table has a well formed version for each supported Kubernetes
+// version.
+func validateVersionTable(tableName string, table map[string]string, supportedVersions []string) []string {
+	problems := []string{}
+	for _, k8sVersion := range supportedVersions {
+		if _, hasVersion := table[k8sVersion]; !hasVersion {
+			problems = append(problems, fmt.Sprintf("%s: missing version for supported Kubernetes version %s", tableName, k8sVersion))
+		}
+	}
+	for _, k8sVersion := range sortedKeys(table) {
+		if _, err := semver.Make(table[k8sVersion]); err != nil {
+			problems = append(problems, fmt.Sprintf("%s: version %s for Kubernetes version %s is not a semantic version", tableName, table[k8sVersion], k8sVersion))
+		}
+	}
+	return problems
+}
+
+// mergeVersionTable copies the entries of the source table into the destination table.
+func mergeVersionTable(destination map[string]string, source map[string]string) {
+	for key, value := range source {
+		destination[key] = value
+	}
+}
+
+// sortKubernetesVersions sorts the Kubernetes versions from the latest to the oldest, like the built-in supported
+// versions. Versions that are not in the format MAJOR.MINOR are sorted last.
+func sortKubernetesVersions(versions []string) {
+	sort.SliceStable(versions, func(i, j int) bool {
+		vi, erri := semver.ParseTolerant(versions[i])
+		vj, errj := semver.ParseTolerant(versions[j])
+		if erri != nil || errj != nil {
+			return erri == nil
+		}
+		return vi.GT(vj)
+	})
+}
+
+// sortedKeys returns the keys of the map in sorted order.
+func sortedKeys(table map[string]string) []string {
+	keys := make([]string, 0, len(table))
+	for key := range table {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
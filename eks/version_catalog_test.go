@@ -0,0 +1,188 @@
+package eks
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/gruntwork-io/go-commons/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDefaultVersionCatalogIsValid(t *testing.T) {
+	t.Parallel()
+
+	catalog, err := LoadVersionCatalog("")
+	require.NoError(t, err)
+	assert.Empty(t, catalog.Validate())
+	assert.Equal(t, supportedVersions, catalog.SupportedVersions)
+}
+
+func TestLoadVersionCatalog(t *testing.T) {
+	t.Parallel()
+
+	testCases := []struct {
+		name     string
+		fileName string
+		contents string
+	}{
+		{
+			"YAML",
+			"catalog.yaml",
+			`supportedVersions: ["1.35"]
+kubeProxyVersions:
+  "1.35": 1.35.0-eksbuild
+coreDNSVersions:
+  "1.35": 1.12.4-eksbuild
+vpcCNIVersions:
+  "1.35": 1.20.4
+  "1.34": 1.20.5
+containerImageAccounts:
+  xx-new-1: "123456789012"
+`,
+		},
+		{
+			"JSON",
+			"catalog.json",
+			`{
+  "supportedVersions": ["1.35"],
+  "kubeProxyVersions": {"1.35": "1.35.0-eksbuild"},
+  "coreDNSVersions": {"1.35": "1.12.4-eksbuild"},
+  "vpcCNIVersions": {"1.35": "1.20.4", "1.34": "1.20.5"},
+  "containerImageAccounts": {"xx-new-1": "123456789012"}
+}`,
+		},
+	}
+
+	for _, tc := range testCases {
+		// Capture range variable to bring it in scope for the for loop.
+		tc := tc
+
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			catalog, err := LoadVersionCatalog(writeVersionCatalogFile(t, tc.fileName, tc.contents))
+			require.NoError(t, err)
+			assert.Equal(t, "1.35", catalog.SupportedVersions[0])
+			assert.True(t, catalog.IsSupportedVersion("1.26"))
+			assert.Equal(t, "1.35.0-eksbuild", catalog.KubeProxyVersions["1.35"])
+			// Entries in the file take precedence over the built-in ones.
+			assert.Equal(t, "1.20.5", catalog.VPCCNIVersions["1.34"])
+			assert.Equal(t, coreDNSVersionLookupTable["1.34"], catalog.CoreDNSVersions["1.34"])
+			assert.Equal(t, "123456789012.dkr.ecr.xx-new-1.amazonaws.com", catalog.getRepoDomain("xx-new-1"))
+			assert.Equal(t, containerImageAccountLookupTable["ap-east-1"]+".dkr.ecr.ap-east-1.amazonaws.com", catalog.getRepoDomain("ap-east-1"))
+		})
+	}
+}
+
+func TestLoadVersionCatalogInvalid(t *testing.T) {
+	t.Parallel()
+
+	testCases := []struct {
+		name             string
+		contents         string
+		expectedProblems []string
+	}{
+		{
+			"MissingComponentVersion",
+			`supportedVersions: ["1.35"]
+kubeProxyVersions:
+  "1.35": 1.35.0-eksbuild
+coreDNSVersions:
+  "1.35": 1.12.4-eksbuild
+`,
+			[]string{"vpcCNIVersions: missing version for supported Kubernetes version 1.35"},
+		},
+		{
+			"MalformedValues",
+			`supportedVersions: ["v1.35.1"]
+kubeProxyVersions:
+  "v1.35.1": latest
+coreDNSVersions:
+  "v1.35.1": 1.12.4-eksbuild
+vpcCNIVersions:
+  "v1.35.1": 1.20.4
+containerImageAccounts:
+  xx-new-1: "1234"
+`,
+			[]string{
+				"supported version v1.35.1 is not in the format MAJOR.MINOR",
+				"kubeProxyVersions: version latest for Kubernetes version v1.35.1 is not a semantic version",
+				"containerImageAccounts: account 1234 for region xx-new-1 is not a 12 digit AWS account ID",
+			},
+		},
+		{
+			"UnknownField",
+			`kubeProxyVersion:
+  "1.35": 1.35.0-eksbuild
+`,
+			nil,
+		},
+	}
+
+	for _, tc := range testCases {
+		// Capture range variable to bring it in scope for the for loop.
+		tc := tc
+
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			_, err := LoadVersionCatalog(writeVersionCatalogFile(t, "catalog.yaml", tc.contents))
+			require.Error(t, err)
+			catalogErr, isCatalogErr := errors.Unwrap(err).(InvalidVersionCatalogErr)
+			require.True(t, isCatalogErr)
+			if tc.expectedProblems != nil {
+				assert.Equal(t, tc.expectedProblems, catalogErr.problems)
+			}
+		})
+	}
+}
+
+func TestVersionCatalogWrite(t *testing.T) {
+	t.Parallel()
+
+	catalog := &VersionCatalog{
+		SupportedVersions:            []string{"1.34"},
+		KubeProxyVersions:            map[string]string{"1.34": "1.34.1-eksbuild"},
+		CoreDNSVersions:              map[string]string{"1.34": "1.12.4-eksbuild"},
+		VPCCNIVersions:               map[string]string{"1.34": "1.20.4"},
+		DefaultContainerImageAccount: "602401143452",
+		ContainerImageAccounts:       map[string]string{"ap-east-1": "800184023465"},
+	}
+	var out bytes.Buffer
+	require.NoError(t, catalog.Write(&out))
+	assert.Equal(
+		t,
+		`KUBERNETES  KUBE-PROXY       COREDNS          VPC CNI
+1.34        1.34.1-eksbuild  1.12.4-eksbuild  1.20.4
+
+REGION     CONTAINER IMAGE ACCOUNT
+(default)  602401143452
+ap-east-1  800184023465
+`,
+		out.String(),
+	)
+}
+
+func TestComponentVersionPinsGetAddonVersion(t *testing.T) {
+	t.Parallel()
+
+	pins := ComponentVersionPins{KubeProxy: "1.30.0-eksbuild.3", VPCCNI: "v1.19.6-eksbuild.2"}
+	assert.Equal(t, "v1.30.0-eksbuild.3", pins.getAddonVersion(kubeProxyAddonName))
+	assert.Equal(t, "", pins.getAddonVersion(coreDNSAddonName))
+	assert.Equal(t, "v1.19.6-eksbuild.2", pins.getAddonVersion(vpcCNIAddonName))
+}
+
+// writeVersionCatalogFile writes the catalog contents to a file in a temporary directory, returning the path.
+func writeVersionCatalogFile(t *testing.T, fileName string, contents string) string {
+	workingDir, err := ioutil.TempDir("", "kubergrunt-catalog")
+	require.NoError(t, err)
+	t.Cleanup(func() { os.RemoveAll(workingDir) })
+
+	path := filepath.Join(workingDir, fileName)
+	require.NoError(t, ioutil.WriteFile(path, []byte(contents), 0644))
+	return path
+}
@@ -2,42 +2,69 @@ package kubectl
 
 import (
 	"os"
+	"os/exec"
 
+	"github.com/gruntwork-io/go-commons/errors"
 	"github.com/gruntwork-io/go-commons/shell"
 )
 
+// kubectlDiffChangesExitCode is the exit code of kubectl diff when there are differences. Any other non-zero exit
+// code means that kubectl diff failed.
+const kubectlDiffChangesExitCode = 1
+
 // RunKubectl will make a call to kubectl, setting the config and context to the ones specified in the provided options.
 func RunKubectl(options *KubectlOptions, args ...string) error {
-	shellOptions := shell.NewShellOptions()
-	cmdArgs := []string{}
-	scheme := options.AuthScheme()
-	switch scheme {
-	case ConfigBased:
-		if options.ContextName != "" {
-			cmdArgs = append(cmdArgs, "--context", options.ContextName)
-		}
-		if options.ConfigPath != "" {
-			cmdArgs = append(cmdArgs, "--kubeconfig", options.ConfigPath)
-		}
-	default:
-		tmpfile, err := options.TempConfigFromAuthInfo()
-		if tmpfile != "" {
-			// Make sure to delete the tmp file at the end
-			defer os.Remove(tmpfile)
-		}
-		if err != nil {
-			return err
-		}
-		cmdArgs = append(cmdArgs, "--kubeconfig", tmpfile)
-	}
-	cmdArgs = append(cmdArgs, args...)
-	_, err := shell.RunShellCommandAndGetAndStreamOutput(shellOptions, "kubectl", cmdArgs...)
+	_, err := RunKubectlWithOutput(options, args...)
 	return err
 }
 
 func RunKubectlWithOutput(options *KubectlOptions, args ...string) (string, error) {
 	shellOptions := shell.NewShellOptions()
+	cmdArgs, tmpfile, err := getKubectlArgs(options, args...)
+	if tmpfile != "" {
+		// Make sure to delete the tmp file at the end
+		defer os.Remove(tmpfile)
+	}
+	if err != nil {
+		return "ERROR", err
+	}
+	out, err := shell.RunShellCommandAndGetAndStreamOutput(shellOptions, "kubectl", cmdArgs...)
+	return out, err
+}
+
+// RunKubectlDiff will run kubectl diff with the given args, which compares the given manifests against a server side
+// dry run of applying them. This returns the diff, along with whether or not there are any differences. Unlike the
+// other kubectl calls, the output is not streamed to stdout.
+func RunKubectlDiff(options *KubectlOptions, args ...string) (string, bool, error) {
+	shellOptions := shell.NewShellOptions()
+	cmdArgs, tmpfile, err := getKubectlArgs(options, append([]string{"diff"}, args...)...)
+	if tmpfile != "" {
+		// Make sure to delete the tmp file at the end
+		defer os.Remove(tmpfile)
+	}
+	if err != nil {
+		return "", false, err
+	}
+	out, err := shell.RunShellCommandAndGetStdout(shellOptions, "kubectl", cmdArgs...)
+	if err == nil {
+		return out, false, nil
+	}
+	exitErr, isExitErr := errors.Unwrap(err).(*exec.ExitError)
+	if isExitErr && exitErr.ExitCode() == kubectlDiffChangesExitCode {
+		return out, true, nil
+	}
+	if isExitErr {
+		return out, false, errors.WithStackTrace(KubectlDiffErr{stderr: string(exitErr.Stderr)})
+	}
+	return out, false, err
+}
+
+// getKubectlArgs returns the args for calling kubectl with the given args, setting the config and context to the ones
+// specified in the provided options. When the options require a temporary kubeconfig file, its path is returned so that
+// the caller can delete it once kubectl is done.
+func getKubectlArgs(options *KubectlOptions, args ...string) ([]string, string, error) {
 	cmdArgs := []string{}
+	tmpfile := ""
 	scheme := options.AuthScheme()
 	switch scheme {
 	case ConfigBased:
@@ -48,17 +75,13 @@ func RunKubectlWithOutput(options *KubectlOptions, args ...string) (string, erro
 			cmdArgs = append(cmdArgs, "--kubeconfig", options.ConfigPath)
 		}
 	default:
-		tmpfile, err := options.TempConfigFromAuthInfo()
-		if tmpfile != "" {
-			// Make sure to delete the tmp file at the end
-			defer os.Remove(tmpfile)
-		}
+		var err error
+		tmpfile, err = options.TempConfigFromAuthInfo()
 		if err != nil {
-			return "ERROR", err
+			return nil, tmpfile, err
 		}
 		cmdArgs = append(cmdArgs, "--kubeconfig", tmpfile)
 	}
 	cmdArgs = append(cmdArgs, args...)
-	out, err := shell.RunShellCommandAndGetAndStreamOutput(shellOptions, "kubectl", cmdArgs...)
-	return out, err
+	return cmdArgs, tmpfile, nil
 }
@@ -0,0 +1,697 @@
+package kubectl
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gruntwork-io/go-commons/collections"
+	"github.com/gruntwork-io/go-commons/errors"
+	"github.com/hashicorp/go-multierror"
+	corev1 "k8s.io/api/core/v1"
+	policyv1 "k8s.io/api/policy/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes"
+
+	"github.com/gruntwork-io/kubergrunt/logging"
+)
+
+const (
+	// DefaultEvictionRetryInterval is the initial amount of time to wait before retrying an eviction that was rejected
+	// because it would violate a PodDisruptionBudget. The wait doubles on each retry, up to maxEvictionRetryInterval.
+	DefaultEvictionRetryInterval = 5 * time.Second
+
+	// DefaultPollInterval is the default amount of time to wait between checks on the Pods that are being deleted,
+	// or that are given a chance to complete.
+	DefaultPollInterval = 2 * time.Second
+
+	// DefaultWaitAnnotation is the default annotation that marks Pods that should be given a chance to complete before
+	// they are evicted, like Pods owned by Jobs.
+	DefaultWaitAnnotation = "kubergrunt.gruntwork.io/wait-for-completion"
+
+	// DefaultStuckPodGracePeriod is the default grace period given to Pods that are deleted after their eviction timed
+	// out, before they are force deleted.
+	DefaultStuckPodGracePeriod = 30 * time.Second
+
+	maxEvictionRetryInterval = 1 * time.Minute
+)
+
+// DrainOptions configures how the Pods are evicted from the nodes when draining.
+type DrainOptions struct {
+	// Timeout is the maximum amount of time to spend draining each node. Zero means infinite.
+	Timeout time.Duration
+
+	// PodTimeout is the maximum amount of time to spend evicting each Pod, including retries on PodDisruptionBudget
+	// violations and waiting for the Pod to terminate. Zero means the Pod is only bounded by Timeout.
+	PodTimeout time.Duration
+
+	// DeleteEmptyDirData allows evicting Pods that use emptyDir volumes, deleting the local data.
+	DeleteEmptyDirData bool
+
+	// EvictionRetryInterval is the initial backoff between eviction retries. Defaults to DefaultEvictionRetryInterval.
+	EvictionRetryInterval time.Duration
+
+	// MaxConcurrentDrains is the maximum number of nodes to drain at the same time. Zero means all the nodes are
+	// drained at once.
+	MaxConcurrentDrains int
+
+	// Order determines the order in which the nodes are drained. Defaults to GivenDrainOrder.
+	Order DrainOrder
+
+	// FailFast stops draining new nodes after the first node fails to drain. The drains already in progress are
+	// allowed to finish.
+	FailFast bool
+
+	// JobGracePeriod is the maximum amount of time to wait for the Pods owned by Jobs, or with the WaitAnnotation, to
+	// complete before evicting them. These Pods are evicted after all the other Pods on the node. Zero means they are
+	// evicted right away, like the other Pods. This is not included in Timeout.
+	JobGracePeriod time.Duration
+
+	// WaitAnnotation is the annotation that marks Pods to be treated like Pods owned by Jobs when set to "true".
+	WaitAnnotation string
+
+	// PollInterval is the amount of time to wait between checks on the Pods that are being deleted, or that are given
+	// a chance to complete. Defaults to DefaultPollInterval.
+	PollInterval time.Duration
+
+	// ForceDeleteStuckPods escalates the Pods whose eviction timed out (e.g a Pod stuck on a finalizer or on an
+	// unresponsive kubelet) instead of failing the drain: the Pod is deleted with StuckPodGracePeriod, and if it is
+	// still not gone after twice that period, it is force deleted with a grace period of zero.
+	ForceDeleteStuckPods bool
+
+	// StuckPodGracePeriod is the grace period used when deleting a stuck Pod. Zero means stuck Pods are force deleted
+	// right away.
+	StuckPodGracePeriod time.Duration
+}
+
+// MaxNodeDrainDuration returns the longest a node drain can take with these options, or zero if it is not bounded.
+func (options DrainOptions) MaxNodeDrainDuration() time.Duration {
+	if options.Timeout == 0 {
+		return 0
+	}
+	maxDuration := options.Timeout
+	if options.JobGracePeriod > 0 {
+		// The Pods that are given a chance to complete get their own drain timeout for the eviction.
+		maxDuration = 2*options.Timeout + options.JobGracePeriod
+	}
+	if options.ForceDeleteStuckPods {
+		// Each of the eviction rounds can be followed by deleting the stuck Pods.
+		maxDuration += 4 * options.StuckPodGracePeriod
+	}
+	return maxDuration
+}
+
+// PodDrainResult records what happened to a Pod while draining a node.
+type PodDrainResult struct {
+	Namespace string
+	Name      string
+	UID       types.UID
+	Reason    string
+
+	// ControllerUID is the UID of the controller managing the Pod (e.g the ReplicaSet), which can be used to find the
+	// Pod that replaced an evicted Pod.
+	ControllerUID types.UID
+
+	// EvictedAt is when the eviction of the Pod was accepted. Only set for evicted Pods.
+	EvictedAt time.Time
+}
+
+func newPodDrainResult(pod corev1.Pod) PodDrainResult {
+	result := PodDrainResult{Namespace: pod.Namespace, Name: pod.Name, UID: pod.UID}
+	if controller := metav1.GetControllerOf(&pod); controller != nil {
+		result.ControllerUID = controller.UID
+	}
+	return result
+}
+
+func (result PodDrainResult) String() string {
+	if result.Reason == "" {
+		return fmt.Sprintf("%s/%s", result.Namespace, result.Name)
+	}
+	return fmt.Sprintf("%s/%s (%s)", result.Namespace, result.Name, result.Reason)
+}
+
+// DrainOrder is an enum for the order in which the nodes are drained.
+type DrainOrder string
+
+const (
+	// GivenDrainOrder drains the nodes in the order they are provided.
+	GivenDrainOrder DrainOrder = "given"
+	// AZDrainOrder drains the nodes one availability zone at a time, so that the capacity of the other zones is
+	// kept while a zone is drained.
+	AZDrainOrder DrainOrder = "az"
+	// FewestPodsDrainOrder drains the nodes with the fewest Pods to evict first.
+	FewestPodsDrainOrder DrainOrder = "fewest-pods"
+)
+
+// DrainOrders lists all the supported DrainOrder values.
+var DrainOrders = []string{string(GivenDrainOrder), string(AZDrainOrder), string(FewestPodsDrainOrder)}
+
+// ValidateDrainOrder returns an error if the drain order is not supported. An empty order is the same as
+// GivenDrainOrder.
+func ValidateDrainOrder(order DrainOrder) error {
+	if order != "" && !collections.ListContainsElement(DrainOrders, string(order)) {
+		return errors.WithStackTrace(InvalidDrainOrderErr{Order: order})
+	}
+	return nil
+}
+
+// NodeDrainResult lists the Pods that were evicted from a node, the Pods that were skipped because they do not need to
+// be evicted (DaemonSet and mirror Pods), and the Pods that could not be evicted.
+type NodeDrainResult struct {
+	NodeName string
+	Evicted  []PodDrainResult
+	Skipped  []PodDrainResult
+	Failed   []PodDrainResult
+
+	// ForceDeleted lists the Pods that were force deleted after their eviction timed out. See
+	// DrainOptions.ForceDeleteStuckPods.
+	ForceDeleted []PodDrainResult
+}
+
+func newNodeDrainResult(nodeName string) NodeDrainResult {
+	return NodeDrainResult{
+		NodeName:     nodeName,
+		Evicted:      []PodDrainResult{},
+		Skipped:      []PodDrainResult{},
+		Failed:       []PodDrainResult{},
+		ForceDeleted: []PodDrainResult{},
+	}
+}
+
+// DrainNodes drains each node provided using the eviction API. Draining a node consists of:
+// - Cordon the node so that new pods are not scheduled
+// - Evict all the pods gracefully, respecting PodDisruptionBudgets
+// See
+// https://kubernetes.io/docs/tasks/administer-cluster/safely-drain-node/
+// for more information. The nodes are drained concurrently, up to options.MaxConcurrentDrains at a time, in the order
+// determined by options.Order. The returned results list the evicted, skipped, and failed Pods of each node that was
+// drained, in the order the drains were started, even when the drain fails.
+func DrainNodes(kubectlOptions *KubectlOptions, nodeIds []string, options DrainOptions) ([]NodeDrainResult, error) {
+	client, err := GetKubernetesClientFromOptions(kubectlOptions)
+	if err != nil {
+		return nil, err
+	}
+	return DrainNodesWithClientset(client, nodeIds, options)
+}
+
+// CordonAndDrainNodes cordons all the nodes provided before draining any of them, so that the Pods evicted from one
+// node are not rescheduled on another node that is about to be drained. See DrainNodes for details on the drain.
+func CordonAndDrainNodes(kubectlOptions *KubectlOptions, nodeIds []string, options DrainOptions) ([]NodeDrainResult, error) {
+	client, err := GetKubernetesClientFromOptions(kubectlOptions)
+	if err != nil {
+		return nil, err
+	}
+	return CordonAndDrainNodesWithClientset(client, nodeIds, options)
+}
+
+// CordonAndDrainNodesWithClientset cordons and drains each node provided using the given clientset. See
+// CordonAndDrainNodes for details.
+func CordonAndDrainNodesWithClientset(clientset kubernetes.Interface, nodeIds []string, options DrainOptions) ([]NodeDrainResult, error) {
+	if err := CordonNodesWithClientset(clientset, nodeIds); err != nil {
+		return nil, err
+	}
+	return DrainNodesWithClientset(clientset, nodeIds, options)
+}
+
+// DrainNodesWithClientset drains each node provided using the given clientset. See DrainNodes for details.
+func DrainNodesWithClientset(clientset kubernetes.Interface, nodeIds []string, options DrainOptions) ([]NodeDrainResult, error) {
+	logger := logging.GetProjectLogger()
+
+	orderedNodeIds, err := orderNodesForDrain(clientset, nodeIds, options.Order)
+	if err != nil {
+		return nil, err
+	}
+	maxConcurrentDrains := options.MaxConcurrentDrains
+	if maxConcurrentDrains <= 0 || maxConcurrentDrains > len(orderedNodeIds) {
+		maxConcurrentDrains = len(orderedNodeIds)
+	}
+
+	// Drain the nodes with a pool of maxConcurrentDrains workers, so that only a bounded number of nodes lose their
+	// capacity at the same time.
+	var wg sync.WaitGroup // So that we can wait for all the drain calls
+	var mutex sync.Mutex  // Protects drainErrs
+	var drainErrs *multierror.Error
+	workers := make(chan struct{}, maxConcurrentDrains)
+	results := make([]NodeDrainResult, len(orderedNodeIds))
+	numStarted := 0
+	for i, nodeID := range orderedNodeIds {
+		workers <- struct{}{}
+
+		mutex.Lock()
+		stop := options.FailFast && drainErrs != nil
+		mutex.Unlock()
+		if stop {
+			<-workers
+			logger.Warnf("A node failed to drain. Not draining the remaining nodes: %s", strings.Join(orderedNodeIds[i:], ","))
+			break
+		}
+
+		wg.Add(1)
+		numStarted++
+		go func(nodeID string, result *NodeDrainResult) {
+			defer wg.Done()
+			defer func() { <-workers }()
+
+			*result = newNodeDrainResult(nodeID)
+			err := drainNodeWithResult(clientset, nodeID, options, result)
+			if err != nil {
+				mutex.Lock()
+				drainErrs = multierror.Append(drainErrs, err)
+				mutex.Unlock()
+			}
+		}(nodeID, &results[i])
+	}
+	wg.Wait()
+
+	return results[:numStarted], errors.WithStackTrace(drainErrs.ErrorOrNil())
+}
+
+// orderNodesForDrain returns the nodes sorted in the order they should be drained.
+func orderNodesForDrain(clientset kubernetes.Interface, nodeIds []string, order DrainOrder) ([]string, error) {
+	if err := ValidateDrainOrder(order); err != nil {
+		return nil, err
+	}
+	orderedNodeIds := append([]string{}, nodeIds...)
+
+	switch order {
+	case AZDrainOrder:
+		zones := map[string]string{}
+		for _, nodeID := range nodeIds {
+			node, err := clientset.CoreV1().Nodes().Get(context.Background(), nodeID, metav1.GetOptions{})
+			if err != nil {
+				return nil, errors.WithStackTrace(err)
+			}
+			zones[nodeID] = nodeZone(*node)
+		}
+		sort.SliceStable(orderedNodeIds, func(i, j int) bool {
+			return zones[orderedNodeIds[i]] < zones[orderedNodeIds[j]]
+		})
+	case FewestPodsDrainOrder:
+		numPods := map[string]int{}
+		for _, nodeID := range nodeIds {
+			pods, err := ListPodsOnNodeWithClientset(context.Background(), clientset, nodeID)
+			if err != nil {
+				return nil, err
+			}
+			numPods[nodeID] = len(FilterEvictablePods(pods))
+		}
+		sort.SliceStable(orderedNodeIds, func(i, j int) bool {
+			return numPods[orderedNodeIds[i]] < numPods[orderedNodeIds[j]]
+		})
+	}
+	return orderedNodeIds, nil
+}
+
+// nodeZone returns the availability zone of the node from the well known topology labels.
+func nodeZone(node corev1.Node) string {
+	if zone, hasZone := node.Labels[corev1.LabelTopologyZone]; hasZone {
+		return zone
+	}
+	return node.Labels[corev1.LabelFailureDomainBetaZone]
+}
+
+// drainNodeWithResult cordons the node and evicts all the Pods on it, recording the outcome of each Pod in result.
+// When options.JobGracePeriod is set, the Pods that should run to completion (see shouldWaitForCompletion) are evicted
+// last, after waiting up to the grace period for them to finish.
+func drainNodeWithResult(clientset kubernetes.Interface, nodeID string, options DrainOptions, result *NodeDrainResult) error {
+	logger := logging.GetProjectLogger()
+
+	ctx, cancel := newDrainContext(options)
+	defer cancel()
+
+	if err := setNodeUnschedulable(ctx, clientset, nodeID, true); err != nil {
+		return err
+	}
+
+	pods, err := ListPodsOnNodeWithClientset(ctx, clientset, nodeID)
+	if err != nil {
+		return err
+	}
+
+	// Like `kubectl drain`, check all the Pods before evicting any of them, so that a node that can not be fully
+	// drained is left untouched.
+	podsToEvict := []corev1.Pod{}
+	podsToWaitFor := []corev1.Pod{}
+	for _, pod := range pods {
+		podResult := newPodDrainResult(pod)
+		switch {
+		case IsDaemonSetPod(pod):
+			podResult.Reason = "managed by a DaemonSet"
+			result.Skipped = append(result.Skipped, podResult)
+		case IsMirrorPod(pod):
+			podResult.Reason = "mirror Pod"
+			result.Skipped = append(result.Skipped, podResult)
+		case isPodCompleted(pod):
+			// Like `kubectl drain`, Pods that already ran to completion are removed regardless of their controller and
+			// volumes, as there is nothing left to lose.
+			podsToEvict = append(podsToEvict, pod)
+		case !hasController(pod):
+			podResult.Reason = "not managed by a controller"
+			result.Failed = append(result.Failed, podResult)
+		case usesEmptyDir(pod) && !options.DeleteEmptyDirData:
+			podResult.Reason = "uses emptyDir volumes (use --delete-emptydir-data to evict)"
+			result.Failed = append(result.Failed, podResult)
+		case options.JobGracePeriod > 0 && shouldWaitForCompletion(pod, options):
+			podsToWaitFor = append(podsToWaitFor, pod)
+		default:
+			podsToEvict = append(podsToEvict, pod)
+		}
+	}
+	if len(result.Failed) > 0 {
+		return errors.WithStackTrace(NodeDrainFailedErr{NodeName: nodeID, Failed: result.Failed})
+	}
+
+	evictPods(ctx, clientset, nodeID, podsToEvict, options, result)
+
+	if len(podsToWaitFor) > 0 {
+		remainingPods, err := waitForPodsToComplete(clientset, nodeID, podsToWaitFor, options, result)
+		if err != nil {
+			return err
+		}
+		// The drain timeout does not include the grace period, so start a new one for evicting the remaining Pods.
+		remainingCtx, cancelRemaining := newDrainContext(options)
+		defer cancelRemaining()
+		evictPods(remainingCtx, clientset, nodeID, remainingPods, options, result)
+	}
+
+	logger.Infof(
+		"Drained node %s: %d Pods evicted, %d force deleted, %d skipped, %d failed",
+		nodeID,
+		len(result.Evicted),
+		len(result.ForceDeleted),
+		len(result.Skipped),
+		len(result.Failed),
+	)
+	if len(result.Failed) > 0 {
+		return errors.WithStackTrace(NodeDrainFailedErr{NodeName: nodeID, Failed: result.Failed})
+	}
+	return nil
+}
+
+// newDrainContext returns a context that expires after the drain timeout, if one is set.
+func newDrainContext(options DrainOptions) (context.Context, context.CancelFunc) {
+	if options.Timeout > 0 {
+		return context.WithTimeout(context.Background(), options.Timeout)
+	}
+	return context.WithCancel(context.Background())
+}
+
+// evictPods concurrently evicts all the Pods, as a PodDisruptionBudget may only allow evicting one Pod at a time. The
+// outcome of each Pod is recorded in result.
+func evictPods(
+	ctx context.Context,
+	clientset kubernetes.Interface,
+	nodeID string,
+	pods []corev1.Pod,
+	options DrainOptions,
+	result *NodeDrainResult,
+) {
+	logger := logging.GetProjectLogger()
+
+	var wg sync.WaitGroup
+	var mutex sync.Mutex
+	for _, pod := range pods {
+		wg.Add(1)
+		go func(pod corev1.Pod) {
+			defer wg.Done()
+			evictedAt, err := evictPod(ctx, clientset, pod, options)
+			forceDeleted := false
+			if err != nil && options.ForceDeleteStuckPods && isPodEvictionTimeout(err) {
+				logger.Warnf("Eviction of Pod %s/%s from node %s timed out: %s. Deleting it.", pod.Namespace, pod.Name, nodeID, err)
+				evictedAt, forceDeleted, err = deleteStuckPod(clientset, pod, options)
+			}
+
+			mutex.Lock()
+			defer mutex.Unlock()
+			podResult := newPodDrainResult(pod)
+			if err != nil {
+				logger.Errorf("Failed to evict Pod %s/%s from node %s: %s", pod.Namespace, pod.Name, nodeID, err)
+				podResult.Reason = err.Error()
+				result.Failed = append(result.Failed, podResult)
+				return
+			}
+			if forceDeleted {
+				logger.Warnf("Force deleted Pod %s/%s from node %s", pod.Namespace, pod.Name, nodeID)
+				podResult.Reason = "force deleted after eviction timed out"
+				podResult.EvictedAt = evictedAt
+				result.ForceDeleted = append(result.ForceDeleted, podResult)
+				return
+			}
+			logger.Infof("Evicted Pod %s/%s from node %s", pod.Namespace, pod.Name, nodeID)
+			podResult.EvictedAt = evictedAt
+			result.Evicted = append(result.Evicted, podResult)
+		}(pod)
+	}
+	wg.Wait()
+}
+
+// waitForPodsToComplete waits up to options.JobGracePeriod for the Pods to complete, logging the progress of each Pod.
+// The Pods that complete are recorded as skipped in result, and the Pods that are still running at the end of the
+// grace period are returned, so that they can be evicted.
+func waitForPodsToComplete(
+	clientset kubernetes.Interface,
+	nodeID string,
+	pods []corev1.Pod,
+	options DrainOptions,
+	result *NodeDrainResult,
+) ([]corev1.Pod, error) {
+	logger := logging.GetProjectLogger()
+	logger.Infof(
+		"Waiting up to %s for %d Pods on node %s to complete before evicting them",
+		options.JobGracePeriod,
+		len(pods),
+		nodeID,
+	)
+
+	ctx, cancel := context.WithTimeout(context.Background(), options.JobGracePeriod)
+	defer cancel()
+
+	pollInterval := options.PollInterval
+	if pollInterval <= 0 {
+		pollInterval = DefaultPollInterval
+	}
+	start := time.Now()
+	remainingPods := pods
+	for {
+		stillRunning := []corev1.Pod{}
+		for _, pod := range remainingPods {
+			currentPod, err := clientset.CoreV1().Pods(pod.Namespace).Get(ctx, pod.Name, metav1.GetOptions{})
+			if err != nil && !apierrors.IsNotFound(err) {
+				if ctx.Err() == nil {
+					return nil, errors.WithStackTrace(err)
+				}
+				// The grace period ran out while checking the Pod, so it is evicted along with the others still
+				// running.
+				stillRunning = append(stillRunning, pod)
+				continue
+			}
+			if apierrors.IsNotFound(err) || currentPod.UID != pod.UID || isPodCompleted(*currentPod) {
+				logger.Infof("Pod %s/%s on node %s completed after %s", pod.Namespace, pod.Name, nodeID, roundedSince(start))
+				podResult := newPodDrainResult(pod)
+				podResult.Reason = "completed before eviction"
+				result.Skipped = append(result.Skipped, podResult)
+				continue
+			}
+			logger.Infof(
+				"Waiting for Pod %s/%s on node %s to complete (%s elapsed, phase %s)",
+				pod.Namespace,
+				pod.Name,
+				nodeID,
+				roundedSince(start),
+				currentPod.Status.Phase,
+			)
+			stillRunning = append(stillRunning, pod)
+		}
+		remainingPods = stillRunning
+		if len(remainingPods) == 0 {
+			return remainingPods, nil
+		}
+		if err := sleepWithContext(ctx, pollInterval); err != nil {
+			for _, pod := range remainingPods {
+				logger.Warnf(
+					"Pod %s/%s on node %s did not complete within the grace period of %s. Evicting it.",
+					pod.Namespace,
+					pod.Name,
+					nodeID,
+					options.JobGracePeriod,
+				)
+			}
+			return remainingPods, nil
+		}
+	}
+}
+
+// shouldWaitForCompletion returns True when the Pod is owned by a Job, or has the annotation configured in
+// options.WaitAnnotation set to "true", meaning it should be given a chance to run to completion before being evicted.
+func shouldWaitForCompletion(pod corev1.Pod, options DrainOptions) bool {
+	if controller := metav1.GetControllerOf(&pod); controller != nil && controller.Kind == "Job" {
+		return true
+	}
+	return options.WaitAnnotation != "" && pod.Annotations[options.WaitAnnotation] == "true"
+}
+
+// isPodCompleted returns True when all the containers of the Pod have terminated, and will not be restarted.
+func isPodCompleted(pod corev1.Pod) bool {
+	return pod.Status.Phase == corev1.PodSucceeded || pod.Status.Phase == corev1.PodFailed
+}
+
+func roundedSince(start time.Time) time.Duration {
+	return time.Since(start).Round(time.Second)
+}
+
+// evictPod requests the eviction of the Pod, retrying with backoff while the eviction would violate a
+// PodDisruptionBudget, and then waits for the Pod to be deleted. Returns when the eviction was accepted.
+func evictPod(ctx context.Context, clientset kubernetes.Interface, pod corev1.Pod, options DrainOptions) (time.Time, error) {
+	logger := logging.GetProjectLogger()
+
+	if options.PodTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, options.PodTimeout)
+		defer cancel()
+	}
+
+	retryInterval := options.EvictionRetryInterval
+	if retryInterval <= 0 {
+		retryInterval = DefaultEvictionRetryInterval
+	}
+	eviction := &policyv1.Eviction{ObjectMeta: metav1.ObjectMeta{Namespace: pod.Namespace, Name: pod.Name}}
+	for {
+		err := clientset.CoreV1().Pods(pod.Namespace).EvictV1(ctx, eviction)
+		if err == nil || apierrors.IsNotFound(err) {
+			break
+		}
+		if ctx.Err() != nil {
+			return time.Time{}, errors.WithStackTrace(PodEvictionTimeoutErr{Namespace: pod.Namespace, Name: pod.Name, LastErr: err})
+		}
+		// The API server responds with 429 Too Many Requests when the eviction would violate a PodDisruptionBudget.
+		if !apierrors.IsTooManyRequests(err) {
+			return time.Time{}, errors.WithStackTrace(err)
+		}
+		logger.Warnf("Eviction of Pod %s/%s is blocked by a PodDisruptionBudget. Retrying in %s.", pod.Namespace, pod.Name, retryInterval)
+		if sleepErr := sleepWithContext(ctx, retryInterval); sleepErr != nil {
+			return time.Time{}, errors.WithStackTrace(PodEvictionTimeoutErr{Namespace: pod.Namespace, Name: pod.Name, LastErr: err})
+		}
+		retryInterval *= 2
+		if retryInterval > maxEvictionRetryInterval {
+			retryInterval = maxEvictionRetryInterval
+		}
+	}
+	evictedAt := time.Now()
+	pollInterval := options.PollInterval
+	if pollInterval <= 0 {
+		pollInterval = DefaultPollInterval
+	}
+	return evictedAt, waitForPodDeletion(ctx, clientset, pod, pollInterval)
+}
+
+// deleteStuckPod deletes a Pod whose eviction timed out, first with options.StuckPodGracePeriod, and if the Pod is
+// still not gone after twice that period, with a grace period of zero. Unlike an eviction, this ignores
+// PodDisruptionBudgets. Returns when the Pod was deleted, and whether it had to be force deleted.
+func deleteStuckPod(clientset kubernetes.Interface, pod corev1.Pod, options DrainOptions) (time.Time, bool, error) {
+	logger := logging.GetProjectLogger()
+
+	pollInterval := options.PollInterval
+	if pollInterval <= 0 {
+		pollInterval = DefaultPollInterval
+	}
+
+	if options.StuckPodGracePeriod > 0 {
+		deletedAt, err := deletePodWithGracePeriod(clientset, pod, options.StuckPodGracePeriod)
+		if err != nil {
+			return time.Time{}, false, err
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), 2*options.StuckPodGracePeriod)
+		defer cancel()
+		err = waitForPodDeletion(ctx, clientset, pod, pollInterval)
+		if err == nil {
+			return deletedAt, false, nil
+		}
+		if !isPodEvictionTimeout(err) {
+			return time.Time{}, false, err
+		}
+		logger.Warnf(
+			"Pod %s/%s is still terminating %s after being deleted. Force deleting it.",
+			pod.Namespace,
+			pod.Name,
+			2*options.StuckPodGracePeriod,
+		)
+	}
+
+	deletedAt, err := deletePodWithGracePeriod(clientset, pod, 0)
+	return deletedAt, true, err
+}
+
+// deletePodWithGracePeriod deletes the Pod with the given grace period. The Pod is only deleted if it has not been
+// replaced by another Pod with the same name in the meantime.
+func deletePodWithGracePeriod(clientset kubernetes.Interface, pod corev1.Pod, gracePeriod time.Duration) (time.Time, error) {
+	gracePeriodSeconds := int64(gracePeriod.Seconds())
+	err := clientset.CoreV1().Pods(pod.Namespace).Delete(
+		context.Background(),
+		pod.Name,
+		metav1.DeleteOptions{
+			GracePeriodSeconds: &gracePeriodSeconds,
+			Preconditions:      &metav1.Preconditions{UID: &pod.UID},
+		},
+	)
+	if err != nil && !apierrors.IsNotFound(err) && !apierrors.IsConflict(err) {
+		return time.Time{}, errors.WithStackTrace(err)
+	}
+	return time.Now(), nil
+}
+
+// isPodEvictionTimeout returns True when the error is a PodEvictionTimeoutErr.
+func isPodEvictionTimeout(err error) bool {
+	_, isTimeoutErr := errors.Unwrap(err).(PodEvictionTimeoutErr)
+	return isTimeoutErr
+}
+
+// waitForPodDeletion waits until the Pod is gone. A Pod with the same name but a different UID (e.g a replaced
+// StatefulSet Pod) counts as deleted.
+func waitForPodDeletion(ctx context.Context, clientset kubernetes.Interface, pod corev1.Pod, pollInterval time.Duration) error {
+	for {
+		currentPod, err := clientset.CoreV1().Pods(pod.Namespace).Get(ctx, pod.Name, metav1.GetOptions{})
+		if apierrors.IsNotFound(err) || (err == nil && currentPod.UID != pod.UID) {
+			return nil
+		}
+		if err != nil && ctx.Err() == nil {
+			return errors.WithStackTrace(err)
+		}
+		if err := sleepWithContext(ctx, pollInterval); err != nil {
+			return errors.WithStackTrace(PodEvictionTimeoutErr{Namespace: pod.Namespace, Name: pod.Name, LastErr: err})
+		}
+	}
+}
+
+// sleepWithContext sleeps for the given duration, returning early with the context error if the context is done.
+func sleepWithContext(ctx context.Context, duration time.Duration) error {
+	timer := time.NewTimer(duration)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+// hasController returns True when the Pod is managed by a controller (e.g a ReplicaSet), and will therefore be
+// recreated on another node after it is evicted.
+func hasController(pod corev1.Pod) bool {
+	return metav1.GetControllerOf(&pod) != nil
+}
+
+// usesEmptyDir returns True when the Pod has emptyDir volumes, whose data is lost when the Pod is evicted.
+func usesEmptyDir(pod corev1.Pod) bool {
+	for _, volume := range pod.Spec.Volumes {
+		if volume.EmptyDir != nil {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,414 @@
+package kubectl
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	policyv1 "k8s.io/api/policy/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+func TestDrainNodesWithClientset(t *testing.T) {
+	t.Parallel()
+
+	emptyDirPod := newDrainTestPod("scratch", "node-a", "ReplicaSet")
+	emptyDirPod.Spec.Volumes = []corev1.Volume{
+		{Name: "scratch", VolumeSource: corev1.VolumeSource{EmptyDir: &corev1.EmptyDirVolumeSource{}}},
+	}
+	mirrorPod := newDrainTestPod("kube-proxy", "node-a", "")
+	mirrorPod.Annotations = map[string]string{corev1.MirrorPodAnnotationKey: "mirror"}
+	clientset := newDrainTestClientset(
+		map[string]int{"web-2": 2},
+		&corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: "node-a"}},
+		newDrainTestPod("web-1", "node-a", "ReplicaSet"),
+		newDrainTestPod("web-2", "node-a", "ReplicaSet"),
+		newDrainTestPod("fluentd", "node-a", "DaemonSet"),
+		newDrainTestPod("other", "node-b", "ReplicaSet"),
+		emptyDirPod,
+		mirrorPod,
+	)
+
+	results, err := DrainNodesWithClientset(
+		clientset,
+		[]string{"node-a"},
+		DrainOptions{Timeout: time.Minute, DeleteEmptyDirData: true, EvictionRetryInterval: time.Millisecond},
+	)
+	require.NoError(t, err)
+	require.Equal(t, 1, len(results))
+	result := results[0]
+	assert.Equal(t, "node-a", result.NodeName)
+	assert.ElementsMatch(t, []string{"web-1", "web-2", "scratch"}, drainResultPodNames(result.Evicted))
+	assert.ElementsMatch(t, []string{"fluentd", "kube-proxy"}, drainResultPodNames(result.Skipped))
+	assert.Equal(t, []PodDrainResult{}, result.Failed)
+
+	node, err := clientset.CoreV1().Nodes().Get(context.Background(), "node-a", metav1.GetOptions{})
+	require.NoError(t, err)
+	assert.True(t, node.Spec.Unschedulable)
+	_, err = clientset.CoreV1().Pods("default").Get(context.Background(), "other", metav1.GetOptions{})
+	assert.NoError(t, err)
+}
+
+func TestDrainNodesWithClientsetRefusesUnsafePods(t *testing.T) {
+	t.Parallel()
+
+	emptyDirPod := newDrainTestPod("scratch", "node-a", "ReplicaSet")
+	emptyDirPod.Spec.Volumes = []corev1.Volume{
+		{Name: "scratch", VolumeSource: corev1.VolumeSource{EmptyDir: &corev1.EmptyDirVolumeSource{}}},
+	}
+	clientset := newDrainTestClientset(
+		map[string]int{},
+		&corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: "node-a"}},
+		newDrainTestPod("web-1", "node-a", "ReplicaSet"),
+		newDrainTestPod("naked", "node-a", ""),
+		emptyDirPod,
+	)
+
+	results, err := DrainNodesWithClientset(clientset, []string{"node-a"}, DrainOptions{})
+	require.Error(t, err)
+	assert.ElementsMatch(t, []string{"naked", "scratch"}, drainResultPodNames(results[0].Failed))
+	assert.Equal(t, []PodDrainResult{}, results[0].Evicted)
+
+	// No Pods are evicted when the node can not be fully drained.
+	_, err = clientset.CoreV1().Pods("default").Get(context.Background(), "web-1", metav1.GetOptions{})
+	assert.NoError(t, err)
+}
+
+func TestDrainNodesWithClientsetEvictsCompletedPods(t *testing.T) {
+	t.Parallel()
+
+	succeededPod := newDrainTestPod("naked-succeeded", "node-a", "")
+	succeededPod.Status.Phase = corev1.PodSucceeded
+	failedPod := newDrainTestPod("scratch-failed", "node-a", "ReplicaSet")
+	failedPod.Spec.Volumes = []corev1.Volume{
+		{Name: "scratch", VolumeSource: corev1.VolumeSource{EmptyDir: &corev1.EmptyDirVolumeSource{}}},
+	}
+	failedPod.Status.Phase = corev1.PodFailed
+	clientset := newDrainTestClientset(
+		map[string]int{},
+		&corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: "node-a"}},
+		newDrainTestPod("web-1", "node-a", "ReplicaSet"),
+		succeededPod,
+		failedPod,
+	)
+
+	results, err := DrainNodesWithClientset(clientset, []string{"node-a"}, DrainOptions{PollInterval: 10 * time.Millisecond})
+	require.NoError(t, err)
+	assert.Empty(t, results[0].Failed)
+	assert.ElementsMatch(t, []string{"web-1", "naked-succeeded", "scratch-failed"}, drainResultPodNames(results[0].Evicted))
+	for _, name := range []string{"naked-succeeded", "scratch-failed"} {
+		_, err = clientset.CoreV1().Pods("default").Get(context.Background(), name, metav1.GetOptions{})
+		assert.True(t, apierrors.IsNotFound(err))
+	}
+}
+
+func TestDrainNodesWithClientsetTimesOutOnPDB(t *testing.T) {
+	t.Parallel()
+
+	clientset := newDrainTestClientset(
+		map[string]int{"db-0": 1000},
+		&corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: "node-a"}},
+		newDrainTestPod("db-0", "node-a", "StatefulSet"),
+		newDrainTestPod("web-1", "node-a", "ReplicaSet"),
+	)
+
+	results, err := DrainNodesWithClientset(
+		clientset,
+		[]string{"node-a"},
+		DrainOptions{PodTimeout: 50 * time.Millisecond, EvictionRetryInterval: time.Millisecond},
+	)
+	require.Error(t, err)
+	assert.Equal(t, []string{"web-1"}, drainResultPodNames(results[0].Evicted))
+	require.Equal(t, 1, len(results[0].Failed))
+	assert.Equal(t, "db-0", results[0].Failed[0].Name)
+
+	assert.Contains(t, err.Error(), "Failed to drain node node-a")
+	assert.Contains(t, err.Error(), "db-0")
+}
+
+func TestCordonAndDrainNodesWithClientsetCordonsAllNodesFirst(t *testing.T) {
+	t.Parallel()
+
+	clientset := newDrainTestClientset(
+		map[string]int{},
+		&corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: "node-a"}},
+		&corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: "node-b"}},
+		newDrainTestPod("web-1", "node-a", "ReplicaSet"),
+		newDrainTestPod("web-2", "node-b", "ReplicaSet"),
+	)
+	// Record which nodes are schedulable whenever a Pod is evicted.
+	var mutex sync.Mutex
+	schedulableNodesAtEviction := []string{}
+	clientset.PrependReactor("create", "pods", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		if action.GetSubresource() != "eviction" {
+			return false, nil, nil
+		}
+		mutex.Lock()
+		defer mutex.Unlock()
+		for _, nodeName := range []string{"node-a", "node-b"} {
+			obj, err := clientset.Tracker().Get(schema.GroupVersionResource{Version: "v1", Resource: "nodes"}, "", nodeName)
+			if err != nil {
+				return true, nil, err
+			}
+			if !obj.(*corev1.Node).Spec.Unschedulable {
+				schedulableNodesAtEviction = append(schedulableNodesAtEviction, nodeName)
+			}
+		}
+		return false, nil, nil
+	})
+
+	results, err := CordonAndDrainNodesWithClientset(
+		clientset,
+		[]string{"node-a", "node-b"},
+		DrainOptions{Timeout: time.Minute, MaxConcurrentDrains: 1, EvictionRetryInterval: time.Millisecond},
+	)
+	require.NoError(t, err)
+	require.Equal(t, 2, len(results))
+	assert.Equal(t, []string{"web-1"}, drainResultPodNames(results[0].Evicted))
+	assert.Equal(t, []string{"web-2"}, drainResultPodNames(results[1].Evicted))
+	assert.Empty(t, schedulableNodesAtEviction)
+}
+
+// newDrainTestClientset returns a fake clientset where evicting a Pod deletes it, after rejecting the eviction with a
+// PodDisruptionBudget violation the provided number of times for the Pod.
+func newDrainTestClientset(pdbRejections map[string]int, objects ...runtime.Object) *fake.Clientset {
+	var mutex sync.Mutex
+	clientset := fake.NewSimpleClientset(objects...)
+	clientset.PrependReactor("create", "pods", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		if action.GetSubresource() != "eviction" {
+			return false, nil, nil
+		}
+		mutex.Lock()
+		defer mutex.Unlock()
+		eviction := action.(k8stesting.CreateAction).GetObject().(*policyv1.Eviction)
+		if pdbRejections[eviction.Name] > 0 {
+			pdbRejections[eviction.Name]--
+			return true, nil, apierrors.NewTooManyRequests("Cannot evict pod as it would violate the pod's disruption budget.", 0)
+		}
+		podsResource := schema.GroupVersionResource{Version: "v1", Resource: "pods"}
+		return true, nil, clientset.Tracker().Delete(podsResource, eviction.Namespace, eviction.Name)
+	})
+	return clientset
+}
+
+func newDrainTestPod(name string, nodeName string, ownerKind string) *corev1.Pod {
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: name},
+		Spec:       corev1.PodSpec{NodeName: nodeName},
+	}
+	if ownerKind != "" {
+		isController := true
+		pod.OwnerReferences = []metav1.OwnerReference{{Kind: ownerKind, Name: "owner", Controller: &isController}}
+	}
+	return pod
+}
+
+func drainResultPodNames(results []PodDrainResult) []string {
+	names := []string{}
+	for _, result := range results {
+		names = append(names, result.Name)
+	}
+	return names
+}
+
+func TestOrderNodesForDrain(t *testing.T) {
+	t.Parallel()
+
+	clientset := fake.NewSimpleClientset(
+		&corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: "node-a", Labels: map[string]string{corev1.LabelTopologyZone: "us-east-2b"}}},
+		&corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: "node-b", Labels: map[string]string{corev1.LabelTopologyZone: "us-east-2a"}}},
+		&corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: "node-c", Labels: map[string]string{corev1.LabelFailureDomainBetaZone: "us-east-2b"}}},
+		newDrainTestPod("web-1", "node-a", "ReplicaSet"),
+		newDrainTestPod("web-2", "node-a", "ReplicaSet"),
+		newDrainTestPod("web-3", "node-b", "ReplicaSet"),
+		newDrainTestPod("fluentd-1", "node-c", "DaemonSet"),
+		newDrainTestPod("fluentd-2", "node-b", "DaemonSet"),
+	)
+	nodeIds := []string{"node-a", "node-b", "node-c"}
+
+	testCases := []struct {
+		order    DrainOrder
+		expected []string
+	}{
+		{"", []string{"node-a", "node-b", "node-c"}},
+		{GivenDrainOrder, []string{"node-a", "node-b", "node-c"}},
+		{AZDrainOrder, []string{"node-b", "node-a", "node-c"}},
+		{FewestPodsDrainOrder, []string{"node-c", "node-b", "node-a"}},
+	}
+
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(string(tc.order), func(t *testing.T) {
+			t.Parallel()
+
+			ordered, err := orderNodesForDrain(clientset, nodeIds, tc.order)
+			require.NoError(t, err)
+			assert.Equal(t, tc.expected, ordered)
+		})
+	}
+
+	_, err := orderNodesForDrain(clientset, nodeIds, DrainOrder("random"))
+	assert.Error(t, err)
+}
+
+func TestDrainNodesWithClientsetFailFast(t *testing.T) {
+	t.Parallel()
+
+	clientset := newDrainTestClientset(
+		map[string]int{},
+		&corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: "node-a"}},
+		&corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: "node-b"}},
+		newDrainTestPod("naked", "node-a", ""),
+		newDrainTestPod("web-1", "node-b", "ReplicaSet"),
+	)
+
+	results, err := DrainNodesWithClientset(
+		clientset,
+		[]string{"node-a", "node-b"},
+		DrainOptions{MaxConcurrentDrains: 1, FailFast: true},
+	)
+	require.Error(t, err)
+	require.Equal(t, 1, len(results))
+	assert.Equal(t, "node-a", results[0].NodeName)
+
+	// The second node is never touched once the first one fails.
+	node, err := clientset.CoreV1().Nodes().Get(context.Background(), "node-b", metav1.GetOptions{})
+	require.NoError(t, err)
+	assert.False(t, node.Spec.Unschedulable)
+	_, err = clientset.CoreV1().Pods("default").Get(context.Background(), "web-1", metav1.GetOptions{})
+	assert.NoError(t, err)
+
+	// Without fail fast, the remaining nodes are drained after a failure.
+	results, err = DrainNodesWithClientset(
+		clientset,
+		[]string{"node-a", "node-b"},
+		DrainOptions{MaxConcurrentDrains: 1},
+	)
+	require.Error(t, err)
+	require.Equal(t, 2, len(results))
+	assert.Equal(t, []string{"web-1"}, drainResultPodNames(results[1].Evicted))
+}
+
+func TestDrainNodesWithClientsetWaitsForJobs(t *testing.T) {
+	t.Parallel()
+
+	batchPod := newDrainTestPod("batch", "node-a", "ReplicaSet")
+	batchPod.Annotations = map[string]string{DefaultWaitAnnotation: "true"}
+	clientset := newDrainTestClientset(
+		map[string]int{},
+		&corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: "node-a"}},
+		newDrainTestPod("web-1", "node-a", "ReplicaSet"),
+		newDrainTestPod("job-1", "node-a", "Job"),
+		batchPod,
+	)
+
+	// The Job completes during the grace period, while the annotated Pod keeps running until it is evicted.
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		job, err := clientset.CoreV1().Pods("default").Get(context.Background(), "job-1", metav1.GetOptions{})
+		if err != nil {
+			return
+		}
+		job.Status.Phase = corev1.PodSucceeded
+		clientset.CoreV1().Pods("default").Update(context.Background(), job, metav1.UpdateOptions{})
+	}()
+
+	results, err := DrainNodesWithClientset(
+		clientset,
+		[]string{"node-a"},
+		DrainOptions{
+			JobGracePeriod: 500 * time.Millisecond,
+			WaitAnnotation: DefaultWaitAnnotation,
+			PollInterval:   10 * time.Millisecond,
+		},
+	)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"web-1", "batch"}, drainResultPodNames(results[0].Evicted))
+	require.Equal(t, 1, len(results[0].Skipped))
+	assert.Equal(t, "job-1", results[0].Skipped[0].Name)
+	assert.Equal(t, "completed before eviction", results[0].Skipped[0].Reason)
+}
+
+func TestShouldWaitForCompletion(t *testing.T) {
+	t.Parallel()
+
+	annotatedPod := newDrainTestPod("annotated", "node-a", "ReplicaSet")
+	annotatedPod.Annotations = map[string]string{DefaultWaitAnnotation: "true"}
+	options := DrainOptions{WaitAnnotation: DefaultWaitAnnotation}
+
+	assert.True(t, shouldWaitForCompletion(*newDrainTestPod("job", "node-a", "Job"), options))
+	assert.True(t, shouldWaitForCompletion(*annotatedPod, options))
+	assert.False(t, shouldWaitForCompletion(*annotatedPod, DrainOptions{}))
+	assert.False(t, shouldWaitForCompletion(*newDrainTestPod("web", "node-a", "ReplicaSet"), options))
+}
+
+func TestMaxNodeDrainDuration(t *testing.T) {
+	t.Parallel()
+
+	assert.Equal(t, time.Duration(0), DrainOptions{JobGracePeriod: time.Minute}.MaxNodeDrainDuration())
+	assert.Equal(t, 10*time.Minute, DrainOptions{Timeout: 10 * time.Minute}.MaxNodeDrainDuration())
+	assert.Equal(
+		t,
+		25*time.Minute,
+		DrainOptions{Timeout: 10 * time.Minute, JobGracePeriod: 5 * time.Minute}.MaxNodeDrainDuration(),
+	)
+	assert.Equal(
+		t,
+		12*time.Minute,
+		DrainOptions{Timeout: 10 * time.Minute, ForceDeleteStuckPods: true, StuckPodGracePeriod: 30 * time.Second}.MaxNodeDrainDuration(),
+	)
+}
+
+func TestDrainNodesWithClientsetForceDeletesStuckPods(t *testing.T) {
+	t.Parallel()
+
+	clientset := newDrainTestClientset(
+		map[string]int{"db-0": 1000},
+		&corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: "node-a"}},
+		newDrainTestPod("db-0", "node-a", "StatefulSet"),
+		newDrainTestPod("stuck", "node-a", "ReplicaSet"),
+		newDrainTestPod("web-1", "node-a", "ReplicaSet"),
+	)
+	// The stuck Pod accepts the eviction and graceful deletes, but never terminates, like a Pod stuck on an
+	// unresponsive kubelet. Only a force delete removes it.
+	clientset.PrependReactor("create", "pods", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		eviction, isEviction := action.(k8stesting.CreateAction).GetObject().(*policyv1.Eviction)
+		return isEviction && eviction.Name == "stuck", nil, nil
+	})
+	clientset.PrependReactor("delete", "pods", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		deleteAction := action.(k8stesting.DeleteAction)
+		gracePeriodSeconds := deleteAction.GetDeleteOptions().GracePeriodSeconds
+		isGraceful := gracePeriodSeconds == nil || *gracePeriodSeconds > 0
+		return deleteAction.GetName() == "stuck" && isGraceful, nil, nil
+	})
+
+	options := DrainOptions{
+		PodTimeout:            50 * time.Millisecond,
+		EvictionRetryInterval: time.Millisecond,
+		PollInterval:          10 * time.Millisecond,
+		ForceDeleteStuckPods:  true,
+		StuckPodGracePeriod:   time.Second,
+	}
+	results, err := DrainNodesWithClientset(clientset, []string{"node-a"}, options)
+	require.NoError(t, err)
+	result := results[0]
+	// The Pod blocked by the PodDisruptionBudget is deleted with the shortened grace period, while the stuck Pod has to
+	// be force deleted.
+	assert.ElementsMatch(t, []string{"db-0", "web-1"}, drainResultPodNames(result.Evicted))
+	assert.Equal(t, []string{"stuck"}, drainResultPodNames(result.ForceDeleted))
+	assert.Equal(t, []PodDrainResult{}, result.Failed)
+
+	for _, name := range []string{"db-0", "stuck", "web-1"} {
+		_, err := clientset.CoreV1().Pods("default").Get(context.Background(), name, metav1.GetOptions{})
+		assert.True(t, apierrors.IsNotFound(err), name)
+	}
+}
@@ -2,6 +2,7 @@ package kubectl
 
 import (
 	"fmt"
+	"strings"
 )
 
 // KubeContextNotFound error is returned when the specified Kubernetes context is unabailable in the specified
@@ -49,10 +50,38 @@ func NewNodeReadyTimeoutError(numNodes int) NodeReadyTimeoutError {
 	return NodeReadyTimeoutError{numNodes}
 }
 
-// NodeDrainError is returned when there is an error draining a node.
-type NodeDrainError struct {
-	Error  error
-	NodeID string
+// NodeDrainFailedErr is returned when some of the Pods on a node could not be evicted.
+type NodeDrainFailedErr struct {
+	NodeName string
+	Failed   []PodDrainResult
+}
+
+func (err NodeDrainFailedErr) Error() string {
+	pods := []string{}
+	for _, pod := range err.Failed {
+		pods = append(pods, pod.String())
+	}
+	return fmt.Sprintf("Failed to drain node %s. The following Pods could not be evicted: %s", err.NodeName, strings.Join(pods, ", "))
+}
+
+// InvalidDrainOrderErr is returned when the requested drain order is not supported.
+type InvalidDrainOrderErr struct {
+	Order DrainOrder
+}
+
+func (err InvalidDrainOrderErr) Error() string {
+	return fmt.Sprintf("Invalid drain order %s: must be one of %s.", err.Order, strings.Join(DrainOrders, ", "))
+}
+
+// PodEvictionTimeoutErr is returned when a Pod could not be evicted within the timeout.
+type PodEvictionTimeoutErr struct {
+	Namespace string
+	Name      string
+	LastErr   error
+}
+
+func (err PodEvictionTimeoutErr) Error() string {
+	return fmt.Sprintf("Timed out evicting Pod %s/%s: %s", err.Namespace, err.Name, err.LastErr)
 }
 
 // NodeCordonError is returned when there is an error cordoning a node.
@@ -61,6 +90,12 @@ type NodeCordonError struct {
 	NodeID string
 }
 
+// NodeUncordonError is returned when there is an error uncordoning a node.
+type NodeUncordonError struct {
+	Error  error
+	NodeID string
+}
+
 // LoadBalancerNotReadyError is returned when the LoadBalancer Service is unexpectedly not ready.
 type LoadBalancerNotReadyError struct {
 	serviceName string
@@ -114,3 +149,12 @@ func (err UnknownAWSLoadBalancerTypeErr) Error() string {
 		err.typeStr,
 	)
 }
+
+// KubectlDiffErr is returned when kubectl diff fails, as opposed to exiting with differences.
+type KubectlDiffErr struct {
+	stderr string
+}
+
+func (err KubectlDiffErr) Error() string {
+	return fmt.Sprintf("kubectl diff failed: %s", strings.TrimSpace(err.stderr))
+}
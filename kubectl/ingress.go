@@ -4,13 +4,22 @@ import (
 	"context"
 	"time"
 
+	"github.com/gruntwork-io/go-commons/collections"
 	"github.com/gruntwork-io/go-commons/errors"
 	networkingv1 "k8s.io/api/networking/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
 
 	"github.com/gruntwork-io/kubergrunt/logging"
 )
 
+const (
+	albIngressController       = "ingress.k8s.aws/alb"
+	ingressClassAnnotationKey  = "kubernetes.io/ingress.class"
+	ingressClassAnnotationALB  = "alb"
+	albTargetTypeAnnotationKey = "alb.ingress.kubernetes.io/target-type"
+)
+
 // GetIngress returns a Kubernetes Ingress resource in the provided namespace with the given name.
 func GetIngress(options *KubectlOptions, namespace string, ingressName string) (*networkingv1.Ingress, error) {
 	client, err := GetKubernetesClientFromOptions(options)
@@ -71,3 +80,128 @@ func WaitUntilIngressEndpointProvisioned(
 	}
 	return errors.WithStackTrace(ProvisionIngressEndpointTimeoutError{ingressName: ingressName, namespace: namespace})
 }
+
+// GetAllIngresses queries Kubernetes for information on all deployed Ingress resources in the current cluster that the
+// provided client can access.
+func GetAllIngresses(clientset kubernetes.Interface) ([]networkingv1.Ingress, error) {
+	// We use the empty string for the namespace to indicate all namespaces
+	ingressesApi := clientset.NetworkingV1().Ingresses("")
+
+	ingresses := []networkingv1.Ingress{}
+	params := metav1.ListOptions{}
+	for {
+		resp, err := ingressesApi.List(context.Background(), params)
+		if err != nil {
+			return nil, errors.WithStackTrace(err)
+		}
+		ingresses = append(ingresses, resp.Items...)
+		if resp.Continue == "" {
+			break
+		}
+		params.Continue = resp.Continue
+	}
+	return ingresses, nil
+}
+
+// GetALBIngressLoadBalancers returns the Application Load Balancers that the AWS Load Balancer Controller provisioned
+// for the Ingress resources in the cluster. The ALB of each Ingress is found from the hostname in the Ingress status.
+// Multiple Ingress resources in the same IngressGroup share a single ALB, which is only returned once. Ingress
+// resources that do not have an ALB yet are skipped. Note that load balancers provisioned for Gateway API resources are
+// not covered.
+func GetALBIngressLoadBalancers(clientset kubernetes.Interface) ([]AWSLoadBalancer, error) {
+	logger := logging.GetProjectLogger()
+
+	albIngressClasses, defaultClassIsALB, err := getALBIngressClasses(clientset)
+	if err != nil {
+		return nil, err
+	}
+	ingresses, err := GetAllIngresses(clientset)
+	if err != nil {
+		return nil, err
+	}
+
+	lbs := []AWSLoadBalancer{}
+	lbIndexes := map[string]int{}
+	numALBIngresses := 0
+	for _, ingress := range ingresses {
+		if !isALBIngress(ingress, albIngressClasses, defaultClassIsALB) {
+			continue
+		}
+		numALBIngresses++
+		endpoints := ingress.Status.LoadBalancer.Ingress
+		if len(endpoints) == 0 || endpoints[0].Hostname == "" {
+			logger.Warnf("Ingress %s (Namespace: %s) has no ALB provisioned yet. Skipping.", ingress.Name, ingress.Namespace)
+			continue
+		}
+		lbName, err := getAWSLoadBalancerNameFromHostname(endpoints[0].Hostname)
+		if err != nil {
+			return nil, err
+		}
+		lbTargetType, err := GetLoadBalancerTargetTypeFromIngress(ingress)
+		if err != nil {
+			return nil, err
+		}
+
+		index, isKnownLB := lbIndexes[lbName]
+		if !isKnownLB {
+			lbIndexes[lbName] = len(lbs)
+			lbs = append(lbs, AWSLoadBalancer{Name: lbName, Type: ALB, TargetType: lbTargetType})
+		} else if lbTargetType == InstanceTarget {
+			// The Ingress resources of an IngressGroup can use different target types. The ALB routes to instances if
+			// any of them does.
+			lbs[index].TargetType = InstanceTarget
+		}
+	}
+	logger.Infof("Found %d ALBs for %d ALB Ingress resources of %d Ingress resources in kubernetes.", len(lbs), numALBIngresses, len(ingresses))
+	return lbs, nil
+}
+
+// GetLoadBalancerTargetTypeFromIngress returns the target type of the ALB Ingress, based on the
+// alb.ingress.kubernetes.io/target-type annotation. When the annotation is not set, the target type is instance.
+func GetLoadBalancerTargetTypeFromIngress(ingress networkingv1.Ingress) (ELBTargetType, error) {
+	lbTargetTypeString, hasLBTargetAnnotation := ingress.ObjectMeta.Annotations[albTargetTypeAnnotationKey]
+	if !hasLBTargetAnnotation {
+		return InstanceTarget, nil
+	}
+	switch lbTargetTypeString {
+	case lbTargetAnnotationInstance:
+		return InstanceTarget, nil
+	case lbTargetAnnotationIP:
+		return IPTarget, nil
+	default:
+		return UnknownELBTarget, errors.WithStackTrace(UnknownAWSLoadBalancerTypeErr{typeKey: albTargetTypeAnnotationKey, typeStr: lbTargetTypeString})
+	}
+}
+
+// getALBIngressClasses returns the names of the IngressClasses handled by the AWS Load Balancer Controller, and whether
+// the default IngressClass of the cluster is one of them.
+func getALBIngressClasses(clientset kubernetes.Interface) ([]string, bool, error) {
+	ingressClasses, err := clientset.NetworkingV1().IngressClasses().List(context.Background(), metav1.ListOptions{})
+	if err != nil {
+		return nil, false, errors.WithStackTrace(err)
+	}
+	albIngressClasses := []string{}
+	defaultClassIsALB := false
+	for _, ingressClass := range ingressClasses.Items {
+		if ingressClass.Spec.Controller != albIngressController {
+			continue
+		}
+		albIngressClasses = append(albIngressClasses, ingressClass.Name)
+		if ingressClass.Annotations[networkingv1.AnnotationIsDefaultIngressClass] == "true" {
+			defaultClassIsALB = true
+		}
+	}
+	return albIngressClasses, defaultClassIsALB, nil
+}
+
+// isALBIngress returns True when the Ingress is handled by the AWS Load Balancer Controller, either through its
+// IngressClass, the legacy kubernetes.io/ingress.class annotation, or the default IngressClass of the cluster.
+func isALBIngress(ingress networkingv1.Ingress, albIngressClasses []string, defaultClassIsALB bool) bool {
+	if ingress.Spec.IngressClassName != nil {
+		return collections.ListContainsElement(albIngressClasses, *ingress.Spec.IngressClassName)
+	}
+	if ingressClass, hasAnnotation := ingress.ObjectMeta.Annotations[ingressClassAnnotationKey]; hasAnnotation {
+		return ingressClass == ingressClassAnnotationALB
+	}
+	return defaultClassIsALB
+}
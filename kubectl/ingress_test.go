@@ -8,7 +8,11 @@ import (
 
 	"github.com/gruntwork-io/terratest/modules/k8s"
 	"github.com/gruntwork-io/terratest/modules/random"
+	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	networkingv1 "k8s.io/api/networking/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
 )
 
 const ExampleIngressName = "nginx-service-ingress"
@@ -123,3 +127,69 @@ spec:
             port: 
               number: 80
 `
+
+func TestGetALBIngressLoadBalancers(t *testing.T) {
+	t.Parallel()
+
+	albClassName := "alb"
+	nginxClassName := "nginx"
+	clientset := fake.NewSimpleClientset(
+		&networkingv1.IngressClass{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:        albClassName,
+				Annotations: map[string]string{networkingv1.AnnotationIsDefaultIngressClass: "true"},
+			},
+			Spec: networkingv1.IngressClassSpec{Controller: albIngressController},
+		},
+		&networkingv1.IngressClass{
+			ObjectMeta: metav1.ObjectMeta{Name: nginxClassName},
+			Spec:       networkingv1.IngressClassSpec{Controller: "k8s.io/ingress-nginx"},
+		},
+		// Two Ingresses in the same IngressGroup, sharing an ALB.
+		newTestIngress("web", &albClassName, nil, "k8s-shared-1a2b3c4d5e-123456789.us-east-2.elb.amazonaws.com"),
+		newTestIngress(
+			"api",
+			&albClassName,
+			map[string]string{albTargetTypeAnnotationKey: "ip"},
+			"k8s-shared-1a2b3c4d5e-123456789.us-east-2.elb.amazonaws.com",
+		),
+		// Uses the default IngressClass, and only routes to Pods.
+		newTestIngress(
+			"admin",
+			nil,
+			map[string]string{albTargetTypeAnnotationKey: "ip"},
+			"internal-k8s-default-admin-9f8e7d6c5b-987654321.us-east-2.elb.amazonaws.com",
+		),
+		newTestIngress(
+			"legacy",
+			nil,
+			map[string]string{ingressClassAnnotationKey: "alb"},
+			"k8s-default-legacy-0a1b2c3d4e-111111111.us-east-2.elb.amazonaws.com",
+		),
+		newTestIngress("nginx", &nginxClassName, nil, "a1b2c3d4e5f6-222222222.us-east-2.elb.amazonaws.com"),
+		newTestIngress("not-provisioned", &albClassName, nil, ""),
+	)
+
+	lbs, err := GetALBIngressLoadBalancers(clientset)
+	require.NoError(t, err)
+	assert.ElementsMatch(
+		t,
+		[]AWSLoadBalancer{
+			{Name: "k8s-shared-1a2b3c4d5e", Type: ALB, TargetType: InstanceTarget},
+			{Name: "k8s-default-admin-9f8e7d6c5b", Type: ALB, TargetType: IPTarget},
+			{Name: "k8s-default-legacy-0a1b2c3d4e", Type: ALB, TargetType: InstanceTarget},
+		},
+		lbs,
+	)
+}
+
+func newTestIngress(name string, ingressClassName *string, annotations map[string]string, hostname string) *networkingv1.Ingress {
+	ingress := &networkingv1.Ingress{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: name, Annotations: annotations},
+		Spec:       networkingv1.IngressSpec{IngressClassName: ingressClassName},
+	}
+	if hostname != "" {
+		ingress.Status.LoadBalancer.Ingress = []networkingv1.IngressLoadBalancerIngress{{Hostname: hostname}}
+	}
+	return ingress
+}
@@ -2,6 +2,7 @@ package kubectl
 
 import (
 	"context"
+	"fmt"
 	"sync"
 	"time"
 
@@ -10,6 +11,7 @@ import (
 	"github.com/hashicorp/go-multierror"
 	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/client-go/kubernetes"
 
 	"github.com/gruntwork-io/kubergrunt/logging"
@@ -99,96 +101,213 @@ func filterNodesByID(nodes []corev1.Node, nodeIds []string) []corev1.Node {
 	return filteredNodes
 }
 
-// DrainNodes calls `kubectl drain` on each node provided. Draining a node consists of:
-// - Taint the nodes so that new pods are not scheduled
-// - Evict all the pods gracefully
-// See
-// https://kubernetes.io/docs/tasks/administer-cluster/safely-drain-node/#use-kubectl-drain-to-remove-a-node-from-service
-// for more information.
-func DrainNodes(kubectlOptions *KubectlOptions, nodeIds []string, timeout time.Duration, deleteEmptyDirData bool) error {
-	// Concurrently trigger drain events for all requested nodes.
-	var wg sync.WaitGroup // So that we can wait for all the drain calls
-	errChans := []chan NodeDrainError{}
+// CordonNodes cordons each node provided. Cordoning a node makes it unschedulable, preventing new Pods from being
+// scheduled on the node. Note that cordoning a node does not evict the running Pods. To evict existing Pods, use
+// DrainNodes.
+func CordonNodes(kubectlOptions *KubectlOptions, nodeIds []string) error {
+	client, err := GetKubernetesClientFromOptions(kubectlOptions)
+	if err != nil {
+		return err
+	}
+	return CordonNodesWithClientset(client, nodeIds)
+}
+
+// CordonNodesWithClientset cordons each node provided using the given clientset. See CordonNodes for details.
+func CordonNodesWithClientset(client kubernetes.Interface, nodeIds []string) error {
+	// Concurrently trigger cordon events for all requested nodes.
+	var wg sync.WaitGroup // So that we can wait for all the cordon calls
+	errChans := []chan NodeCordonError{}
 	for _, nodeID := range nodeIds {
 		wg.Add(1)
-		errChannel := make(chan NodeDrainError, 1)
-		go drainNode(&wg, errChannel, kubectlOptions, nodeID, timeout, deleteEmptyDirData)
+		errChannel := make(chan NodeCordonError, 1) // Collect all errors from each command
+		go cordonNode(&wg, errChannel, client, nodeID)
 		errChans = append(errChans, errChannel)
 	}
 	wg.Wait()
 
-	var drainErrs *multierror.Error
+	var cordonErrs *multierror.Error
 	for _, errChan := range errChans {
 		err := <-errChan
 		if err.Error != nil {
-			drainErrs = multierror.Append(drainErrs, err.Error)
+			cordonErrs = multierror.Append(cordonErrs, err.Error)
 		}
 	}
-	return errors.WithStackTrace(drainErrs.ErrorOrNil())
+	return errors.WithStackTrace(cordonErrs.ErrorOrNil())
 }
 
-func drainNode(
+func cordonNode(
 	wg *sync.WaitGroup,
-	errChannel chan<- NodeDrainError,
-	kubectlOptions *KubectlOptions,
+	errChannel chan<- NodeCordonError,
+	clientset kubernetes.Interface,
 	nodeID string,
-	timeout time.Duration,
-	deleteEmptyDirData bool,
 ) {
 	defer wg.Done()
 	defer close(errChannel)
+	err := setNodeUnschedulable(context.Background(), clientset, nodeID, true)
+	errChannel <- NodeCordonError{NodeID: nodeID, Error: err}
+}
 
-	args := []string{"drain", nodeID, "--ignore-daemonsets", "--timeout", timeout.String()}
-
-	if deleteEmptyDirData {
-		args = append(args, "--delete-emptydir-data")
+// UncordonNodes uncordons each node provided. Uncordoning a node makes it schedulable again, allowing new Pods to be
+// scheduled on the node.
+func UncordonNodes(kubectlOptions *KubectlOptions, nodeIds []string) error {
+	client, err := GetKubernetesClientFromOptions(kubectlOptions)
+	if err != nil {
+		return err
 	}
 
-	err := RunKubectl(kubectlOptions, args...)
-	errChannel <- NodeDrainError{NodeID: nodeID, Error: err}
-}
-
-// CordonNodes calls `kubectl cordon` on each node provided. Cordoning a node makes it unschedulable, preventing new
-// Pods from being scheduled on the node. Note that cordoning a node does not evict the running Pods. To evict existing
-// Pods, use DrainNodes.
-func CordonNodes(kubectlOptions *KubectlOptions, nodeIds []string) error {
-	// Concurrently trigger cordon events for all requested nodes.
-	var wg sync.WaitGroup // So that we can wait for all the cordon calls
-	errChans := []chan NodeCordonError{}
+	// Concurrently trigger uncordon events for all requested nodes.
+	var wg sync.WaitGroup // So that we can wait for all the uncordon calls
+	errChans := []chan NodeUncordonError{}
 	for _, nodeID := range nodeIds {
 		wg.Add(1)
-		errChannel := make(chan NodeCordonError, 1) // Collect all errors from each command
-		go cordonNode(&wg, errChannel, kubectlOptions, nodeID)
+		errChannel := make(chan NodeUncordonError, 1) // Collect all errors from each command
+		go uncordonNode(&wg, errChannel, client, nodeID)
 		errChans = append(errChans, errChannel)
 	}
 	wg.Wait()
 
-	var cordonErrs *multierror.Error
+	var uncordonErrs *multierror.Error
 	for _, errChan := range errChans {
 		err := <-errChan
 		if err.Error != nil {
-			cordonErrs = multierror.Append(cordonErrs, err.Error)
+			uncordonErrs = multierror.Append(uncordonErrs, err.Error)
 		}
 	}
-	return errors.WithStackTrace(cordonErrs.ErrorOrNil())
+	return errors.WithStackTrace(uncordonErrs.ErrorOrNil())
 }
 
-func cordonNode(
+func uncordonNode(
 	wg *sync.WaitGroup,
-	errChannel chan<- NodeCordonError,
-	kubectlOptions *KubectlOptions,
+	errChannel chan<- NodeUncordonError,
+	clientset kubernetes.Interface,
 	nodeID string,
 ) {
 	defer wg.Done()
 	defer close(errChannel)
-	err := RunKubectl(kubectlOptions, "cordon", nodeID)
-	errChannel <- NodeCordonError{NodeID: nodeID, Error: err}
+	err := setNodeUnschedulable(context.Background(), clientset, nodeID, false)
+	errChannel <- NodeUncordonError{NodeID: nodeID, Error: err}
+}
+
+// setNodeUnschedulable patches the unschedulable field of the node, which is what `kubectl cordon` and
+// `kubectl uncordon` do.
+func setNodeUnschedulable(ctx context.Context, clientset kubernetes.Interface, nodeID string, unschedulable bool) error {
+	patch := fmt.Sprintf(`{"spec":{"unschedulable":%t}}`, unschedulable)
+	_, err := clientset.CoreV1().Nodes().Patch(ctx, nodeID, types.StrategicMergePatchType, []byte(patch), metav1.PatchOptions{})
+	return errors.WithStackTrace(err)
+}
+
+// FilterNodesIncludedInLoadBalancers returns the subset of the provided node names that are registered to the
+// Kubernetes cluster and are not labeled to be excluded from external load balancers yet.
+func FilterNodesIncludedInLoadBalancers(kubectlOptions *KubectlOptions, nodeNames []string) ([]string, error) {
+	client, err := GetKubernetesClientFromOptions(kubectlOptions)
+	if err != nil {
+		return nil, err
+	}
+	nodes, err := GetNodes(client, metav1.ListOptions{})
+	if err != nil {
+		return nil, errors.WithStackTrace(err)
+	}
+	return filterNodesByLoadBalancerExclusion(filterNodesByID(nodes, nodeNames), false), nil
+}
+
+// FilterNodesExcludedFromLoadBalancers returns the subset of the provided node names that are registered to the
+// Kubernetes cluster and are labeled to be excluded from external load balancers.
+func FilterNodesExcludedFromLoadBalancers(kubectlOptions *KubectlOptions, nodeNames []string) ([]string, error) {
+	client, err := GetKubernetesClientFromOptions(kubectlOptions)
+	if err != nil {
+		return nil, err
+	}
+	nodes, err := GetNodes(client, metav1.ListOptions{})
+	if err != nil {
+		return nil, errors.WithStackTrace(err)
+	}
+	return filterNodesByLoadBalancerExclusion(filterNodesByID(nodes, nodeNames), true), nil
+}
+
+// filterNodesByLoadBalancerExclusion returns the names of the nodes that carry the
+// node.kubernetes.io/exclude-from-external-load-balancers label when excluded is set, and of those that don't
+// otherwise.
+func filterNodesByLoadBalancerExclusion(nodes []corev1.Node, excluded bool) []string {
+	nodeNames := []string{}
+	for _, node := range nodes {
+		if _, isExcluded := node.Labels[corev1.LabelNodeExcludeBalancers]; isExcluded == excluded {
+			nodeNames = append(nodeNames, node.Name)
+		}
+	}
+	return nodeNames
+}
+
+// SetNodesExcludedFromLoadBalancers adds the node.kubernetes.io/exclude-from-external-load-balancers label to each node
+// provided when excluded is set, and removes it otherwise. The controllers managing the external load balancers of the
+// cluster stop routing to the nodes with this label.
+func SetNodesExcludedFromLoadBalancers(kubectlOptions *KubectlOptions, nodeIds []string, excluded bool) error {
+	client, err := GetKubernetesClientFromOptions(kubectlOptions)
+	if err != nil {
+		return err
+	}
+
+	var labelErrs *multierror.Error
+	for _, nodeID := range nodeIds {
+		if err := setNodeExcludedFromLoadBalancers(context.Background(), client, nodeID, excluded); err != nil {
+			labelErrs = multierror.Append(labelErrs, err)
+		}
+	}
+	return errors.WithStackTrace(labelErrs.ErrorOrNil())
+}
+
+// setNodeExcludedFromLoadBalancers patches the node.kubernetes.io/exclude-from-external-load-balancers label of the
+// node. A null value in a merge patch removes the label.
+func setNodeExcludedFromLoadBalancers(ctx context.Context, clientset kubernetes.Interface, nodeID string, excluded bool) error {
+	value := "null"
+	if excluded {
+		value = `"true"`
+	}
+	patch := fmt.Sprintf(`{"metadata":{"labels":{%q:%s}}}`, corev1.LabelNodeExcludeBalancers, value)
+	_, err := clientset.CoreV1().Nodes().Patch(ctx, nodeID, types.MergePatchType, []byte(patch), metav1.PatchOptions{})
+	return errors.WithStackTrace(err)
+}
+
+// ListNodeNames returns the names of the nodes matching the given label selector.
+func ListNodeNames(kubectlOptions *KubectlOptions, labelSelector string) ([]string, error) {
+	client, err := GetKubernetesClientFromOptions(kubectlOptions)
+	if err != nil {
+		return nil, err
+	}
+	nodes, err := GetNodes(client, metav1.ListOptions{LabelSelector: labelSelector})
+	if err != nil {
+		return nil, errors.WithStackTrace(err)
+	}
+	nodeNames := []string{}
+	for _, node := range nodes {
+		nodeNames = append(nodeNames, node.Name)
+	}
+	return nodeNames, nil
 }
 
 func waitForAllCordons(wg *sync.WaitGroup) {
 	wg.Wait()
 }
 
+// FilterRegisteredNodes returns the subset of the provided node names that are registered to the Kubernetes cluster.
+// This is useful for working with instances that may not have joined the cluster yet.
+func FilterRegisteredNodes(kubectlOptions *KubectlOptions, nodeNames []string) ([]string, error) {
+	client, err := GetKubernetesClientFromOptions(kubectlOptions)
+	if err != nil {
+		return nil, err
+	}
+	nodes, err := GetNodes(client, metav1.ListOptions{})
+	if err != nil {
+		return nil, errors.WithStackTrace(err)
+	}
+	registeredNodeNames := []string{}
+	for _, node := range nodes {
+		if collections.ListContainsElement(nodeNames, node.Name) {
+			registeredNodeNames = append(registeredNodeNames, node.Name)
+		}
+	}
+	return registeredNodeNames, nil
+}
+
 // GetNodes queries Kubernetes for information about the worker nodes registered to the cluster, given a
 // clientset.
 func GetNodes(clientset *kubernetes.Clientset, options metav1.ListOptions) ([]corev1.Node, error) {
@@ -1,12 +1,16 @@
 package kubectl
 
 import (
+	"context"
 	"testing"
 	"time"
 
 	"github.com/gruntwork-io/terratest/modules/k8s"
+	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
 )
 
 func TestWaitForNodesReady(t *testing.T) {
@@ -33,6 +37,47 @@ func TestFilterNodesById(t *testing.T) {
 	require.Equal(t, len(filterNodesByID(nodes, []string{nodes[0].Name})), 1)
 }
 
+func TestSetNodeExcludedFromLoadBalancers(t *testing.T) {
+	t.Parallel()
+
+	clientset := fake.NewSimpleClientset(&corev1.Node{
+		ObjectMeta: metav1.ObjectMeta{Name: "node-a", Labels: map[string]string{"app": "web"}},
+	})
+	require.NoError(t, setNodeExcludedFromLoadBalancers(context.Background(), clientset, "node-a", true))
+	node, err := clientset.CoreV1().Nodes().Get(context.Background(), "node-a", metav1.GetOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{"app": "web", corev1.LabelNodeExcludeBalancers: "true"}, node.Labels)
+
+	require.NoError(t, setNodeExcludedFromLoadBalancers(context.Background(), clientset, "node-a", false))
+	node, err = clientset.CoreV1().Nodes().Get(context.Background(), "node-a", metav1.GetOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{"app": "web"}, node.Labels)
+}
+
+func TestFilterNodesByLoadBalancerExclusion(t *testing.T) {
+	t.Parallel()
+
+	nodes := []corev1.Node{
+		{ObjectMeta: metav1.ObjectMeta{Name: "node-a", Labels: map[string]string{corev1.LabelNodeExcludeBalancers: "true"}}},
+		{ObjectMeta: metav1.ObjectMeta{Name: "node-b", Labels: map[string]string{"app": "web"}}},
+		{ObjectMeta: metav1.ObjectMeta{Name: "node-c"}},
+	}
+	assert.Equal(t, []string{"node-a"}, filterNodesByLoadBalancerExclusion(nodes, true))
+	assert.Equal(t, []string{"node-b", "node-c"}, filterNodesByLoadBalancerExclusion(nodes, false))
+}
+
+func TestSetNodeUnschedulable(t *testing.T) {
+	t.Parallel()
+
+	clientset := fake.NewSimpleClientset(&corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: "node-a"}})
+	for _, unschedulable := range []bool{true, false} {
+		require.NoError(t, setNodeUnschedulable(context.Background(), clientset, "node-a", unschedulable))
+		node, err := clientset.CoreV1().Nodes().Get(context.Background(), "node-a", metav1.GetOptions{})
+		require.NoError(t, err)
+		assert.Equal(t, unschedulable, node.Spec.Unschedulable)
+	}
+}
+
 func getNodes(t *testing.T, options *k8s.KubectlOptions) []corev1.Node {
 	nodes := k8s.GetNodes(t, options)
 	// Assumes local kubernetes (minikube or docker-for-desktop kube), where there is only one node
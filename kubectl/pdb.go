@@ -0,0 +1,157 @@
+package kubectl
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/gruntwork-io/go-commons/errors"
+	corev1 "k8s.io/api/core/v1"
+	policyv1 "k8s.io/api/policy/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/client-go/kubernetes"
+)
+
+// BlockedEviction describes a Pod that can never be evicted, because a PodDisruptionBudget covering the Pod never
+// allows any disruptions.
+type BlockedEviction struct {
+	NodeName     string
+	PodNamespace string
+	PodName      string
+	PDBName      string
+	Reason       string
+}
+
+func (blocked BlockedEviction) String() string {
+	return fmt.Sprintf(
+		"Pod %s/%s on node %s is covered by PodDisruptionBudget %s/%s: %s",
+		blocked.PodNamespace,
+		blocked.PodName,
+		blocked.NodeName,
+		blocked.PodNamespace,
+		blocked.PDBName,
+		blocked.Reason,
+	)
+}
+
+// FindBlockedEvictions lists the Pods that would be evicted from the provided nodes, matches them against the
+// PodDisruptionBudgets in the cluster, and returns the evictions that can never succeed. An eviction can never succeed
+// when the PodDisruptionBudget has a maxUnavailable of 0, or a minAvailable that is at least the number of Pods it
+// covers (e.g a single replica Deployment with minAvailable of 1).
+func FindBlockedEvictions(clientset kubernetes.Interface, nodeNames []string) ([]BlockedEviction, error) {
+	pdbList, err := clientset.PolicyV1().PodDisruptionBudgets(metav1.NamespaceAll).List(context.Background(), metav1.ListOptions{})
+	if err != nil {
+		return nil, errors.WithStackTrace(err)
+	}
+
+	// Determine which PDBs can never allow a disruption, so that we only need to match Pods against those.
+	blockingPDBs := []blockingPDB{}
+	for _, pdb := range pdbList.Items {
+		reason, isBlocking, err := pdbBlockingReason(clientset, pdb)
+		if err != nil {
+			return nil, err
+		}
+		if !isBlocking {
+			continue
+		}
+		selector, err := metav1.LabelSelectorAsSelector(pdb.Spec.Selector)
+		if err != nil {
+			return nil, errors.WithStackTrace(err)
+		}
+		blockingPDBs = append(blockingPDBs, blockingPDB{pdb: pdb, selector: selector, reason: reason})
+	}
+	if len(blockingPDBs) == 0 {
+		return []BlockedEviction{}, nil
+	}
+
+	blocked := []BlockedEviction{}
+	for _, nodeName := range nodeNames {
+		pods, err := ListPodsOnNodeWithClientset(context.Background(), clientset, nodeName)
+		if err != nil {
+			return nil, err
+		}
+		for _, pod := range FilterEvictablePods(pods) {
+			for _, blocking := range blockingPDBs {
+				if blocking.matches(pod) {
+					blocked = append(blocked, BlockedEviction{
+						NodeName:     nodeName,
+						PodNamespace: pod.Namespace,
+						PodName:      pod.Name,
+						PDBName:      blocking.pdb.Name,
+						Reason:       blocking.reason,
+					})
+				}
+			}
+		}
+	}
+	return blocked, nil
+}
+
+// blockingPDB is a PodDisruptionBudget that never allows any disruptions.
+type blockingPDB struct {
+	pdb      policyv1.PodDisruptionBudget
+	selector labels.Selector
+	reason   string
+}
+
+func (blocking blockingPDB) matches(pod corev1.Pod) bool {
+	return pod.Namespace == blocking.pdb.Namespace && blocking.selector.Matches(labels.Set(pod.Labels))
+}
+
+// pdbBlockingReason returns whether the PodDisruptionBudget never allows any disruptions, along with a human readable
+// reason why.
+func pdbBlockingReason(clientset kubernetes.Interface, pdb policyv1.PodDisruptionBudget) (string, bool, error) {
+	// A nil selector selects no Pods.
+	if pdb.Spec.Selector == nil {
+		return "", false, nil
+	}
+
+	if pdb.Spec.MaxUnavailable != nil {
+		// Percentages are rounded up when computing the allowed disruptions, so any non zero percentage allows at least
+		// one disruption.
+		maxUnavailable, err := intstr.GetScaledValueFromIntOrPercent(pdb.Spec.MaxUnavailable, 100, true)
+		if err != nil {
+			return "", false, errors.WithStackTrace(err)
+		}
+		if maxUnavailable == 0 {
+			return fmt.Sprintf("maxUnavailable is %s", pdb.Spec.MaxUnavailable.String()), true, nil
+		}
+		return "", false, nil
+	}
+
+	if pdb.Spec.MinAvailable != nil {
+		expectedPods, err := pdbExpectedPods(clientset, pdb)
+		if err != nil {
+			return "", false, err
+		}
+		if expectedPods == 0 {
+			return "", false, nil
+		}
+		minAvailable, err := intstr.GetScaledValueFromIntOrPercent(pdb.Spec.MinAvailable, int(expectedPods), true)
+		if err != nil {
+			return "", false, errors.WithStackTrace(err)
+		}
+		if minAvailable >= int(expectedPods) {
+			return fmt.Sprintf("minAvailable is %s, but only %d Pods are expected", pdb.Spec.MinAvailable.String(), expectedPods), true, nil
+		}
+	}
+	return "", false, nil
+}
+
+// pdbExpectedPods returns the number of Pods the PodDisruptionBudget covers. This uses the status computed by the
+// disruption controller when available, and otherwise counts the Pods matching the selector.
+func pdbExpectedPods(clientset kubernetes.Interface, pdb policyv1.PodDisruptionBudget) (int32, error) {
+	if pdb.Status.ExpectedPods > 0 {
+		return pdb.Status.ExpectedPods, nil
+	}
+	selector, err := metav1.LabelSelectorAsSelector(pdb.Spec.Selector)
+	if err != nil {
+		return 0, errors.WithStackTrace(err)
+	}
+	podList, err := clientset.CoreV1().Pods(pdb.Namespace).List(context.Background(), metav1.ListOptions{LabelSelector: selector.String()})
+	if err != nil {
+		return 0, errors.WithStackTrace(err)
+	}
+	return int32(len(podList.Items)), nil
+}
@@ -0,0 +1,109 @@
+package kubectl
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	policyv1 "k8s.io/api/policy/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestFindBlockedEvictions(t *testing.T) {
+	t.Parallel()
+
+	zero := intstr.FromInt(0)
+	zeroPercent := intstr.FromString("0%")
+	one := intstr.FromInt(1)
+	allPercent := intstr.FromString("100%")
+
+	testCases := []struct {
+		name            string
+		pdbSpec         policyv1.PodDisruptionBudgetSpec
+		expectedBlocked []string
+	}{
+		{
+			"maxUnavailable 0",
+			policyv1.PodDisruptionBudgetSpec{MaxUnavailable: &zero, Selector: appSelector("web")},
+			[]string{"web-1"},
+		},
+		{
+			"maxUnavailable 0%",
+			policyv1.PodDisruptionBudgetSpec{MaxUnavailable: &zeroPercent, Selector: appSelector("web")},
+			[]string{"web-1"},
+		},
+		{
+			"maxUnavailable 1",
+			policyv1.PodDisruptionBudgetSpec{MaxUnavailable: &one, Selector: appSelector("web")},
+			[]string{},
+		},
+		{
+			"minAvailable 1 on single replica",
+			policyv1.PodDisruptionBudgetSpec{MinAvailable: &one, Selector: appSelector("db")},
+			[]string{"db-1"},
+		},
+		{
+			"minAvailable 1 on two replicas",
+			policyv1.PodDisruptionBudgetSpec{MinAvailable: &one, Selector: appSelector("web")},
+			[]string{},
+		},
+		{
+			"minAvailable 100%",
+			policyv1.PodDisruptionBudgetSpec{MinAvailable: &allPercent, Selector: appSelector("web")},
+			[]string{"web-1"},
+		},
+		{
+			"nil selector",
+			policyv1.PodDisruptionBudgetSpec{MaxUnavailable: &zero},
+			[]string{},
+		},
+	}
+
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			isController := true
+			objects := []runtime.Object{
+				&policyv1.PodDisruptionBudget{
+					ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "pdb"},
+					Spec:       tc.pdbSpec,
+				},
+				testPod("web-1", "node-a", "web"),
+				testPod("web-2", "node-b", "web"),
+				testPod("db-1", "node-a", "db"),
+			}
+			// DaemonSet Pods are never evicted, so they are never blocked.
+			daemonSetPod := testPod("web-ds", "node-a", "web")
+			daemonSetPod.OwnerReferences = []metav1.OwnerReference{{Kind: "DaemonSet", Controller: &isController}}
+			objects = append(objects, daemonSetPod)
+			clientset := fake.NewSimpleClientset(objects...)
+
+			blocked, err := FindBlockedEvictions(clientset, []string{"node-a"})
+			require.NoError(t, err)
+			blockedPods := []string{}
+			for _, blockedEviction := range blocked {
+				assert.Equal(t, "node-a", blockedEviction.NodeName)
+				assert.Equal(t, "pdb", blockedEviction.PDBName)
+				blockedPods = append(blockedPods, blockedEviction.PodName)
+			}
+			assert.Equal(t, tc.expectedBlocked, blockedPods)
+		})
+	}
+}
+
+func appSelector(app string) *metav1.LabelSelector {
+	return &metav1.LabelSelector{MatchLabels: map[string]string{"app": app}}
+}
+
+func testPod(name string, nodeName string, app string) *corev1.Pod {
+	return &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: name, Labels: map[string]string{"app": app}},
+		Spec:       corev1.PodSpec{NodeName: nodeName},
+	}
+}
@@ -3,9 +3,11 @@ package kubectl
 import (
 	"context"
 
+	"github.com/gruntwork-io/go-commons/collections"
 	"github.com/gruntwork-io/go-commons/errors"
 	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
 )
 
 // ListPods will look for pods in the given namespace and return them.
@@ -31,3 +33,97 @@ func IsPodReady(pod corev1.Pod) bool {
 	}
 	return false
 }
+
+// ListPodsOnNode will return all the pods scheduled on the given node, across all namespaces.
+func ListPodsOnNode(options *KubectlOptions, nodeName string) ([]corev1.Pod, error) {
+	client, err := GetKubernetesClientFromOptions(options)
+	if err != nil {
+		return nil, err
+	}
+	return ListPodsOnNodeWithClientset(context.Background(), client, nodeName)
+}
+
+// ListPodsOnNodeWithClientset will return all the pods scheduled on the given node, across all namespaces, using the
+// provided clientset. DaemonSet and mirror Pods are included: use FilterEvictablePods to only keep the Pods that a drain
+// would evict.
+func ListPodsOnNodeWithClientset(ctx context.Context, clientset kubernetes.Interface, nodeName string) ([]corev1.Pod, error) {
+	podList, err := clientset.CoreV1().Pods(metav1.NamespaceAll).List(
+		ctx,
+		metav1.ListOptions{FieldSelector: "spec.nodeName=" + nodeName},
+	)
+	if err != nil {
+		return nil, errors.WithStackTrace(err)
+	}
+	pods := []corev1.Pod{}
+	for _, pod := range podList.Items {
+		// Not all clients honor field selectors, so double check the Pod is on the node.
+		if pod.Spec.NodeName == nodeName {
+			pods = append(pods, pod)
+		}
+	}
+	return pods, nil
+}
+
+// IsDaemonSetPod returns True when the Pod is managed by a DaemonSet. These Pods are ignored when draining a node, as
+// the DaemonSet controller would immediately replace them.
+func IsDaemonSetPod(pod corev1.Pod) bool {
+	for _, ownerRef := range pod.OwnerReferences {
+		if ownerRef.Controller != nil && *ownerRef.Controller && ownerRef.Kind == "DaemonSet" {
+			return true
+		}
+	}
+	return false
+}
+
+// IsMirrorPod returns True when the Pod is a mirror of a static Pod managed directly by the kubelet. These Pods can not
+// be evicted through the API server.
+func IsMirrorPod(pod corev1.Pod) bool {
+	_, isMirror := pod.Annotations[corev1.MirrorPodAnnotationKey]
+	return isMirror
+}
+
+// FilterEvictablePods returns the Pods that would be evicted when draining a node, skipping DaemonSet and mirror Pods.
+func FilterEvictablePods(pods []corev1.Pod) []corev1.Pod {
+	out := []corev1.Pod{}
+	for _, pod := range pods {
+		if IsDaemonSetPod(pod) || IsMirrorPod(pod) {
+			continue
+		}
+		out = append(out, pod)
+	}
+	return out
+}
+
+// GetPodIPsOutsideNodes returns the IP addresses of all the Pods scheduled on nodes other than the given nodes, across
+// all namespaces.
+func GetPodIPsOutsideNodes(options *KubectlOptions, excludedNodeNames []string) ([]string, error) {
+	pods, err := ListPods(options, metav1.NamespaceAll, metav1.ListOptions{})
+	if err != nil {
+		return nil, err
+	}
+	return podIPsOutsideNodes(pods, excludedNodeNames), nil
+}
+
+// podIPsOutsideNodes returns the IP addresses of the Pods that are scheduled on nodes other than the given nodes.
+func podIPsOutsideNodes(pods []corev1.Pod, excludedNodeNames []string) []string {
+	ips := []string{}
+	for _, pod := range pods {
+		if pod.Spec.NodeName == "" || collections.ListContainsElement(excludedNodeNames, pod.Spec.NodeName) {
+			continue
+		}
+		ips = append(ips, getPodIPs(pod)...)
+	}
+	return ips
+}
+
+// getPodIPs returns all the IP addresses assigned to the Pod, which has more than one on dual-stack clusters.
+func getPodIPs(pod corev1.Pod) []string {
+	ips := []string{}
+	for _, podIP := range pod.Status.PodIPs {
+		ips = append(ips, podIP.IP)
+	}
+	if len(ips) == 0 && pod.Status.PodIP != "" {
+		ips = append(ips, pod.Status.PodIP)
+	}
+	return ips
+}
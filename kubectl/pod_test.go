@@ -1,11 +1,15 @@
 package kubectl
 
 import (
+	"context"
 	"testing"
 
 	"github.com/gruntwork-io/terratest/modules/k8s"
+	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
 )
 
 func TestListPodsReturnsPods(t *testing.T) {
@@ -20,3 +24,96 @@ func TestListPodsReturnsPods(t *testing.T) {
 	require.NoError(t, err)
 	require.True(t, len(pods) > 0)
 }
+
+func TestListPodsOnNodeWithClientsetOnlyReturnsPodsOnNode(t *testing.T) {
+	t.Parallel()
+
+	isController := true
+	clientset := fake.NewSimpleClientset(
+		&corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Name: "app", Namespace: "default"},
+			Spec:       corev1.PodSpec{NodeName: "node-a"},
+		},
+		&corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:            "daemonset-pod",
+				Namespace:       "kube-system",
+				OwnerReferences: []metav1.OwnerReference{{Kind: "DaemonSet", Controller: &isController}},
+			},
+			Spec: corev1.PodSpec{NodeName: "node-a"},
+		},
+		&corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Name: "other", Namespace: "default"},
+			Spec:       corev1.PodSpec{NodeName: "node-b"},
+		},
+	)
+
+	// The fake clientset ignores field selectors, so this also checks that Pods on other nodes are filtered out.
+	pods, err := ListPodsOnNodeWithClientset(context.Background(), clientset, "node-a")
+	require.NoError(t, err)
+	names := []string{}
+	for _, pod := range pods {
+		names = append(names, pod.Name)
+	}
+	assert.ElementsMatch(t, []string{"app", "daemonset-pod"}, names)
+}
+
+func TestFilterEvictablePodsSkipsDaemonSetAndMirrorPods(t *testing.T) {
+	t.Parallel()
+
+	isController := true
+	pods := []corev1.Pod{
+		{ObjectMeta: metav1.ObjectMeta{Name: "app"}},
+		{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:            "replicaset-pod",
+				OwnerReferences: []metav1.OwnerReference{{Kind: "ReplicaSet", Controller: &isController}},
+			},
+		},
+		{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:            "daemonset-pod",
+				OwnerReferences: []metav1.OwnerReference{{Kind: "DaemonSet", Controller: &isController}},
+			},
+		},
+		{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:        "mirror-pod",
+				Annotations: map[string]string{corev1.MirrorPodAnnotationKey: "hash"},
+			},
+		},
+	}
+
+	evictable := FilterEvictablePods(pods)
+	names := []string{}
+	for _, pod := range evictable {
+		names = append(names, pod.Name)
+	}
+	assert.Equal(t, []string{"app", "replicaset-pod"}, names)
+}
+
+func TestGetPodIPs(t *testing.T) {
+	t.Parallel()
+
+	dualStackPod := corev1.Pod{
+		Status: corev1.PodStatus{
+			PodIP:  "10.0.0.1",
+			PodIPs: []corev1.PodIP{{IP: "10.0.0.1"}, {IP: "2600:1f14::1"}},
+		},
+	}
+	assert.Equal(t, []string{"10.0.0.1", "2600:1f14::1"}, getPodIPs(dualStackPod))
+	assert.Equal(t, []string{"10.0.0.2"}, getPodIPs(corev1.Pod{Status: corev1.PodStatus{PodIP: "10.0.0.2"}}))
+	assert.Equal(t, []string{}, getPodIPs(corev1.Pod{}))
+}
+
+func TestPodIPsOutsideNodes(t *testing.T) {
+	t.Parallel()
+
+	pods := []corev1.Pod{
+		{Spec: corev1.PodSpec{NodeName: "old-node"}, Status: corev1.PodStatus{PodIP: "10.0.0.1"}},
+		{Spec: corev1.PodSpec{NodeName: "new-node"}, Status: corev1.PodStatus{PodIP: "10.0.0.2"}},
+		// Pending Pods are not scheduled on any node yet.
+		{Status: corev1.PodStatus{PodIP: "10.0.0.3"}},
+	}
+	assert.Equal(t, []string{"10.0.0.2"}, podIPsOutsideNodes(pods, []string{"old-node"}))
+}
@@ -25,7 +25,7 @@ const (
 
 // GetAllServices queries Kubernetes for information on all deployed Service resources in the current cluster that the
 // provided client can access.
-func GetAllServices(clientset *kubernetes.Clientset) ([]corev1.Service, error) {
+func GetAllServices(clientset kubernetes.Interface) ([]corev1.Service, error) {
 	// We use the empty string for the namespace to indicate all namespaces
 	namespace := ""
 	servicesApi := clientset.CoreV1().Services(namespace)
@@ -52,10 +52,12 @@ func GetAllServices(clientset *kubernetes.Clientset) ([]corev1.Service, error) {
 // following information:
 // - Type of LB (NLB or Classic LB)
 // - Instance target or IP target
-// TODO: support ALBs with Ingress as well
+// - Whether the Service uses the Local external traffic policy
+// The ALBs provisioned for Ingress resources by the AWS Load Balancer Controller are included as well (see
+// GetALBIngressLoadBalancers).
 func GetAWSLoadBalancers(kubectlOptions *KubectlOptions) ([]AWSLoadBalancer, error) {
 	logger := logging.GetProjectLogger()
-	logger.Infof("Getting all LoadBalancers from services in kubernetes")
+	logger.Infof("Getting all LoadBalancers from services and ingresses in kubernetes")
 
 	client, err := GetKubernetesClientFromOptions(kubectlOptions)
 	if err != nil {
@@ -81,12 +83,19 @@ func GetAWSLoadBalancers(kubectlOptions *KubectlOptions) ([]AWSLoadBalancer, err
 		lbs = append(
 			lbs,
 			AWSLoadBalancer{
-				Name:       lbName,
-				Type:       lbType,
-				TargetType: lbTargetType,
+				Name:               lbName,
+				Type:               lbType,
+				TargetType:         lbTargetType,
+				LocalTrafficPolicy: service.Spec.ExternalTrafficPolicy == corev1.ServiceExternalTrafficPolicyLocal,
 			},
 		)
 	}
+
+	albs, err := GetALBIngressLoadBalancers(client)
+	if err != nil {
+		return nil, err
+	}
+	lbs = append(lbs, albs...)
 	logger.Infof("Successfully extracted AWS Load Balancers")
 	return lbs, nil
 }
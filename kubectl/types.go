@@ -2,10 +2,13 @@ package kubectl
 
 // AWSLoadBalancer is a struct that represents an AWS ELB that is associated with Kubernetes resources (Service or
 // Ingress).
+// LocalTrafficPolicy is set for the load balancers of Services with externalTrafficPolicy set to Local, where the
+// health checks of the instance targets only pass on the nodes running the Pods of the Service.
 type AWSLoadBalancer struct {
-	Name       string
-	Type       ELBType
-	TargetType ELBTargetType
+	Name               string
+	Type               ELBType
+	TargetType         ELBTargetType
+	LocalTrafficPolicy bool
 }
 
 // ELBType represents the underlying type of the load balancer (classic, network, or application)
@@ -18,6 +21,18 @@ const (
 	UnknownELB
 )
 
+func (elbType ELBType) String() string {
+	switch elbType {
+	case ALB:
+		return "alb"
+	case NLB:
+		return "nlb"
+	case CLB:
+		return "clb"
+	}
+	return "unknown"
+}
+
 // ELBTargetType represents the different ways the AWS ELB routes to the services.
 type ELBTargetType int
 
@@ -26,3 +41,13 @@ const (
 	IPTarget
 	UnknownELBTarget
 )
+
+func (targetType ELBTargetType) String() string {
+	switch targetType {
+	case InstanceTarget:
+		return "instance"
+	case IPTarget:
+		return "ip"
+	}
+	return "unknown"
+}
@@ -0,0 +1,34 @@
+package kubectl
+
+import (
+	appsv1 "k8s.io/api/apps/v1"
+)
+
+// IsDeploymentAvailable returns true if the Deployment has finished rolling out and all of its desired replicas are
+// updated and available.
+func IsDeploymentAvailable(deployment appsv1.Deployment) bool {
+	desiredReplicas := int32(1)
+	if deployment.Spec.Replicas != nil {
+		desiredReplicas = *deployment.Spec.Replicas
+	}
+	status := deployment.Status
+	return status.ObservedGeneration >= deployment.Generation &&
+		status.Replicas == desiredReplicas &&
+		status.UpdatedReplicas == desiredReplicas &&
+		status.AvailableReplicas == desiredReplicas
+}
+
+// IsStatefulSetAvailable returns true if the StatefulSet has finished rolling out and all of its desired replicas are
+// updated and available.
+func IsStatefulSetAvailable(statefulSet appsv1.StatefulSet) bool {
+	desiredReplicas := int32(1)
+	if statefulSet.Spec.Replicas != nil {
+		desiredReplicas = *statefulSet.Spec.Replicas
+	}
+	status := statefulSet.Status
+	return status.ObservedGeneration >= statefulSet.Generation &&
+		status.Replicas == desiredReplicas &&
+		status.UpdatedReplicas == desiredReplicas &&
+		status.ReadyReplicas == desiredReplicas &&
+		status.AvailableReplicas == desiredReplicas
+}